@@ -24,10 +24,12 @@ import (
 	"net/url"
 	"os"
 
+	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"gocloud.dev/blob"
+	"gocloud.dev/blob/azureblob"
 	"gocloud.dev/blob/fileblob"
 	"gocloud.dev/blob/gcsblob"
 	"gocloud.dev/blob/s3blob"
@@ -40,7 +42,9 @@ import (
 // Even if name contains subdirectories, only the base directory name will be
 // used when opening the bucket.
 // The currently accepted storage providers are "file" for the local filesystem
-// (e.g., for testing), "gs" for Google Cloud Storage, and "s3" for AWS S3.
+// (e.g., for testing), "gs" for Google Cloud Storage, "s3" for AWS S3
+// or S3-compatible storage such as MinIO or Ceph, and "azblob" for
+// Azure Blob Storage.
 func OpenBucket(ctx context.Context, bucketName string) (*blob.Bucket, error) {
 	url, err := url.Parse(bucketName)
 	if err != nil {
@@ -53,6 +57,8 @@ func OpenBucket(ctx context.Context, bucketName string) (*blob.Bucket, error) {
 		return gsBucket(ctx, url.Hostname())
 	case "s3":
 		return s3Bucket(ctx, url.Hostname())
+	case "azblob":
+		return azureBucket(ctx, url.Hostname())
 	default:
 		return nil, fmt.Errorf("cloud.OpenBucket: invalid provider %s", url.Scheme)
 	}
@@ -75,8 +81,13 @@ func gsBucket(ctx context.Context, name string) (*blob.Bucket, error) {
 // s3Bucket opens an s3 storage bucket. It assumes the following
 // environment variables are set: AWS_REGION, AWS_ACCESS_KEY_ID, and
 // AWS_SECRET_ACCESS_KEY.
+// To use S3-compatible storage other than AWS, such as MinIO or Ceph,
+// the AWS_ENDPOINT_URL_S3 environment variable can additionally be set
+// to the URL of the storage service (e.g., "http://localhost:9000").
+// In that case, path-style addressing (i.e., 'endpoint/bucket/key' rather
+// than 'bucket.endpoint/key') is used.
 func s3Bucket(ctx context.Context, name string) (*blob.Bucket, error) {
-	region := os.ExpandEnv("AWS_REGION")
+	region := os.Getenv("AWS_REGION")
 	if region == "" {
 		region = "us-east-2"
 	}
@@ -84,6 +95,42 @@ func s3Bucket(ctx context.Context, name string) (*blob.Bucket, error) {
 		Region:      aws.String(region),
 		Credentials: credentials.NewEnvCredentials(),
 	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_S3"); endpoint != "" {
+		c.Endpoint = aws.String(endpoint)
+		c.S3ForcePathStyle = aws.Bool(true)
+	}
 	s := session.Must(session.NewSession(c))
 	return s3blob.OpenBucket(ctx, s, name, nil)
 }
+
+// azureBucket opens an Azure Blob Storage container. It assumes that
+// the AZURE_STORAGE_ACCOUNT environment variable is set, along with either
+// AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN.
+// AZURE_STORAGE_DOMAIN and AZURE_STORAGE_PROTOCOL can optionally be set
+// to use a different Azure cloud environment or a local emulator.
+func azureBucket(ctx context.Context, name string) (*blob.Bucket, error) {
+	accountName, err := azureblob.DefaultAccountName()
+	if err != nil {
+		return nil, fmt.Errorf("cloud: opening Azure bucket: %v", err)
+	}
+	opts := &azureblob.Options{
+		StorageDomain: azureblob.StorageDomain(os.Getenv("AZURE_STORAGE_DOMAIN")),
+		Protocol:      azureblob.Protocol(os.Getenv("AZURE_STORAGE_PROTOCOL")),
+	}
+	var cred azblob.Credential
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		sharedKey, err := azureblob.NewCredential(accountName, azureblob.AccountKey(key))
+		if err != nil {
+			return nil, fmt.Errorf("cloud: opening Azure bucket: %v", err)
+		}
+		cred = sharedKey
+		opts.Credential = sharedKey
+	} else if sas := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); sas != "" {
+		cred = azblob.NewAnonymousCredential()
+		opts.SASToken = azureblob.SASToken(sas)
+	} else {
+		return nil, fmt.Errorf("cloud: opening Azure bucket: either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set")
+	}
+	p := azureblob.NewPipeline(cred, azblob.PipelineOptions{})
+	return azureblob.OpenBucket(ctx, p, accountName, name, opts)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestOpenBucket_s3Compatible(t *testing.T) {
+	s3 := NewFakeS3()
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	for k, v := range map[string]string{
+		"AWS_ENDPOINT_URL_S3":   srv.URL,
+		"AWS_ACCESS_KEY_ID":     "minio",
+		"AWS_SECRET_ACCESS_KEY": "minio123",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	ctx := context.Background()
+	bucket, err := OpenBucket(ctx, "s3://testbucket/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bucket.Close()
+
+	if err := writeBlob(ctx, bucket, "dir/a/job/test.txt", []byte("test data")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"testbucket/dir/a/job/test.txt"}; !reflect.DeepEqual(s3.Keys(), want) {
+		t.Errorf("path-style keys: %v != %v", s3.Keys(), want)
+	}
+	exists, err := bucket.Exists(ctx, "dir/a/job/test.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("blob should exist")
+	}
+	r, err := bucket.NewReader(ctx, "dir/a/job/test.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "test data" {
+		t.Errorf("read %q", b)
+	}
+
+	if err := deleteBlobDir(ctx, "s3://testbucket/dir", "a", "job"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s3.Keys()) != 0 {
+		t.Errorf("blobs should have been deleted: %v", s3.Keys())
+	}
+}
+
+func TestOpenBucket_azblob(t *testing.T) {
+	for _, k := range []string{"AZURE_STORAGE_ACCOUNT", "AZURE_STORAGE_KEY", "AZURE_STORAGE_SAS_TOKEN"} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Unsetenv(k)
+	}
+	ctx := context.Background()
+	if _, err := OpenBucket(ctx, "azblob://container"); err == nil {
+		t.Error("missing account name should cause an error")
+	}
+	os.Setenv("AZURE_STORAGE_ACCOUNT", "inmap")
+	if _, err := OpenBucket(ctx, "azblob://container"); err == nil {
+		t.Error("missing credentials should cause an error")
+	}
+	os.Setenv("AZURE_STORAGE_SAS_TOKEN", "sv=2019-12-12&sig=x")
+	bucket, err := OpenBucket(ctx, "azblob://container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket.Close()
+}
@@ -28,20 +28,20 @@ import (
 	"github.com/spatialmodel/inmap/cloud/cloudrpc"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
-	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
-	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	batchclient "k8s.io/client-go/kubernetes/typed/batch/v1"
 )
 
-// Client is a Kubernetes client for InMAP.
+// Client is a client for running InMAP jobs. By default
+// jobs are run on a Kubernetes cluster, but other JobRunners
+// can be used instead.
 type Client struct {
 	*grpcweb.WrappedGrpcServer
 
 	kubernetes.Interface
-	jobControl batchclient.JobInterface
+
+	// runner executes the jobs.
+	runner JobRunner
 
 	bucketName string
 
@@ -71,12 +71,25 @@ type Client struct {
 // inputFileArgs and outputFileArgs list the names of the
 // configuration arguments that represent input and output files.
 func NewClient(k kubernetes.Interface, root *cobra.Command, config *viper.Viper, bucketName string, inputFileArgs, outputFileArgs []string) (*Client, error) {
-	batchClient := k.BatchV1()
-	jobControl := batchClient.Jobs("inmap-distributed")
+	const namespace = "inmap-distributed"
+	r := &kubernetesRunner{
+		jobControl: k.BatchV1().Jobs(namespace),
+		pods:       k.CoreV1().Pods(namespace),
+	}
+	c, err := NewRunnerClient(r, root, config, bucketName, inputFileArgs, outputFileArgs)
+	if err != nil {
+		return nil, err
+	}
+	c.Interface = k
+	r.c = c
+	return c, nil
+}
 
+// NewRunnerClient creates a new InMAP client that uses r to execute jobs.
+// The other arguments are the same as for NewClient.
+func NewRunnerClient(r JobRunner, root *cobra.Command, config *viper.Viper, bucketName string, inputFileArgs, outputFileArgs []string) (*Client, error) {
 	c := &Client{
-		Interface:      k,
-		jobControl:     jobControl,
+		runner:         r,
 		bucketName:     bucketName,
 		root:           root,
 		config:         config,
@@ -91,7 +104,7 @@ func NewClient(k kubernetes.Interface, root *cobra.Command, config *viper.Viper,
 	return c, nil
 }
 
-// RunJob creates (and queues) a Kubernetes job with the given name that executes
+// RunJob creates (and queues) a job with the given name that executes
 // the given command with the given command-line arguments on the given container
 // image. resources specifies the minimum required resources for execution.
 func (c *Client) RunJob(ctx context.Context, job *cloudrpc.JobSpec) (*cloudrpc.JobStatus, error) {
@@ -118,11 +131,7 @@ func (c *Client) RunJob(ctx context.Context, job *cloudrpc.JobSpec) (*cloudrpc.J
 	if err != nil {
 		return nil, err
 	}
-	k8sJob := createJob(userJobName(user, job.Name), job.Cmd, job.Args, job.Version, core.ResourceList{
-		core.ResourceMemory: resource.MustParse(fmt.Sprintf("%dGi", job.MemoryGB)),
-	}, c.Volumes)
-	_, err = c.jobControl.Create(ctx, k8sJob, meta.CreateOptions{})
-	if err != nil {
+	if err = c.runner.Run(ctx, userJobName(user, job.Name), job); err != nil {
 		return nil, err
 	}
 	return c.Status(ctx, &cloudrpc.JobName{Name: job.Name, Version: job.Version})
@@ -137,28 +146,20 @@ func (c *Client) Delete(ctx context.Context, job *cloudrpc.JobName) (*cloudrpc.J
 	if err = deleteBlobDir(ctx, c.bucketName, user, job.Name); err != nil {
 		return nil, err
 	}
-	p := meta.DeletePropagationForeground
-	return job, c.jobControl.Delete(ctx, userJobName(user, job.Name), meta.DeleteOptions{
-		PropagationPolicy: &p,
-	})
+	if err = c.releaseInputs(ctx, user, job.Name); err != nil {
+		return nil, err
+	}
+	return job, c.runner.Delete(ctx, userJobName(user, job.Name))
 }
 
-func (c *Client) getk8sJob(ctx context.Context, job *cloudrpc.JobName) (*batch.Job, error) {
+// jobCommand returns the command that the given job is executing.
+func (c *Client) jobCommand(ctx context.Context, job *cloudrpc.JobName) ([]string, error) {
 	user, err := getUser(ctx)
 	if err != nil {
 		return nil, err
 	}
-	jobName := userJobName(user, job.Name)
-	jobList, err := c.jobControl.List(ctx, meta.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
-	for _, k8sJob := range jobList.Items {
-		if k8sJob.GetName() == jobName {
-			return &k8sJob, nil
-		}
-	}
-	return nil, fmt.Errorf("cannot find job %s", jobName)
+	_, cmd, err := c.runner.Status(ctx, userJobName(user, job.Name))
+	return cmd, err
 }
 
 // getUser returns the "user" value of ctx.
@@ -177,91 +178,27 @@ func userJobName(user, name string) string {
 
 // Status returns the status of the given job.
 func (c *Client) Status(ctx context.Context, job *cloudrpc.JobName) (*cloudrpc.JobStatus, error) {
-	s := new(cloudrpc.JobStatus)
-	k8sJob, err := c.getk8sJob(ctx, job)
+	user, err := getUser(ctx)
 	if err != nil {
 		return &cloudrpc.JobStatus{
 			Status:  cloudrpc.Status_Missing,
 			Message: err.Error(),
 		}, nil
 	}
-	for i, cond := range k8sJob.Status.Conditions {
-		if i != len(k8sJob.Status.Conditions)-1 {
-			continue
-		}
-		if cond.Type == batch.JobComplete && cond.Status == core.ConditionTrue {
-			s.Status = cloudrpc.Status_Complete
-			s.StartTime = k8sJob.Status.StartTime.Time.Unix()
-			s.CompletionTime = k8sJob.Status.CompletionTime.Time.Unix()
-			err := c.checkOutputs(ctx, job.Name, k8sJob.Spec.Template.Spec.Containers[0].Command)
-			if err != nil {
-				s.Status = cloudrpc.Status_Failed
-				s.Message = fmt.Sprintf("job completed but the following error occurred when checking outputs: %s", err)
-				return s, nil
-			}
-		} else if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue {
-			s.Status = cloudrpc.Status_Failed
-			s.Message = cond.Message
-		}
+	s, cmd, err := c.runner.Status(ctx, userJobName(user, job.Name))
+	if err != nil {
+		return &cloudrpc.JobStatus{
+			Status:  cloudrpc.Status_Missing,
+			Message: err.Error(),
+		}, nil
 	}
-	if len(k8sJob.Status.Conditions) == 0 {
-		if k8sJob.Status.Active > 0 {
-			s.Status = cloudrpc.Status_Running
-			s.StartTime = k8sJob.Status.StartTime.Time.Unix()
-		} else {
-			s.Status = cloudrpc.Status_Waiting
+	if s.Status == cloudrpc.Status_Complete {
+		err := c.checkOutputs(ctx, job.Name, cmd)
+		if err != nil {
+			s.Status = cloudrpc.Status_Failed
+			s.Message = fmt.Sprintf("job completed but the following error occurred when checking outputs: %s", err)
+			return s, nil
 		}
 	}
 	return s, nil
 }
-
-// createJob creates a Kubernetes job specification with the given name that executes the
-// given command with the given command-line arguments on the given container
-// image. resources specifies the minimum required resources for execution.
-// volumes holds the list of k8s volumes to mount, with all volumes assumed to
-// be read-only.
-// Version is the version of the InMAP docker image to use, such as "latest" or "v1.7.2".
-func createJob(name string, command, args []string, version string, resources core.ResourceList, volumes []core.Volume) *batch.Job {
-	volumeMounts := make([]core.VolumeMount, len(volumes))
-	for i, v := range volumes {
-		volumeMounts[i] = core.VolumeMount{
-			Name:      v.Name,
-			ReadOnly:  true,
-			MountPath: "/data/" + v.Name,
-		}
-	}
-
-	return &batch.Job{
-		TypeMeta: meta.TypeMeta{
-			Kind:       "Job",
-			APIVersion: "batch/v1",
-		},
-		ObjectMeta: meta.ObjectMeta{
-			Name: name,
-		},
-		Spec: batch.JobSpec{
-			Template: core.PodTemplateSpec{
-				ObjectMeta: meta.ObjectMeta{
-					Name:   name + "_pod",
-					Labels: map[string]string{"app": "inmap-distributed"},
-				},
-				Spec: core.PodSpec{
-					Containers: []core.Container{
-						{
-							Name:    "inmap-container",
-							Image:   fmt.Sprintf("inmap/inmap:%s", version),
-							Command: command,
-							Args:    args,
-							Resources: core.ResourceRequirements{
-								Requests: resources,
-							},
-							VolumeMounts: volumeMounts,
-						},
-					},
-					Volumes:       volumes,
-					RestartPolicy: core.RestartPolicyOnFailure,
-				},
-			},
-		},
-	}
-}
@@ -43,28 +43,35 @@ func TestClient_fake(t *testing.T) {
 		wantCmd := []string{"inmap", "run", "steady",
 			"--EmissionMaskGeoJSON=",
 			"--EmissionUnits=tons/year",
-			"--EmissionsShapefiles=file://test/test/test_user/test_job/258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp",
-			"--InMAPData=file://test/test/test_user/test_job/434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf",
+			"--EmissionsShapefiles=file://test/test/inputs/cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shp",
+			"--InMAPData=file://test/test/inputs/434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf",
 			"--LogFile=file://test/test/test_user/test_job/LogFile",
 			"--NumIterations=0",
 			"--OutputFile=file://test/test/test_user/test_job/OutputFile.shp",
 			"--OutputVariables={\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
-			"--VarGrid.CensusFile=file://test/test/test_user/test_job/72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shp",
+			"--VarGrid.CensusFile=file://test/test/inputs/d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.shp",
 			"--VarGrid.CensusPopColumns=TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
+			"--VarGrid.GridFile=",
 			"--VarGrid.GridProj=+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
 			"--VarGrid.HiResLayers=1",
 			"--VarGrid.MortalityRateColumns={\"AllCause\":\"TotalPop\",\"AsianMort\":\"Asian\",\"BlackMort\":\"Black\",\"LatinoMort\":\"Latino\",\"NativeMort\":\"Native\",\"WhNoLMort\":\"WhiteNoLat\"}\n",
-			"--VarGrid.MortalityRateFile=file://test/test/test_user/test_job/764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shp",
+			"--VarGrid.MortalityRateFile=file://test/test/inputs/d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.shp",
 			"--VarGrid.PopConcThreshold=1e-09", "--VarGrid.PopDensityThreshold=0.0055",
-			"--VarGrid.PopGridColumn=TotalPop", "--VarGrid.PopThreshold=40000", "--VarGrid.VariableGridDx=4000",
+			"--VarGrid.PopGridColumn=TotalPop", "--VarGrid.PopThreshold=40000",
+			"--VarGrid.RefinementCriteria=", "--VarGrid.RefinementRule=Population", "--VarGrid.VariableGridDx=4000",
 			"--VarGrid.VariableGridDy=4000", "--VarGrid.VariableGridXo=-4000", "--VarGrid.VariableGridYo=-4000",
 			"--VarGrid.Xnests=2,2,2", "--VarGrid.Ynests=2,2,2",
-			"--VariableGridData=file://test/test/test_user/test_job/26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
+			"--VariableGridData=file://test/test/inputs/26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
 			"--aep.GridRef=",
 			"--aep.InventoryConfig.COARDSFiles=",
 			"--aep.InventoryConfig.COARDSYear=0",
+			"--aep.InventoryConfig.ControlFiles=",
 			"--aep.InventoryConfig.InputUnits=no_default",
 			"--aep.InventoryConfig.NEIFiles=",
+			"--aep.InventoryConfig.ProjectionBaseYear=0",
+			"--aep.InventoryConfig.ProjectionFiles=",
+			"--aep.InventoryConfig.ProjectionYear=0",
+			"--aep.OSMFile=",
 			"--aep.PostGISURL=",
 			"--aep.SCCExactMatch=true",
 			"--aep.SpatialConfig.GridName=inmap",
@@ -72,8 +79,21 @@ func TestClient_fake(t *testing.T) {
 			"--aep.SpatialConfig.MaxCacheEntries=10",
 			"--aep.SpatialConfig.SpatialCache=",
 			"--aep.SpatialConfig.SrgDataCache=",
+			"--aep.SpeciateConfig.ChemicalMechanism=",
+			"--aep.SpeciateConfig.GasProfile=",
+			"--aep.SpeciateConfig.GasSpecies=",
+			"--aep.SpeciateConfig.MechAssignment=",
+			"--aep.SpeciateConfig.MolarWeight=",
+			"--aep.SpeciateConfig.OtherGasSpecies=",
+			"--aep.SpeciateConfig.PMSpecies=",
+			"--aep.SpeciateConfig.SpecRef=",
+			"--aep.SpeciateConfig.SpecRefCombo=",
+			"--aep.SpeciateConfig.SpeciesGroups={}\n",
+			"--aep.SpeciateConfig.SpeciesInfo=",
+			"--aep.SpeciateConfig.SpeciesProperties=",
 			"--aep.SrgShapefileDirectory=no_default",
 			"--aep.SrgSpecOSM=",
+			"--aep.SrgSpecRaster=",
 			"--aep.SrgSpecSMOKE=",
 		}
 		if len(cmd) != len(wantCmd) {
@@ -210,27 +230,34 @@ func TestClient_fakeCOARDS(t *testing.T) {
 			"--EmissionMaskGeoJSON=",
 			"--EmissionUnits=tons/year",
 			"--EmissionsShapefiles=",
-			"--InMAPData=file://test/test/test_user/test_job/434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf",
+			"--InMAPData=file://test/test/inputs/434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf",
 			"--LogFile=file://test/test/test_user/test_job/LogFile",
 			"--NumIterations=0",
 			"--OutputFile=file://test/test/test_user/test_job/OutputFile.shp",
 			"--OutputVariables={\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
-			"--VarGrid.CensusFile=file://test/test/test_user/test_job/72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shp",
+			"--VarGrid.CensusFile=file://test/test/inputs/d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.shp",
 			"--VarGrid.CensusPopColumns=TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
+			"--VarGrid.GridFile=",
 			"--VarGrid.GridProj=+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
 			"--VarGrid.HiResLayers=1",
 			"--VarGrid.MortalityRateColumns={\"AllCause\":\"TotalPop\",\"AsianMort\":\"Asian\",\"BlackMort\":\"Black\",\"LatinoMort\":\"Latino\",\"NativeMort\":\"Native\",\"WhNoLMort\":\"WhiteNoLat\"}\n",
-			"--VarGrid.MortalityRateFile=file://test/test/test_user/test_job/764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shp",
+			"--VarGrid.MortalityRateFile=file://test/test/inputs/d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.shp",
 			"--VarGrid.PopConcThreshold=1e-09", "--VarGrid.PopDensityThreshold=0.0055",
-			"--VarGrid.PopGridColumn=TotalPop", "--VarGrid.PopThreshold=40000", "--VarGrid.VariableGridDx=4000",
+			"--VarGrid.PopGridColumn=TotalPop", "--VarGrid.PopThreshold=40000",
+			"--VarGrid.RefinementCriteria=", "--VarGrid.RefinementRule=Population", "--VarGrid.VariableGridDx=4000",
 			"--VarGrid.VariableGridDy=4000", "--VarGrid.VariableGridXo=-4000", "--VarGrid.VariableGridYo=-4000",
 			"--VarGrid.Xnests=2,2,2", "--VarGrid.Ynests=2,2,2",
-			"--VariableGridData=file://test/test/test_user/test_job/26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
-			"--aep.GridRef=file://test/test/test_user/test_job/d471298031ee531438f90ae92878df0aae1f76fb81424e1f223bf7a602a1864c.txt",
-			"--aep.InventoryConfig.COARDSFiles={\"all\":[\"file://test/test/test_user/test_job/ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"]}",
+			"--VariableGridData=file://test/test/inputs/26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
+			"--aep.GridRef=file://test/test/inputs/d471298031ee531438f90ae92878df0aae1f76fb81424e1f223bf7a602a1864c.txt",
+			"--aep.InventoryConfig.COARDSFiles={\"all\":[\"file://test/test/inputs/ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"]}",
 			"--aep.InventoryConfig.COARDSYear=2016",
+			"--aep.InventoryConfig.ControlFiles=",
 			"--aep.InventoryConfig.InputUnits=tons",
 			"--aep.InventoryConfig.NEIFiles=",
+			"--aep.InventoryConfig.ProjectionBaseYear=0",
+			"--aep.InventoryConfig.ProjectionFiles=",
+			"--aep.InventoryConfig.ProjectionYear=0",
+			"--aep.OSMFile=",
 			"--aep.PostGISURL=" + postGISURL,
 			"--aep.SCCExactMatch=true",
 			"--aep.SpatialConfig.GridName=inmap",
@@ -238,8 +265,21 @@ func TestClient_fakeCOARDS(t *testing.T) {
 			"--aep.SpatialConfig.MaxCacheEntries=10",
 			"--aep.SpatialConfig.SpatialCache=",
 			"--aep.SpatialConfig.SrgDataCache=",
+			"--aep.SpeciateConfig.ChemicalMechanism=",
+			"--aep.SpeciateConfig.GasProfile=",
+			"--aep.SpeciateConfig.GasSpecies=",
+			"--aep.SpeciateConfig.MechAssignment=",
+			"--aep.SpeciateConfig.MolarWeight=",
+			"--aep.SpeciateConfig.OtherGasSpecies=",
+			"--aep.SpeciateConfig.PMSpecies=",
+			"--aep.SpeciateConfig.SpecRef=",
+			"--aep.SpeciateConfig.SpecRefCombo=",
+			"--aep.SpeciateConfig.SpeciesGroups={}\n",
+			"--aep.SpeciateConfig.SpeciesInfo=",
+			"--aep.SpeciateConfig.SpeciesProperties=",
 			"--aep.SrgShapefileDirectory=no_default",
-			"--aep.SrgSpecOSM=file://test/test/test_user/test_job/b43a1c3e7e6841aadfb0b9efeb36f07a26e9cb04af5e3c6057e9ea4f4be4e9cd.json",
+			"--aep.SrgSpecOSM=file://test/test/inputs/b43a1c3e7e6841aadfb0b9efeb36f07a26e9cb04af5e3c6057e9ea4f4be4e9cd.json",
+			"--aep.SrgSpecRaster=",
 			"--aep.SrgSpecSMOKE=",
 		}
 		if len(cmd) != len(wantCmd) {
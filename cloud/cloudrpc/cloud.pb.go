@@ -70,10 +70,17 @@ type JobSpec struct {
 	// simulation.
 	MemoryGB int32 `protobuf:"varint,5,opt,name=MemoryGB,proto3" json:"MemoryGB,omitempty"`
 	// FileData holds the contents of any local files referred to by Args
-	FileData             map[string][]byte `protobuf:"bytes,7,rep,name=FileData,proto3" json:"FileData,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	FileData map[string][]byte `protobuf:"bytes,7,rep,name=FileData,proto3" json:"FileData,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Priority specifies the priority of the job in the job queue.
+	// Jobs with higher priorities are run first.
+	Priority int32 `protobuf:"varint,8,opt,name=Priority,proto3" json:"Priority,omitempty"`
+	// StagedFiles holds the names of any content-addressed input files
+	// referred to by Args that have already been staged on the server
+	// and are therefore not included in FileData.
+	StagedFiles          []string `protobuf:"bytes,9,rep,name=StagedFiles,proto3" json:"StagedFiles,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *JobSpec) Reset()         { *m = JobSpec{} }
@@ -143,13 +150,31 @@ func (m *JobSpec) GetFileData() map[string][]byte {
 	return nil
 }
 
+func (m *JobSpec) GetPriority() int32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
+func (m *JobSpec) GetStagedFiles() []string {
+	if m != nil {
+		return m.StagedFiles
+	}
+	return nil
+}
+
 type JobStatus struct {
 	// Status holds the current status of the job.
 	Status  Status `protobuf:"varint,1,opt,name=Status,proto3,enum=cloudrpc.Status" json:"Status,omitempty"`
 	Message string `protobuf:"bytes,2,opt,name=Message,proto3" json:"Message,omitempty"`
 	// Unix time, the number of seconds elapsed since January 1, 1970 UTC
-	StartTime            int64    `protobuf:"varint,3,opt,name=StartTime,proto3" json:"StartTime,omitempty"`
-	CompletionTime       int64    `protobuf:"varint,4,opt,name=CompletionTime,proto3" json:"CompletionTime,omitempty"`
+	StartTime      int64 `protobuf:"varint,3,opt,name=StartTime,proto3" json:"StartTime,omitempty"`
+	CompletionTime int64 `protobuf:"varint,4,opt,name=CompletionTime,proto3" json:"CompletionTime,omitempty"`
+	// QueuePosition is the position of the job in the job queue,
+	// where 1 is the next job to be run. It is zero if the job
+	// is not queued.
+	QueuePosition        int32    `protobuf:"varint,5,opt,name=QueuePosition,proto3" json:"QueuePosition,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -208,6 +233,13 @@ func (m *JobStatus) GetCompletionTime() int64 {
 	return 0
 }
 
+func (m *JobStatus) GetQueuePosition() int32 {
+	if m != nil {
+		return m.QueuePosition
+	}
+	return 0
+}
+
 type JobOutput struct {
 	// Files holds the contents of each output file.
 	Files                map[string][]byte `protobuf:"bytes,1,rep,name=Files,proto3" json:"Files,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
@@ -297,6 +329,195 @@ func (m *JobName) GetName() string {
 	return ""
 }
 
+// JobLog is a log message from a running job.
+type JobLog struct {
+	// Unix time, the number of seconds elapsed since January 1, 1970 UTC
+	Time int64 `protobuf:"varint,1,opt,name=Time,proto3" json:"Time,omitempty"`
+	// Message holds a line of log output from the job.
+	Message string `protobuf:"bytes,2,opt,name=Message,proto3" json:"Message,omitempty"`
+	// Progress holds information about the progress of the
+	// simulation, if this message reports it.
+	Progress *JobProgress `protobuf:"bytes,3,opt,name=Progress,proto3" json:"Progress,omitempty"`
+	// Status holds the status of the job, if it has changed.
+	Status               *JobStatus `protobuf:"bytes,4,opt,name=Status,proto3" json:"Status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *JobLog) Reset()         { *m = JobLog{} }
+func (m *JobLog) String() string { return proto.CompactTextString(m) }
+func (*JobLog) ProtoMessage()    {}
+func (*JobLog) Descriptor() ([]byte, []int) {
+	return fileDescriptor_01f9cba63d8f209f, []int{4}
+}
+
+func (m *JobLog) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_JobLog.Unmarshal(m, b)
+}
+func (m *JobLog) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_JobLog.Marshal(b, m, deterministic)
+}
+func (m *JobLog) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_JobLog.Merge(m, src)
+}
+func (m *JobLog) XXX_Size() int {
+	return xxx_messageInfo_JobLog.Size(m)
+}
+func (m *JobLog) XXX_DiscardUnknown() {
+	xxx_messageInfo_JobLog.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_JobLog proto.InternalMessageInfo
+
+func (m *JobLog) GetTime() int64 {
+	if m != nil {
+		return m.Time
+	}
+	return 0
+}
+
+func (m *JobLog) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+func (m *JobLog) GetProgress() *JobProgress {
+	if m != nil {
+		return m.Progress
+	}
+	return nil
+}
+
+func (m *JobLog) GetStatus() *JobStatus {
+	if m != nil {
+		return m.Status
+	}
+	return nil
+}
+
+// JobProgress holds information about the progress of a simulation.
+type JobProgress struct {
+	// Iteration is the current iteration number.
+	Iteration int32 `protobuf:"varint,1,opt,name=Iteration,proto3" json:"Iteration,omitempty"`
+	// SimulationDays is the number of days in simulation time since the
+	// start of the simulation.
+	SimulationDays float64 `protobuf:"fixed64,2,opt,name=SimulationDays,proto3" json:"SimulationDays,omitempty"`
+	// WalltimeHours is the total wall time since the beginning of the simulation.
+	WalltimeHours float64 `protobuf:"fixed64,3,opt,name=WalltimeHours,proto3" json:"WalltimeHours,omitempty"`
+	// TimestepSeconds is the duration of the current time step.
+	TimestepSeconds float64 `protobuf:"fixed64,4,opt,name=TimestepSeconds,proto3" json:"TimestepSeconds,omitempty"`
+	// Convergence holds the percent change in each convergence
+	// metric since the last convergence check.
+	Convergence          map[string]float64 `protobuf:"bytes,5,rep,name=Convergence,proto3" json:"Convergence,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *JobProgress) Reset()         { *m = JobProgress{} }
+func (m *JobProgress) String() string { return proto.CompactTextString(m) }
+func (*JobProgress) ProtoMessage()    {}
+func (*JobProgress) Descriptor() ([]byte, []int) {
+	return fileDescriptor_01f9cba63d8f209f, []int{5}
+}
+
+func (m *JobProgress) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_JobProgress.Unmarshal(m, b)
+}
+func (m *JobProgress) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_JobProgress.Marshal(b, m, deterministic)
+}
+func (m *JobProgress) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_JobProgress.Merge(m, src)
+}
+func (m *JobProgress) XXX_Size() int {
+	return xxx_messageInfo_JobProgress.Size(m)
+}
+func (m *JobProgress) XXX_DiscardUnknown() {
+	xxx_messageInfo_JobProgress.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_JobProgress proto.InternalMessageInfo
+
+func (m *JobProgress) GetIteration() int32 {
+	if m != nil {
+		return m.Iteration
+	}
+	return 0
+}
+
+func (m *JobProgress) GetSimulationDays() float64 {
+	if m != nil {
+		return m.SimulationDays
+	}
+	return 0
+}
+
+func (m *JobProgress) GetWalltimeHours() float64 {
+	if m != nil {
+		return m.WalltimeHours
+	}
+	return 0
+}
+
+func (m *JobProgress) GetTimestepSeconds() float64 {
+	if m != nil {
+		return m.TimestepSeconds
+	}
+	return 0
+}
+
+func (m *JobProgress) GetConvergence() map[string]float64 {
+	if m != nil {
+		return m.Convergence
+	}
+	return nil
+}
+
+// InputFiles holds the names of content-addressed input files.
+type InputFiles struct {
+	// Names holds the file names, in the format 'sha256checksum.ext'.
+	Names                []string `protobuf:"bytes,1,rep,name=Names,proto3" json:"Names,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *InputFiles) Reset()         { *m = InputFiles{} }
+func (m *InputFiles) String() string { return proto.CompactTextString(m) }
+func (*InputFiles) ProtoMessage()    {}
+func (*InputFiles) Descriptor() ([]byte, []int) {
+	return fileDescriptor_01f9cba63d8f209f, []int{6}
+}
+
+func (m *InputFiles) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_InputFiles.Unmarshal(m, b)
+}
+func (m *InputFiles) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_InputFiles.Marshal(b, m, deterministic)
+}
+func (m *InputFiles) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_InputFiles.Merge(m, src)
+}
+func (m *InputFiles) XXX_Size() int {
+	return xxx_messageInfo_InputFiles.Size(m)
+}
+func (m *InputFiles) XXX_DiscardUnknown() {
+	xxx_messageInfo_InputFiles.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_InputFiles proto.InternalMessageInfo
+
+func (m *InputFiles) GetNames() []string {
+	if m != nil {
+		return m.Names
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("cloudrpc.Status", Status_name, Status_value)
 	proto.RegisterType((*JobSpec)(nil), "cloudrpc.JobSpec")
@@ -305,40 +526,60 @@ func init() {
 	proto.RegisterType((*JobOutput)(nil), "cloudrpc.JobOutput")
 	proto.RegisterMapType((map[string][]byte)(nil), "cloudrpc.JobOutput.FilesEntry")
 	proto.RegisterType((*JobName)(nil), "cloudrpc.JobName")
+	proto.RegisterType((*JobLog)(nil), "cloudrpc.JobLog")
+	proto.RegisterType((*JobProgress)(nil), "cloudrpc.JobProgress")
+	proto.RegisterMapType((map[string]float64)(nil), "cloudrpc.JobProgress.ConvergenceEntry")
+	proto.RegisterType((*InputFiles)(nil), "cloudrpc.InputFiles")
 }
 
 func init() { proto.RegisterFile("cloud.proto", fileDescriptor_01f9cba63d8f209f) }
 
 var fileDescriptor_01f9cba63d8f209f = []byte{
-	// 445 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x53, 0xd1, 0x8a, 0xd3, 0x40,
-	0x14, 0xed, 0x34, 0x6d, 0x9a, 0xde, 0xae, 0x4b, 0xbc, 0xfa, 0x10, 0x8a, 0x68, 0xc8, 0x83, 0x04,
-	0x1f, 0x8a, 0x54, 0xc1, 0xc5, 0x7d, 0xd2, 0xae, 0x2b, 0x2e, 0x54, 0x65, 0x56, 0xf4, 0x79, 0xda,
-	0x0e, 0x25, 0x98, 0x64, 0x42, 0x66, 0x22, 0x14, 0xbf, 0xc3, 0x7f, 0xf3, 0x3f, 0xfc, 0x01, 0xb9,
-	0x33, 0xc9, 0x96, 0xba, 0xa2, 0xf4, 0xed, 0x9e, 0x33, 0xe7, 0x30, 0xe7, 0x9e, 0x4c, 0x60, 0xb2,
-	0xce, 0x55, 0xb3, 0x99, 0x55, 0xb5, 0x32, 0x0a, 0x03, 0x0b, 0xea, 0x6a, 0x9d, 0xfc, 0x62, 0x30,
-	0xba, 0x52, 0xab, 0xeb, 0x4a, 0xae, 0x31, 0x82, 0xd1, 0x67, 0x59, 0xeb, 0x4c, 0x95, 0x11, 0x8b,
-	0x59, 0x3a, 0xe6, 0x1d, 0x44, 0x84, 0xc1, 0x7b, 0x51, 0xc8, 0xa8, 0x6f, 0x69, 0x3b, 0x63, 0x08,
-	0xde, 0xa2, 0xd8, 0x44, 0x5e, 0xec, 0xa5, 0x63, 0x4e, 0x23, 0xa9, 0x5e, 0xd5, 0x5b, 0x1d, 0x0d,
-	0x2c, 0x65, 0x67, 0x9c, 0x42, 0xb0, 0x94, 0x85, 0xaa, 0x77, 0x6f, 0x5f, 0x47, 0xc3, 0x98, 0xa5,
-	0x43, 0x7e, 0x83, 0xf1, 0x1c, 0x82, 0xcb, 0x2c, 0x97, 0x17, 0xc2, 0x88, 0x68, 0x14, 0x7b, 0xe9,
-	0x64, 0xfe, 0x68, 0xd6, 0x05, 0x9b, 0xb5, 0xa1, 0x66, 0x9d, 0xe2, 0x4d, 0x69, 0xea, 0x1d, 0xbf,
-	0x31, 0x4c, 0xcf, 0xe1, 0xce, 0xc1, 0x11, 0xe5, 0xf9, 0x2a, 0x77, 0x6d, 0x72, 0x1a, 0xf1, 0x3e,
-	0x0c, 0xbf, 0x89, 0xbc, 0x71, 0xb1, 0x4f, 0xb8, 0x03, 0x2f, 0xfb, 0x67, 0x2c, 0xf9, 0xc1, 0x60,
-	0x4c, 0x17, 0x18, 0x61, 0x1a, 0x8d, 0x29, 0xf8, 0x6e, 0xb2, 0xe6, 0xd3, 0x79, 0xb8, 0x4f, 0xe1,
-	0x78, 0xde, 0x9e, 0x53, 0x43, 0x4b, 0xa9, 0xb5, 0xd8, 0x76, 0x55, 0x74, 0x10, 0x1f, 0xc0, 0xf8,
-	0xda, 0x88, 0xda, 0x7c, 0xca, 0x0a, 0x19, 0x79, 0x31, 0x4b, 0x3d, 0xbe, 0x27, 0xf0, 0x31, 0x9c,
-	0x2e, 0x54, 0x51, 0xe5, 0xd2, 0x64, 0xaa, 0xb4, 0x92, 0x81, 0x95, 0xfc, 0xc1, 0x26, 0xdf, 0x6d,
-	0xac, 0x0f, 0x8d, 0xa9, 0x1a, 0x83, 0xcf, 0x61, 0x48, 0x1b, 0x52, 0x2a, 0xea, 0xe6, 0xe1, 0x41,
-	0x37, 0x4e, 0x63, 0xdb, 0xd1, 0xae, 0x1a, 0x27, 0x9e, 0x9e, 0x01, 0xec, 0xc9, 0xa3, 0x4a, 0x79,
-	0x61, 0x5f, 0x82, 0xfd, 0xb6, 0x47, 0xbd, 0x84, 0x27, 0xef, 0xba, 0xfe, 0xf0, 0x04, 0x82, 0x76,
-	0x23, 0x19, 0xf6, 0x10, 0xc0, 0xbf, 0x14, 0x59, 0x2e, 0x37, 0x21, 0xc3, 0x09, 0x8c, 0x96, 0x99,
-	0xd6, 0x59, 0xb9, 0x0d, 0xfb, 0x04, 0x78, 0x53, 0x96, 0x04, 0x3c, 0x02, 0x5f, 0x44, 0x66, 0x08,
-	0x0c, 0xe6, 0x3f, 0x19, 0x04, 0x0b, 0x5a, 0x93, 0x7f, 0x5c, 0xe0, 0x1c, 0x7c, 0xde, 0x94, 0x57,
-	0x6a, 0x85, 0x77, 0x6f, 0xbd, 0x8b, 0xe9, 0xbd, 0x43, 0xca, 0xde, 0x9f, 0xf4, 0xc8, 0xd3, 0x66,
-	0x39, 0xf4, 0x50, 0xd0, 0x7f, 0x78, 0xda, 0xca, 0xff, 0xeb, 0x71, 0xba, 0xa4, 0x87, 0x4f, 0xc1,
-	0xbf, 0x90, 0xb4, 0xe7, 0xdf, 0x3c, 0xb7, 0xa9, 0xa4, 0xb7, 0xf2, 0xed, 0xaf, 0xf7, 0xec, 0x77,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0x73, 0x40, 0xcd, 0x57, 0x89, 0x03, 0x00, 0x00,
+	// 696 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0xed, 0x6a, 0xdb, 0x48,
+	0x14, 0xf5, 0x58, 0xfe, 0xbc, 0x4a, 0xb2, 0xda, 0xd9, 0x2c, 0x08, 0xb3, 0x6c, 0x8d, 0x28, 0x41,
+	0xb4, 0xe0, 0xa6, 0x6e, 0xa1, 0xa1, 0xa1, 0x85, 0xd6, 0x69, 0x9a, 0x84, 0xa4, 0x4d, 0xc7, 0xa5,
+	0xf9, 0x2d, 0xdb, 0x83, 0x10, 0x95, 0x34, 0x62, 0x66, 0x14, 0x30, 0x7d, 0x8f, 0xbe, 0x45, 0x7f,
+	0xf4, 0x77, 0x5f, 0xa0, 0x8f, 0x55, 0x66, 0x46, 0xb2, 0x2d, 0xc7, 0x24, 0xe4, 0xdf, 0x3d, 0x47,
+	0xe7, 0x0e, 0xf7, 0x9e, 0x73, 0x13, 0x83, 0x3d, 0x8d, 0x59, 0x3e, 0x1b, 0x64, 0x9c, 0x49, 0x86,
+	0x3b, 0x1a, 0xf0, 0x6c, 0xea, 0xfd, 0xac, 0x43, 0xfb, 0x8c, 0x4d, 0xc6, 0x19, 0x9d, 0x62, 0x17,
+	0xda, 0x5f, 0x28, 0x17, 0x11, 0x4b, 0x5d, 0xd4, 0x47, 0x7e, 0x97, 0x94, 0x10, 0x63, 0x68, 0x7c,
+	0x08, 0x12, 0xea, 0xd6, 0x35, 0xad, 0x6b, 0xec, 0x80, 0x35, 0x4a, 0x66, 0xae, 0xd5, 0xb7, 0xfc,
+	0x2e, 0x51, 0xa5, 0x52, 0xbd, 0xe1, 0xa1, 0x70, 0x1b, 0x9a, 0xd2, 0x35, 0xee, 0x41, 0xe7, 0x82,
+	0x26, 0x8c, 0xcf, 0xdf, 0xbf, 0x75, 0x9b, 0x7d, 0xe4, 0x37, 0xc9, 0x02, 0xe3, 0x43, 0xe8, 0x1c,
+	0x47, 0x31, 0x3d, 0x0a, 0x64, 0xe0, 0xb6, 0xfb, 0x96, 0x6f, 0x0f, 0x1f, 0x0c, 0xca, 0xc1, 0x06,
+	0xc5, 0x50, 0x83, 0x52, 0xf1, 0x2e, 0x95, 0x7c, 0x4e, 0x16, 0x0d, 0xea, 0xe1, 0x4b, 0x1e, 0x31,
+	0x1e, 0xc9, 0xb9, 0xdb, 0x31, 0x0f, 0x97, 0x18, 0xf7, 0xc1, 0x1e, 0xcb, 0x20, 0xa4, 0x33, 0xa5,
+	0x16, 0x6e, 0x57, 0xcf, 0xb3, 0x4a, 0xf5, 0x0e, 0x61, 0xbb, 0xf2, 0xb0, 0xda, 0xe6, 0x2b, 0x9d,
+	0x17, 0x7b, 0xab, 0x12, 0xef, 0x42, 0xf3, 0x3a, 0x88, 0x73, 0xb3, 0xf4, 0x16, 0x31, 0xe0, 0x65,
+	0xfd, 0x00, 0x79, 0xbf, 0x10, 0x74, 0xd5, 0x78, 0x32, 0x90, 0xb9, 0xc0, 0x3e, 0xb4, 0x4c, 0xa5,
+	0x9b, 0x77, 0x86, 0xce, 0x72, 0x07, 0xc3, 0x93, 0xe2, 0xbb, 0xf2, 0xf7, 0x82, 0x0a, 0x11, 0x84,
+	0xa5, 0x91, 0x25, 0xc4, 0xff, 0x41, 0x77, 0x2c, 0x03, 0x2e, 0x3f, 0x47, 0x09, 0x75, 0xad, 0x3e,
+	0xf2, 0x2d, 0xb2, 0x24, 0xf0, 0x1e, 0xec, 0x8c, 0x58, 0x92, 0xc5, 0x54, 0x46, 0x2c, 0xd5, 0x92,
+	0x86, 0x96, 0xac, 0xb1, 0xf8, 0x21, 0x6c, 0x7f, 0xca, 0x69, 0x4e, 0x2f, 0x99, 0x88, 0x14, 0x59,
+	0x18, 0x5e, 0x25, 0xbd, 0x6f, 0x7a, 0xf8, 0x8f, 0xb9, 0xcc, 0x72, 0x89, 0x9f, 0x43, 0xd3, 0x78,
+	0x84, 0xb4, 0xff, 0xff, 0x57, 0xfc, 0x37, 0x1a, 0x9d, 0x80, 0x30, 0xf6, 0x1b, 0x71, 0xef, 0x00,
+	0x60, 0x49, 0xde, 0xcb, 0xba, 0x17, 0xfa, 0xda, 0xf4, 0xfd, 0xdc, 0xeb, 0xda, 0xbc, 0xef, 0x08,
+	0x5a, 0x67, 0x6c, 0x72, 0xce, 0x42, 0xf5, 0x59, 0x9b, 0x80, 0xb4, 0x09, 0xba, 0xbe, 0xc5, 0xda,
+	0xa7, 0xea, 0x4e, 0x58, 0xc8, 0xa9, 0x10, 0xda, 0x59, 0x7b, 0xf8, 0x6f, 0x65, 0xc9, 0xf2, 0x23,
+	0x59, 0xc8, 0xf0, 0xe3, 0x45, 0xa2, 0x0d, 0xdd, 0xf0, 0x4f, 0xf5, 0x2a, 0x2b, 0xa1, 0x7a, 0x3f,
+	0xea, 0x60, 0xaf, 0x3c, 0xa3, 0xa2, 0x3c, 0x95, 0x94, 0x07, 0xb2, 0x5c, 0xac, 0x49, 0x96, 0x84,
+	0x8a, 0x72, 0x1c, 0x25, 0x79, 0xac, 0xd1, 0x51, 0x30, 0x17, 0x7a, 0x5c, 0x44, 0xd6, 0x58, 0x15,
+	0xe5, 0x55, 0x10, 0xc7, 0x32, 0x4a, 0xe8, 0x09, 0xcb, 0xb9, 0x19, 0x1d, 0x91, 0x2a, 0x89, 0x7d,
+	0xf8, 0x4b, 0x6d, 0x2f, 0x24, 0xcd, 0xc6, 0x74, 0xca, 0xd2, 0x99, 0x99, 0x18, 0x91, 0x75, 0x1a,
+	0x9f, 0x80, 0x3d, 0x62, 0xe9, 0x35, 0xe5, 0x21, 0x4d, 0xa7, 0xd4, 0x6d, 0xea, 0xb4, 0xf7, 0x36,
+	0x1a, 0x31, 0x58, 0x11, 0x9a, 0xd4, 0x57, 0x5b, 0x7b, 0xaf, 0xc1, 0x59, 0x17, 0xdc, 0x75, 0x01,
+	0x68, 0xf5, 0x02, 0x3c, 0x80, 0xd3, 0x34, 0xcb, 0xa5, 0x3e, 0x20, 0xa5, 0x53, 0xf1, 0x9a, 0xfb,
+	0xeb, 0x12, 0x03, 0x1e, 0x9d, 0x96, 0x01, 0xe0, 0x2d, 0xe8, 0x14, 0x47, 0x4e, 0x9d, 0x1a, 0x06,
+	0x68, 0x1d, 0x07, 0x51, 0x4c, 0x67, 0x0e, 0xc2, 0x36, 0xb4, 0x2f, 0x22, 0x21, 0xa2, 0x34, 0x74,
+	0xea, 0x0a, 0x90, 0x3c, 0x4d, 0x15, 0xb0, 0x14, 0xb8, 0x0a, 0x22, 0xa9, 0x40, 0x63, 0xf8, 0xbb,
+	0x0e, 0x9d, 0x91, 0xda, 0x92, 0x5c, 0x8e, 0xf0, 0x10, 0x5a, 0x24, 0x4f, 0xcf, 0xd8, 0x04, 0xff,
+	0x7d, 0xe3, 0x1f, 0x4d, 0x6f, 0x53, 0xca, 0x5e, 0x4d, 0xf5, 0x14, 0xb3, 0x54, 0x7b, 0xd4, 0xa4,
+	0xb7, 0xf4, 0x14, 0x7f, 0x5f, 0x77, 0xf6, 0x18, 0x9d, 0x57, 0xc3, 0xfb, 0xd0, 0x3a, 0xa2, 0x6a,
+	0xcf, 0x4d, 0x3d, 0x37, 0x29, 0xaf, 0x86, 0x9f, 0x40, 0xe3, 0x9c, 0x85, 0x1b, 0xe7, 0x72, 0x2a,
+	0xd4, 0x39, 0x0b, 0xbd, 0xda, 0x3e, 0xc2, 0xaf, 0x60, 0xbb, 0xb0, 0x4c, 0x27, 0x20, 0xf0, 0xee,
+	0x52, 0xb6, 0xcc, 0xa4, 0xb7, 0x91, 0xf5, 0x6a, 0x93, 0x96, 0xfe, 0xed, 0x78, 0xf6, 0x67, 0x00,
+	0xdc, 0x53, 0xf7, 0xd0, 0x4a, 0x06, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -364,6 +605,13 @@ type CloudRPCClient interface {
 	Output(ctx context.Context, in *JobName, opts ...grpc.CallOption) (*JobOutput, error)
 	// Delete deletes the specified simulation.
 	Delete(ctx context.Context, in *JobName, opts ...grpc.CallOption) (*JobName, error)
+	// Logs streams the log messages and progress of the
+	// requested simulation while it runs.
+	Logs(ctx context.Context, in *JobName, opts ...grpc.CallOption) (CloudRPC_LogsClient, error)
+	// MissingInputs returns the subset of the given
+	// content-addressed input files that have not yet been
+	// staged on the server and must be included in JobSpec.FileData.
+	MissingInputs(ctx context.Context, in *InputFiles, opts ...grpc.CallOption) (*InputFiles, error)
 }
 
 type cloudRPCClient struct {
@@ -410,6 +658,47 @@ func (c *cloudRPCClient) Delete(ctx context.Context, in *JobName, opts ...grpc.C
 	return out, nil
 }
 
+func (c *cloudRPCClient) Logs(ctx context.Context, in *JobName, opts ...grpc.CallOption) (CloudRPC_LogsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_CloudRPC_serviceDesc.Streams[0], "/cloudrpc.CloudRPC/Logs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &cloudRPCLogsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type CloudRPC_LogsClient interface {
+	Recv() (*JobLog, error)
+	grpc.ClientStream
+}
+
+type cloudRPCLogsClient struct {
+	grpc.ClientStream
+}
+
+func (x *cloudRPCLogsClient) Recv() (*JobLog, error) {
+	m := new(JobLog)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *cloudRPCClient) MissingInputs(ctx context.Context, in *InputFiles, opts ...grpc.CallOption) (*InputFiles, error) {
+	out := new(InputFiles)
+	err := c.cc.Invoke(ctx, "/cloudrpc.CloudRPC/MissingInputs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CloudRPCServer is the server API for CloudRPC service.
 type CloudRPCServer interface {
 	// RunJob performs an InMAP simulation and returns the paths to the
@@ -423,6 +712,13 @@ type CloudRPCServer interface {
 	Output(context.Context, *JobName) (*JobOutput, error)
 	// Delete deletes the specified simulation.
 	Delete(context.Context, *JobName) (*JobName, error)
+	// Logs streams the log messages and progress of the
+	// requested simulation while it runs.
+	Logs(*JobName, CloudRPC_LogsServer) error
+	// MissingInputs returns the subset of the given
+	// content-addressed input files that have not yet been
+	// staged on the server and must be included in JobSpec.FileData.
+	MissingInputs(context.Context, *InputFiles) (*InputFiles, error)
 }
 
 func RegisterCloudRPCServer(s *grpc.Server, srv CloudRPCServer) {
@@ -501,6 +797,45 @@ func _CloudRPC_Delete_Handler(srv interface{}, ctx context.Context, dec func(int
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CloudRPC_Logs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(JobName)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CloudRPCServer).Logs(m, &cloudRPCLogsServer{stream})
+}
+
+type CloudRPC_LogsServer interface {
+	Send(*JobLog) error
+	grpc.ServerStream
+}
+
+type cloudRPCLogsServer struct {
+	grpc.ServerStream
+}
+
+func (x *cloudRPCLogsServer) Send(m *JobLog) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _CloudRPC_MissingInputs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InputFiles)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CloudRPCServer).MissingInputs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cloudrpc.CloudRPC/MissingInputs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CloudRPCServer).MissingInputs(ctx, req.(*InputFiles))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _CloudRPC_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "cloudrpc.CloudRPC",
 	HandlerType: (*CloudRPCServer)(nil),
@@ -521,7 +856,17 @@ var _CloudRPC_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _CloudRPC_Delete_Handler,
 		},
+		{
+			MethodName: "MissingInputs",
+			Handler:    _CloudRPC_MissingInputs_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Logs",
+			Handler:       _CloudRPC_Logs_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "cloud.proto",
 }
@@ -12,6 +12,8 @@
 		JobStatus
 		JobOutput
 		JobName
+		JobLog
+		JobProgress
 */
 package cloudrpc
 
@@ -71,6 +73,13 @@ type JobSpec struct {
 	MemoryGB int32
 	// FileData holds the contents of any local files referred to by Args
 	FileData map[string][]byte
+	// Priority specifies the priority of the job in the job queue.
+	// Jobs with higher priorities are run first.
+	Priority int32
+	// StagedFiles holds the names of any content-addressed input files
+	// referred to by Args that have already been staged on the server
+	// and are therefore not included in FileData.
+	StagedFiles []string
 }
 
 // GetVersion gets the Version of the JobSpec.
@@ -121,6 +130,22 @@ func (m *JobSpec) GetFileData() (x map[string][]byte) {
 	return m.FileData
 }
 
+// GetPriority gets the Priority of the JobSpec.
+func (m *JobSpec) GetPriority() (x int32) {
+	if m == nil {
+		return x
+	}
+	return m.Priority
+}
+
+// GetStagedFiles gets the StagedFiles of the JobSpec.
+func (m *JobSpec) GetStagedFiles() (x []string) {
+	if m == nil {
+		return x
+	}
+	return m.StagedFiles
+}
+
 // MarshalToWriter marshals JobSpec to the provided writer.
 func (m *JobSpec) MarshalToWriter(writer jspb.Writer) {
 	if m == nil {
@@ -156,6 +181,14 @@ func (m *JobSpec) MarshalToWriter(writer jspb.Writer) {
 		}
 	}
 
+	if m.Priority != 0 {
+		writer.WriteInt32(8, m.Priority)
+	}
+
+	for _, val := range m.StagedFiles {
+		writer.WriteString(9, val)
+	}
+
 	return
 }
 
@@ -201,6 +234,10 @@ func (m *JobSpec) UnmarshalFromReader(reader jspb.Reader) *JobSpec {
 					m.FileData[key] = value
 				}
 			})
+		case 8:
+			m.Priority = reader.ReadInt32()
+		case 9:
+			m.StagedFiles = append(m.StagedFiles, reader.ReadString())
 		default:
 			reader.SkipField()
 		}
@@ -229,6 +266,10 @@ type JobStatus struct {
 	// Unix time, the number of seconds elapsed since January 1, 1970 UTC
 	StartTime      int64
 	CompletionTime int64
+	// QueuePosition is the position of the job in the job queue,
+	// where 1 is the next job to be run. It is zero if the job
+	// is not queued.
+	QueuePosition int32
 }
 
 // GetStatus gets the Status of the JobStatus.
@@ -263,6 +304,14 @@ func (m *JobStatus) GetCompletionTime() (x int64) {
 	return m.CompletionTime
 }
 
+// GetQueuePosition gets the QueuePosition of the JobStatus.
+func (m *JobStatus) GetQueuePosition() (x int32) {
+	if m == nil {
+		return x
+	}
+	return m.QueuePosition
+}
+
 // MarshalToWriter marshals JobStatus to the provided writer.
 func (m *JobStatus) MarshalToWriter(writer jspb.Writer) {
 	if m == nil {
@@ -285,6 +334,10 @@ func (m *JobStatus) MarshalToWriter(writer jspb.Writer) {
 		writer.WriteInt64(4, m.CompletionTime)
 	}
 
+	if m.QueuePosition != 0 {
+		writer.WriteInt32(5, m.QueuePosition)
+	}
+
 	return
 }
 
@@ -311,6 +364,8 @@ func (m *JobStatus) UnmarshalFromReader(reader jspb.Reader) *JobStatus {
 			m.StartTime = reader.ReadInt64()
 		case 4:
 			m.CompletionTime = reader.ReadInt64()
+		case 5:
+			m.QueuePosition = reader.ReadInt32()
 		default:
 			reader.SkipField()
 		}
@@ -496,6 +551,344 @@ func (m *JobName) Unmarshal(rawBytes []byte) (*JobName, error) {
 	return m, nil
 }
 
+// JobLog is a log message from a running job.
+type JobLog struct {
+	// Unix time, the number of seconds elapsed since January 1, 1970 UTC
+	Time int64
+	// Message holds a line of log output from the job.
+	Message string
+	// Progress holds information about the progress of the
+	// simulation, if this message reports it.
+	Progress *JobProgress
+	// Status holds the status of the job, if it has changed.
+	Status *JobStatus
+}
+
+// GetTime gets the Time of the JobLog.
+func (m *JobLog) GetTime() (x int64) {
+	if m == nil {
+		return x
+	}
+	return m.Time
+}
+
+// GetMessage gets the Message of the JobLog.
+func (m *JobLog) GetMessage() (x string) {
+	if m == nil {
+		return x
+	}
+	return m.Message
+}
+
+// GetProgress gets the Progress of the JobLog.
+func (m *JobLog) GetProgress() (x *JobProgress) {
+	if m == nil {
+		return x
+	}
+	return m.Progress
+}
+
+// GetStatus gets the Status of the JobLog.
+func (m *JobLog) GetStatus() (x *JobStatus) {
+	if m == nil {
+		return x
+	}
+	return m.Status
+}
+
+// MarshalToWriter marshals JobLog to the provided writer.
+func (m *JobLog) MarshalToWriter(writer jspb.Writer) {
+	if m == nil {
+		return
+	}
+
+	if m.Time != 0 {
+		writer.WriteInt64(1, m.Time)
+	}
+
+	if len(m.Message) > 0 {
+		writer.WriteString(2, m.Message)
+	}
+
+	if m.Progress != nil {
+		writer.WriteMessage(3, func() {
+			m.Progress.MarshalToWriter(writer)
+		})
+	}
+
+	if m.Status != nil {
+		writer.WriteMessage(4, func() {
+			m.Status.MarshalToWriter(writer)
+		})
+	}
+
+	return
+}
+
+// Marshal marshals JobLog to a slice of bytes.
+func (m *JobLog) Marshal() []byte {
+	writer := jspb.NewWriter()
+	m.MarshalToWriter(writer)
+	return writer.GetResult()
+}
+
+// UnmarshalFromReader unmarshals a JobLog from the provided reader.
+func (m *JobLog) UnmarshalFromReader(reader jspb.Reader) *JobLog {
+	for reader.Next() {
+		if m == nil {
+			m = &JobLog{}
+		}
+
+		switch reader.GetFieldNumber() {
+		case 1:
+			m.Time = reader.ReadInt64()
+		case 2:
+			m.Message = reader.ReadString()
+		case 3:
+			reader.ReadMessage(func() {
+				m.Progress = m.Progress.UnmarshalFromReader(reader)
+			})
+		case 4:
+			reader.ReadMessage(func() {
+				m.Status = m.Status.UnmarshalFromReader(reader)
+			})
+		default:
+			reader.SkipField()
+		}
+	}
+
+	return m
+}
+
+// Unmarshal unmarshals a JobLog from a slice of bytes.
+func (m *JobLog) Unmarshal(rawBytes []byte) (*JobLog, error) {
+	reader := jspb.NewReader(rawBytes)
+
+	m = m.UnmarshalFromReader(reader)
+
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// JobProgress holds information about the progress of a simulation.
+type JobProgress struct {
+	// Iteration is the current iteration number.
+	Iteration int32
+	// SimulationDays is the number of days in simulation time since the
+	// start of the simulation.
+	SimulationDays float64
+	// WalltimeHours is the total wall time since the beginning of the simulation.
+	WalltimeHours float64
+	// TimestepSeconds is the duration of the current time step.
+	TimestepSeconds float64
+	// Convergence holds the percent change in each convergence
+	// metric since the last convergence check.
+	Convergence map[string]float64
+}
+
+// GetIteration gets the Iteration of the JobProgress.
+func (m *JobProgress) GetIteration() (x int32) {
+	if m == nil {
+		return x
+	}
+	return m.Iteration
+}
+
+// GetSimulationDays gets the SimulationDays of the JobProgress.
+func (m *JobProgress) GetSimulationDays() (x float64) {
+	if m == nil {
+		return x
+	}
+	return m.SimulationDays
+}
+
+// GetWalltimeHours gets the WalltimeHours of the JobProgress.
+func (m *JobProgress) GetWalltimeHours() (x float64) {
+	if m == nil {
+		return x
+	}
+	return m.WalltimeHours
+}
+
+// GetTimestepSeconds gets the TimestepSeconds of the JobProgress.
+func (m *JobProgress) GetTimestepSeconds() (x float64) {
+	if m == nil {
+		return x
+	}
+	return m.TimestepSeconds
+}
+
+// GetConvergence gets the Convergence of the JobProgress.
+func (m *JobProgress) GetConvergence() (x map[string]float64) {
+	if m == nil {
+		return x
+	}
+	return m.Convergence
+}
+
+// MarshalToWriter marshals JobProgress to the provided writer.
+func (m *JobProgress) MarshalToWriter(writer jspb.Writer) {
+	if m == nil {
+		return
+	}
+
+	if m.Iteration != 0 {
+		writer.WriteInt32(1, m.Iteration)
+	}
+
+	if m.SimulationDays != 0 {
+		writer.WriteFloat64(2, m.SimulationDays)
+	}
+
+	if m.WalltimeHours != 0 {
+		writer.WriteFloat64(3, m.WalltimeHours)
+	}
+
+	if m.TimestepSeconds != 0 {
+		writer.WriteFloat64(4, m.TimestepSeconds)
+	}
+
+	if len(m.Convergence) > 0 {
+		for key, value := range m.Convergence {
+			writer.WriteMessage(5, func() {
+				writer.WriteString(1, key)
+				writer.WriteFloat64(2, value)
+			})
+		}
+	}
+
+	return
+}
+
+// Marshal marshals JobProgress to a slice of bytes.
+func (m *JobProgress) Marshal() []byte {
+	writer := jspb.NewWriter()
+	m.MarshalToWriter(writer)
+	return writer.GetResult()
+}
+
+// UnmarshalFromReader unmarshals a JobProgress from the provided reader.
+func (m *JobProgress) UnmarshalFromReader(reader jspb.Reader) *JobProgress {
+	for reader.Next() {
+		if m == nil {
+			m = &JobProgress{}
+		}
+
+		switch reader.GetFieldNumber() {
+		case 1:
+			m.Iteration = reader.ReadInt32()
+		case 2:
+			m.SimulationDays = reader.ReadFloat64()
+		case 3:
+			m.WalltimeHours = reader.ReadFloat64()
+		case 4:
+			m.TimestepSeconds = reader.ReadFloat64()
+		case 5:
+			if m.Convergence == nil {
+				m.Convergence = map[string]float64{}
+			}
+			reader.ReadMessage(func() {
+				var key string
+				var value float64
+				for reader.Next() {
+					switch reader.GetFieldNumber() {
+					case 1:
+						key = reader.ReadString()
+					case 2:
+						value = reader.ReadFloat64()
+					}
+					m.Convergence[key] = value
+				}
+			})
+		default:
+			reader.SkipField()
+		}
+	}
+
+	return m
+}
+
+// Unmarshal unmarshals a JobProgress from a slice of bytes.
+func (m *JobProgress) Unmarshal(rawBytes []byte) (*JobProgress, error) {
+	reader := jspb.NewReader(rawBytes)
+
+	m = m.UnmarshalFromReader(reader)
+
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// InputFiles holds the names of content-addressed input files.
+type InputFiles struct {
+	// Names holds the file names, in the format 'sha256checksum.ext'.
+	Names []string
+}
+
+// GetNames gets the Names of the InputFiles.
+func (m *InputFiles) GetNames() (x []string) {
+	if m == nil {
+		return x
+	}
+	return m.Names
+}
+
+// MarshalToWriter marshals InputFiles to the provided writer.
+func (m *InputFiles) MarshalToWriter(writer jspb.Writer) {
+	if m == nil {
+		return
+	}
+
+	for _, val := range m.Names {
+		writer.WriteString(1, val)
+	}
+
+	return
+}
+
+// Marshal marshals InputFiles to a slice of bytes.
+func (m *InputFiles) Marshal() []byte {
+	writer := jspb.NewWriter()
+	m.MarshalToWriter(writer)
+	return writer.GetResult()
+}
+
+// UnmarshalFromReader unmarshals a InputFiles from the provided reader.
+func (m *InputFiles) UnmarshalFromReader(reader jspb.Reader) *InputFiles {
+	for reader.Next() {
+		if m == nil {
+			m = &InputFiles{}
+		}
+
+		switch reader.GetFieldNumber() {
+		case 1:
+			m.Names = append(m.Names, reader.ReadString())
+		default:
+			reader.SkipField()
+		}
+	}
+
+	return m
+}
+
+// Unmarshal unmarshals a InputFiles from a slice of bytes.
+func (m *InputFiles) Unmarshal(rawBytes []byte) (*InputFiles, error) {
+	reader := jspb.NewReader(rawBytes)
+
+	m = m.UnmarshalFromReader(reader)
+
+	if err := reader.Err(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
 // Reference imports to suppress errors if they are not otherwise used.
 var _ context.Context
 var _ grpcweb.Client
@@ -518,6 +911,13 @@ type CloudRPCClient interface {
 	Output(ctx context.Context, in *JobName, opts ...grpcweb.CallOption) (*JobOutput, error)
 	// Delete deletes the specified simulation.
 	Delete(ctx context.Context, in *JobName, opts ...grpcweb.CallOption) (*JobName, error)
+	// Logs streams the log messages and progress of the
+	// requested simulation while it runs.
+	Logs(ctx context.Context, in *JobName, opts ...grpcweb.CallOption) (CloudRPC_LogsClient, error)
+	// MissingInputs returns the subset of the given
+	// content-addressed input files that have not yet been
+	// staged on the server and must be included in JobSpec.FileData.
+	MissingInputs(ctx context.Context, in *InputFiles, opts ...grpcweb.CallOption) (*InputFiles, error)
 }
 
 type cloudRPCClient struct {
@@ -566,3 +966,44 @@ func (c *cloudRPCClient) Delete(ctx context.Context, in *JobName, opts ...grpcwe
 
 	return new(JobName).Unmarshal(resp)
 }
+
+func (c *cloudRPCClient) Logs(ctx context.Context, in *JobName, opts ...grpcweb.CallOption) (CloudRPC_LogsClient, error) {
+	srv, err := c.client.NewClientStream(ctx, false, true, "Logs", opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.SendMsg(in.Marshal())
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudRPCLogsClient{srv}, nil
+}
+
+type CloudRPC_LogsClient interface {
+	Recv() (*JobLog, error)
+	grpcweb.ClientStream
+}
+
+type cloudRPCLogsClient struct {
+	grpcweb.ClientStream
+}
+
+func (x *cloudRPCLogsClient) Recv() (*JobLog, error) {
+	resp, err := x.RecvMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	return new(JobLog).Unmarshal(resp)
+}
+
+func (c *cloudRPCClient) MissingInputs(ctx context.Context, in *InputFiles, opts ...grpcweb.CallOption) (*InputFiles, error) {
+	resp, err := c.client.RPCCall(ctx, "MissingInputs", in.Marshal(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(InputFiles).Unmarshal(resp)
+}
@@ -20,13 +20,22 @@ package cloud
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"net/url"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spatialmodel/inmap/cloud/cloudrpc"
 	"github.com/spf13/pflag"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // jobOutputAddresses returns the locations of where the output files of the job
@@ -104,25 +113,87 @@ func (c *Client) setOutputPaths(ctx context.Context, job *cloudrpc.JobSpec) erro
 
 // stageInputs stages the input data in blob storage and replaces the input
 // file locations with the actual locations of the staged input files.
-func (c *Client) stageInputs(ctx context.Context, job *cloudrpc.JobSpec) error {
+// Input files are content-addressed: each file is stored once per bucket
+// under its SHA-256 checksum and is shared among all jobs and users.
+// Files listed in job.StagedFiles must already have been staged.
+// The job is recorded as a user of each of its input files, so that the
+// files can be removed by releaseInputs once no job is using them.
+func (c *Client) stageInputs(ctx context.Context, job *cloudrpc.JobSpec) (err error) {
 	bucket, err := OpenBucket(ctx, c.bucketName)
 	if err != nil {
 		return err
 	}
+	defer bucket.Close()
 	url, err := url.Parse(c.bucketName)
 	if err != nil {
 		return fmt.Errorf("inmap/cloud: staging inputs: %v", err)
 	}
-
 	user, err := getUser(ctx)
 	if err != nil {
 		return err
 	}
+
+	// staged returns the contents of a file that is part of the job
+	// or has already been staged.
+	staged := func(fname string) ([]byte, error) {
+		if data, ok := job.FileData[fname]; ok {
+			return data, nil
+		}
+		return readBlob(ctx, bucket, inputKey(url.Path, fname))
+	}
+	fnames := make(map[string]struct{})
 	for fname, data := range job.FileData {
-		filePath := strings.TrimPrefix(url.Path+"/"+user+"/"+job.Name+"/"+fname, "/")
-		if err := writeBlob(ctx, bucket, filePath, data); err != nil {
+		if err := checkInputFile(fname, data, staged); err != nil {
+			return err
+		}
+		fnames[fname] = struct{}{}
+	}
+	for _, fname := range job.StagedFiles {
+		if err := checkInputName(fname); err != nil {
+			return err
+		}
+		fnames[fname] = struct{}{}
+	}
+
+	// Record the references before checking whether the files exist.
+	// A job that is being deleted only removes a file if it doesn't find
+	// any references to it, and it marks the file as being removed before
+	// it looks for references. So, once the references have been recorded
+	// and any removal that was already in progress has finished, a file
+	// that exists will not be removed while this job is using it.
+	if err = c.referenceInputs(ctx, bucket, url.Path, user, job.Name, fnames); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.releaseInputs(ctx, user, job.Name)
+		}
+	}()
+	for fname := range fnames {
+		if err := waitForRelease(ctx, bucket, url.Path, fname); err != nil {
 			return err
 		}
+		key := inputKey(url.Path, fname)
+		exists, err := bucket.Exists(ctx, key)
+		if err != nil {
+			return fmt.Errorf("inmap/cloud: staging inputs: %v", err)
+		}
+		if exists {
+			continue
+		}
+		data, ok := job.FileData[fname]
+		if !ok {
+			// The file may have been removed after the client checked
+			// whether it was staged, so the client should try again
+			// with the file contents included (see IsNotStaged).
+			return status.Errorf(codes.FailedPrecondition, "inmap/cloud: input file %s has not been staged", fname)
+		}
+		if err := writeBlob(ctx, bucket, key, data); err != nil {
+			return err
+		}
+	}
+	for fname := range fnames {
+		filePath := inputKey(url.Path, fname)
 		for i, arg := range job.Args {
 			if strings.Contains(arg, fname) {
 				job.Args[i] = strings.Replace(arg, fname, url.Scheme+"://"+url.Hostname()+"/"+filePath, -1)
@@ -131,3 +202,236 @@ func (c *Client) stageInputs(ctx context.Context, job *cloudrpc.JobSpec) error {
 	}
 	return nil
 }
+
+// referenceInputs records that the given job belonging to the given user
+// uses the staged input files in fnames.
+func (c *Client) referenceInputs(ctx context.Context, bucket *blob.Bucket, bucketPath, user, jobName string, fnames map[string]struct{}) error {
+	if len(fnames) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(fnames))
+	for fname := range fnames {
+		names = append(names, fname)
+		if err := writeBlob(ctx, bucket, inputRefKey(bucketPath, fname, user, jobName), nil); err != nil {
+			return err
+		}
+	}
+	sort.Strings(names)
+	return writeBlob(ctx, bucket, jobInputsKey(bucketPath, user, jobName), []byte(strings.Join(names, "\n")))
+}
+
+// waitForRelease waits until any removals of the staged input file
+// fname that are in progress have finished.
+// Removals that started more than releaseTimeout ago are assumed
+// to have failed and are ignored.
+func waitForRelease(ctx context.Context, bucket *blob.Bucket, bucketPath, fname string) error {
+	for {
+		releasing := false
+		iter := bucket.List(&blob.ListOptions{Prefix: inputReleaseKey(bucketPath, fname, "", "")})
+		for {
+			obj, err := iter.Next(ctx)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("inmap/cloud: checking removal of input file %s: %v", fname, err)
+			}
+			if time.Since(obj.ModTime) < releaseTimeout {
+				releasing = true
+				break
+			}
+		}
+		if !releasing {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(releasePollInterval):
+		}
+	}
+}
+
+const (
+	// releaseTimeout is how long a removal of an input file
+	// can take before it is assumed to have failed.
+	releaseTimeout = time.Minute
+
+	// releasePollInterval is how often to check whether a removal
+	// of an input file has finished.
+	releasePollInterval = 100 * time.Millisecond
+)
+
+// releaseInputs removes the references of the given job belonging to
+// the given user to its staged input files, and removes any of the
+// files that are no longer used by any job. Files are marked as being
+// removed before checking for references so that stageInputs does not
+// use a file that is about to be removed.
+func (c *Client) releaseInputs(ctx context.Context, user, jobName string) error {
+	bucket, err := OpenBucket(ctx, c.bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+	url, err := url.Parse(c.bucketName)
+	if err != nil {
+		return fmt.Errorf("inmap/cloud: releasing inputs: %v", err)
+	}
+	listKey := jobInputsKey(url.Path, user, jobName)
+	exists, err := bucket.Exists(ctx, listKey)
+	if err != nil {
+		return fmt.Errorf("inmap/cloud: releasing inputs: %v", err)
+	}
+	if !exists {
+		return nil // The job doesn't have any staged inputs.
+	}
+	b, err := readBlob(ctx, bucket, listKey)
+	if err != nil {
+		return err
+	}
+	for _, fname := range strings.Split(string(b), "\n") {
+		if fname == "" {
+			continue
+		}
+		if err := deleteIfExists(ctx, bucket, inputRefKey(url.Path, fname, user, jobName)); err != nil {
+			return err
+		}
+		releaseKey := inputReleaseKey(url.Path, fname, user, jobName)
+		if err := writeBlob(ctx, bucket, releaseKey, nil); err != nil {
+			return err
+		}
+		iter := bucket.List(&blob.ListOptions{Prefix: inputRefKey(url.Path, fname, "", "")})
+		if _, err := iter.Next(ctx); err == io.EOF {
+			// The file is no longer being used by any job.
+			if err := deleteIfExists(ctx, bucket, inputKey(url.Path, fname)); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return fmt.Errorf("inmap/cloud: listing references to input file %s: %v", fname, err)
+		}
+		if err := deleteIfExists(ctx, bucket, releaseKey); err != nil {
+			return err
+		}
+	}
+	return bucket.Delete(ctx, listKey)
+}
+
+// deleteIfExists deletes the given blob if it exists.
+func deleteIfExists(ctx context.Context, bucket *blob.Bucket, key string) error {
+	if err := bucket.Delete(ctx, key); err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return fmt.Errorf("inmap/cloud: deleting blob %s: %v", key, err)
+	}
+	return nil
+}
+
+// MissingInputs returns the subset of the given content-addressed
+// input files that have not yet been staged in blob storage.
+func (c *Client) MissingInputs(ctx context.Context, in *cloudrpc.InputFiles) (*cloudrpc.InputFiles, error) {
+	if _, err := getUser(ctx); err != nil {
+		return nil, err
+	}
+	bucket, err := OpenBucket(ctx, c.bucketName)
+	if err != nil {
+		return nil, err
+	}
+	defer bucket.Close()
+	url, err := url.Parse(c.bucketName)
+	if err != nil {
+		return nil, fmt.Errorf("inmap/cloud: checking inputs: %v", err)
+	}
+	o := new(cloudrpc.InputFiles)
+	for _, fname := range in.Names {
+		if err := checkInputName(fname); err != nil {
+			return nil, err
+		}
+		exists, err := bucket.Exists(ctx, inputKey(url.Path, fname))
+		if err != nil {
+			return nil, fmt.Errorf("inmap/cloud: checking inputs: %v", err)
+		}
+		if !exists {
+			o.Names = append(o.Names, fname)
+		}
+	}
+	return o, nil
+}
+
+// inputKey returns the key of the content-addressed input file
+// with the given name in a bucket with the given URL path.
+func inputKey(bucketPath, fname string) string {
+	return strings.TrimPrefix(bucketPath+"/inputs/"+fname, "/")
+}
+
+// inputRefKey returns the key of the blob that records that the
+// given job belonging to the given user uses the content-addressed
+// input file with the given name. If user and jobName are empty, the
+// prefix of the keys of all of the references to the file is returned.
+func inputRefKey(bucketPath, fname, user, jobName string) string {
+	key := inputKey(bucketPath, ".refs/"+fname+"/")
+	if user == "" && jobName == "" {
+		return key
+	}
+	return key + user + "/" + jobName
+}
+
+// inputReleaseKey returns the key of the blob that records that the
+// given job belonging to the given user is removing the content-addressed
+// input file with the given name. If user and jobName are empty, the
+// prefix of the keys of all of the removals of the file is returned.
+func inputReleaseKey(bucketPath, fname, user, jobName string) string {
+	key := inputKey(bucketPath, ".releases/"+fname+"/")
+	if user == "" && jobName == "" {
+		return key
+	}
+	return key + user + "/" + jobName
+}
+
+// jobInputsKey returns the key of the blob that lists the content-addressed
+// input files used by the given job belonging to the given user.
+func jobInputsKey(bucketPath, user, jobName string) string {
+	return inputKey(bucketPath, ".jobs/"+user+"/"+jobName)
+}
+
+// inputName matches the names of content-addressed input files.
+var inputName = regexp.MustCompile(`^[0-9a-f]{64}(\.[0-9A-Za-z_]+)?$`)
+
+// checkInputName returns an error if fname is not in the
+// format 'sha256checksum.ext'.
+func checkInputName(fname string) error {
+	if !inputName.MatchString(fname) {
+		return fmt.Errorf("inmap/cloud: invalid input file name %q", fname)
+	}
+	return nil
+}
+
+// checkInputFile returns an error if the name of an input file
+// does not match its contents. The files that make up a shapefile are
+// named after the combined checksum of all of the files (see shapefileSum),
+// so staged is used to retrieve the contents of the other files.
+func checkInputFile(fname string, data []byte, staged func(fname string) ([]byte, error)) error {
+	if err := checkInputName(fname); err != nil {
+		return err
+	}
+	ext := filepath.Ext(fname)
+	base := strings.TrimSuffix(fname, ext)
+	var sum string
+	if isShapefileExt(ext) {
+		files := map[string][]byte{ext: data}
+		for _, e := range shapefileExts {
+			if e == ext {
+				continue
+			}
+			d, err := staged(base + e)
+			if err != nil {
+				return fmt.Errorf("inmap/cloud: checking input file %s: %v", fname, err)
+			}
+			files[e] = d
+		}
+		sum = shapefileSum(files)
+	} else {
+		sumBytes := sha256.Sum256(data)
+		sum = fmt.Sprintf("%x", sumBytes[0:sha256.Size])
+	}
+	if base != sum {
+		return fmt.Errorf("inmap/cloud: checksum of input file %s does not match its contents", fname)
+	}
+	return nil
+}
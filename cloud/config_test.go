@@ -19,6 +19,12 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package cloud_test
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -41,46 +47,67 @@ func TestRunInputFromViper(t *testing.T) {
 	}
 
 	wantArgs := map[string]string{
-		"--EmissionMaskGeoJSON":               "",
-		"--aep.GridRef":                       "",
-		"--aep.InventoryConfig.NEIFiles":      "",
-		"--aep.SpatialConfig.SpatialCache":    "",
-		"--aep.SpatialConfig.SrgDataCache":    "",
-		"--aep.SrgSpecSMOKE":                  "",
-		"--aep.SrgSpecOSM":                    "",
-		"--VarGrid.MortalityRateFile":         "764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shp",
-		"--VarGrid.VariableGridDx":            "4000",
-		"--NumIterations":                     "0",
-		"--VarGrid.CensusPopColumns":          "TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
-		"--VariableGridData":                  "26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
-		"--OutputVariables":                   "{\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
-		"--OutputFile":                        "inmap_output.shp",
-		"--VarGrid.PopThreshold":              "40000",
-		"--VarGrid.Ynests":                    "2,2,2",
-		"--VarGrid.MortalityRateColumns":      "{\"AllCause\":\"TotalPop\",\"AsianMort\":\"Asian\",\"BlackMort\":\"Black\",\"LatinoMort\":\"Latino\",\"NativeMort\":\"Native\",\"WhNoLMort\":\"WhiteNoLat\"}\n",
-		"--VarGrid.Xnests":                    "2,2,2",
-		"--EmissionsShapefiles":               "258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp",
-		"--VarGrid.PopGridColumn":             "TotalPop",
-		"--VarGrid.GridProj":                  "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
-		"--VarGrid.PopConcThreshold":          "1e-09",
-		"--VarGrid.CensusFile":                "72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shp",
-		"--VarGrid.VariableGridYo":            "-4000",
-		"--InMAPData":                         "434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf",
-		"--VarGrid.VariableGridXo":            "-4000",
-		"--VarGrid.HiResLayers":               "1",
-		"--VarGrid.PopDensityThreshold":       "0.0055",
-		"--VarGrid.VariableGridDy":            "4000",
-		"--EmissionUnits":                     "tons/year",
-		"--LogFile":                           "",
-		"--aep.InventoryConfig.COARDSFiles":   "{\"xxx\":[\"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\",\"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"],\"yyy\":[\"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"]}",
-		"--aep.InventoryConfig.COARDSYear":    "0",
-		"--aep.InventoryConfig.InputUnits":    "no_default",
-		"--aep.SCCExactMatch":                 "true",
-		"--aep.PostGISURL":                    "",
-		"--aep.SpatialConfig.GridName":        "inmap",
-		"--aep.SpatialConfig.InputSR":         "+proj=longlat",
-		"--aep.SpatialConfig.MaxCacheEntries": "10",
-		"--aep.SrgShapefileDirectory":         "no_default",
+		"--EmissionMaskGeoJSON":                    "",
+		"--aep.GridRef":                            "",
+		"--aep.InventoryConfig.NEIFiles":           "",
+		"--aep.SpatialConfig.SpatialCache":         "",
+		"--aep.SpatialConfig.SrgDataCache":         "",
+		"--aep.SrgSpecSMOKE":                       "",
+		"--aep.SrgSpecOSM":                         "",
+		"--aep.SrgSpecRaster":                      "",
+		"--aep.OSMFile":                            "",
+		"--VarGrid.MortalityRateFile":              "d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.shp",
+		"--VarGrid.VariableGridDx":                 "4000",
+		"--NumIterations":                          "0",
+		"--VarGrid.CensusPopColumns":               "TotalPop,WhiteNoLat,Black,Native,Asian,Latino",
+		"--VariableGridData":                       "26b310adcf36530acdb518bd74b61355b2a2e7825c20a07f3631db412c655881.gob",
+		"--OutputVariables":                        "{\"TotalPM25\":\"PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA\",\"TotalPopD\":\"(exp(log(1.078)/10 * TotalPM25) - 1) * TotalPop * AllCause / 100000\"}\n",
+		"--OutputFile":                             "inmap_output.shp",
+		"--VarGrid.PopThreshold":                   "40000",
+		"--VarGrid.GridFile":                       "",
+		"--VarGrid.RefinementCriteria":             "",
+		"--VarGrid.RefinementRule":                 "Population",
+		"--VarGrid.Ynests":                         "2,2,2",
+		"--VarGrid.MortalityRateColumns":           "{\"AllCause\":\"TotalPop\",\"AsianMort\":\"Asian\",\"BlackMort\":\"Black\",\"LatinoMort\":\"Latino\",\"NativeMort\":\"Native\",\"WhNoLMort\":\"WhiteNoLat\"}\n",
+		"--VarGrid.Xnests":                         "2,2,2",
+		"--EmissionsShapefiles":                    "cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shp",
+		"--VarGrid.PopGridColumn":                  "TotalPop",
+		"--VarGrid.GridProj":                       "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
+		"--VarGrid.PopConcThreshold":               "1e-09",
+		"--VarGrid.CensusFile":                     "d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.shp",
+		"--VarGrid.VariableGridYo":                 "-4000",
+		"--InMAPData":                              "434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf",
+		"--VarGrid.VariableGridXo":                 "-4000",
+		"--VarGrid.HiResLayers":                    "1",
+		"--VarGrid.PopDensityThreshold":            "0.0055",
+		"--VarGrid.VariableGridDy":                 "4000",
+		"--EmissionUnits":                          "tons/year",
+		"--LogFile":                                "",
+		"--aep.InventoryConfig.COARDSFiles":        "{\"xxx\":[\"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\",\"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"],\"yyy\":[\"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc\"]}",
+		"--aep.InventoryConfig.COARDSYear":         "0",
+		"--aep.InventoryConfig.ControlFiles":       "",
+		"--aep.InventoryConfig.ProjectionFiles":    "",
+		"--aep.InventoryConfig.ProjectionBaseYear": "0",
+		"--aep.InventoryConfig.ProjectionYear":     "0",
+		"--aep.InventoryConfig.InputUnits":         "no_default",
+		"--aep.SCCExactMatch":                      "true",
+		"--aep.PostGISURL":                         "",
+		"--aep.SpatialConfig.GridName":             "inmap",
+		"--aep.SpatialConfig.InputSR":              "+proj=longlat",
+		"--aep.SpatialConfig.MaxCacheEntries":      "10",
+		"--aep.SrgShapefileDirectory":              "no_default",
+		"--aep.SpeciateConfig.ChemicalMechanism":   "",
+		"--aep.SpeciateConfig.GasProfile":          "",
+		"--aep.SpeciateConfig.GasSpecies":          "",
+		"--aep.SpeciateConfig.MechAssignment":      "",
+		"--aep.SpeciateConfig.MolarWeight":         "",
+		"--aep.SpeciateConfig.OtherGasSpecies":     "",
+		"--aep.SpeciateConfig.PMSpecies":           "",
+		"--aep.SpeciateConfig.SpecRef":             "",
+		"--aep.SpeciateConfig.SpecRefCombo":        "",
+		"--aep.SpeciateConfig.SpeciesGroups":       "{}\n",
+		"--aep.SpeciateConfig.SpeciesInfo":         "",
+		"--aep.SpeciateConfig.SpeciesProperties":   "",
 	}
 	if len(js.Args) != len(wantArgs)*2 {
 		t.Errorf("wrong number of arguments: %d != %d", len(js.Args)/2, len(wantArgs))
@@ -97,18 +124,18 @@ func TestRunInputFromViper(t *testing.T) {
 	}
 
 	wantFiles := map[string]int{
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp": 620,
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.dbf": 869,
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.prj": 432,
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shx": 140,
-		"72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shp": 236,
-		"72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.dbf": 353,
-		"72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.shx": 108,
-		"72f6717ef5f6f9600378fe5b192776ba142b3e93311c3dfd0b67bfecbe399990.prj": 432,
-		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shp": 236,
-		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.shx": 108,
-		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.dbf": 341,
-		"764874ad5081665459c67d40607f68df6fc689aa695b4822e012aef84cba5394.prj": 432,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shp": 620,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.dbf": 869,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.prj": 432,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shx": 140,
+		"d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.shp": 236,
+		"d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.dbf": 353,
+		"d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.shx": 108,
+		"d227c28918d3722dd753a0cfad575f02c8efabbd9f1eeab31bed27934d6d576a.prj": 432,
+		"d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.shp": 236,
+		"d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.shx": 108,
+		"d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.dbf": 341,
+		"d8ac48309196fe21bd6a54d04f6bd9f60a9598c381159feff5b206525b5456cb.prj": 432,
 		"434bf26e3fda1ef9cef7e1fa6cc6b5174d11a22b19cbe10d256adc83b2a97d44.ncf": 14284,
 		"ffe280d818c1549074d0e15cfb74377b891287d7f81a4ad9038d0f65b12f6642.nc":  3484,
 		"2cf092df9eed4646cddfb73ac5bd313e508f56249081b06a6ad7f1607ce1406e.gob": 21305,
@@ -153,9 +180,10 @@ func TestSRPredictInputFromViper(t *testing.T) {
 	wantArgs := map[string]string{
 		"--EmissionMaskGeoJSON": "",
 		"--EmissionUnits":       "tons/year",
-		"--EmissionsShapefiles": "258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp",
+		"--EmissionsShapefiles": "cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shp",
 		"--OutputFile":          "inmap_output.shp",
 		"--OutputVariables":     "{\"PrimPM25\":\"PrimaryPM25\"}",
+		"--SR.AboveTop":         "error",
 		"--SR.OutputFile":       "${INMAP_ROOT_DIR}/cmd/inmap/testdata/output_${InMAPRunType}.shp",
 		"--VarGrid.GridProj":    "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
 	}
@@ -174,10 +202,10 @@ func TestSRPredictInputFromViper(t *testing.T) {
 	}
 
 	wantFiles := map[string]int{
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shx": 140,
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.prj": 432,
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.shp": 620,
-		"258bbcefe8c0073d6f323351463be9e9685e74bb92e367ca769b9536ed247213.dbf": 869,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shx": 140,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.prj": 432,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.shp": 620,
+		"cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219.dbf": 869,
 	}
 	if len(js.FileData) != len(wantFiles) {
 		t.Errorf("incorrect number of files: %d != %d", len(js.FileData), len(wantFiles))
@@ -203,3 +231,110 @@ func TestSRPredictInputFromViper(t *testing.T) {
 		t.Errorf("memory: %d != 1", js.MemoryGB)
 	}
 }
+
+func TestRefinementCriteriaInputFromViper(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		criteria interface{}
+	}{
+		{name: "json", criteria: `{"Sources": {"Type": "point", "File": "../cmd/inmap/testdata/testEmis.shp", "Distance": 100}}`},
+		{name: "map", criteria: map[string]interface{}{
+			"Sources": map[string]interface{}{"Type": "point", "File": "../cmd/inmap/testdata/testEmis.shp", "Distance": 100},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := inmaputil.InitializeConfig()
+			cfg.Set("EmissionsShapefiles", []string{})
+			cfg.Set("VarGrid.RefinementCriteria", test.criteria)
+			js, err := cloud.JobSpec(cfg.Root, cfg.Viper, "latest", "test_job", []string{"run", "steady"}, cfg.InputFiles(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			const sum = "cc7fc3c80b6908149e3511cd56ed42d9d35b1ee7c2982252313e67533db5b219"
+			wantArg := `{"Sources":{"Distance":100,"File":"` + sum + `.shp","Type":"point"}}`
+			var haveArg string
+			for i := 0; i < len(js.Args); i += 2 {
+				if js.Args[i] == "--VarGrid.RefinementCriteria" {
+					haveArg = js.Args[i+1]
+				}
+			}
+			if haveArg != wantArg {
+				t.Errorf("VarGrid.RefinementCriteria: %s != %s", haveArg, wantArg)
+			}
+			for _, ext := range []string{".shp", ".dbf", ".shx", ".prj"} {
+				if _, ok := js.FileData[sum+ext]; !ok {
+					t.Errorf("missing file %s", sum+ext)
+				}
+			}
+		})
+	}
+}
+
+func TestSrgSpecRasterInputFromViper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srgspec_raster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	raster := []byte("raster data")
+	if err := ioutil.WriteFile(filepath.Join(dir, "srg.tif"), raster, 0644); err != nil {
+		t.Fatal(err)
+	}
+	const remote = "https://example.com/srg2.tif"
+	spec := `[
+	{"region": 0, "name": "Population", "code": "100", "file": "srg.tif"},
+	{"region": 0, "name": "Urban land", "code": "200", "file": "srg.tif", "filter": "VALUE=1,16", "categorical": true},
+	{"region": 0, "name": "Remote", "code": "300", "file": "` + remote + `"}
+]`
+	if err := ioutil.WriteFile(filepath.Join(dir, "srgspec.json"), []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := inmaputil.InitializeConfig()
+	cfg.Set("EmissionsShapefiles", []string{})
+	cfg.Set("aep.SrgSpecRaster", filepath.Join(dir, "srgspec.json"))
+	js, err := cloud.JobSpec(cfg.Root, cfg.Viper, "latest", "test_job", []string{"run", "steady"}, cfg.InputFiles(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var specName string
+	for i := 0; i < len(js.Args); i += 2 {
+		if js.Args[i] == "--aep.SrgSpecRaster" {
+			specName = js.Args[i+1]
+		}
+	}
+	specData, ok := js.FileData[specName]
+	if !ok {
+		t.Fatalf("missing raster surrogate specification %s", specName)
+	}
+	if sum := fmt.Sprintf("%x.json", sha256.Sum256(specData)); specName != sum {
+		t.Errorf("specification name: %s != %s", specName, sum)
+	}
+	rasterName := fmt.Sprintf("%x.tif", sha256.Sum256(raster))
+	if !reflect.DeepEqual(js.FileData[rasterName], raster) {
+		t.Errorf("missing raster file %s", rasterName)
+	}
+
+	var specs []struct {
+		Name        string
+		File        string
+		Filter      string
+		Categorical bool
+	}
+	if err := json.Unmarshal(specData, &specs); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		Name        string
+		File        string
+		Filter      string
+		Categorical bool
+	}{
+		{Name: "Population", File: rasterName},
+		{Name: "Urban land", File: rasterName, Filter: "VALUE=1,16", Categorical: true},
+		{Name: "Remote", File: remote},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("specification: %+v != %+v", specs, want)
+	}
+}
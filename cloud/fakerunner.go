@@ -21,6 +21,7 @@ package cloud
 import (
 	"context"
 	"fmt"
+	"io"
 	"os/exec"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/spatialmodel/inmap/cloud/cloudrpc"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	batch "k8s.io/api/batch/v1"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -123,3 +125,61 @@ func (c FakeRPCClient) Output(ctx context.Context, job *cloudrpc.JobName, op ...
 func (c FakeRPCClient) Delete(ctx context.Context, job *cloudrpc.JobName, op ...grpc.CallOption) (*cloudrpc.JobName, error) {
 	return c.Client.Delete(ctx, job)
 }
+
+func (c FakeRPCClient) Logs(ctx context.Context, job *cloudrpc.JobName, op ...grpc.CallOption) (cloudrpc.CloudRPC_LogsClient, error) {
+	s := &fakeLogStream{ctx: ctx, logs: make(chan *cloudrpc.JobLog), done: make(chan struct{})}
+	go func() {
+		s.err = c.Client.Logs(job, s)
+		close(s.done)
+	}()
+	return s, nil
+}
+
+func (c FakeRPCClient) MissingInputs(ctx context.Context, in *cloudrpc.InputFiles, op ...grpc.CallOption) (*cloudrpc.InputFiles, error) {
+	return c.Client.MissingInputs(ctx, in)
+}
+
+// fakeLogStream connects a client and a server for streaming
+// job logs without a network connection.
+type fakeLogStream struct {
+	ctx  context.Context
+	logs chan *cloudrpc.JobLog
+	done chan struct{}
+	err  error
+}
+
+// Send sends a log message to the client.
+func (s *fakeLogStream) Send(l *cloudrpc.JobLog) error {
+	select {
+	case s.logs <- l:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// Recv receives a log message from the server. It returns io.EOF
+// when the server has finished sending messages successfully.
+func (s *fakeLogStream) Recv() (*cloudrpc.JobLog, error) {
+	select {
+	case l := <-s.logs:
+		return l, nil
+	case <-s.done:
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+}
+
+func (s *fakeLogStream) Context() context.Context     { return s.ctx }
+func (s *fakeLogStream) SetHeader(metadata.MD) error  { return nil }
+func (s *fakeLogStream) SendHeader(metadata.MD) error { return nil }
+func (s *fakeLogStream) SetTrailer(metadata.MD)       {}
+func (s *fakeLogStream) Header() (metadata.MD, error) { return nil, nil }
+func (s *fakeLogStream) Trailer() metadata.MD         { return nil }
+func (s *fakeLogStream) CloseSend() error             { return nil }
+func (s *fakeLogStream) SendMsg(m interface{}) error  { return s.Send(m.(*cloudrpc.JobLog)) }
+func (s *fakeLogStream) RecvMsg(m interface{}) error {
+	return fmt.Errorf("inmap/cloud: RecvMsg not supported")
+}
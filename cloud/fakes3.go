@@ -0,0 +1,144 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeS3 is a minimal, in-memory stand-in for an S3-compatible
+// storage service such as MinIO, for testing.
+// It supports path-style addressing only, and it
+// does not check credentials. To use it, run it using an
+// httptest.Server and set the AWS_ENDPOINT_URL_S3 environment variable to
+// the URL of the server.
+type FakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte // Keys are in the format 'bucket/key'.
+}
+
+// NewFakeS3 returns a new FakeS3 without any objects.
+func NewFakeS3() *FakeS3 {
+	return &FakeS3{objects: make(map[string][]byte)}
+}
+
+// Keys returns the keys of all of the stored objects,
+// in the format 'bucket/key'.
+func (s *FakeS3) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *FakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(path, "/") {
+		if r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+			s.list(w, path, r.URL.Query().Get("prefix"))
+			return
+		}
+		http.Error(w, "unsupported request", http.StatusNotImplemented)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[path] = b
+		w.Header().Set("ETag", etag(b))
+	case http.MethodGet, http.MethodHead:
+		b, ok := s.objects[path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>%s not found</Message></Error>", path)
+			}
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", etag(b))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	case http.MethodDelete:
+		delete(s.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported request", http.StatusNotImplemented)
+	}
+}
+
+// list responds to a ListObjectsV2 request.
+func (s *FakeS3) list(w http.ResponseWriter, bucket, prefix string) {
+	type object struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []object
+	}{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+	for k, b := range s.objects {
+		if !strings.HasPrefix(k, bucket+"/"+prefix) {
+			continue
+		}
+		result.Contents = append(result.Contents, object{
+			Key:          strings.TrimPrefix(k, bucket+"/"),
+			LastModified: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         etag(b),
+			Size:         len(b),
+		})
+	}
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func etag(b []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(b))
+}
@@ -20,18 +20,22 @@ package cloud
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/lnashier/viper"
 	"github.com/spatialmodel/inmap/cloud/cloudrpc"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // JobSpec initializes a cloudrpc.JobSpec object from the given
@@ -98,7 +102,11 @@ func JobSpec(root *cobra.Command, config *viper.Viper, version, name string, cmd
 					if val == "no_default" {
 						continue
 					}
-					val, visitErr = localFileToRunInput(val, js)
+					if f.Name == "aep.SrgSpecRaster" {
+						val, visitErr = srgSpecRasterToRunInput(val, js)
+					} else {
+						val, visitErr = localFileToRunInput(val, js)
+					}
 					if visitErr != nil {
 						return
 					}
@@ -122,6 +130,23 @@ func JobSpec(root *cobra.Command, config *viper.Viper, version, name string, cmd
 					panic(err)
 				}
 				argVal = strings.TrimSpace(b.String())
+			case map[string]map[string]interface{}:
+				// The "File" field of each object is an input file.
+				for _, obj := range v {
+					file, ok := obj["File"].(string)
+					if !ok {
+						continue
+					}
+					obj["File"], visitErr = localFileToRunInput(file, js)
+					if visitErr != nil {
+						return
+					}
+				}
+				b := bytes.NewBuffer(nil)
+				if err := json.NewEncoder(b).Encode(v); err != nil {
+					panic(err)
+				}
+				argVal = strings.TrimSpace(b.String())
 			default:
 				panic(fmt.Errorf("invalid type %T", vals))
 			}
@@ -140,7 +165,8 @@ func JobSpec(root *cobra.Command, config *viper.Viper, version, name string, cmd
 	return js, nil
 }
 
-// stringsFromInterface takes an interface{} and returns either a []string or a map[string][]string
+// stringsFromInterface takes an interface{} and returns either a []string or a map[string][]string,
+// or, for maps of objects such as VarGrid.RefinementCriteria, a map[string]map[string]interface{}.
 func stringsFromInterface(val interface{}) interface{} {
 	switch t := val.(type) {
 	case string:
@@ -166,11 +192,19 @@ func stringsFromInterface(val interface{}) interface{} {
 		return t
 	case map[string]interface{}:
 		s := make(map[string][]string)
+		objects := make(map[string]map[string]interface{})
 		for k, vs := range t {
+			if obj, ok := vs.(map[string]interface{}); ok {
+				objects[k] = obj
+				continue
+			}
 			for _, v := range vs.([]interface{}) {
 				s[k] = append(s[k], v.(string))
 			}
 		}
+		if len(objects) > 0 {
+			return objects
+		}
 		return s
 	default:
 		panic(fmt.Errorf("cloud.JobSpec: invalid file field type %T", t))
@@ -178,41 +212,172 @@ func stringsFromInterface(val interface{}) interface{} {
 }
 
 // localFileToRunInput checks if filePath represents a local file (i.e., it doesn't
-// start with http://, https://, gs://, or s3://) and if so copies its contents
+// start with http://, https://, gs://, s3://, or azblob://) and if so copies its contents
 // to the FileData field of ri using 'sha256checksum.ext' as the new file path,
 // and returns the new file path of the file.
 // As a special case, if the file has the extension '.shp', the function
-// will copy the corresponding '.dbf', '.shx', and '.prj' files but only
-// return the path of the original '.shp' file.
+// will copy the corresponding '.dbf', '.shx', and '.prj' files and name
+// all four files after their combined checksum (see shapefileSum),
+// but only return the path of the '.shp' file.
 // filePath can contain environment variables.
 func localFileToRunInput(filePath string, js *cloudrpc.JobSpec) (string, error) {
-	if filePath == "" ||
-		strings.HasPrefix(filePath, "http://") ||
-		strings.HasPrefix(filePath, "https://") ||
-		strings.HasPrefix(filePath, "gs://") ||
-		strings.HasPrefix(filePath, "s3://") {
+	if filePath == "" || isRemote(filePath) {
 		return filePath, nil
 	}
 	filePath = os.ExpandEnv(filePath)
 	ext := filepath.Ext(filePath)
-	data, sum, err := fileContentsAndSum(filePath)
-	if err != nil {
-		return "", err
-	}
-	newPath := sum + ext
-	js.FileData[newPath] = data
 	if ext == ".shp" {
-		for _, newExt := range []string{".dbf", ".shx", ".prj"} {
+		files := make(map[string][]byte)
+		for _, newExt := range shapefileExts {
 			data, _, err := fileContentsAndSum(filePath[0:len(filePath)-4] + newExt)
 			if err != nil {
 				return "", err
 			}
+			files[newExt] = data
+		}
+		sum := shapefileSum(files)
+		for newExt, data := range files {
 			js.FileData[sum+newExt] = data
 		}
+		return sum + ext, nil
+	}
+	data, sum, err := fileContentsAndSum(filePath)
+	if err != nil {
+		return "", err
+	}
+	newPath := sum + ext
+	js.FileData[newPath] = data
+	return newPath, nil
+}
+
+// isRemote returns whether filePath starts with http://, https://, gs://,
+// s3://, or azblob://.
+func isRemote(filePath string) bool {
+	return strings.HasPrefix(filePath, "http://") ||
+		strings.HasPrefix(filePath, "https://") ||
+		strings.HasPrefix(filePath, "gs://") ||
+		strings.HasPrefix(filePath, "s3://") ||
+		strings.HasPrefix(filePath, "azblob://")
+}
+
+// srgSpecRasterToRunInput is like localFileToRunInput, but for raster
+// surrogate specification files. The local raster files that the
+// specification refers to are also copied to the FileData field of js,
+// and the specification is rewritten to refer to them by their new
+// file paths before it is copied. Because all of the files are staged
+// in the same location, the new paths are relative to the location of
+// the staged specification. As when the specification is read locally,
+// relative raster file paths are relative to the directory that holds
+// the specification.
+func srgSpecRasterToRunInput(filePath string, js *cloudrpc.JobSpec) (string, error) {
+	if filePath == "" || isRemote(filePath) {
+		return filePath, nil
+	}
+	filePath = os.ExpandEnv(filePath)
+	data, _, err := fileContentsAndSum(filePath)
+	if err != nil {
+		return "", err
+	}
+	var specs []map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&specs); err != nil {
+		return "", fmt.Errorf("inmap/cloud: reading raster surrogate specification %s: %v", filePath, err)
+	}
+	dir := filepath.Dir(filePath)
+	for _, spec := range specs {
+		file, ok := spec["file"].(string)
+		if !ok || file == "" {
+			continue
+		}
+		file = os.ExpandEnv(file)
+		if !isRemote(file) && !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		if spec["file"], err = localFileToRunInput(file, js); err != nil {
+			return "", err
+		}
 	}
+	data, err = json.Marshal(specs)
+	if err != nil {
+		return "", err
+	}
+	sumBytes := sha256.Sum256(data)
+	newPath := fmt.Sprintf("%x", sumBytes[0:sha256.Size]) + filepath.Ext(filePath)
+	js.FileData[newPath] = data
 	return newPath, nil
 }
 
+// shapefileExts are the extensions of the files that make up a shapefile.
+var shapefileExts = []string{".shp", ".dbf", ".shx", ".prj"}
+
+// isShapefileExt returns whether ext is one of shapefileExts.
+func isShapefileExt(ext string) bool {
+	for _, e := range shapefileExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// shapefileSum returns the checksum that the files making up a shapefile
+// are named after, where files holds the contents of each file keyed by its
+// extension. The checksum combines the sha256 checksums of all of the files,
+// so a change to any one of them results in a different name.
+func shapefileSum(files map[string][]byte) string {
+	h := sha256.New()
+	for _, ext := range shapefileExts {
+		fmt.Fprintf(h, "%s %x\n", ext, sha256.Sum256(files[ext]))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// RemoveStagedInputs asks the server which of the input files in js
+// have not yet been staged, removes the files that have already been staged
+// from js.FileData, and lists them in js.StagedFiles instead,
+// so that they do not need to be uploaded again.
+// It returns a function that adds the removed files back to js, which
+// should be called before trying again if starting the job fails with
+// an error for which IsNotStaged is true.
+func RemoveStagedInputs(ctx context.Context, c cloudrpc.CloudRPCClient, js *cloudrpc.JobSpec) (restore func(), err error) {
+	in := &cloudrpc.InputFiles{Names: make([]string, 0, len(js.FileData))}
+	for fname := range js.FileData {
+		in.Names = append(in.Names, fname)
+	}
+	sort.Strings(in.Names)
+	missing, err := c.MissingInputs(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("inmap/cloud: checking for staged inputs: %v", err)
+	}
+	m := make(map[string]struct{})
+	for _, fname := range missing.Names {
+		m[fname] = struct{}{}
+	}
+	removed := make(map[string][]byte)
+	for _, fname := range in.Names {
+		if _, ok := m[fname]; !ok {
+			removed[fname] = js.FileData[fname]
+			delete(js.FileData, fname)
+			js.StagedFiles = append(js.StagedFiles, fname)
+		}
+	}
+	restore = func() {
+		for fname, data := range removed {
+			js.FileData[fname] = data
+		}
+		js.StagedFiles = nil
+	}
+	return restore, nil
+}
+
+// IsNotStaged returns whether err was caused by an input file that was
+// listed in JobSpec.StagedFiles not being staged, for example because
+// it was removed after RemoveStagedInputs was called.
+func IsNotStaged(err error) bool {
+	return status.Code(err) == codes.FailedPrecondition
+}
+
 // fileContentsAndSum returns the contents and sha256 checksum of a file.
 func fileContentsAndSum(filePath string) ([]byte, string, error) {
 	var dst bytes.Buffer
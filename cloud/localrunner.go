@@ -0,0 +1,299 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnashier/viper"
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+	"github.com/spf13/cobra"
+)
+
+// LocalRunner is a JobRunner that runs jobs on the local machine,
+// either as subprocesses or in local Docker containers.
+// When jobs are run as subprocesses, the job command
+// (e.g., `inmap`) must be installed locally.
+type LocalRunner struct {
+	// Image, if not empty, specifies that jobs should be run
+	// in Docker containers created from the given image,
+	// e.g., "inmap/inmap". The job version is used as the image tag.
+	// Blob storage buckets must be accessible from within the container,
+	// so the "file" storage provider will typically not work.
+	Image string
+
+	// Docker is the name of the Docker executable.
+	// The default is "docker".
+	Docker string
+
+	mu   sync.Mutex
+	jobs map[string]*localJob
+}
+
+// localJob holds information about a job run by a LocalRunner.
+type localJob struct {
+	cmd    []string
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// output holds the combined standard output and standard
+	// error of the job.
+	output *logBuffer
+
+	// status is protected by the mutex of the LocalRunner.
+	status cloudrpc.JobStatus
+}
+
+// NewLocalRunner returns a LocalRunner that runs jobs in Docker containers
+// created from the given image, or as subprocesses if image is empty.
+func NewLocalRunner(image string) *LocalRunner {
+	return &LocalRunner{
+		Image:  image,
+		Docker: "docker",
+		jobs:   make(map[string]*localJob),
+	}
+}
+
+// NewLocalClient creates a new InMAP client that runs jobs on the local
+// machine using a LocalRunner with the given image, without the need for a
+// Kubernetes cluster. The other arguments are the same as for NewClient.
+func NewLocalClient(image string, root *cobra.Command, config *viper.Viper, bucketName string, inputFileArgs, outputFileArgs []string) (*Client, error) {
+	return NewRunnerClient(NewLocalRunner(image), root, config, bucketName, inputFileArgs, outputFileArgs)
+}
+
+// command returns the command line that should be executed to run
+// the given job.
+func (r *LocalRunner) command(name string, job *cloudrpc.JobSpec) []string {
+	var cmd []string
+	if r.Image != "" {
+		cmd = []string{r.Docker, "run", "--rm", "--name", name}
+		if job.MemoryGB > 0 {
+			cmd = append(cmd, fmt.Sprintf("--memory=%dg", job.MemoryGB))
+		}
+		cmd = append(cmd, fmt.Sprintf("%s:%s", r.Image, job.Version))
+	}
+	cmd = append(cmd, job.Cmd...)
+	for i := 0; i < len(job.Args); i += 2 {
+		cmd = append(cmd, fmt.Sprintf("%s=%s", job.Args[i], job.Args[i+1]))
+	}
+	return cmd
+}
+
+// Run starts running the given job in the background.
+func (r *LocalRunner) Run(ctx context.Context, name string, job *cloudrpc.JobSpec) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.jobs == nil {
+		r.jobs = make(map[string]*localJob)
+	}
+	if _, ok := r.jobs[name]; ok {
+		return fmt.Errorf("inmap/cloud: job %s already exists", name)
+	}
+	if len(job.Cmd) == 0 {
+		return fmt.Errorf("inmap/cloud: job %s has no command", name)
+	}
+
+	// The job should keep running after the request that created it
+	// has finished, so we don't use ctx here.
+	runCtx, cancel := context.WithCancel(context.Background())
+	j := &localJob{
+		cmd:    job.Cmd,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		output: newLogBuffer(),
+	}
+	cmd := r.command(name, job)
+	xcmd := exec.CommandContext(runCtx, cmd[0], cmd[1:]...)
+	xcmd.Stdout = j.output
+	xcmd.Stderr = j.output
+	if err := xcmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("inmap/cloud: starting job %s: %v", name, err)
+	}
+	j.status = cloudrpc.JobStatus{
+		Status:    cloudrpc.Status_Running,
+		StartTime: time.Now().Unix(),
+	}
+	r.jobs[name] = j
+
+	go func() {
+		err := xcmd.Wait()
+		j.output.Close()
+		r.mu.Lock()
+		j.status.CompletionTime = time.Now().Unix()
+		if err != nil {
+			j.status.Status = cloudrpc.Status_Failed
+			j.status.Message = fmt.Sprintf("%v: %s", err, lastLines(j.output.String(), 10))
+		} else {
+			j.status.Status = cloudrpc.Status_Complete
+		}
+		r.mu.Unlock()
+		close(j.done)
+	}()
+	return nil
+}
+
+// Status returns the status of the job with the given name.
+func (r *LocalRunner) Status(ctx context.Context, name string) (*cloudrpc.JobStatus, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot find job %s", name)
+	}
+	s := j.status
+	return &s, j.cmd, nil
+}
+
+// List returns the statuses of all of the jobs.
+func (r *LocalRunner) List(ctx context.Context) (map[string]*cloudrpc.JobStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o := make(map[string]*cloudrpc.JobStatus, len(r.jobs))
+	for name, j := range r.jobs {
+		s := j.status
+		o[name] = &s
+	}
+	return o, nil
+}
+
+// Delete stops the job with the given name if it is running and removes
+// it from the list of jobs.
+func (r *LocalRunner) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	j, ok := r.jobs[name]
+	delete(r.jobs, name)
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("cannot find job %s", name)
+	}
+	j.cancel()
+	if r.Image != "" {
+		// Stopping the docker client doesn't necessarily stop the container.
+		exec.Command(r.Docker, "rm", "--force", name).Run()
+	}
+	<-j.done
+	return nil
+}
+
+// Logs returns the output of the job with the given name.
+func (r *LocalRunner) Logs(ctx context.Context, name string) (io.ReadCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot find job %s", name)
+	}
+	return j.output.NewReader(ctx), nil
+}
+
+// logBuffer holds the output of a job. It can be read
+// from by multiple readers while it is being written to.
+type logBuffer struct {
+	mu      sync.Mutex
+	data    []byte
+	closed  bool
+	changed chan struct{}
+}
+
+func newLogBuffer() *logBuffer {
+	return &logBuffer{changed: make(chan struct{})}
+}
+
+// Write appends p to the buffer and notifies any waiting readers.
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	close(b.changed)
+	b.changed = make(chan struct{})
+	return len(p), nil
+}
+
+// Close marks the buffer as finished, so that readers
+// will receive io.EOF once they have read all the data.
+func (b *logBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.changed)
+	}
+	return nil
+}
+
+// String returns the contents of the buffer.
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// NewReader returns a reader that reads the buffer from the beginning,
+// blocking until more data is available, the buffer is closed,
+// or ctx is canceled.
+func (b *logBuffer) NewReader(ctx context.Context) io.ReadCloser {
+	return &logReader{b: b, ctx: ctx}
+}
+
+type logReader struct {
+	b      *logBuffer
+	ctx    context.Context
+	offset int
+}
+
+func (r *logReader) Read(p []byte) (int, error) {
+	for {
+		r.b.mu.Lock()
+		if r.offset < len(r.b.data) {
+			n := copy(p, r.b.data[r.offset:])
+			r.offset += n
+			r.b.mu.Unlock()
+			return n, nil
+		}
+		if r.b.closed {
+			r.b.mu.Unlock()
+			return 0, io.EOF
+		}
+		changed := r.b.changed
+		r.b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+}
+
+func (r *logReader) Close() error { return nil }
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
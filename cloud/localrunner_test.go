@@ -0,0 +1,117 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+)
+
+// waitLocal waits for the given local job to stop running.
+func waitLocal(r *LocalRunner, name string, t *testing.T) *cloudrpc.JobStatus {
+	for i := 0; i < 100; i++ {
+		s, _, err := r.Status(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Status != cloudrpc.Status_Running {
+			return s
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", name)
+	return nil
+}
+
+func TestLocalRunner(t *testing.T) {
+	ctx := context.Background()
+	r := NewLocalRunner("")
+
+	t.Run("command", func(t *testing.T) {
+		job := &cloudrpc.JobSpec{Cmd: []string{"inmap", "run", "steady"}, Args: []string{"--a", "1", "--b", "x y"}, Version: "v1.7.2", MemoryGB: 2}
+		want := []string{"inmap", "run", "steady", "--a=1", "--b=x y"}
+		if have := r.command("test", job); !reflect.DeepEqual(have, want) {
+			t.Errorf("%v != %v", have, want)
+		}
+		d := NewLocalRunner("inmap/inmap")
+		want = append([]string{"docker", "run", "--rm", "--name", "test", "--memory=2g", "inmap/inmap:v1.7.2"}, want...)
+		if have := d.command("test", job); !reflect.DeepEqual(have, want) {
+			t.Errorf("%v != %v", have, want)
+		}
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		if err := r.Run(ctx, "complete", &cloudrpc.JobSpec{Cmd: []string{"true"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Run(ctx, "complete", &cloudrpc.JobSpec{Cmd: []string{"true"}}); err == nil {
+			t.Error("running a duplicate job should cause an error")
+		}
+		s := waitLocal(r, "complete", t)
+		if s.Status != cloudrpc.Status_Complete {
+			t.Errorf("status: %v", s)
+		}
+		if s.StartTime == 0 || s.CompletionTime < s.StartTime {
+			t.Errorf("invalid times: %v", s)
+		}
+		if err := r.Delete(ctx, "complete"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := r.Status(ctx, "complete"); err == nil {
+			t.Error("deleted job should be missing")
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		if err := r.Run(ctx, "failed", &cloudrpc.JobSpec{Cmd: []string{"sh", "-c", "echo problem; exit 1"}}); err != nil {
+			t.Fatal(err)
+		}
+		s := waitLocal(r, "failed", t)
+		if s.Status != cloudrpc.Status_Failed {
+			t.Errorf("status: %v", s)
+		}
+		if !strings.Contains(s.Message, "problem") {
+			t.Errorf("message should contain job output: %s", s.Message)
+		}
+	})
+
+	t.Run("delete_running", func(t *testing.T) {
+		if err := r.Run(ctx, "running", &cloudrpc.JobSpec{Cmd: []string{"sleep", "60"}}); err != nil {
+			t.Fatal(err)
+		}
+		s, cmd, err := r.Status(ctx, "running")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Status != cloudrpc.Status_Running {
+			t.Errorf("status: %v", s)
+		}
+		if !reflect.DeepEqual(cmd, []string{"sleep", "60"}) {
+			t.Errorf("command: %v", cmd)
+		}
+		if err := r.Delete(ctx, "running"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+)
+
+// logPollInterval specifies how often the status of a waiting
+// job is checked when streaming its logs.
+var logPollInterval = 5 * time.Second
+
+// Logs streams the log output of the given job while it runs, along
+// with any simulation progress information in the output.
+// If the job has not started yet, its status is sent each time it changes
+// until it starts. The final status of the job is sent once it finishes.
+func (c *Client) Logs(job *cloudrpc.JobName, stream cloudrpc.CloudRPC_LogsServer) error {
+	ctx := stream.Context()
+	user, err := getUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the job to start.
+	var lastStatus *cloudrpc.JobStatus
+	for {
+		status, err := c.Status(ctx, job)
+		if err != nil {
+			return err
+		}
+		if status.Status == cloudrpc.Status_Missing {
+			return fmt.Errorf("inmap/cloud: job %s is missing: %s", job.Name, status.Message)
+		}
+		if status.Status != cloudrpc.Status_Waiting {
+			break
+		}
+		if !proto.Equal(status, lastStatus) {
+			if err = stream.Send(&cloudrpc.JobLog{Time: time.Now().Unix(), Status: status}); err != nil {
+				return err
+			}
+			lastStatus = status
+		}
+		select {
+		case <-time.After(logPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	r, err := c.runner.Logs(ctx, userJobName(user, job.Name))
+	if err != nil {
+		if err = stream.Send(&cloudrpc.JobLog{
+			Time:    time.Now().Unix(),
+			Message: fmt.Sprintf("logs are not available: %v", err),
+		}); err != nil {
+			return err
+		}
+	} else {
+		defer r.Close()
+		var p progressParser
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			for _, l := range p.parse(scanner.Text()) {
+				l.Time = time.Now().Unix()
+				if err = stream.Send(l); err != nil {
+					return err
+				}
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return fmt.Errorf("inmap/cloud: reading logs for job %s: %v", job.Name, err)
+		}
+		if l := p.flush(); l != nil {
+			l.Time = time.Now().Unix()
+			if err = stream.Send(l); err != nil {
+				return err
+			}
+		}
+	}
+
+	status, err := c.Status(ctx, job)
+	if err != nil {
+		return err
+	}
+	return stream.Send(&cloudrpc.JobLog{Time: time.Now().Unix(), Status: status})
+}
+
+var (
+	// logPrefix matches the date and time that the standard logger
+	// adds to the beginning of each message.
+	logPrefix = regexp.MustCompile(`^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `)
+
+	// iterationPattern matches the output of inmap.SimulationStatus.String.
+	iterationPattern = regexp.MustCompile(`^iteration\s+(\d+)\s+walltime=\s*(\S+)h\s+Δwalltime=\s*\S+s\s+timestep=\s*(\S+)s\s+day=\s*(\S+)$`)
+
+	// convergencePattern matches the lines following the header in the output
+	// of inmap.ConvergenceStatus.String.
+	convergencePattern = regexp.MustCompile(`^([^:]+):\s+(\S+)%$`)
+)
+
+// convergenceHeader is the first line of the output of
+// inmap.ConvergenceStatus.String.
+const convergenceHeader = "Percent change since last convergence check:"
+
+// progressParser extracts simulation progress information from
+// lines of InMAP log output.
+type progressParser struct {
+	// convergence holds the convergence information that has been
+	// read so far, if a convergence report is being read.
+	convergence map[string]float64
+}
+
+// parse returns the log messages corresponding to the given line of output.
+func (p *progressParser) parse(line string) []*cloudrpc.JobLog {
+	msg := strings.TrimSpace(logPrefix.ReplaceAllString(line, ""))
+	var o []*cloudrpc.JobLog
+	if p.convergence != nil {
+		if m := convergencePattern.FindStringSubmatch(msg); m != nil {
+			if v, err := strconv.ParseFloat(m[2], 64); err == nil {
+				p.convergence[strings.TrimSpace(m[1])] = v
+				return []*cloudrpc.JobLog{{Message: line}}
+			}
+		}
+		o = append(o, p.flush())
+	}
+	l := &cloudrpc.JobLog{Message: line}
+	if msg == convergenceHeader {
+		p.convergence = make(map[string]float64)
+	} else if m := iterationPattern.FindStringSubmatch(msg); m != nil {
+		l.Progress = parseIteration(m)
+	}
+	return append(o, l)
+}
+
+// flush returns a message containing any convergence information that
+// has been read, or nil if there is none.
+func (p *progressParser) flush() *cloudrpc.JobLog {
+	if p.convergence == nil {
+		return nil
+	}
+	l := &cloudrpc.JobLog{Progress: &cloudrpc.JobProgress{Convergence: p.convergence}}
+	p.convergence = nil
+	return l
+}
+
+// parseIteration converts the matches of iterationPattern to
+// a progress report. Values that cannot be parsed are left as zero.
+func parseIteration(m []string) *cloudrpc.JobProgress {
+	p := new(cloudrpc.JobProgress)
+	if i, err := strconv.Atoi(m[1]); err == nil {
+		p.Iteration = int32(i)
+	}
+	p.WalltimeHours, _ = strconv.ParseFloat(m[2], 64)
+	p.TimestepSeconds, _ = strconv.ParseFloat(m[3], 64)
+	p.SimulationDays, _ = strconv.ParseFloat(m[4], 64)
+	return p
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+)
+
+func TestProgressParser(t *testing.T) {
+	lines := []string{
+		"2019/04/05 10:11:12 iteration 12    walltime= 0.0312h  Δwalltime=0.32s  timestep=61s  day=0.0085",
+		"Percent change since last convergence check:",
+		"TotalPM25:\t0.25%",
+		"PNH4:\t-1.5%",
+		"iteration 13    walltime= 0.0313h  Δwalltime=0.31s  timestep=60s  day=0.0092",
+	}
+	var p progressParser
+	var logs []*cloudrpc.JobLog
+	for _, l := range lines {
+		logs = append(logs, p.parse(l)...)
+	}
+	if l := p.flush(); l != nil {
+		t.Errorf("unexpected flushed message: %v", l)
+	}
+	if len(logs) != 6 {
+		t.Fatalf("wrong number of messages: %d", len(logs))
+	}
+	want := &cloudrpc.JobProgress{Iteration: 12, WalltimeHours: 0.0312, TimestepSeconds: 61, SimulationDays: 0.0085}
+	if !reflect.DeepEqual(logs[0].Progress, want) {
+		t.Errorf("iteration: %v != %v", logs[0].Progress, want)
+	}
+	if logs[0].Message != lines[0] {
+		t.Errorf("message: %s", logs[0].Message)
+	}
+	wantConv := map[string]float64{"TotalPM25": 0.25, "PNH4": -1.5}
+	if logs[4].Message != "" || !reflect.DeepEqual(logs[4].Progress.Convergence, wantConv) {
+		t.Errorf("convergence: %v", logs[4])
+	}
+	if logs[5].Progress.Iteration != 13 {
+		t.Errorf("iteration: %v", logs[5].Progress)
+	}
+}
+
+func TestClient_Logs(t *testing.T) {
+	r := NewLocalRunner("")
+	c, err := NewRunnerClient(r, nil, nil, "file://test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "user", "user")
+	err = r.Run(ctx, userJobName("user", "logs"), &cloudrpc.JobSpec{
+		Cmd: []string{"sh", "-c", `sleep 0.1; echo "Percent change since last convergence check:"; printf "TotalPM25:\t1.5%%\n"; exit 1`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := FakeRPCClient{Client: c}.Logs(ctx, &cloudrpc.JobName{Name: "logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	var progress *cloudrpc.JobProgress
+	var status *cloudrpc.JobStatus
+	for {
+		l, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if l.Message != "" {
+			messages = append(messages, l.Message)
+		}
+		if l.Progress != nil {
+			progress = l.Progress
+		}
+		if l.Status != nil {
+			status = l.Status
+		}
+	}
+	wantMessages := []string{"Percent change since last convergence check:", "TotalPM25:\t1.5%"}
+	if !reflect.DeepEqual(messages, wantMessages) {
+		t.Errorf("messages: %q", messages)
+	}
+	if progress == nil || progress.Convergence["TotalPM25"] != 1.5 {
+		t.Errorf("progress: %v", progress)
+	}
+	if status == nil || status.Status != cloudrpc.Status_Failed || !strings.Contains(status.Message, "TotalPM25") {
+		t.Errorf("status: %v", status)
+	}
+
+	stream, err = FakeRPCClient{Client: c}.Logs(ctx, &cloudrpc.JobName{Name: "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err == nil || err == io.EOF {
+		t.Errorf("missing job should cause an error but have %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	batchclient "k8s.io/client-go/kubernetes/typed/batch/v1"
+	coreclient "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// JobRunner executes the jobs that are submitted to a Client.
+// The Client takes care of staging input and output files in blob storage
+// before jobs are passed to the JobRunner.
+type JobRunner interface {
+	// Run starts executing the given job. name is a unique identifier
+	// for the job, combining the user and job names.
+	Run(ctx context.Context, name string, job *cloudrpc.JobSpec) error
+
+	// Status returns the status of the job with the given name
+	// and the command that the job is executing.
+	// An error is returned if the job cannot be found.
+	Status(ctx context.Context, name string) (*cloudrpc.JobStatus, []string, error)
+
+	// List returns the statuses of all of the jobs that the
+	// runner knows about, keyed by job name.
+	List(ctx context.Context) (map[string]*cloudrpc.JobStatus, error)
+
+	// Delete stops and removes the job with the given name.
+	Delete(ctx context.Context, name string) error
+
+	// Logs returns the combined standard output and standard error
+	// of the job with the given name. Reading from the returned
+	// stream blocks until more output is available or the job finishes.
+	Logs(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// kubernetesRunner is a JobRunner that runs jobs on a Kubernetes cluster.
+type kubernetesRunner struct {
+	jobControl batchclient.JobInterface
+	pods       coreclient.PodInterface
+
+	// c is the client the runner belongs to. It is used to
+	// retrieve the volumes to mount in created containers.
+	c *Client
+}
+
+// Run creates (and queues) a Kubernetes job that executes the given job.
+func (r *kubernetesRunner) Run(ctx context.Context, name string, job *cloudrpc.JobSpec) error {
+	k8sJob := createJob(name, job.Cmd, job.Args, job.Version, core.ResourceList{
+		core.ResourceMemory: resource.MustParse(fmt.Sprintf("%dGi", job.MemoryGB)),
+	}, r.c.Volumes)
+	_, err := r.jobControl.Create(ctx, k8sJob, meta.CreateOptions{})
+	return err
+}
+
+// Status returns the status of the Kubernetes job with the given name.
+func (r *kubernetesRunner) Status(ctx context.Context, name string) (*cloudrpc.JobStatus, []string, error) {
+	k8sJob, err := r.getk8sJob(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return k8sJobStatus(k8sJob), k8sJob.Spec.Template.Spec.Containers[0].Command, nil
+}
+
+// List returns the statuses of all of the Kubernetes jobs.
+func (r *kubernetesRunner) List(ctx context.Context) (map[string]*cloudrpc.JobStatus, error) {
+	jobList, err := r.jobControl.List(ctx, meta.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	o := make(map[string]*cloudrpc.JobStatus, len(jobList.Items))
+	for i := range jobList.Items {
+		o[jobList.Items[i].GetName()] = k8sJobStatus(&jobList.Items[i])
+	}
+	return o, nil
+}
+
+// k8sJobStatus returns the status of the given Kubernetes job.
+func k8sJobStatus(k8sJob *batch.Job) *cloudrpc.JobStatus {
+	s := new(cloudrpc.JobStatus)
+	for i, cond := range k8sJob.Status.Conditions {
+		if i != len(k8sJob.Status.Conditions)-1 {
+			continue
+		}
+		if cond.Type == batch.JobComplete && cond.Status == core.ConditionTrue {
+			s.Status = cloudrpc.Status_Complete
+			s.StartTime = k8sJob.Status.StartTime.Time.Unix()
+			s.CompletionTime = k8sJob.Status.CompletionTime.Time.Unix()
+		} else if cond.Type == batch.JobFailed && cond.Status == core.ConditionTrue {
+			s.Status = cloudrpc.Status_Failed
+			s.Message = cond.Message
+		}
+	}
+	if len(k8sJob.Status.Conditions) == 0 {
+		if k8sJob.Status.Active > 0 {
+			s.Status = cloudrpc.Status_Running
+			s.StartTime = k8sJob.Status.StartTime.Time.Unix()
+		} else {
+			s.Status = cloudrpc.Status_Waiting
+		}
+	}
+	return s
+}
+
+// Delete deletes the Kubernetes job with the given name.
+func (r *kubernetesRunner) Delete(ctx context.Context, name string) error {
+	p := meta.DeletePropagationForeground
+	return r.jobControl.Delete(ctx, name, meta.DeleteOptions{
+		PropagationPolicy: &p,
+	})
+}
+
+// Logs streams the logs of the most recently created pod
+// belonging to the Kubernetes job with the given name.
+func (r *kubernetesRunner) Logs(ctx context.Context, name string) (io.ReadCloser, error) {
+	pods, err := r.pods.List(ctx, meta.ListOptions{LabelSelector: "job-name=" + name})
+	if err != nil {
+		return nil, err
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("cannot find pod for job %s", name)
+	}
+	pod := pods.Items[0]
+	for _, p := range pods.Items[1:] {
+		if p.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = p
+		}
+	}
+	return r.pods.GetLogs(pod.Name, &core.PodLogOptions{Follow: true}).Stream(ctx)
+}
+
+func (r *kubernetesRunner) getk8sJob(ctx context.Context, name string) (*batch.Job, error) {
+	jobList, err := r.jobControl.List(ctx, meta.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, k8sJob := range jobList.Items {
+		if k8sJob.GetName() == name {
+			return &k8sJob, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot find job %s", name)
+}
+
+// createJob creates a Kubernetes job specification with the given name that executes the
+// given command with the given command-line arguments on the given container
+// image. resources specifies the minimum required resources for execution.
+// volumes holds the list of k8s volumes to mount, with all volumes assumed to
+// be read-only.
+// Version is the version of the InMAP docker image to use, such as "latest" or "v1.7.2".
+func createJob(name string, command, args []string, version string, resources core.ResourceList, volumes []core.Volume) *batch.Job {
+	volumeMounts := make([]core.VolumeMount, len(volumes))
+	for i, v := range volumes {
+		volumeMounts[i] = core.VolumeMount{
+			Name:      v.Name,
+			ReadOnly:  true,
+			MountPath: "/data/" + v.Name,
+		}
+	}
+
+	return &batch.Job{
+		TypeMeta: meta.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: meta.ObjectMeta{
+			Name: name,
+		},
+		Spec: batch.JobSpec{
+			Template: core.PodTemplateSpec{
+				ObjectMeta: meta.ObjectMeta{
+					Name:   name + "_pod",
+					Labels: map[string]string{"app": "inmap-distributed"},
+				},
+				Spec: core.PodSpec{
+					Containers: []core.Container{
+						{
+							Name:    "inmap-container",
+							Image:   fmt.Sprintf("inmap/inmap:%s", version),
+							Command: command,
+							Args:    args,
+							Resources: core.ResourceRequirements{
+								Requests: resources,
+							},
+							VolumeMounts: volumeMounts,
+						},
+					},
+					Volumes:       volumes,
+					RestartPolicy: core.RestartPolicyOnFailure,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,589 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+	"gocloud.dev/blob"
+)
+
+// queueDir is the directory in the storage bucket where queued
+// job specifications are persisted.
+const queueDir = ".queue"
+
+// DefaultPollInterval is the default value of SchedulerConfig.PollInterval.
+const DefaultPollInterval = 10 * time.Second
+
+// SchedulerConfig holds configuration information for a Scheduler.
+type SchedulerConfig struct {
+	// MaxJobs is the maximum number of jobs that can be running at once.
+	// Zero means there is no limit.
+	MaxJobs int
+
+	// MaxJobsPerUser is the maximum number of jobs that each
+	// user can have running at once. Zero means there is no limit.
+	MaxJobsPerUser int
+
+	// MaxRetries is the number of times a job that fails, or that
+	// cannot be started, will be retried before it is reported as failed.
+	MaxRetries int
+
+	// RetryBackoff is the amount of time to wait before the first
+	// retry of a failed job. The wait time doubles for each subsequent retry.
+	RetryBackoff time.Duration
+
+	// PollInterval specifies how often the status of running jobs
+	// is checked in the background so that queued jobs can be started.
+	// Jobs are also checked whenever a job is submitted or deleted.
+	// If it is zero, DefaultPollInterval is used.
+	PollInterval time.Duration
+}
+
+// Scheduler is a JobRunner that queues jobs and runs them using another
+// JobRunner, while enforcing concurrency limits and retrying failed jobs.
+// Queued jobs are run in order of decreasing priority, and then in the order
+// in which they were submitted. Queued job specifications are persisted in blob
+// storage, so they are not lost when the server restarts.
+type Scheduler struct {
+	runner     JobRunner
+	bucketName string
+	cfg        SchedulerConfig
+
+	// mu protects the fields below it. It is never held while
+	// communicating with the runner or the storage bucket.
+	mu sync.Mutex
+
+	// queue holds the jobs that are waiting to be run, in the order
+	// in which they will be run.
+	queue []*scheduledJob
+
+	// active holds the jobs that have been submitted to the runner and
+	// have not yet finished.
+	active map[string]*scheduledJob
+
+	// failed holds the jobs that could not be started
+	// within the allowed number of retries.
+	failed map[string]*scheduledJob
+
+	// submitting holds the names of jobs that are in the
+	// process of being added to the queue.
+	submitting map[string]bool
+
+	// seq is the sequence number of the most recently submitted job.
+	seq int64
+
+	// updateMu ensures that only one update runs at a time.
+	updateMu sync.Mutex
+
+	wake, stop, done chan struct{}
+}
+
+// scheduledJob holds information about a job that is managed by
+// a Scheduler.
+type scheduledJob struct {
+	name, user string
+	spec       *cloudrpc.JobSpec
+	seq        int64
+
+	// attempts is the number of times the job has been run,
+	// including attempts where it could not be started.
+	attempts int
+
+	// notBefore is the earliest time the job can be run.
+	notBefore time.Time
+
+	// message holds information about the most recent failure
+	// of the job, if any.
+	message string
+}
+
+// NewScheduler returns a Scheduler that runs jobs using r, and persists
+// queued jobs in the blob storage bucket with the given name. Any jobs
+// previously persisted in the bucket will be restored.
+// The returned Scheduler checks the status of its jobs in the background
+// until Close is called.
+func NewScheduler(ctx context.Context, r JobRunner, bucketName string, cfg SchedulerConfig) (*Scheduler, error) {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultPollInterval
+	}
+	s := &Scheduler{
+		runner:     r,
+		bucketName: bucketName,
+		cfg:        cfg,
+		active:     make(map[string]*scheduledJob),
+		failed:     make(map[string]*scheduledJob),
+		submitting: make(map[string]bool),
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := s.restore(ctx); err != nil {
+		return nil, err
+	}
+	s.notify()
+	go func() {
+		defer close(s.done)
+		t := time.NewTicker(cfg.PollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-s.wake:
+			case <-s.stop:
+				return
+			}
+			s.update(context.Background())
+		}
+	}()
+	return s, nil
+}
+
+// Schedule replaces the JobRunner of the receiver with a Scheduler
+// that queues jobs and runs them using the original JobRunner.
+func (c *Client) Schedule(ctx context.Context, cfg SchedulerConfig) (*Scheduler, error) {
+	s, err := NewScheduler(ctx, c.runner, c.bucketName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.runner = s
+	return s, nil
+}
+
+// Close stops the scheduler from checking the status of jobs in the
+// background. Jobs that have already been started are not affected.
+func (s *Scheduler) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// notify asks the background goroutine to check the status of the jobs
+// without waiting for the next poll.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run adds the given job to the queue. The job is started by
+// the background goroutine once the concurrency limits allow.
+func (s *Scheduler) Run(ctx context.Context, name string, job *cloudrpc.JobSpec) error {
+	user, err := getUser(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.find(name) >= 0 || s.active[name] != nil || s.failed[name] != nil || s.submitting[name] {
+		s.mu.Unlock()
+		return fmt.Errorf("inmap/cloud: job %s already exists", name)
+	}
+	s.submitting[name] = true
+	s.seq++
+	seq := s.seq
+	s.mu.Unlock()
+
+	// Input files have already been staged, so we don't need
+	// to keep the file data.
+	spec := proto.Clone(job).(*cloudrpc.JobSpec)
+	spec.FileData = nil
+	j := &scheduledJob{name: name, user: user, spec: spec, seq: seq}
+	err = s.persist(ctx, j)
+
+	s.mu.Lock()
+	delete(s.submitting, name)
+	if err == nil {
+		s.enqueue(j)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// Status returns the status of the job with the given name. The status
+// of queued jobs is Waiting, and their position in the queue is included.
+func (s *Scheduler) Status(ctx context.Context, name string) (*cloudrpc.JobStatus, []string, error) {
+	s.mu.Lock()
+	if i := s.find(name); i >= 0 {
+		j := s.queue[i]
+		s.mu.Unlock()
+		return queuedStatus(j, i), j.spec.Cmd, nil
+	}
+	if j, ok := s.failed[name]; ok {
+		s.mu.Unlock()
+		return failedStatus(j), j.spec.Cmd, nil
+	}
+	s.mu.Unlock()
+	return s.runner.Status(ctx, name)
+}
+
+// List returns the statuses of all of the jobs known to
+// the underlying runner, along with the queued jobs and the
+// jobs that could not be started.
+func (s *Scheduler) List(ctx context.Context) (map[string]*cloudrpc.JobStatus, error) {
+	o, err := s.runner.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, j := range s.queue {
+		o[j.name] = queuedStatus(j, i)
+	}
+	for name, j := range s.failed {
+		o[name] = failedStatus(j)
+	}
+	return o, nil
+}
+
+// queuedStatus returns the status of job j at index i in the queue.
+func queuedStatus(j *scheduledJob, i int) *cloudrpc.JobStatus {
+	return &cloudrpc.JobStatus{
+		Status:        cloudrpc.Status_Waiting,
+		Message:       j.message,
+		QueuePosition: int32(i + 1),
+	}
+}
+
+// failedStatus returns the status of job j, which could not be started.
+func failedStatus(j *scheduledJob) *cloudrpc.JobStatus {
+	return &cloudrpc.JobStatus{
+		Status:  cloudrpc.Status_Failed,
+		Message: j.message,
+	}
+}
+
+// Delete removes the job with the given name from the queue, or
+// deletes it using the underlying runner if it has already been started.
+func (s *Scheduler) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	if i := s.find(name); i >= 0 {
+		j := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+		s.mu.Unlock()
+		return s.unpersist(ctx, j)
+	}
+	if _, ok := s.failed[name]; ok {
+		// Failed jobs have already been removed from storage.
+		delete(s.failed, name)
+		s.mu.Unlock()
+		return nil
+	}
+	j, ok := s.active[name]
+	delete(s.active, name)
+	s.mu.Unlock()
+	if ok {
+		if err := s.unpersist(ctx, j); err != nil {
+			return err
+		}
+		s.notify()
+	}
+	return s.runner.Delete(ctx, name)
+}
+
+// Logs returns the output of the job with the given name. An error is
+// returned if the job is still queued.
+func (s *Scheduler) Logs(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	if i := s.find(name); i >= 0 {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("inmap/cloud: job %s is queued at position %d", name, i+1)
+	}
+	s.mu.Unlock()
+	return s.runner.Logs(ctx, name)
+}
+
+// find returns the index of the job with the given name in the queue,
+// or -1 if it is not queued. The caller must hold s.mu.
+func (s *Scheduler) find(name string) int {
+	for i, j := range s.queue {
+		if j.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// enqueue adds job j to the queue. The queue is kept in the order in
+// which the jobs will be run, so the position of each job in the queue
+// is always up to date. The caller must hold s.mu.
+func (s *Scheduler) enqueue(j *scheduledJob) {
+	i := sort.Search(len(s.queue), func(i int) bool { return runsBefore(j, s.queue[i]) })
+	s.queue = append(s.queue, nil)
+	copy(s.queue[i+1:], s.queue[i:])
+	s.queue[i] = j
+}
+
+// runsBefore returns whether queued job a should be run before queued job b:
+// jobs are run in order of decreasing priority, and then in the order
+// in which they were submitted.
+func runsBefore(a, b *scheduledJob) bool {
+	if a.spec.Priority != b.spec.Priority {
+		return a.spec.Priority > b.spec.Priority
+	}
+	return a.seq < b.seq
+}
+
+// retry requeues job j after a failure, or marks it as failed if it
+// has already been run the maximum number of times. It returns
+// true if the job has been marked as failed. The caller must hold s.mu.
+func (s *Scheduler) retry(j *scheduledJob, now time.Time) (failed bool) {
+	if j.attempts > s.cfg.MaxRetries {
+		s.failed[j.name] = j
+		return true
+	}
+	j.notBefore = now.Add(s.cfg.RetryBackoff << uint(j.attempts-1))
+	s.enqueue(j)
+	return false
+}
+
+// update checks the status of the active jobs using a single snapshot
+// of the jobs known to the runner, requeues any failed jobs that can be
+// retried, and starts queued jobs as the concurrency limits allow.
+// s.mu is only held while the state of the scheduler is being changed,
+// not while communicating with the runner or the storage bucket.
+func (s *Scheduler) update(ctx context.Context) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	statuses, err := s.runner.List(ctx)
+	if err != nil {
+		// Try again at the next poll.
+		return
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	userJobs := make(map[string]int)
+	var finished, restart []*scheduledJob
+	for name, j := range s.active {
+		status, ok := statuses[name]
+		if !ok {
+			// The job has been removed from the runner.
+			delete(s.active, name)
+			finished = append(finished, j)
+			continue
+		}
+		switch status.Status {
+		case cloudrpc.Status_Complete:
+			delete(s.active, name)
+			finished = append(finished, j)
+		case cloudrpc.Status_Failed:
+			if j.attempts > s.cfg.MaxRetries {
+				delete(s.active, name)
+				finished = append(finished, j)
+				continue
+			}
+			// The job stays active until it has been
+			// removed from the runner.
+			j.message = fmt.Sprintf("retrying after failure: %s", status.Message)
+			restart = append(restart, j)
+		default:
+			userJobs[j.user]++
+		}
+	}
+
+	running := len(s.active) - len(restart)
+	var stillQueued, start []*scheduledJob
+	for _, j := range s.queue {
+		if now.Before(j.notBefore) ||
+			(s.cfg.MaxJobs > 0 && running >= s.cfg.MaxJobs) ||
+			(s.cfg.MaxJobsPerUser > 0 && userJobs[j.user] >= s.cfg.MaxJobsPerUser) {
+			stillQueued = append(stillQueued, j)
+			continue
+		}
+		j.attempts++
+		s.active[j.name] = j
+		start = append(start, j)
+		userJobs[j.user]++
+		running++
+	}
+	s.queue = stillQueued
+	s.mu.Unlock()
+
+	for _, j := range finished {
+		s.unpersist(ctx, j)
+	}
+
+	restartErrs := make([]error, len(restart))
+	for i, j := range restart {
+		restartErrs[i] = s.runner.Delete(ctx, j.name)
+	}
+
+	startErrs := make([]error, len(start))
+	for i, j := range start {
+		// The job should keep running after the request that started
+		// it has finished.
+		runCtx := context.WithValue(context.Background(), "user", j.user)
+		startErrs[i] = s.runner.Run(runCtx, j.name, j.spec)
+	}
+
+	s.mu.Lock()
+	now = time.Now()
+	for i, j := range restart {
+		if s.active[j.name] != j {
+			continue // The job has been deleted.
+		}
+		if restartErrs[i] != nil {
+			j.message = fmt.Sprintf("job failed and could not be restarted: %v", restartErrs[i])
+			continue
+		}
+		delete(s.active, j.name)
+		s.retry(j, now)
+	}
+	var failed []*scheduledJob
+	for i, j := range start {
+		if startErrs[i] == nil || s.active[j.name] != j {
+			continue
+		}
+		delete(s.active, j.name)
+		j.message = fmt.Sprintf("job could not be started: %v", startErrs[i])
+		if s.retry(j, now) {
+			failed = append(failed, j)
+		}
+	}
+	s.mu.Unlock()
+
+	// Jobs that could not be started are reported as failed until they
+	// are deleted, but they should not be restored after a restart.
+	for _, j := range failed {
+		s.unpersist(ctx, j)
+	}
+}
+
+// queueKey returns the key in the storage bucket where the
+// specification of the given job is persisted.
+func (s *Scheduler) queueKey(user, jobName string) (string, error) {
+	u, err := url.Parse(s.bucketName)
+	if err != nil {
+		return "", fmt.Errorf("inmap/cloud: parsing bucket name: %v", err)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%s/%s/%s/%s", u.Path, queueDir, user, jobName), "/"), nil
+}
+
+// persist saves the specification of the given job in the storage bucket.
+func (s *Scheduler) persist(ctx context.Context, j *scheduledJob) error {
+	bucket, err := OpenBucket(ctx, s.bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+	key, err := s.queueKey(j.user, j.spec.Name)
+	if err != nil {
+		return err
+	}
+	b, err := proto.Marshal(j.spec)
+	if err != nil {
+		return fmt.Errorf("inmap/cloud: persisting job %s: %v", j.name, err)
+	}
+	return writeBlob(ctx, bucket, key, b)
+}
+
+// unpersist removes the specification of the given job from the storage bucket.
+func (s *Scheduler) unpersist(ctx context.Context, j *scheduledJob) error {
+	bucket, err := OpenBucket(ctx, s.bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+	key, err := s.queueKey(j.user, j.spec.Name)
+	if err != nil {
+		return err
+	}
+	if err = bucket.Delete(ctx, key); err != nil {
+		return fmt.Errorf("inmap/cloud: deleting persisted job %s: %v", j.name, err)
+	}
+	return nil
+}
+
+// restore loads persisted job specifications from the storage bucket.
+// Jobs that the runner already knows about are considered active, and
+// the rest are queued.
+func (s *Scheduler) restore(ctx context.Context) error {
+	bucket, err := OpenBucket(ctx, s.bucketName)
+	if err != nil {
+		return err
+	}
+	defer bucket.Close()
+	prefix, err := s.queueKey("", "")
+	if err != nil {
+		return err
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	iter := bucket.List(&blob.ListOptions{Prefix: prefix})
+	var jobs []*scheduledJob
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("inmap/cloud: listing queued jobs: %v", err)
+		}
+		parts := strings.Split(strings.TrimPrefix(obj.Key, prefix), "/")
+		if len(parts) != 2 {
+			continue
+		}
+		b, err := readBlob(ctx, bucket, obj.Key)
+		if err != nil {
+			return err
+		}
+		spec := new(cloudrpc.JobSpec)
+		if err = proto.Unmarshal(b, spec); err != nil {
+			return fmt.Errorf("inmap/cloud: reading queued job %s: %v", obj.Key, err)
+		}
+		user := parts[0]
+		jobs = append(jobs, &scheduledJob{
+			name: userJobName(user, spec.Name),
+			user: user,
+			spec: spec,
+			// We don't know the original order of the jobs, so
+			// use their modification times.
+			seq: obj.ModTime.UnixNano(),
+		})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].seq < jobs[j].seq })
+	statuses, err := s.runner.List(ctx)
+	if err != nil {
+		return fmt.Errorf("inmap/cloud: listing jobs: %v", err)
+	}
+	for _, j := range jobs {
+		s.seq++
+		j.seq = s.seq
+		if _, ok := statuses[j.name]; ok {
+			j.attempts = 1
+			s.active[j.name] = j
+		} else {
+			s.enqueue(j)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+)
+
+// testRunner is a JobRunner whose job statuses are set manually.
+type testRunner struct {
+	mu   sync.Mutex
+	jobs map[string]*cloudrpc.JobStatus
+	runs map[string]int
+
+	// runErr holds errors to be returned when
+	// starting jobs with the given names.
+	runErr map[string]error
+}
+
+func newTestRunner(jobs map[string]*cloudrpc.JobStatus) *testRunner {
+	if jobs == nil {
+		jobs = make(map[string]*cloudrpc.JobStatus)
+	}
+	return &testRunner{jobs: jobs, runs: make(map[string]int), runErr: make(map[string]error)}
+}
+
+func (r *testRunner) Run(ctx context.Context, name string, job *cloudrpc.JobSpec) error {
+	if _, err := getUser(ctx); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[name]++
+	if err := r.runErr[name]; err != nil {
+		return err
+	}
+	r.jobs[name] = &cloudrpc.JobStatus{Status: cloudrpc.Status_Running}
+	return nil
+}
+
+func (r *testRunner) Status(ctx context.Context, name string) (*cloudrpc.JobStatus, []string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.jobs[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot find job %s", name)
+	}
+	st := *s
+	return &st, []string{"inmap"}, nil
+}
+
+func (r *testRunner) List(ctx context.Context) (map[string]*cloudrpc.JobStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o := make(map[string]*cloudrpc.JobStatus, len(r.jobs))
+	for name, s := range r.jobs {
+		st := *s
+		o[name] = &st
+	}
+	return o, nil
+}
+
+func (r *testRunner) Delete(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.jobs, name)
+	return nil
+}
+
+func (r *testRunner) Logs(ctx context.Context, name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(name)), nil
+}
+
+// set sets the status of the job with the given name.
+func (r *testRunner) set(name string, status cloudrpc.Status, message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[name] = &cloudrpc.JobStatus{Status: status, Message: message}
+}
+
+// numRuns returns the number of times the job with
+// the given name has been run.
+func (r *testRunner) numRuns(name string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.runs[name]
+}
+
+func TestScheduler(t *testing.T) {
+	const (
+		bucket  = "file://schedtest/test"
+		backoff = time.Hour
+	)
+	os.Mkdir("schedtest", os.ModePerm)
+	defer os.RemoveAll("schedtest")
+
+	r := newTestRunner(nil)
+	s, err := NewScheduler(context.Background(), r, bucket, SchedulerConfig{
+		MaxJobsPerUser: 1,
+		MaxRetries:     1,
+		RetryBackoff:   backoff,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stop the background updates so the jobs can be checked
+	// deterministically below.
+	s.Close()
+
+	userA := context.WithValue(context.Background(), "user", "a")
+	userB := context.WithValue(context.Background(), "user", "b")
+	userC := context.WithValue(context.Background(), "user", "c")
+	submit := func(ctx context.Context, name string, priority int32) {
+		user, _ := getUser(ctx)
+		err := s.Run(ctx, userJobName(user, name), &cloudrpc.JobSpec{
+			Name:     name,
+			Cmd:      []string{"inmap"},
+			Priority: priority,
+			FileData: map[string][]byte{"x": []byte("y")},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkStatus := func(name string, status cloudrpc.Status, position int32) *cloudrpc.JobStatus {
+		t.Helper()
+		st, _, err := s.Status(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Status != status || st.QueuePosition != position {
+			t.Errorf("%s: want %v at position %d but have %v at position %d",
+				name, status, position, st.Status, st.QueuePosition)
+		}
+		return st
+	}
+	// expireBackoff allows all queued jobs to be retried immediately,
+	// after checking that they have been delayed by the backoff time.
+	expireBackoff := func() {
+		t.Helper()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, j := range s.queue {
+			if j.notBefore.IsZero() {
+				continue
+			}
+			if wait := time.Until(j.notBefore); wait <= backoff/2 || wait > backoff {
+				t.Errorf("%s: retry in %v", j.name, wait)
+			}
+			j.notBefore = time.Time{}
+		}
+	}
+
+	submit(userA, "job1", 0)
+	submit(userA, "job2", 0)
+	submit(userB, "job1", 0)
+	s.update(context.Background())
+	submit(userA, "job3", 10)
+	// Queue positions are up to date before the next update.
+	checkStatus("a-job3", cloudrpc.Status_Waiting, 1)
+	checkStatus("a-job2", cloudrpc.Status_Waiting, 2)
+	s.update(context.Background())
+
+	checkStatus("a-job1", cloudrpc.Status_Running, 0)
+	checkStatus("b-job1", cloudrpc.Status_Running, 0)
+	checkStatus("a-job3", cloudrpc.Status_Waiting, 1)
+	checkStatus("a-job2", cloudrpc.Status_Waiting, 2)
+
+	if err := s.Run(userA, "a-job2", &cloudrpc.JobSpec{Name: "job2"}); err == nil {
+		t.Error("submitting a duplicate job should cause an error")
+	}
+
+	list, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 4 || list["a-job2"].QueuePosition != 2 || list["b-job1"].Status != cloudrpc.Status_Running {
+		t.Errorf("incorrect job list: %v", list)
+	}
+
+	// Queue positions change as soon as jobs are added or removed.
+	submit(userB, "job2", 5)
+	checkStatus("a-job3", cloudrpc.Status_Waiting, 1)
+	checkStatus("b-job2", cloudrpc.Status_Waiting, 2)
+	checkStatus("a-job2", cloudrpc.Status_Waiting, 3)
+	if err := s.Delete(userB, "b-job2"); err != nil {
+		t.Fatal(err)
+	}
+	checkStatus("a-job2", cloudrpc.Status_Waiting, 2)
+
+	t.Run("persist", func(t *testing.T) {
+		r2 := newTestRunner(map[string]*cloudrpc.JobStatus{
+			"a-job1": {Status: cloudrpc.Status_Running},
+			"b-job1": {Status: cloudrpc.Status_Running},
+		})
+		s2, err := NewScheduler(context.Background(), r2, bucket, SchedulerConfig{MaxJobsPerUser: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s2.Close()
+		s2.update(context.Background())
+		s2.mu.Lock()
+		defer s2.mu.Unlock()
+		if len(s2.active) != 2 || len(s2.queue) != 2 {
+			t.Fatalf("restored %d active and %d queued jobs", len(s2.active), len(s2.queue))
+		}
+		if i := s2.find("a-job3"); i != 0 {
+			t.Errorf("restored queue position: %d", i+1)
+		}
+		if s2.queue[0].spec.FileData != nil {
+			t.Errorf("file data should not be persisted")
+		}
+	})
+
+	// Finishing a job allows the next job from the same user to start.
+	r.set("a-job1", cloudrpc.Status_Complete, "")
+	s.update(context.Background())
+	checkStatus("a-job1", cloudrpc.Status_Complete, 0)
+	checkStatus("a-job3", cloudrpc.Status_Running, 0)
+	checkStatus("a-job2", cloudrpc.Status_Waiting, 1)
+
+	// Failed jobs are retried after the backoff time.
+	r.set("b-job1", cloudrpc.Status_Failed, "out of memory")
+	s.update(context.Background())
+	checkStatus("b-job1", cloudrpc.Status_Waiting, 2)
+	s.update(context.Background())
+	checkStatus("b-job1", cloudrpc.Status_Waiting, 2)
+	expireBackoff()
+	s.update(context.Background())
+	checkStatus("b-job1", cloudrpc.Status_Running, 0)
+	if n := r.numRuns("b-job1"); n != 2 {
+		t.Errorf("b-job1 should have been run twice but was run %d times", n)
+	}
+	r.set("b-job1", cloudrpc.Status_Failed, "out of memory")
+	s.update(context.Background())
+	checkStatus("b-job1", cloudrpc.Status_Failed, 0)
+
+	// Jobs that cannot be started are retried, and then
+	// reported as failed.
+	r.mu.Lock()
+	r.runErr["c-job1"] = fmt.Errorf("no space left")
+	r.mu.Unlock()
+	submit(userC, "job1", 0)
+	s.update(context.Background())
+	st := checkStatus("c-job1", cloudrpc.Status_Waiting, 2)
+	if want := "job could not be started: no space left"; st.Message != want {
+		t.Errorf("c-job1 message: want %q but have %q", want, st.Message)
+	}
+	expireBackoff()
+	s.update(context.Background())
+	checkStatus("c-job1", cloudrpc.Status_Failed, 0)
+	if n := r.numRuns("c-job1"); n != 2 {
+		t.Errorf("c-job1 should have been run twice but was run %d times", n)
+	}
+	s.update(context.Background())
+	checkStatus("c-job1", cloudrpc.Status_Failed, 0)
+	if err := s.Run(userC, "c-job1", &cloudrpc.JobSpec{Name: "job1"}); err == nil {
+		t.Error("failed jobs should be deleted before they are resubmitted")
+	}
+	if err := s.Delete(userC, "c-job1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Status(context.Background(), "c-job1"); err == nil {
+		t.Error("deleted job should be missing")
+	}
+
+	if err := s.Delete(userA, "a-job2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Status(context.Background(), "a-job2"); err == nil {
+		t.Error("deleted job should be missing")
+	}
+	if err := s.Delete(userA, "a-job3"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) != 0 || len(s.active) != 0 || len(s.failed) != 0 {
+		t.Errorf("there should be no remaining jobs: %d queued, %d active, %d failed", len(s.queue), len(s.active), len(s.failed))
+	}
+}
+
+// Test whether queued jobs are started in the background.
+func TestScheduler_background(t *testing.T) {
+	os.Mkdir("schedtest", os.ModePerm)
+	defer os.RemoveAll("schedtest")
+
+	r := newTestRunner(nil)
+	s, err := NewScheduler(context.Background(), r, "file://schedtest/test", SchedulerConfig{
+		MaxJobs:      1,
+		PollInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx := context.WithValue(context.Background(), "user", "a")
+	for _, name := range []string{"job1", "job2"} {
+		if err := s.Run(ctx, userJobName("a", name), &cloudrpc.JobSpec{Name: name, Cmd: []string{"inmap"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(name string, status cloudrpc.Status) {
+		t.Helper()
+		for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(time.Millisecond) {
+			if st, _, err := s.Status(ctx, name); err == nil && st.Status == status {
+				return
+			}
+		}
+		t.Fatalf("%s did not reach status %v", name, status)
+	}
+	waitFor("a-job1", cloudrpc.Status_Running)
+	waitFor("a-job2", cloudrpc.Status_Waiting)
+	r.set("a-job1", cloudrpc.Status_Complete, "")
+	waitFor("a-job2", cloudrpc.Status_Running)
+}
@@ -0,0 +1,244 @@
+/*
+Copyright © 2018 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package cloud
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
+)
+
+func TestStageInputs(t *testing.T) {
+	const bucket = "file://stagetest/test"
+	os.Mkdir("stagetest", os.ModePerm)
+	defer os.RemoveAll("stagetest")
+
+	c, err := NewRunnerClient(NewLocalRunner(""), nil, nil, bucket, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rpc := FakeRPCClient{Client: c}
+
+	data := []byte("input data")
+	sum := sha256.Sum256(data)
+	fname := fmt.Sprintf("%x.ncf", sum[:])
+	newJob := func(name string) *cloudrpc.JobSpec {
+		return &cloudrpc.JobSpec{
+			Name:     name,
+			Args:     []string{"--InMAPData", fname},
+			FileData: map[string][]byte{fname: data},
+		}
+	}
+	wantArgs := []string{"--InMAPData", "file://stagetest/test/inputs/" + fname}
+
+	// The first job uploads the file.
+	userA := context.WithValue(context.Background(), "user", "a")
+	js := newJob("job1")
+	if _, err := RemoveStagedInputs(userA, rpc, js); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.FileData) != 1 || len(js.StagedFiles) != 0 {
+		t.Fatalf("file should not be staged yet: %v", js)
+	}
+	if err := c.stageInputs(userA, js); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(js.Args, wantArgs) {
+		t.Errorf("args: %v != %v", js.Args, wantArgs)
+	}
+
+	// Another user's job reuses the staged file.
+	userB := context.WithValue(context.Background(), "user", "b")
+	js = newJob("job2")
+	if _, err := RemoveStagedInputs(userB, rpc, js); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.FileData) != 0 || !reflect.DeepEqual(js.StagedFiles, []string{fname}) {
+		t.Fatalf("file should already be staged: %v", js)
+	}
+	if err := c.stageInputs(userB, js); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(js.Args, wantArgs) {
+		t.Errorf("args: %v != %v", js.Args, wantArgs)
+	}
+
+	t.Run("not_staged", func(t *testing.T) {
+		missing := fmt.Sprintf("%x.ncf", sha256.Sum256([]byte("other")))
+		js := &cloudrpc.JobSpec{Name: "job3", StagedFiles: []string{missing}}
+		if err := c.stageInputs(userA, js); !IsNotStaged(err) {
+			t.Errorf("missing staged file should cause a 'not staged' error, but have %v", err)
+		}
+	})
+
+	t.Run("bad_checksum", func(t *testing.T) {
+		js := &cloudrpc.JobSpec{Name: "job4", FileData: map[string][]byte{fname: []byte("wrong")}}
+		if err := c.stageInputs(userA, js); err == nil {
+			t.Error("incorrect checksum should cause an error")
+		}
+	})
+
+	t.Run("shapefile", func(t *testing.T) {
+		files := map[string][]byte{".shp": []byte("shp"), ".dbf": []byte("dbf"), ".shx": []byte("shx"), ".prj": []byte("prj")}
+		sum := shapefileSum(files)
+		newJob := func() *cloudrpc.JobSpec {
+			js := &cloudrpc.JobSpec{Name: "job5", Args: []string{"--EmissionsShapefiles", sum + ".shp"}, FileData: make(map[string][]byte)}
+			for ext, data := range files {
+				js.FileData[sum+ext] = data
+			}
+			return js
+		}
+		if err := c.stageInputs(userA, newJob()); err != nil {
+			t.Fatal(err)
+		}
+		for ext := range files {
+			js := newJob()
+			js.FileData[sum+ext] = []byte("wrong")
+			if err := c.stageInputs(userA, js); err == nil {
+				t.Errorf("incorrect %s file should cause an error", ext)
+			}
+		}
+		// Sidecar files can be checked against files that have already been staged.
+		js := newJob()
+		delete(js.FileData, sum+".dbf")
+		js.StagedFiles = []string{sum + ".dbf"}
+		if err := c.stageInputs(userA, js); err != nil {
+			t.Error(err)
+		}
+		js.FileData[sum+".prj"] = []byte("wrong")
+		if err := c.stageInputs(userA, js); err == nil {
+			t.Error("incorrect .prj file should cause an error")
+		}
+		if err := c.releaseInputs(userA, "a", "job5"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		key := "stagetest/test/inputs/" + fname
+		if err := c.releaseInputs(userA, "a", "job1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(key); err != nil {
+			t.Errorf("file should still be staged for job2: %v", err)
+		}
+		if err := c.releaseInputs(userB, "b", "job2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(key); !os.IsNotExist(err) {
+			t.Errorf("file should have been removed: %v", err)
+		}
+		// Releasing a job that has no inputs is not an error.
+		if err := c.releaseInputs(userB, "b", "job2"); err != nil {
+			t.Error(err)
+		}
+		err := filepath.Walk("stagetest", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				t.Errorf("file %s should have been deleted", path)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("restage", func(t *testing.T) {
+		// The file is removed after the client checks whether it is staged.
+		js := newJob("job6")
+		if err := c.stageInputs(userA, js); err != nil {
+			t.Fatal(err)
+		}
+		js = newJob("job7")
+		restore, err := RemoveStagedInputs(userB, rpc, js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(js.StagedFiles) != 1 {
+			t.Fatalf("file should already be staged: %v", js)
+		}
+		if err := c.releaseInputs(userA, "a", "job6"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.stageInputs(userB, js); !IsNotStaged(err) {
+			t.Fatalf("should be a 'not staged' error but have %v", err)
+		}
+		restore()
+		if err := c.stageInputs(userB, js); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(js.Args, wantArgs) {
+			t.Errorf("args: %v != %v", js.Args, wantArgs)
+		}
+		if _, err := os.Stat("stagetest/test/inputs/" + fname); err != nil {
+			t.Error(err)
+		}
+		if err := c.releaseInputs(userB, "b", "job7"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("release_in_progress", func(t *testing.T) {
+		// Another job is removing the file, so staging must wait for it to finish.
+		marker := filepath.Join("stagetest/test/inputs/.releases", fname, "a", "job8")
+		if err := os.MkdirAll(filepath.Dir(marker), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(userB, 5*releasePollInterval)
+		defer cancel()
+		if err := c.stageInputs(ctx, newJob("job9")); err != context.DeadlineExceeded {
+			t.Errorf("staging should wait for the removal to finish but have error %v", err)
+		}
+		// Removals that have taken too long are assumed to have failed.
+		old := time.Now().Add(-2 * releaseTimeout)
+		if err := os.Chtimes(marker, old, old); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.stageInputs(userB, newJob("job9")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.releaseInputs(userB, "b", "job9"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.RemoveAll("stagetest/test/inputs/.releases"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("bad_name", func(t *testing.T) {
+		_, err := rpc.MissingInputs(userA, &cloudrpc.InputFiles{Names: []string{"../a-job1/x.ncf"}})
+		if err == nil {
+			t.Error("invalid file name should cause an error")
+		}
+	})
+}
@@ -59,6 +59,10 @@ var (
 	tlsPort    = flag.String("tls-port", "10000", "Port to listen for encrypted requests")
 	port       = flag.String("port", "8080", "Port to listen for unencrypted requests")
 	bucket     = flag.String("bucket", "file://test", "Name of bucket for saving data")
+	maxJobs    = flag.Int("max-jobs", 0, "Maximum number of jobs to run at once; zero means no limit")
+	maxUser    = flag.Int("max-jobs-per-user", 0, "Maximum number of jobs each user can run at once; zero means no limit")
+	maxRetries = flag.Int("max-retries", 0, "Number of times to retry failed jobs")
+	runner     = flag.String("runner", "kubernetes", "How to run jobs in a production setting: 'kubernetes' to run them on a cluster, 'local' to run them as local processes, or 'docker' to run them in local containers")
 )
 
 var logger *logrus.Logger
@@ -132,7 +136,16 @@ func main() {
 	cfg := inmaputil.InitializeConfig()
 
 	var inmapServer *cloud.Client
-	if *production {
+	if *production && (*runner == "local" || *runner == "docker") {
+		var image string
+		if *runner == "docker" {
+			image = "inmap/inmap"
+		}
+		inmapServer, err = cloud.NewLocalClient(image, cfg.Root, cfg.Viper, *bucket, cfg.InputFiles(), cfg.OutputFiles())
+		if err != nil {
+			logger.WithError(err).Fatal("failed to initialize local InMAP server")
+		}
+	} else if *production {
 		config, err := rest.InClusterConfig()
 		if err != nil {
 			logger.WithError(err).Fatal("failed to load in-cluster Kubernetes configuration")
@@ -153,6 +166,19 @@ func main() {
 		}
 	}
 
+	if *maxJobs > 0 || *maxUser > 0 || *maxRetries > 0 {
+		_, err = inmapServer.Schedule(context.Background(), cloud.SchedulerConfig{
+			MaxJobs:        *maxJobs,
+			MaxJobsPerUser: *maxUser,
+			MaxRetries:     *maxRetries,
+			RetryBackoff:   time.Minute,
+			PollInterval:   30 * time.Second,
+		})
+		if err != nil {
+			logger.WithError(err).Fatal("failed to initialize job scheduler")
+		}
+	}
+
 	_, greet := initCSTDB(&s.SpatialEIO.CSTConfig)
 	greet.RegisterHTTPHandlers("/greet/", filepath.Join(os.ExpandEnv(*staticRoot), "emissions", "slca"))
 
@@ -0,0 +1,857 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/sparse"
+	"github.com/ctessum/unit"
+	"github.com/lnashier/viper"
+	"github.com/spatialmodel/inmap/emissions/aep"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Config holds the configuration for processing an emissions inventory
+// from start to finish. It is usually read from the TOML-format
+// configuration file given to the aep command.
+type Config struct {
+	// Inventory specifies the emissions inventory files to read.
+	Inventory InventoryConfig
+
+	// Speciate specifies how to chemically speciate the emissions.
+	// If Speciate.SpecRef is empty, the emissions are not speciated.
+	Speciate SpeciateConfig
+
+	// Spatial specifies how to spatially allocate the emissions.
+	// The grid cells are created from Grid, so Spatial.GridCells
+	// does not need to be specified.
+	Spatial SpatialConfig
+
+	// Grid specifies the output grid.
+	Grid GridConfig
+}
+
+// GridConfig specifies a regular output grid in the spatial reference
+// given by SpatialConfig.OutputSR.
+type GridConfig struct {
+	// Nx and Ny are the numbers of grid columns and rows.
+	Nx, Ny int
+
+	// Dx and Dy are the widths and heights of the grid cells.
+	Dx, Dy float64
+
+	// X0 and Y0 are the coordinates of the lower-left corner of the grid.
+	X0, Y0 float64
+}
+
+// ReadConfig reads a TOML-format configuration from r.
+func ReadConfig(r io.Reader) (*Config, error) {
+	c := new(Config)
+	if _, err := toml.DecodeReader(r, c); err != nil {
+		return nil, fmt.Errorf("aeputil: reading configuration: %v", err)
+	}
+	return c, nil
+}
+
+// Run reads, speciates, and spatially allocates the emissions and writes
+// the results to directory outputDir. The output files are:
+//
+//	gridded_totals.csv: the total emissions of each pollutant in each grid cell;
+//	<GridName>.shp: the output grid;
+//	<step>_totals.txt and <step>_dropped.txt: the total and dropped emissions
+//	from each of the inventory, speciation, and spatial processing steps;
+//	speciation_species.txt: the total emissions of each model species and
+//	the group in Speciate.SpeciesGroups it belongs to, if the emissions are
+//	speciated;
+//	surrogates/srg_<country>_<code>.shp: the spatial surrogates that were
+//	used, if writeSurrogates is true.
+func (c *Config) Run(outputDir string, writeSurrogates bool) error {
+	if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
+		return fmt.Errorf("aeputil: creating output directory: %v", err)
+	}
+	grid, err := c.setGrid()
+	if err != nil {
+		return err
+	}
+
+	log.Println("aep: reading emissions")
+	records, inventoryReport, err := c.Inventory.ReadEmissions()
+	if err != nil {
+		return err
+	}
+	iter := IteratorFromMap(records)
+	var speciateIter Iterator
+	if c.Speciate.SpecRef != "" {
+		if c.Speciate.Speciation == nil {
+			c.Speciate.Speciation = c.Inventory.PolsToKeep
+		}
+		speciateIter = c.Speciate.Iterator(iter)
+		iter = speciateIter
+	}
+	spatialIter := c.Spatial.Iterator(iter, 0)
+
+	log.Println("aep: processing emissions")
+	srgs := newSurrogateLocations()
+	for {
+		rec, err := spatialIter.NextGridded()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if writeSurrogates {
+			if err := srgs.add(rec, &c.Spatial); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Println("aep: writing output")
+	if err := writeGriddedTotals(filepath.Join(outputDir, "gridded_totals.csv"), spatialIter, grid); err != nil {
+		return err
+	}
+	if err := grid.WriteToShp(outputDir); err != nil {
+		return fmt.Errorf("aeputil: writing grid shapefile: %v", err)
+	}
+	if err := writeReport(outputDir, "inventory", inventoryReport); err != nil {
+		return err
+	}
+	if speciateIter != nil {
+		if err := writeReport(outputDir, "speciation", speciateIter.Report()); err != nil {
+			return err
+		}
+		if err := writeSpeciesTotals(filepath.Join(outputDir, "speciation_species.txt"), &c.Speciate, speciateIter.Report()); err != nil {
+			return err
+		}
+	}
+	if err := writeReport(outputDir, "spatial", spatialIter.Report()); err != nil {
+		return err
+	}
+	if writeSurrogates {
+		return srgs.write(filepath.Join(outputDir, "surrogates"), &c.Spatial, grid)
+	}
+	return nil
+}
+
+// setGrid creates the output grid and sets the spatial processor
+// grid cells to match it.
+func (c *Config) setGrid() (*aep.GridDef, error) {
+	if c.Spatial.GridName == "" {
+		c.Spatial.GridName = "aep"
+	}
+	sr, err := proj.Parse(os.ExpandEnv(c.Spatial.OutputSR))
+	if err != nil {
+		return nil, fmt.Errorf("aeputil: parsing OutputSR: %v", err)
+	}
+	g := c.Grid
+	grid := aep.NewGridRegular(c.Spatial.GridName, g.Nx, g.Ny, g.Dx, g.Dy, g.X0, g.Y0, sr)
+	c.Spatial.GridCells = make([]geom.Polygonal, len(grid.Cells))
+	for i, cell := range grid.Cells {
+		c.Spatial.GridCells[i] = cell.Polygonal
+	}
+	return grid, nil
+}
+
+// Surrogates creates every spatial surrogate in the surrogate specification
+// for the output grid and each input location and writes the results to
+// directory outputDir. The output files are:
+//
+//	surrogates/<region>_<code>/<location>.shp: the gridded surrogate used for
+//	each surrogate code and location, which may be a backup surrogate
+//	(no file is written if emissions would be allocated evenly across
+//	the grid cells);
+//	surrogate_report.csv: the surrogate used for each surrogate code and
+//	location, the surrogates that had zero weight in the location,
+//	whether the location is completely covered by the grid, and the
+//	fraction of the surrogate weight that is within the grid;
+//	surrogate_summary.csv: for each surrogate code, the number of
+//	locations, the number of locations where a backup surrogate or even
+//	allocation was used, and the minimum and average coverage fractions.
+func (c *Config) Surrogates(outputDir string) error {
+	grid, err := c.setGrid()
+	if err != nil {
+		return err
+	}
+	sp, err := c.Spatial.SpatialProcessor()
+	if err != nil {
+		return err
+	}
+	srgDir := filepath.Join(outputDir, "surrogates")
+	if err := os.MkdirAll(srgDir, os.ModePerm); err != nil {
+		return fmt.Errorf("aeputil: creating surrogate directory: %v", err)
+	}
+
+	report, err := os.Create(filepath.Join(outputDir, "surrogate_report.csv"))
+	if err != nil {
+		return fmt.Errorf("aeputil: writing surrogate report: %v", err)
+	}
+	w := csv.NewWriter(report)
+	w.Write([]string{"region", "code", "name", "location", "used", "zero_weight", "covered_by_grid", "coverage"})
+
+	type summary struct {
+		name                       string
+		locations, fallbacks, even int
+		minCoverage, sumCoverage   float64
+	}
+	var keys []string
+	summaries := make(map[string]*summary)
+
+	log.Println("aep: generating surrogates")
+	err = sp.GenerateSurrogates(sp.Grids[0], func(qa *aep.SurrogateQA, data *aep.GriddedSrgData) error {
+		key := fmt.Sprintf("%s_%s", qa.Region, qa.Code)
+		w.Write([]string{qa.Region.String(), qa.Code, qa.Name, qa.Location, qa.Used,
+			strings.Join(qa.ZeroWeight, ";"), strconv.FormatBool(qa.CoveredByGrid),
+			strconv.FormatFloat(qa.Coverage, 'g', -1, 64)})
+
+		s, ok := summaries[key]
+		if !ok {
+			s = &summary{name: qa.Name, minCoverage: math.Inf(1)}
+			summaries[key] = s
+			keys = append(keys, key)
+		}
+		s.locations++
+		if qa.Fallback() {
+			s.fallbacks++
+		}
+		if qa.Used == "" {
+			s.even++
+		}
+		s.minCoverage = math.Min(s.minCoverage, qa.Coverage)
+		s.sumCoverage += qa.Coverage
+
+		if data == nil {
+			return nil
+		}
+		// The spatial processor grid is irregular, with one row per cell,
+		// so we convert the cells back to the output grid.
+		out := *data
+		out.Cells = make([]*aep.GridCell, len(data.Cells))
+		for i, cell := range data.Cells {
+			out.Cells[i] = grid.Cells[cell.Row].Copy()
+			out.Cells[i].Weight = cell.Weight
+		}
+		out.Nx, out.Ny = grid.Nx, grid.Ny
+		dir := filepath.Join(srgDir, key)
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return fmt.Errorf("aeputil: creating surrogate directory: %v", err)
+		}
+		return out.WriteToShp(filepath.Join(dir, qa.Location+".shp"))
+	})
+	w.Flush()
+	if err != nil {
+		report.Close()
+		return err
+	}
+	if err := w.Error(); err != nil {
+		report.Close()
+		return fmt.Errorf("aeputil: writing surrogate report: %v", err)
+	}
+	if err := report.Close(); err != nil {
+		return fmt.Errorf("aeputil: writing surrogate report: %v", err)
+	}
+
+	f, err := os.Create(filepath.Join(outputDir, "surrogate_summary.csv"))
+	if err != nil {
+		return fmt.Errorf("aeputil: writing surrogate summary: %v", err)
+	}
+	w = csv.NewWriter(f)
+	w.Write([]string{"surrogate", "name", "locations", "fallbacks", "even_allocation", "min_coverage", "mean_coverage"})
+	for _, key := range keys {
+		s := summaries[key]
+		w.Write([]string{key, s.name, strconv.Itoa(s.locations), strconv.Itoa(s.fallbacks), strconv.Itoa(s.even),
+			strconv.FormatFloat(s.minCoverage, 'g', -1, 64),
+			strconv.FormatFloat(s.sumCoverage/float64(s.locations), 'g', -1, 64)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("aeputil: writing surrogate summary: %v", err)
+	}
+	return f.Close()
+}
+
+// Diff compares the emissions in the inventory in c (the base inventory)
+// to the emissions in the inventory specified by compare and writes the
+// results to directory outputDir. Sources are matched and changes are
+// detected as described for aep.DiffInventories, using the given tolerance.
+// Both inventories are spatially allocated using the spatial and grid
+// configurations in c. The output files are:
+//
+//	diff_sources.csv: the emissions of each pollutant from each source that
+//	was added, removed, or changed;
+//	diff_by_sector.csv, diff_by_state.csv, and diff_by_scc_tier1.csv: the
+//	total emissions of each pollutant in each inventory and their difference
+//	by sector, by state, and by the first tier of the SCC code, along with
+//	the number of sources that were added, removed, or changed;
+//	gridded_difference.csv: the total emissions of each pollutant in each
+//	inventory and their difference in each grid cell;
+//	gridded_difference/<pollutant>.shp: maps of the gridded emissions of
+//	each pollutant in each inventory and their difference.
+func (c *Config) Diff(compare *InventoryConfig, outputDir string, tolerance float64) error {
+	if err := os.MkdirAll(filepath.Join(outputDir, "gridded_difference"), os.ModePerm); err != nil {
+		return fmt.Errorf("aeputil: creating output directory: %v", err)
+	}
+	grid, err := c.setGrid()
+	if err != nil {
+		return err
+	}
+
+	log.Println("aep: reading base emissions")
+	baseRecs, _, err := c.Inventory.ReadEmissions()
+	if err != nil {
+		return err
+	}
+	log.Println("aep: reading comparison emissions")
+	compareRecs, _, err := compare.ReadEmissions()
+	if err != nil {
+		return err
+	}
+	d, err := aep.DiffInventories(baseRecs, compareRecs, tolerance)
+	if err != nil {
+		return err
+	}
+	for file, t := range map[string]aep.Table{
+		"diff_sources.csv":      d.SourcesTable(),
+		"diff_by_sector.csv":    d.Summary(aep.DiffBySector),
+		"diff_by_state.csv":     d.Summary(aep.DiffByState),
+		"diff_by_scc_tier1.csv": d.Summary(aep.DiffBySCCTier(1)),
+	} {
+		if err := writeTableCSV(filepath.Join(outputDir, file), t); err != nil {
+			return err
+		}
+	}
+
+	log.Println("aep: gridding emissions")
+	baseEmis, units, err := c.spatialTotals(baseRecs)
+	if err != nil {
+		return err
+	}
+	compareEmis, compareUnits, err := c.spatialTotals(compareRecs)
+	if err != nil {
+		return err
+	}
+	for p, u := range compareUnits {
+		if bu, ok := units[p]; ok && !bu.Matches(u) {
+			return fmt.Errorf("aeputil: pollutant %s units mismatch: %v != %v", p, bu, u)
+		}
+		units[p] = u
+	}
+	pols := make([]aep.Pollutant, 0, len(units))
+	for p := range units {
+		pols = append(pols, p)
+	}
+	sort.Slice(pols, func(i, j int) bool { return pols[i].String() < pols[j].String() })
+
+	// get returns the gridded emissions of pollutant p in cell i.
+	get := func(emis map[aep.Pollutant]*sparse.SparseArray, p aep.Pollutant, i int) float64 {
+		if e, ok := emis[p]; ok {
+			// The spatial processor grid is irregular, with one row per cell.
+			return e.Get(i, 0)
+		}
+		return 0
+	}
+
+	t := aep.Table{{"row", "col", "pollutant", "units", "base", "compare", "difference"}}
+	for _, p := range pols {
+		e, err := shp.NewEncoder(filepath.Join(outputDir, "gridded_difference", p.String()+".shp"), struct {
+			geom.Polygon
+			Row, Col            int
+			Base, Compare, Diff float64
+		}{})
+		if err != nil {
+			return fmt.Errorf("aeputil: writing gridded difference: %v", err)
+		}
+		for i, cell := range grid.Cells {
+			bv, cv := get(baseEmis, p, i), get(compareEmis, p, i)
+			t = append(t, []string{strconv.Itoa(cell.Row), strconv.Itoa(cell.Col), p.String(), units[p].String(),
+				strconv.FormatFloat(bv, 'g', -1, 64), strconv.FormatFloat(cv, 'g', -1, 64),
+				strconv.FormatFloat(cv-bv, 'g', -1, 64)})
+			if err := e.EncodeFields(cell.Polygonal, cell.Row, cell.Col, bv, cv, cv-bv); err != nil {
+				e.Close()
+				return fmt.Errorf("aeputil: writing gridded difference: %v", err)
+			}
+		}
+		e.Close()
+	}
+	return writeTableCSV(filepath.Join(outputDir, "gridded_difference.csv"), t)
+}
+
+// spatialTotals spatially allocates the given emissions records using
+// the spatial configuration in c and returns the total emissions of
+// each pollutant in each grid cell.
+func (c *Config) spatialTotals(recs map[string][]aep.Record) (map[aep.Pollutant]*sparse.SparseArray, map[aep.Pollutant]unit.Dimensions, error) {
+	si := c.Spatial.Iterator(IteratorFromMap(recs), 0)
+	for {
+		if _, err := si.NextGridded(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	emis, units := si.SpatialTotals()
+	u := make(map[aep.Pollutant]unit.Dimensions, len(units))
+	for p, d := range units {
+		u[p] = d
+	}
+	return emis, u, nil
+}
+
+// writeTableCSV writes t to a CSV file.
+func writeTableCSV(fileName string, t aep.Table) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("aeputil: writing %s: %v", filepath.Base(fileName), err)
+	}
+	if err := t.CSV(f); err != nil {
+		f.Close()
+		return fmt.Errorf("aeputil: writing %s: %v", filepath.Base(fileName), err)
+	}
+	return f.Close()
+}
+
+// writeGriddedTotals writes the total emissions of each pollutant in each
+// cell of grid to a CSV file.
+func writeGriddedTotals(fileName string, si *SpatialIterator, grid *aep.GridDef) error {
+	emis, units := si.SpatialTotals()
+	pols := make([]aep.Pollutant, 0, len(emis))
+	for p := range emis {
+		pols = append(pols, p)
+	}
+	sort.Slice(pols, func(i, j int) bool { return pols[i].String() < pols[j].String() })
+
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("aeputil: writing gridded totals: %v", err)
+	}
+	w := csv.NewWriter(f)
+	header := []string{"row", "col"}
+	for _, p := range pols {
+		header = append(header, fmt.Sprintf("%s (%s)", p, units[p]))
+	}
+	w.Write(header)
+	for i, cell := range grid.Cells {
+		line := []string{strconv.Itoa(cell.Row), strconv.Itoa(cell.Col)}
+		for _, p := range pols {
+			// The spatial processor grid is irregular, with one row per cell.
+			line = append(line, strconv.FormatFloat(emis[p].Get(i, 0), 'g', -1, 64))
+		}
+		w.Write(line)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("aeputil: writing gridded totals: %v", err)
+	}
+	return f.Close()
+}
+
+// writeReport writes the total and dropped emissions tables
+// in r to directory dir.
+func writeReport(dir, name string, r *aep.InventoryReport) error {
+	for suffix, t := range map[string]aep.Table{
+		"totals":  r.TotalsTable(),
+		"dropped": r.DroppedTotalsTable(),
+	} {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s_%s.txt", name, suffix)))
+		if err != nil {
+			return fmt.Errorf("aeputil: writing %s report: %v", name, err)
+		}
+		if _, err := t.Tabbed(f); err != nil {
+			f.Close()
+			return fmt.Errorf("aeputil: writing %s report: %v", name, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("aeputil: writing %s report: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// writeSpeciesTotals writes the total emissions of each model species
+// in report r to fileName.
+func writeSpeciesTotals(fileName string, c *SpeciateConfig, r *aep.InventoryReport) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("aeputil: writing species totals: %v", err)
+	}
+	if _, err := c.SpeciesTotals(r).Tabbed(f); err != nil {
+		f.Close()
+		return fmt.Errorf("aeputil: writing species totals: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("aeputil: writing species totals: %v", err)
+	}
+	return nil
+}
+
+// surrogateLocations holds the locations that each spatial surrogate
+// has been used for.
+type surrogateLocations struct {
+	specs map[string]aep.SrgSpec
+	locs  map[string]map[string]*aep.Location
+}
+
+func newSurrogateLocations() *surrogateLocations {
+	return &surrogateLocations{
+		specs: make(map[string]aep.SrgSpec),
+		locs:  make(map[string]map[string]*aep.Location),
+	}
+}
+
+// add records the spatial surrogate and location used by rec, if any.
+func (s *surrogateLocations) add(rec aep.RecordGridded, c *SpatialConfig) error {
+	rs, ok := rec.Parent().(aep.RecordSpatialSurrogate)
+	if !ok {
+		return nil
+	}
+	sp, err := c.SpatialProcessor()
+	if err != nil {
+		return err
+	}
+	code, err := sp.GridRef.GetSrgCode(rs.GetSCC(), rs.GetCountry(), rs.GetFIPS())
+	if err != nil {
+		return err
+	}
+	spec, err := rs.SurrogateSpecification()
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s_%s", rs.GetCountry(), code)
+	if _, ok := s.specs[key]; !ok {
+		s.specs[key] = spec
+		s.locs[key] = make(map[string]*aep.Location)
+	}
+	loc := rs.Location()
+	s.locs[key][loc.Name] = loc
+	return nil
+}
+
+// write writes a shapefile of the gridded weights of each spatial surrogate
+// to directory dir, with one polygon for each grid cell and input location.
+func (s *surrogateLocations) write(dir string, c *SpatialConfig, grid *aep.GridDef) error {
+	if len(s.specs) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("aeputil: creating surrogate directory: %v", err)
+	}
+	sp, err := c.SpatialProcessor()
+	if err != nil {
+		return err
+	}
+	for key, spec := range s.specs {
+		e, err := shp.NewEncoder(filepath.Join(dir, "srg_"+key+".shp"), struct {
+			geom.Polygon
+			Row, Col int
+			InputID  string
+			Weight   float64
+		}{})
+		if err != nil {
+			return fmt.Errorf("aeputil: creating surrogate shapefile: %v", err)
+		}
+		names := make([]string, 0, len(s.locs[key]))
+		for name := range s.locs[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			srg, _, err := sp.Surrogate(spec, sp.Grids[0], s.locs[key][name])
+			if err != nil {
+				e.Close()
+				return err
+			}
+			if srg == nil {
+				continue
+			}
+			for i, cell := range grid.Cells {
+				w := srg.Get(i, 0)
+				if w == 0 {
+					continue
+				}
+				if err := e.EncodeFields(cell.Polygonal, cell.Row, cell.Col, name, w); err != nil {
+					e.Close()
+					return fmt.Errorf("aeputil: writing surrogate shapefile: %v", err)
+				}
+			}
+		}
+		e.Close()
+	}
+	return nil
+}
+
+// Cfg holds configuration information for the aep command.
+type Cfg struct {
+	*viper.Viper
+
+	Root, runCmd, surrogatesCmd, diffCmd, fetchCmd *cobra.Command
+}
+
+// InitializeConfig creates the aep command and its configuration options.
+func InitializeConfig() *Cfg {
+	cfg := &Cfg{
+		Viper: viper.New(),
+	}
+
+	// Root is the main command.
+	cfg.Root = &cobra.Command{
+		Use:   "aep",
+		Short: "A program for processing air pollutant emissions inventories.",
+		Long: `aep reads, speciates, and spatially allocates emissions inventories.
+Use the subcommands specified below to access the functionality.
+
+Emissions processing is configured with a TOML-format configuration file
+(provided using the --config flag) with [Inventory], [Speciate], [Spatial],
+and [Grid] sections, which correspond to the aeputil.InventoryConfig,
+aeputil.SpeciateConfig, aeputil.SpatialConfig, and aeputil.GridConfig types.
+The other options can be set in the configuration file, by using command-line
+arguments, or by setting environment variables in the format 'AEP_var' where
+'var' is the name of the variable to be set.`,
+		DisableAutoGenTag: true,
+		PersistentPreRunE: func(*cobra.Command, []string) error {
+			return setConfig(cfg)
+		},
+	}
+
+	cfg.runCmd = &cobra.Command{
+		Use:   "run",
+		Short: "Process an emissions inventory.",
+		Long: `run reads the emissions inventory, speciates it, and spatially
+allocates it to the output grid, then writes the gridded emissions totals,
+the output grid, emissions reports for each processing step, and (optionally)
+the spatial surrogates to OutputDir.`,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(cfg.GetString("config"))
+			if err != nil {
+				return fmt.Errorf("aep: opening configuration file: %v", err)
+			}
+			c, err := ReadConfig(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			return c.Run(os.ExpandEnv(cfg.GetString("OutputDir")), cfg.GetBool("SurrogateShapefiles"))
+		},
+	}
+
+	cfg.surrogatesCmd = &cobra.Command{
+		Use:   "surrogates",
+		Short: "Generate spatial surrogates.",
+		Long: `surrogates creates every spatial surrogate in the surrogate specification
+for the output grid and each input location, rather than creating them as they
+are needed during emissions processing. It writes shapefiles of the gridded
+surrogates and reports of which locations used backup surrogates and how much
+of each location is covered by the grid to OutputDir. If a spatial cache is
+specified in the configuration file, the surrogates are saved there for use
+in later emissions processing.`,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			f, err := os.Open(cfg.GetString("config"))
+			if err != nil {
+				return fmt.Errorf("aep: opening configuration file: %v", err)
+			}
+			c, err := ReadConfig(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			return c.Surrogates(os.ExpandEnv(cfg.GetString("OutputDir")))
+		},
+	}
+
+	cfg.diffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Compare two emissions inventories.",
+		Long: `diff compares the emissions inventory in the configuration file (the
+base inventory) to the inventory in the [Inventory] section of CompareConfig,
+for example to see the effects of switching to a new inventory version or of
+applying scaling factors. Sources are matched by their sector, location, SCC
+code, and facility. Reports of the sources that were added, removed, or changed
+and of the changes in emissions totals by sector, state, and SCC tier, as
+well as gridded maps of the emissions differences, are written to OutputDir.
+Both inventories are spatially allocated using the [Spatial] and [Grid]
+sections of the base configuration file.`,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			read := func(file string) (*Config, error) {
+				f, err := os.Open(file)
+				if err != nil {
+					return nil, fmt.Errorf("aep: opening configuration file: %v", err)
+				}
+				defer f.Close()
+				return ReadConfig(f)
+			}
+			base, err := read(cfg.GetString("config"))
+			if err != nil {
+				return err
+			}
+			compare, err := read(os.ExpandEnv(cfg.GetString("CompareConfig")))
+			if err != nil {
+				return err
+			}
+			return base.Diff(&compare.Inventory, os.ExpandEnv(cfg.GetString("OutputDir")), cfg.GetFloat64("DiffTolerance"))
+		},
+	}
+
+	cfg.fetchCmd = &cobra.Command{
+		Use:   "fetch",
+		Short: "Download an emissions modeling platform.",
+		Long: `fetch downloads the files for the emissions modeling platform named
+Platform in the TOML-format platform manifest Manifest to FetchDir, verifies
+their checksums, and extracts them. Files that have already been downloaded
+are skipped, and interrupted downloads are resumed, so the command can be run
+again if it fails partway through. If Mirror is specified, the files are
+downloaded from there instead of from the location in the manifest.
+An [Inventory] configuration section for the downloaded platform is written
+to inventory_<Platform>.toml in FetchDir, and the list of platform files with
+their SHA256 checksums is written to checksums_<Platform>.toml in FetchDir
+so that any missing checksums can be added to the manifest.`,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return Fetch(http.DefaultClient, os.ExpandEnv(cfg.GetString("Manifest")), cfg.GetString("Platform"),
+				os.ExpandEnv(cfg.GetString("FetchDir")), os.ExpandEnv(cfg.GetString("Mirror")))
+		},
+	}
+
+	cfg.Root.AddCommand(cfg.runCmd)
+	cfg.Root.AddCommand(cfg.surrogatesCmd)
+	cfg.Root.AddCommand(cfg.diffCmd)
+	cfg.Root.AddCommand(cfg.fetchCmd)
+
+	options := []struct {
+		name, usage, shorthand string
+		defaultVal             interface{}
+		flagsets               []*pflag.FlagSet
+	}{
+		{
+			name:       "config",
+			usage:      `config specifies the configuration file location.`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.Root.PersistentFlags()},
+		},
+		{
+			name: "OutputDir",
+			usage: `OutputDir is the directory where the output files should be written. It can contain environment variables.
+`,
+			defaultVal: "aep_output",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.Flags(), cfg.surrogatesCmd.Flags(), cfg.diffCmd.Flags()},
+		},
+		{
+			name: "CompareConfig",
+			usage: `CompareConfig specifies the location of the configuration file with the inventory to compare to the inventory in the main configuration file. It can contain environment variables.
+`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.diffCmd.Flags()},
+		},
+		{
+			name: "DiffTolerance",
+			usage: `DiffTolerance specifies the relative difference in the emissions of a pollutant from a source that is considered to be a change.
+`,
+			defaultVal: 1.0e-6,
+			flagsets:   []*pflag.FlagSet{cfg.diffCmd.Flags()},
+		},
+		{
+			name: "Manifest",
+			usage: `Manifest specifies the location of the TOML-format manifest listing the files in each emissions modeling platform. It can contain environment variables.
+`,
+			defaultVal: "${INMAP_ROOT_DIR}/emissions/aep/data/nei_manifest.toml",
+			flagsets:   []*pflag.FlagSet{cfg.fetchCmd.Flags()},
+		},
+		{
+			name: "Platform",
+			usage: `Platform specifies the name of the emissions modeling platform in Manifest to download, for example "2014v1".
+`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.fetchCmd.Flags()},
+		},
+		{
+			name: "FetchDir",
+			usage: `FetchDir is the directory where the emissions modeling platform files should be downloaded to. It can contain environment variables.
+`,
+			defaultVal: "nei",
+			flagsets:   []*pflag.FlagSet{cfg.fetchCmd.Flags()},
+		},
+		{
+			name: "Mirror",
+			usage: `Mirror specifies a URL to download the emissions modeling platform files from instead of the location in Manifest, for example a local copy of the files.
+`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.fetchCmd.Flags()},
+		},
+		{
+			name: "SurrogateShapefiles",
+			usage: `SurrogateShapefiles specifies whether shapefiles of the spatial surrogates used for allocating the emissions should be written.
+`,
+			defaultVal: true,
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.Flags()},
+		},
+	}
+
+	// Set the prefix for configuration environment variables.
+	cfg.SetEnvPrefix("AEP")
+	cfg.AutomaticEnv()
+
+	for _, option := range options {
+		for i, set := range option.flagsets {
+			if i != 0 { // We don't want to create the same flag twice.
+				set.AddFlag(option.flagsets[0].Lookup(option.name))
+				continue
+			}
+			switch option.defaultVal.(type) {
+			case string:
+				set.StringP(option.name, option.shorthand, option.defaultVal.(string), option.usage)
+			case bool:
+				set.BoolP(option.name, option.shorthand, option.defaultVal.(bool), option.usage)
+			case float64:
+				set.Float64P(option.name, option.shorthand, option.defaultVal.(float64), option.usage)
+			default:
+				panic(fmt.Errorf("invalid argument type: %T", option.defaultVal))
+			}
+			cfg.BindPFlag(option.name, set.Lookup(option.name))
+		}
+	}
+	return cfg
+}
+
+// setConfig finds and reads in the configuration file, if there is one.
+func setConfig(cfg *Cfg) error {
+	if cfgpath := cfg.GetString("config"); cfgpath != "" {
+		cfg.SetConfigFile(cfgpath)
+		if err := cfg.ReadInConfig(); err != nil {
+			return fmt.Errorf("aep: problem reading configuration file: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/ctessum/unit/badunit"
+)
+
+func TestCfg_run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aep_run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const config = `
+[Inventory]
+  InputUnits = "tons"
+  COARDSYear = 2016
+  [Inventory.COARDSFiles]
+    all = ["../testdata/emis_coards_hawaii.nc"]
+
+[Spatial]
+  OutputSR = "+proj=longlat"
+  InputSR = "+proj=longlat"
+  GridName = "hawaii"
+
+[Grid]
+  Nx = 4
+  Ny = 4
+  Dx = 0.1
+  Dy = 0.1
+  X0 = -158.0
+  Y0 = 21.25
+`
+	configFile := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(configFile, []byte(config), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "output")
+
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"run", "--config=" + configFile, "--OutputDir=" + outDir})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"hawaii.shp", "inventory_totals.txt", "inventory_dropped.txt",
+		"spatial_totals.txt", "spatial_dropped.txt"} {
+		if _, err := os.Stat(filepath.Join(outDir, f)); err != nil {
+			t.Errorf("missing output file %s: %v", f, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "speciation_totals.txt")); !os.IsNotExist(err) {
+		t.Errorf("speciation report should not be written without speciation: %v", err)
+	}
+
+	f, err := os.Open(filepath.Join(outDir, "gridded_totals.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHeader := []string{"row", "col", "NH3 (kg)", "NOx (kg)", "PM2_5 (kg)", "SOx (kg)", "VOC (kg)"}
+	for i, h := range wantHeader {
+		if lines[0][i] != h {
+			t.Errorf("header column %d: have %s, want %s", i, lines[0][i], h)
+		}
+	}
+	if len(lines) != 17 {
+		t.Fatalf("have %d lines, want 17", len(lines))
+	}
+	wantTotals := []float64{66765.23687167828, 758866.7728921714, 324952.8165140556, 298144.42248186853, 151891.34532749676}
+	for j, want := range wantTotals {
+		var sum float64
+		for _, line := range lines[1:] {
+			v, err := strconv.ParseFloat(line[j+2], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum += v
+		}
+		if different(sum, want, 1e-10) {
+			t.Errorf("%s: have %g, want %g", wantHeader[j+2], sum, want)
+		}
+	}
+}
+
+func TestCfg_diff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aep_diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The comparison inventory is the same as the base inventory
+	// but in different units.
+	const config = `
+[Inventory]
+  InputUnits = "%s"
+  COARDSYear = 2016
+  [Inventory.COARDSFiles]
+    all = ["../testdata/emis_coards_hawaii.nc"]
+
+[Spatial]
+  OutputSR = "+proj=longlat"
+  InputSR = "+proj=longlat"
+  GridName = "hawaii"
+
+[Grid]
+  Nx = 4
+  Ny = 4
+  Dx = 0.1
+  Dy = 0.1
+  X0 = -158.0
+  Y0 = 21.25
+`
+	baseFile := filepath.Join(dir, "base.toml")
+	if err := ioutil.WriteFile(baseFile, []byte(fmt.Sprintf(config, "tons")), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	compareFile := filepath.Join(dir, "compare.toml")
+	if err := ioutil.WriteFile(compareFile, []byte(fmt.Sprintf(config, "kg")), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "output")
+
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"diff", "--config=" + baseFile, "--CompareConfig=" + compareFile, "--OutputDir=" + outDir})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	readCSV := func(name string) [][]string {
+		f, err := os.Open(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		lines, err := csv.NewReader(f).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lines
+	}
+
+	kgPerTon := badunit.Ton(1).Value()
+	differs := func(v1, v2 float64) bool { return different(v1, v2, 1e-10) || different(v2, v1, 1e-10) }
+	sources := readCSV("diff_sources.csv")
+	if len(sources) < 2 {
+		t.Errorf("no changed sources")
+	}
+	for _, line := range sources[1:] {
+		if line[0] != "changed" {
+			t.Errorf("source %s status: have %s, want changed", line[6], line[0])
+		}
+	}
+	for _, name := range []string{"diff_by_sector.csv", "diff_by_state.csv", "diff_by_scc_tier1.csv"} {
+		lines := readCSV(name)
+		if len(lines) < 6 {
+			t.Errorf("%s: have %d lines, want at least 6", name, len(lines))
+		}
+		for _, line := range lines[1:] {
+			base, _ := strconv.ParseFloat(line[3], 64)
+			compare, _ := strconv.ParseFloat(line[4], 64)
+			if differs(base, compare*kgPerTon) {
+				t.Errorf("%s %s %s: base %g, compare %g", name, line[0], line[1], base, compare)
+			}
+		}
+	}
+
+	lines := readCSV("gridded_difference.csv")
+	wantHeader := []string{"row", "col", "pollutant", "units", "base", "compare", "difference"}
+	for i, h := range wantHeader {
+		if lines[0][i] != h {
+			t.Errorf("header column %d: have %s, want %s", i, lines[0][i], h)
+		}
+	}
+	if len(lines) != 5*16+1 {
+		t.Fatalf("have %d lines, want %d", len(lines), 5*16+1)
+	}
+	wantTotals := map[string]float64{"NH3": 66765.23687167828, "NOx": 758866.7728921714,
+		"PM2_5": 324952.8165140556, "SOx": 298144.42248186853, "VOC": 151891.34532749676}
+	sums := make(map[string][3]float64)
+	for _, line := range lines[1:] {
+		s := sums[line[2]]
+		for j := range s {
+			v, err := strconv.ParseFloat(line[j+4], 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s[j] += v
+		}
+		sums[line[2]] = s
+	}
+	for pol, want := range wantTotals {
+		s := sums[pol]
+		if differs(s[0], want) {
+			t.Errorf("%s base: have %g, want %g", pol, s[0], want)
+		}
+		if differs(s[1], want/kgPerTon) {
+			t.Errorf("%s compare: have %g, want %g", pol, s[1], want/kgPerTon)
+		}
+		if differs(-s[2], want-want/kgPerTon) {
+			t.Errorf("%s difference: have %g, want %g", pol, s[2], want/kgPerTon-want)
+		}
+		if _, err := os.Stat(filepath.Join(outDir, "gridded_difference", pol+".shp")); err != nil {
+			t.Errorf("missing gridded difference map: %v", err)
+		}
+	}
+}
+
+func TestCfg_surrogates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aep_surrogates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const srgSpec = `"REGION","SURROGATE","SURROGATE CODE","DATA SHAPEFILE","DATA ATTRIBUTE","WEIGHT SHAPEFILE","WEIGHT ATTRIBUTE","WEIGHT FUNCTION","FILTER FUNCTION","MERGE FUNCTION","SECONDARY SURROGATE","TERTIARY SURROGATE","QUARTERNARY SURROGATE","DETAILS","COMMENTS"
+"USA","Urban Primary Road Miles",200,"cty_pophu2k_revised","FIPSSTCO","rd_ps_tiger2010","NONE",,"RDTYPE = 1",,"Total Road Miles",,,"Road Miles of Urban Primary Roads"," "
+"USA","Total Road Miles",240,"cty_pophu2k_revised","FIPSSTCO","rd_ps_tiger2010","NONE",,,,,,,"Total Road Miles"," "
+`
+	srgSpecFile := filepath.Join(dir, "srgspec.csv")
+	if err := ioutil.WriteFile(srgSpecFile, []byte(srgSpec), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	config := `
+[Spatial]
+  SrgSpecSMOKE = "` + srgSpecFile + `"
+  SrgShapefileDirectory = "../testdata"
+  SCCExactMatch = true
+  OutputSR = "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1"
+  InputSR = "+proj=longlat"
+  GridName = "nyc"
+
+[Grid]
+  Nx = 4
+  Ny = 4
+  Dx = 20000.0
+  Dy = 20000.0
+  X0 = 1870000.0
+  Y0 = 280000.0
+`
+	configFile := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(configFile, []byte(config), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "output")
+
+	cfg := InitializeConfig()
+	cfg.Root.SetArgs([]string{"surrogates", "--config=" + configFile, "--OutputDir=" + outDir})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range []string{"surrogates/USA_200/36047.shp", "surrogates/USA_200/09001.shp", "surrogates/USA_240/36103.shp"} {
+		if _, err := os.Stat(filepath.Join(outDir, f)); err != nil {
+			t.Errorf("missing output file %s: %v", f, err)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(outDir, "surrogate_report.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 39 {
+		t.Fatalf("have %d report lines, want 39", len(lines))
+	}
+	want := []string{"USA", "200", "Urban Primary Road Miles", "09001", "Total Road Miles", "Urban Primary Road Miles", "false", "0.12232315431137619"}
+	for i, w := range want {
+		if lines[1][i] != w {
+			t.Errorf("report column %d: have %s, want %s", i, lines[1][i], w)
+		}
+	}
+
+	f2, err := os.Open(filepath.Join(outDir, "surrogate_summary.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	lines, err = csv.NewReader(f2).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSummary := [][]string{
+		{"surrogate", "name", "locations", "fallbacks", "even_allocation"},
+		{"USA_200", "Urban Primary Road Miles", "19", "2", "0"},
+		{"USA_240", "Total Road Miles", "19", "0", "0"},
+	}
+	if len(lines) != len(wantSummary) {
+		t.Fatalf("have %d summary lines, want %d", len(lines), len(wantSummary))
+	}
+	for i, wl := range wantSummary {
+		for j, w := range wl {
+			if lines[i][j] != w {
+				t.Errorf("summary line %d column %d: have %s, want %s", i, j, lines[i][j], w)
+			}
+		}
+	}
+}
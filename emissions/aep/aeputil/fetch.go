@@ -0,0 +1,588 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// FetchManifest lists the files that make up the emissions modeling
+// platforms for one or more National Emissions Inventory years.
+// It is usually read from a TOML file with one [[Platform]] section
+// for each platform.
+type FetchManifest struct {
+	Platform []*FetchPlatform
+}
+
+// FetchPlatform holds the information needed to download and prepare
+// the files for an emissions modeling platform.
+type FetchPlatform struct {
+	// Name is the name of the platform, for example "2014v1".
+	Name string
+
+	// Year is the inventory year.
+	Year int
+
+	// BaseURL is the location that the relative file paths in File
+	// are relative to.
+	BaseURL string
+
+	// File lists the files to download.
+	File []*FetchFile
+
+	// Copy specifies files that should be copied to other files after
+	// all of the files have been downloaded and extracted, in the format
+	// map[source][destinations], with paths relative to the download
+	// directory. This can be used, for example, to supply .prj files for
+	// shapefiles that do not come with them.
+	Copy map[string][]string
+
+	// Inventory is the inventory configuration for the platform.
+	// Relative file paths are relative to the download directory;
+	// paths beginning with an environment variable are left as-is.
+	Inventory InventoryConfig
+}
+
+// FetchFile specifies a file to be downloaded.
+type FetchFile struct {
+	// Path is the location of the file, either relative to the
+	// platform BaseURL or as a full URL.
+	Path string
+
+	// SHA256 is the expected hex-encoded SHA-256 checksum of the file.
+	// If it is empty, the checksum is not verified, but it is set to the
+	// checksum of the downloaded file so that it can be added to the
+	// manifest (see Fetch).
+	SHA256 string `toml:",omitempty"`
+
+	// Extract specifies how the file should be extracted after it is
+	// downloaded. Options are "zip", "tar", "tar.gz", "tar.zip"
+	// (a zip archive of tar archives), and "none". If it is empty,
+	// the extraction method is determined from the file extension.
+	// Archives are kept in the "downloads" subdirectory of the download
+	// directory and their contents are extracted into the download
+	// directory; other files are saved directly to the download directory.
+	Extract string `toml:",omitempty"`
+}
+
+// ReadFetchManifest reads a TOML-format platform manifest from r.
+func ReadFetchManifest(r io.Reader) (*FetchManifest, error) {
+	m := new(FetchManifest)
+	if _, err := toml.DecodeReader(r, m); err != nil {
+		return nil, fmt.Errorf("aeputil: reading fetch manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Find returns the platform with the given name.
+func (m *FetchManifest) Find(name string) (*FetchPlatform, error) {
+	names := make([]string, len(m.Platform))
+	for i, p := range m.Platform {
+		if p.Name == name {
+			return p, nil
+		}
+		names[i] = p.Name
+	}
+	return nil, fmt.Errorf("aeputil: platform %q is not in the fetch manifest; options are %v", name, names)
+}
+
+// Fetch downloads the platform files to directory dir, verifies their
+// checksums, extracts them, and copies the files specified in the
+// Copy field. Files that have already been downloaded and extracted are
+// skipped, and partially downloaded files are resumed where they left off
+// if the server supports it. If mirror is not empty, it is used instead of
+// the platform BaseURL. HTTP, HTTPS, and FTP URLs are supported; client
+// is used for HTTP and HTTPS downloads. Files without a checksum in the
+// receiver are assigned the checksum of the downloaded file.
+func (p *FetchPlatform) Fetch(client *http.Client, dir, mirror string) error {
+	base := p.BaseURL
+	if mirror != "" {
+		base = mirror
+	}
+	for _, f := range p.File {
+		u := f.Path
+		if !strings.Contains(u, "://") {
+			u = strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(f.Path, "/")
+		}
+		extract := f.extractMethod()
+		dst := filepath.Join(dir, path.Base(f.Path))
+		if extract != "none" {
+			dst = filepath.Join(dir, "downloads", path.Base(f.Path))
+		}
+		sum, downloaded, err := download(client, u, dst, f.SHA256)
+		if err != nil {
+			return err
+		}
+		f.SHA256 = sum
+		if extract == "none" {
+			continue
+		}
+		done := dst + ".extracted"
+		if downloaded {
+			// The archive has changed, so it needs to be extracted again.
+			if err := os.Remove(done); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("aeputil: %v", err)
+			}
+		} else if _, err := os.Stat(done); err == nil {
+			continue
+		}
+		log.Printf("aep: extracting %s", dst)
+		if err := extractFile(dst, dir, extract); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(done, nil, 0644); err != nil {
+			return fmt.Errorf("aeputil: %v", err)
+		}
+	}
+	for src, dsts := range p.Copy {
+		for _, dst := range dsts {
+			if err := copyFile(filepath.Join(dir, src), filepath.Join(dir, dst)); err != nil {
+				return fmt.Errorf("aeputil: copying file %s to %s: %v", src, dst, err)
+			}
+		}
+	}
+	return nil
+}
+
+// extractMethod returns how f should be extracted.
+func (f *FetchFile) extractMethod() string {
+	if f.Extract != "" {
+		return f.Extract
+	}
+	switch {
+	case strings.HasSuffix(f.Path, ".tar.zip"):
+		return "tar.zip"
+	case strings.HasSuffix(f.Path, ".zip"):
+		return "zip"
+	case strings.HasSuffix(f.Path, ".tar.gz"), strings.HasSuffix(f.Path, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(f.Path, ".tar"):
+		return "tar"
+	default:
+		return "none"
+	}
+}
+
+// download downloads the file at URL u to file dst. If dst already exists
+// and matches checksum, it is not downloaded again. The file is downloaded
+// to dst + ".part" and renamed to dst once it is complete and its checksum
+// has been verified; if a ".part" file already exists, the download resumes
+// from the end of it. HTTP, HTTPS, and FTP URLs are supported.
+// It returns the SHA-256 checksum of the file and whether it was downloaded
+// (rather than already existing).
+func download(client *http.Client, u, dst, checksum string) (sum string, downloaded bool, err error) {
+	if _, err := os.Stat(dst); err == nil {
+		if sum, err := verifyChecksum(dst, checksum); err == nil {
+			return sum, false, nil
+		}
+		log.Printf("aep: %s does not match its checksum; downloading it again", dst)
+		if err := os.Remove(dst); err != nil {
+			return "", false, fmt.Errorf("aeputil: %v", err)
+		}
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", false, fmt.Errorf("aeputil: downloading %s: %v", u, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return "", false, fmt.Errorf("aeputil: %v", err)
+	}
+
+	part := dst + ".part"
+	var offset int64
+	if fi, err := os.Stat(part); err == nil {
+		offset = fi.Size()
+	}
+	if offset > 0 {
+		log.Printf("aep: resuming download of %s at byte %d", u, offset)
+	} else {
+		log.Printf("aep: downloading %s", u)
+	}
+	var body io.ReadCloser
+	var resumed bool
+	switch parsed.Scheme {
+	case "http", "https":
+		body, resumed, err = httpGet(client, u, offset)
+	case "ftp":
+		body, resumed, err = ftpGet(parsed, offset)
+	default:
+		return "", false, fmt.Errorf("aeputil: downloading %s: unsupported URL scheme %q", u, parsed.Scheme)
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("aeputil: downloading %s: %v", u, err)
+	}
+	if body != nil {
+		flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if resumed {
+			flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		w, err := os.OpenFile(part, flag, 0644)
+		if err != nil {
+			body.Close()
+			return "", false, fmt.Errorf("aeputil: %v", err)
+		}
+		_, err = io.Copy(w, body)
+		if closeErr := body.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			w.Close()
+			return "", false, fmt.Errorf("aeputil: downloading %s: %v", u, err)
+		}
+		if err := w.Close(); err != nil {
+			return "", false, fmt.Errorf("aeputil: %v", err)
+		}
+	}
+	sum, err = verifyChecksum(part, checksum)
+	if err != nil {
+		// Remove the file so that the next attempt starts over.
+		os.Remove(part)
+		return "", false, fmt.Errorf("aeputil: downloading %s: %v", u, err)
+	}
+	if err := os.Rename(part, dst); err != nil {
+		return "", false, fmt.Errorf("aeputil: %v", err)
+	}
+	return sum, true, nil
+}
+
+// httpGet starts downloading the file at HTTP or HTTPS URL u, starting at
+// byte offset. It returns the response body and whether the server was able
+// to start the download at offset; if not, the download starts at the
+// beginning of the file. The body is nil if the partial file is already
+// complete.
+func httpGet(client *http.Client, u string, offset int64) (io.ReadCloser, bool, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 &&
+		strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		return resp.Body, true, nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The partial file is already complete.
+		resp.Body.Close()
+		return nil, true, nil
+	case resp.StatusCode == http.StatusOK:
+		// The server does not support resuming, so start over.
+		return resp.Body, false, nil
+	default:
+		resp.Body.Close()
+		return nil, false, fmt.Errorf("%s", resp.Status)
+	}
+}
+
+// verifyChecksum returns the SHA-256 checksum of file, and an error if it
+// does not match checksum. If checksum is empty, the checksum is not verified.
+func verifyChecksum(file, checksum string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if checksum != "" && !strings.EqualFold(sum, checksum) {
+		return "", fmt.Errorf("SHA256 checksum %s does not match expected checksum %s", sum, checksum)
+	}
+	return sum, nil
+}
+
+// extractFile extracts archive file into directory dir using the given
+// method.
+func extractFile(file, dir, method string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("aeputil: %v", err)
+	}
+	defer f.Close()
+	switch method {
+	case "zip", "tar.zip":
+		fi, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("aeputil: %v", err)
+		}
+		r, err := zip.NewReader(f, fi.Size())
+		if err != nil {
+			return fmt.Errorf("aeputil: extracting %s: %v", file, err)
+		}
+		for _, zf := range r.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return fmt.Errorf("aeputil: extracting %s: %v", file, err)
+			}
+			if method == "tar.zip" {
+				err = extractTar(rc, dir)
+			} else {
+				err = saveExtracted(rc, dir, zf.Name)
+			}
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("aeputil: extracting %s: %v", file, err)
+			}
+		}
+		return nil
+	case "tar.gz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("aeputil: extracting %s: %v", file, err)
+		}
+		if err := extractTar(gz, dir); err != nil {
+			return fmt.Errorf("aeputil: extracting %s: %v", file, err)
+		}
+		return nil
+	case "tar":
+		if err := extractTar(f, dir); err != nil {
+			return fmt.Errorf("aeputil: extracting %s: %v", file, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("aeputil: invalid extraction method %q for %s", method, file)
+	}
+}
+
+// extractTar extracts the regular files in tar archive r into directory dir.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+		case tar.TypeReg, tar.TypeRegA:
+			if err := saveExtracted(tr, dir, header.Name); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported type %c in file %s", header.Typeflag, header.Name)
+		}
+	}
+}
+
+// saveExtracted saves the contents of r to the file with the given name
+// in directory dir, returning an error if the file would be outside of dir.
+func saveExtracted(r io.Reader, dir, name string) error {
+	dst := filepath.Join(dir, name)
+	if !strings.HasPrefix(dst, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("archive file %s is outside of the extraction directory", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// copyFile copies file src to file dst, replacing the contents of dst
+// if it already exists.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// InventoryConfig returns the platform inventory configuration with
+// relative file paths converted to paths within download directory dir.
+func (p *FetchPlatform) InventoryConfig(dir string) *InventoryConfig {
+	abs := func(f string) string {
+		if f == "" || filepath.IsAbs(f) || strings.HasPrefix(f, "$") {
+			return f
+		}
+		return filepath.Join(dir, f)
+	}
+	absAll := func(files []string) []string {
+		if files == nil {
+			return nil
+		}
+		o := make([]string, len(files))
+		for i, f := range files {
+			o[i] = abs(f)
+		}
+		return o
+	}
+	absMap := func(m map[string][]string) map[string][]string {
+		if m == nil {
+			return nil
+		}
+		o := make(map[string][]string, len(m))
+		for sector, files := range m {
+			o[sector] = absAll(files)
+		}
+		return o
+	}
+	c := p.Inventory
+	c.NEIFiles = absMap(c.NEIFiles)
+	c.COARDSFiles = absMap(c.COARDSFiles)
+	c.SrgSpecSMOKE = abs(c.SrgSpecSMOKE)
+	c.SrgSpecOSM = abs(c.SrgSpecOSM)
+	c.OSMFile = abs(c.OSMFile)
+	c.SrgShapefileDirectory = abs(c.SrgShapefileDirectory)
+	c.GridRef = absAll(c.GridRef)
+	c.ProjectionFiles = absAll(c.ProjectionFiles)
+	c.ControlFiles = absAll(c.ControlFiles)
+	return &c
+}
+
+// WriteInventoryConfig writes the platform inventory configuration, with
+// file paths within download directory dir, to w as the [Inventory]
+// section of a TOML-format configuration file.
+func (p *FetchPlatform) WriteInventoryConfig(w io.Writer, dir string) error {
+	c := p.InventoryConfig(dir)
+	if _, err := fmt.Fprintf(w, "# Inventory configuration for the %s emissions modeling platform (%d)\n", p.Name, p.Year); err != nil {
+		return fmt.Errorf("aeputil: writing inventory configuration: %v", err)
+	}
+	// The TOML encoder can't encode function fields such as FilterFunc,
+	// so only the fields that are set and can be encoded are written.
+	rv := reflect.ValueOf(c).Elem()
+	fields := make(map[string]interface{})
+	for i := 0; i < rv.NumField(); i++ {
+		if f := rv.Field(i); f.Kind() != reflect.Func && !f.IsZero() {
+			fields[rv.Type().Field(i).Name] = f.Interface()
+		}
+	}
+	if err := toml.NewEncoder(w).Encode(map[string]interface{}{"Inventory": fields}); err != nil {
+		return fmt.Errorf("aeputil: writing inventory configuration: %v", err)
+	}
+	return nil
+}
+
+// WriteFileChecksums writes the [[Platform.File]] entries of the receiver,
+// including their checksums, to w in the format of the platform manifest.
+// After the platform has been downloaded, the output can be used to add
+// missing checksums to the manifest.
+func (p *FetchPlatform) WriteFileChecksums(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "# Files in the %s emissions modeling platform with their SHA256 checksums\n", p.Name); err != nil {
+		return fmt.Errorf("aeputil: writing file checksums: %v", err)
+	}
+	platform := map[string]interface{}{"Name": p.Name, "File": p.File}
+	if err := toml.NewEncoder(w).Encode(map[string]interface{}{"Platform": []interface{}{platform}}); err != nil {
+		return fmt.Errorf("aeputil: writing file checksums: %v", err)
+	}
+	return nil
+}
+
+// Fetch downloads the emissions modeling platform with the given name in
+// the manifest file to directory dir using client (see FetchPlatform.Fetch)
+// and writes its inventory configuration to inventory_<platform>.toml in dir.
+// It also writes the files in the platform with their checksums to
+// checksums_<platform>.toml in dir (see FetchPlatform.WriteFileChecksums).
+func Fetch(client *http.Client, manifestFile, platform, dir, mirror string) error {
+	f, err := os.Open(manifestFile)
+	if err != nil {
+		return fmt.Errorf("aeputil: opening fetch manifest: %v", err)
+	}
+	m, err := ReadFetchManifest(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	p, err := m.Find(platform)
+	if err != nil {
+		return err
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return fmt.Errorf("aeputil: %v", err)
+	}
+	if err := p.Fetch(client, dir, mirror); err != nil {
+		return err
+	}
+	cfgFile := filepath.Join(dir, "inventory_"+p.Name+".toml")
+	w, err := os.Create(cfgFile)
+	if err != nil {
+		return fmt.Errorf("aeputil: writing inventory configuration: %v", err)
+	}
+	if err := p.WriteInventoryConfig(w, dir); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("aeputil: writing inventory configuration: %v", err)
+	}
+	log.Printf("aep: wrote inventory configuration to %s", cfgFile)
+
+	sumFile := filepath.Join(dir, "checksums_"+p.Name+".toml")
+	w, err = os.Create(sumFile)
+	if err != nil {
+		return fmt.Errorf("aeputil: writing file checksums: %v", err)
+	}
+	if err := p.WriteFileChecksums(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("aeputil: writing file checksums: %v", err)
+	}
+	log.Printf("aep: wrote file checksums to %s", sumFile)
+	return nil
+}
@@ -0,0 +1,492 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fetchTestServer is a local stand-in for the server holding the
+// emissions modeling platform files.
+type fetchTestServer struct {
+	files map[string][]byte
+
+	mu       sync.Mutex
+	requests []string // The paths and ranges of the requests.
+}
+
+func (s *fetchTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, strings.TrimSpace(r.URL.Path+" "+r.Header.Get("Range")))
+	s.mu.Unlock()
+	b, ok := s.files[strings.TrimPrefix(r.URL.Path, "/emis/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, path.Base(r.URL.Path), time.Time{}, bytes.NewReader(b))
+}
+
+// serveFTP serves the files in the receiver over FTP on a local port
+// until l is closed. Only the commands used by ftpGet are supported,
+// and only one file can be retrieved per connection.
+func (s *fetchTestServer) serveFTP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			reply := func(format string, args ...interface{}) { fmt.Fprintf(conn, format+"\r\n", args...) }
+			reply("220 test server ready")
+			var dataL net.Listener
+			var offset int
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+				switch fields[0] {
+				case "USER":
+					reply("331 password required")
+				case "PASS":
+					reply("230-welcome\r\n230 logged in")
+				case "TYPE":
+					reply("200 type set")
+				case "EPSV":
+					if dataL, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+						reply("425 %v", err)
+						continue
+					}
+					defer dataL.Close()
+					reply("229 Entering Extended Passive Mode (|||%d|)", dataL.Addr().(*net.TCPAddr).Port)
+				case "REST":
+					offset, _ = strconv.Atoi(fields[1])
+					reply("350 restarting at %d", offset)
+				case "RETR":
+					req := fields[1]
+					if offset > 0 {
+						req += fmt.Sprintf(" bytes=%d-", offset)
+					}
+					s.mu.Lock()
+					s.requests = append(s.requests, req)
+					s.mu.Unlock()
+					b, ok := s.files[strings.TrimPrefix(fields[1], "/emis/")]
+					if !ok || dataL == nil {
+						reply("550 file not found")
+						continue
+					}
+					reply("150 opening data connection")
+					data, err := dataL.Accept()
+					if err != nil {
+						return
+					}
+					data.Write(b[offset:])
+					data.Close()
+					reply("226 transfer complete")
+				case "QUIT":
+					reply("221 goodbye")
+					return
+				default:
+					reply("502 command not implemented")
+				}
+			}
+		}(conn)
+	}
+}
+
+func (s *fetchTestServer) reset() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.requests
+	s.requests = nil
+	return r
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func TestFetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aep_fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	zipBuf := new(bytes.Buffer)
+	zw := zip.NewWriter(zipBuf)
+	zf, err := zw.Create("inputs/nonpt/nonpt.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(zf, "nonpoint emissions")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tgzBuf := new(bytes.Buffer)
+	gw := gzip.NewWriter(tgzBuf)
+	tw := tar.NewWriter(gw)
+	shp := []byte("shapefile projection")
+	if err := tw.WriteHeader(&tar.Header{Name: "shapefiles/pop.prj", Mode: 0644, Size: int64(len(shp)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(shp)
+	tw.Close()
+	gw.Close()
+
+	readme := []byte(strings.Repeat("This is the platform README. ", 100))
+
+	srv := &fetchTestServer{files: map[string][]byte{
+		"2014/inputs.zip":     zipBuf.Bytes(),
+		"2014/shapefiles.tgz": tgzBuf.Bytes(),
+		"2014/README.txt":     readme,
+		"2017/README.txt":     []byte("2017 README"),
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	manifest := fmt.Sprintf(`
+[[Platform]]
+Name = "2014v1"
+Year = 2014
+BaseURL = "%[1]s/emis/2014"
+  [[Platform.File]]
+  Path = "inputs.zip"
+  SHA256 = "%[2]s"
+  [[Platform.File]]
+  Path = "shapefiles.tgz"
+  SHA256 = "%[3]s"
+  [[Platform.File]]
+  Path = "%[1]s/emis/2014/README.txt"
+  [Platform.Copy]
+    "shapefiles/pop.prj" = ["shapefiles/road.prj"]
+  [Platform.Inventory]
+    InputUnits = "tons"
+    SrgSpecSMOKE = "${INMAP_ROOT_DIR}/srgspec.csv"
+    SrgShapefileDirectory = "shapefiles"
+    GridRef = ["gridref.txt"]
+    [Platform.Inventory.NEIFiles]
+      nonpt = ["inputs/nonpt/nonpt.csv"]
+    [Platform.Inventory.PolsToKeep.NOX]
+      SpecType = "NOx"
+
+[[Platform]]
+Name = "2017gb"
+Year = 2017
+BaseURL = "%[1]s/emis/2017"
+  [[Platform.File]]
+  Path = "README.txt"
+  SHA256 = "%[4]s"
+`, ts.URL, sha256Hex(zipBuf.Bytes()), sha256Hex(tgzBuf.Bytes()), sha256Hex([]byte("2017 README")))
+	manifestFile := filepath.Join(dir, "manifest.toml")
+	if err := ioutil.WriteFile(manifestFile, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fetchDir := filepath.Join(dir, "nei")
+
+	// Simulate an interrupted download of the README.
+	if err := os.MkdirAll(fetchDir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(fetchDir, "README.txt.part"), readme[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Fetch(ts.Client(), manifestFile, "2014v1", fetchDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	wantRequests := []string{"/emis/2014/inputs.zip", "/emis/2014/shapefiles.tgz", "/emis/2014/README.txt bytes=1000-"}
+	if have := srv.reset(); !reflect.DeepEqual(have, wantRequests) {
+		t.Errorf("requests: have %v, want %v", have, wantRequests)
+	}
+	for file, want := range map[string][]byte{
+		"inputs/nonpt/nonpt.csv": []byte("nonpoint emissions"),
+		"shapefiles/pop.prj":     shp,
+		"shapefiles/road.prj":    shp,
+		"README.txt":             readme,
+		"downloads/inputs.zip":   zipBuf.Bytes(),
+	} {
+		have, err := ioutil.ReadFile(filepath.Join(fetchDir, file))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(have, want) {
+			t.Errorf("%s: have %q, want %q", file, have, want)
+		}
+	}
+
+	t.Run("inventory config", func(t *testing.T) {
+		f, err := os.Open(filepath.Join(fetchDir, "inventory_2014v1.toml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		c, err := ReadConfig(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		absDir, err := filepath.Abs(fetchDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{filepath.Join(absDir, "inputs/nonpt/nonpt.csv")}; !reflect.DeepEqual(c.Inventory.NEIFiles["nonpt"], want) {
+			t.Errorf("NEIFiles: have %v, want %v", c.Inventory.NEIFiles["nonpt"], want)
+		}
+		if want := []string{filepath.Join(absDir, "gridref.txt")}; !reflect.DeepEqual(c.Inventory.GridRef, want) {
+			t.Errorf("GridRef: have %v, want %v", c.Inventory.GridRef, want)
+		}
+		if want := filepath.Join(absDir, "shapefiles"); c.Inventory.SrgShapefileDirectory != want {
+			t.Errorf("SrgShapefileDirectory: have %s, want %s", c.Inventory.SrgShapefileDirectory, want)
+		}
+		if want := "${INMAP_ROOT_DIR}/srgspec.csv"; c.Inventory.SrgSpecSMOKE != want {
+			t.Errorf("SrgSpecSMOKE: have %s, want %s", c.Inventory.SrgSpecSMOKE, want)
+		}
+		if c.Inventory.InputUnits != "tons" {
+			t.Errorf("InputUnits: have %s, want tons", c.Inventory.InputUnits)
+		}
+		if c.Inventory.PolsToKeep["NOX"].SpecType != "NOx" {
+			t.Errorf("PolsToKeep: have %+v", c.Inventory.PolsToKeep)
+		}
+	})
+
+	t.Run("checksums", func(t *testing.T) {
+		f, err := os.Open(filepath.Join(fetchDir, "checksums_2014v1.toml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		m, err := ReadFetchManifest(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := m.Find("2014v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []*FetchFile{
+			{Path: "inputs.zip", SHA256: sha256Hex(zipBuf.Bytes())},
+			{Path: "shapefiles.tgz", SHA256: sha256Hex(tgzBuf.Bytes())},
+			// The checksum of the README is not in the manifest, so it is
+			// calculated from the downloaded file.
+			{Path: ts.URL + "/emis/2014/README.txt", SHA256: sha256Hex(readme)},
+		}
+		if !reflect.DeepEqual(p.File, want) {
+			t.Errorf("files: have %+v, want %+v", p.File, want)
+		}
+	})
+
+	t.Run("already downloaded", func(t *testing.T) {
+		if err := Fetch(ts.Client(), manifestFile, "2014v1", fetchDir, ""); err != nil {
+			t.Fatal(err)
+		}
+		if have := srv.reset(); len(have) != 0 {
+			t.Errorf("there should be no requests but have %v", have)
+		}
+	})
+
+	t.Run("corrupted archive", func(t *testing.T) {
+		if err := ioutil.WriteFile(filepath.Join(fetchDir, "downloads/inputs.zip"), []byte("corrupted"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		nonpt := filepath.Join(fetchDir, "inputs/nonpt/nonpt.csv")
+		if err := ioutil.WriteFile(nonpt, []byte("corrupted"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := Fetch(ts.Client(), manifestFile, "2014v1", fetchDir, ""); err != nil {
+			t.Fatal(err)
+		}
+		if have, want := srv.reset(), []string{"/emis/2014/inputs.zip"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("requests: have %v, want %v", have, want)
+		}
+		// The archive should have been extracted again.
+		have, err := ioutil.ReadFile(nonpt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte("nonpoint emissions"); !bytes.Equal(have, want) {
+			t.Errorf("nonpt.csv: have %q, want %q", have, want)
+		}
+	})
+
+	t.Run("another year", func(t *testing.T) {
+		otherDir := filepath.Join(dir, "nei2017")
+		if err := Fetch(ts.Client(), manifestFile, "2017gb", otherDir, ""); err != nil {
+			t.Fatal(err)
+		}
+		if have, want := srv.reset(), []string{"/emis/2017/README.txt"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("requests: have %v, want %v", have, want)
+		}
+	})
+
+	t.Run("mirror", func(t *testing.T) {
+		f, err := os.Open(manifestFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := ReadFetchManifest(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := m.Find("2017gb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.BaseURL = "gopher://example.com/emis/2017"
+		if err := p.Fetch(ts.Client(), filepath.Join(dir, "gopher"), ""); err == nil {
+			t.Error("there should be an error for an unsupported URL scheme")
+		}
+		if err := p.Fetch(ts.Client(), filepath.Join(dir, "mirror"), ts.URL+"/emis/2017/"); err != nil {
+			t.Fatal(err)
+		}
+		if have, want := srv.reset(), []string{"/emis/2017/README.txt"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("requests: have %v, want %v", have, want)
+		}
+	})
+
+	t.Run("ftp", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go srv.serveFTP(l)
+
+		p := &FetchPlatform{
+			BaseURL: "ftp://" + l.Addr().String() + "/emis/2014",
+			File: []*FetchFile{
+				{Path: "inputs.zip", SHA256: sha256Hex(zipBuf.Bytes())},
+				{Path: "README.txt", SHA256: sha256Hex(readme)},
+			},
+		}
+		ftpDir := filepath.Join(dir, "ftp")
+		if err := os.MkdirAll(ftpDir, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(ftpDir, "README.txt.part"), readme[:1000], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Fetch(ts.Client(), ftpDir, ""); err != nil {
+			t.Fatal(err)
+		}
+		if have, want := srv.reset(), []string{"/emis/2014/inputs.zip", "/emis/2014/README.txt bytes=1000-"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("requests: have %v, want %v", have, want)
+		}
+		for file, want := range map[string][]byte{
+			"inputs/nonpt/nonpt.csv": []byte("nonpoint emissions"),
+			"README.txt":             readme,
+		} {
+			have, err := ioutil.ReadFile(filepath.Join(ftpDir, file))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if !bytes.Equal(have, want) {
+				t.Errorf("%s: have %q, want %q", file, have, want)
+			}
+		}
+
+		p.File = []*FetchFile{{Path: "missing.txt"}}
+		if err := p.Fetch(ts.Client(), ftpDir, ""); err == nil {
+			t.Error("there should be an error for a missing file")
+		}
+		srv.reset()
+	})
+
+	t.Run("bad checksum", func(t *testing.T) {
+		p := &FetchPlatform{
+			BaseURL: ts.URL + "/emis/2017",
+			File:    []*FetchFile{{Path: "README.txt", SHA256: sha256Hex([]byte("wrong"))}},
+		}
+		badDir := filepath.Join(dir, "bad")
+		if err := p.Fetch(ts.Client(), badDir, ""); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("there should be a checksum error but have %v", err)
+		}
+		for _, f := range []string{"README.txt", "README.txt.part"} {
+			if _, err := os.Stat(filepath.Join(badDir, f)); !os.IsNotExist(err) {
+				t.Errorf("%s should not exist", f)
+			}
+		}
+	})
+
+	t.Run("missing platform", func(t *testing.T) {
+		if err := Fetch(ts.Client(), manifestFile, "1999", filepath.Join(dir, "missing"), ""); err == nil {
+			t.Error("there should be an error for a platform that is not in the manifest")
+		}
+	})
+}
+
+func TestFetchManifest_nei(t *testing.T) {
+	f, err := os.Open("../data/nei_manifest.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m, err := ReadFetchManifest(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := m.Find("2014v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Year != 2014 {
+		t.Errorf("year: have %d, want 2014", p.Year)
+	}
+	if len(p.File) == 0 {
+		t.Error("there should be files")
+	}
+	if len(p.Inventory.NEIFiles) == 0 || len(p.Inventory.PolsToKeep) == 0 {
+		t.Error("the inventory configuration should be set")
+	}
+	p, err = m.Find("2016fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Year != 2016 {
+		t.Errorf("year: have %d, want 2016", p.Year)
+	}
+}
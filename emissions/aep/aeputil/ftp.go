@@ -0,0 +1,165 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpTimeout is the timeout for connecting to FTP servers.
+const ftpTimeout = 30 * time.Second
+
+// ftpFile is the body of a file being retrieved from an FTP server.
+type ftpFile struct {
+	data net.Conn
+	ctrl *textproto.Conn
+}
+
+func (f *ftpFile) Read(p []byte) (int, error) { return f.data.Read(p) }
+
+// Close closes the data connection and then waits for the server to
+// confirm that the transfer is complete before closing the control
+// connection.
+func (f *ftpFile) Close() error {
+	f.data.Close()
+	_, _, err := f.ctrl.ReadResponse(2)
+	f.ctrl.PrintfLine("QUIT")
+	f.ctrl.Close()
+	return err
+}
+
+// ftpGet starts retrieving the file at FTP URL u, starting at byte offset.
+// Anonymous login is used unless u includes user information.
+// It returns the file body and whether the server was able to start the
+// transfer at offset; if not, the transfer starts at the beginning
+// of the file.
+func ftpGet(u *url.URL, offset int64) (body io.ReadCloser, resumed bool, err error) {
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "21")
+	}
+	conn, err := net.DialTimeout("tcp", host, ftpTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	ctrl := textproto.NewConn(conn)
+	defer func() {
+		if err != nil {
+			ctrl.Close()
+		}
+	}()
+	cmd := func(expectCode int, format string, args ...interface{}) (int, string, error) {
+		if err := ctrl.PrintfLine(format, args...); err != nil {
+			return 0, "", err
+		}
+		return ctrl.ReadResponse(expectCode)
+	}
+	if _, _, err = ctrl.ReadResponse(2); err != nil {
+		return nil, false, err
+	}
+
+	user, pass := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			pass = p
+		}
+	}
+	code, _, err := cmd(0, "USER %s", user)
+	switch {
+	case err != nil:
+		return nil, false, err
+	case code == 331:
+		if _, _, err = cmd(2, "PASS %s", pass); err != nil {
+			return nil, false, err
+		}
+	case code/100 != 2:
+		return nil, false, fmt.Errorf("logging in: unexpected response code %d", code)
+	}
+	if _, _, err = cmd(2, "TYPE I"); err != nil {
+		return nil, false, err
+	}
+
+	dataAddr, err := ftpPassive(cmd, u.Hostname())
+	if err != nil {
+		return nil, false, err
+	}
+	if offset > 0 {
+		// The server may not support restarting transfers, in which case
+		// the transfer starts at the beginning of the file.
+		if _, _, err := cmd(3, "REST %d", offset); err == nil {
+			resumed = true
+		}
+	}
+	data, err := net.DialTimeout("tcp", dataAddr, ftpTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, _, err = cmd(1, "RETR %s", u.Path); err != nil {
+		data.Close()
+		return nil, false, err
+	}
+	return &ftpFile{data: data, ctrl: ctrl}, resumed, nil
+}
+
+// ftpPassive puts the FTP server into passive mode and returns the address
+// to connect to for the data connection. The data connection uses the
+// same host as the control connection, because servers behind NAT often
+// report an unreachable address in response to PASV.
+func ftpPassive(cmd func(int, string, ...interface{}) (int, string, error), host string) (string, error) {
+	if _, msg, err := cmd(229, "EPSV"); err == nil {
+		// The response is in the format "Entering Extended Passive Mode (|||port|)".
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start >= 0 && end > start {
+			fields := strings.Split(msg[start+1:end], "|")
+			if len(fields) == 5 {
+				if _, err := strconv.Atoi(fields[3]); err == nil {
+					return net.JoinHostPort(host, fields[3]), nil
+				}
+			}
+		}
+		return "", fmt.Errorf("invalid EPSV response %q", msg)
+	}
+	_, msg, err := cmd(227, "PASV")
+	if err != nil {
+		return "", err
+	}
+	// The response is in the format "Entering Passive Mode (h1,h2,h3,h4,p1,p2)".
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("invalid PASV response %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return "", fmt.Errorf("invalid PASV response %q", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("invalid PASV response %q", msg)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p1*256+p2)), nil
+}
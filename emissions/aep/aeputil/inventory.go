@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/ctessum/unit"
@@ -78,6 +79,12 @@ type InventoryConfig struct {
 	// loading the data.
 	PostGISURL string
 
+	// OSMFile gives the location of a local OpenStreetMap data file
+	// (in .osm.pbf or .osm format) to read the OSM surrogate data from.
+	// If it is specified, it is used instead of the PostGIS database
+	// specified by PostGISURL.
+	OSMFile string
+
 	// SrgShapefileDirectory gives the location of the directory holding
 	// the shapefiles used for creating spatial surrogates.
 	// It is used for assigning spatial locations to emissions records.
@@ -95,13 +102,31 @@ type InventoryConfig struct {
 	// FilterFunc specifies which records should be kept.
 	// If it is nil, all records are kept.
 	FilterFunc aep.RecFilter
+
+	// ProjectionFiles lists files holding SMOKE-style projection packets
+	// used to project emissions from ProjectionBaseYear to ProjectionYear
+	// after they are read. The file names can include environment variables.
+	// See ScaleProjection for the file format.
+	ProjectionFiles []string
+
+	// ProjectionBaseYear and ProjectionYear specify the year of the
+	// input emissions and the year they should be projected to
+	// when ProjectionFiles is specified.
+	ProjectionBaseYear, ProjectionYear int
+
+	// ControlFiles lists files holding control strategy packets
+	// to be applied to the emissions records after they are read.
+	// The file names can include environment variables.
+	// See aep.ReadControlStrategy for the file format; emissions
+	// caps and replacements are in the units specified by InputUnits.
+	ControlFiles []string
 }
 
 // ReadEmissions returns emissions records for the files specified
 // in the NEIFiles field in the receiver. The returned records are
 // split up by sector.
 func (c *InventoryConfig) ReadEmissions() (map[string][]aep.Record, *aep.InventoryReport, error) {
-	srgSpecs, err := readSrgSpec(c.SrgSpecSMOKE, c.SrgSpecOSM, c.PostGISURL, c.SrgShapefileDirectory, c.SCCExactMatch, "", 0)
+	srgSpecs, err := readSrgSpec(c.SrgSpecSMOKE, c.SrgSpecOSM, "", c.PostGISURL, c.OSMFile, c.SrgShapefileDirectory, c.SCCExactMatch, "", 0)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -186,9 +211,91 @@ func (c *InventoryConfig) ReadEmissions() (map[string][]aep.Record, *aep.Invento
 			inventoryReport.AddData(t)
 		}
 	}
+
+	if len(c.ProjectionFiles) > 0 {
+		projectionReport, err := c.project(records)
+		if err != nil {
+			return nil, nil, err
+		}
+		inventoryReport.AddData(projectionReport...)
+	}
+
+	if len(c.ControlFiles) > 0 {
+		controlReport, err := c.applyControls(records, units)
+		if err != nil {
+			return nil, nil, err
+		}
+		inventoryReport.AddData(controlReport.Data...)
+	}
 	return records, inventoryReport, nil
 }
 
+// project projects the given records from ProjectionBaseYear to
+// ProjectionYear using the packets in the ProjectionFiles field of
+// the receiver and returns the projected totals for each sector.
+func (c *InventoryConfig) project(records map[string][]aep.Record) ([]aep.Totaler, error) {
+	if c.ProjectionBaseYear == 0 || c.ProjectionYear == 0 || c.ProjectionBaseYear == c.ProjectionYear {
+		return nil, fmt.Errorf("aeputil: ProjectionBaseYear (%d) and ProjectionYear (%d) must be set and different when ProjectionFiles is specified",
+			c.ProjectionBaseYear, c.ProjectionYear)
+	}
+	files := make([]io.Reader, len(c.ProjectionFiles))
+	for i, file := range c.ProjectionFiles {
+		f, err := os.Open(os.ExpandEnv(file))
+		if err != nil {
+			return nil, fmt.Errorf("aeputil: opening projection file: %v", err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	scale, err := ScaleProjection(c.ProjectionBaseYear, c.ProjectionYear, files...)
+	if err != nil {
+		return nil, err
+	}
+	if err = Scale(records, scale); err != nil {
+		return nil, fmt.Errorf("aeputil: projecting emissions: %v", err)
+	}
+	sectors := make([]string, 0, len(records))
+	for sector := range records {
+		sectors = append(sectors, sector)
+	}
+	sort.Strings(sectors)
+	o := make([]aep.Totaler, len(sectors))
+	for i, sector := range sectors {
+		t := &recordTotaler{
+			name:  fmt.Sprintf("projected to %d", c.ProjectionYear),
+			group: sector,
+		}
+		for _, rec := range records[sector] {
+			t.add(rec)
+		}
+		o[i] = t
+	}
+	return o, nil
+}
+
+// applyControls applies the control strategy in the ControlFiles field
+// of the receiver to the given records.
+func (c *InventoryConfig) applyControls(records map[string][]aep.Record, units aep.InputUnits) (*aep.InventoryReport, error) {
+	files := make([]io.Reader, len(c.ControlFiles))
+	for i, file := range c.ControlFiles {
+		f, err := os.Open(os.ExpandEnv(file))
+		if err != nil {
+			return nil, fmt.Errorf("aeputil: opening control strategy file: %v", err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	cs, err := aep.ReadControlStrategy(units, files...)
+	if err != nil {
+		return nil, fmt.Errorf("aeputil: reading control strategy: %v", err)
+	}
+	report, err := cs.ApplyAll(records)
+	if err != nil {
+		return nil, fmt.Errorf("aeputil: applying control strategy: %v", err)
+	}
+	return report, nil
+}
+
 // A recordTotaler stores information about records.
 type recordTotaler struct {
 
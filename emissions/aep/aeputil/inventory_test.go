@@ -90,3 +90,35 @@ func TestInventory_coards(t *testing.T) {
 		t.Errorf("inventory report: have %v, want %v", report.TotalsTable(), want)
 	}
 }
+
+func TestInventory_controls(t *testing.T) {
+	type config struct {
+		Inventory InventoryConfig
+	}
+	r, err := os.Open("testdata/example_config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(config)
+
+	// Read the configuration file into the configuration variable.
+	if _, err = toml.DecodeReader(r, c); err != nil {
+		t.Fatal(err)
+	}
+	c.Inventory.ControlFiles = []string{"testdata/controls.txt"}
+
+	_, report, err := c.Inventory.ReadEmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := aep.Table{
+		[]string{"Group", "File", "NH3 (kg)", "NOX (kg)", "PM2_5 (kg)", "SO2 (kg)", "VOC (kg)"},
+		[]string{"othar", "testdata/testemis.csv", "34.056105917699995", "1.9697839276290547e+07", "1.3253413523899838e+06", "1.5806320939220862e+07", "650426.9504917137"},
+		[]string{"othar", "before controls", "34.056105917699995", "1.9697839276290547e+07", "1.3253413523899838e+06", "1.5806320939220862e+07", "650426.9504917137"},
+		[]string{"othar", "after controls", "34.056105917699995", "9.848919638145274e+06", "1.3253413523899838e+06", "1.5806320939220862e+07", "650426.9504917137"},
+	}
+	if !reflect.DeepEqual(report.TotalsTable(), want) {
+		t.Errorf("inventory report: have %v, want %v", report.TotalsTable(), want)
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/spatialmodel/inmap/emissions/aep"
+)
+
+// projectionKey specifies which emissions records a projection factor
+// applies to. Blank fields match all records.
+type projectionKey struct {
+	fips, scc, naics, pol string
+}
+
+// projectionPacket holds the growth factors for projecting emissions
+// from one year to another.
+type projectionPacket struct {
+	baseYear, futureYear int
+	factors              map[projectionKey]float64
+}
+
+// factor returns the growth factor in the receiver that most
+// specifically matches the given record and pollutant.
+// Matching pollutant is the most important, followed by NAICS
+// code, SCC, county, and state. If there is no match, the
+// factor is 1.
+func (p *projectionPacket) factor(fips, scc, naics, pol string) float64 {
+	states := []string{fips, "", ""}
+	if len(fips) == 5 {
+		states[1] = fips[0:2] + "000"
+	}
+	for _, kPol := range []string{pol, ""} {
+		for _, kNAICS := range []string{naics, ""} {
+			for _, kSCC := range []string{scc, ""} {
+				for _, kFIPS := range states {
+					if f, ok := p.factors[projectionKey{fips: kFIPS, scc: kSCC, naics: kNAICS, pol: kPol}]; ok {
+						return f
+					}
+				}
+			}
+		}
+	}
+	return 1
+}
+
+// ScaleProjection provides an emissions scaling function to project
+// emissions from baseYear to scaleYear using SMOKE-style projection packets.
+// Each packet starts with a "/PROJECTION <from year> <to year>/" line
+// and ends with an "/END/" line, and lines starting with '#' are comments.
+// Each line in a packet has the comma-separated fields:
+//
+//	FIPS, SCC, pollutant, growth factor, NAICS
+//
+// where blank FIPS, SCC, pollutant, and NAICS fields match all records and
+// a FIPS code ending in "000" matches all counties in the given state.
+// For each record and pollutant, only the most specific matching line in
+// each packet is used, where matching pollutant is the most important,
+// followed by NAICS code, SCC, county, and state. Records that do not
+// match any line in a packet are not scaled by that packet.
+// Packets can be chained together: for example, packets from 2014 to 2025
+// and from 2025 to 2035 can be used to project emissions from 2014 to 2035.
+func ScaleProjection(baseYear, scaleYear int, packets ...io.Reader) (ScaleFunc, error) {
+	if baseYear == scaleYear {
+		return func(rec aep.Record, pol aep.Pollutant) (float64, error) { return 1, nil }, nil
+	}
+	byYear := make(map[int]*projectionPacket)
+	for _, r := range packets {
+		pp, err := readProjectionPackets(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pp {
+			if _, ok := byYear[p.baseYear]; ok {
+				return nil, fmt.Errorf("aeputil.ScaleProjection: more than one projection packet from year %d", p.baseYear)
+			}
+			byYear[p.baseYear] = p
+		}
+	}
+	var chain []*projectionPacket
+	for year := baseYear; year != scaleYear; {
+		p, ok := byYear[year]
+		if !ok || len(chain) > len(byYear) {
+			return nil, fmt.Errorf("aeputil.ScaleProjection: no projection packets from %d to %d", baseYear, scaleYear)
+		}
+		chain = append(chain, p)
+		year = p.futureYear
+	}
+
+	return func(rec aep.Record, pol aep.Pollutant) (float64, error) {
+		var naics string
+		if e, ok := rec.(interface {
+			GetEconomicData() *aep.EconomicData
+		}); ok {
+			if ed := e.GetEconomicData(); ed != nil {
+				naics = ed.NAICS
+			}
+		}
+		f := 1.
+		for _, p := range chain {
+			f *= p.factor(rec.GetFIPS(), rec.GetSCC(), naics, pol.Name)
+		}
+		return f, nil
+	}, nil
+}
+
+// readProjectionPackets reads the projection packets in r.
+func readProjectionPackets(r io.Reader) ([]*projectionPacket, error) {
+	var o []*projectionPacket
+	var p *projectionPacket
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '/' {
+			header := strings.Fields(strings.ToUpper(strings.Trim(line, "/")))
+			switch {
+			case len(header) == 1 && header[0] == "END":
+				p = nil
+			case len(header) == 3 && header[0] == "PROJECTION":
+				from, err1 := strconv.Atoi(header[1])
+				to, err2 := strconv.Atoi(header[2])
+				if err1 != nil || err2 != nil || from == to {
+					return nil, fmt.Errorf("aeputil: projection packet line %d: invalid years in %s", lineNum, line)
+				}
+				p = &projectionPacket{baseYear: from, futureYear: to, factors: make(map[projectionKey]float64)}
+				o = append(o, p)
+			default:
+				return nil, fmt.Errorf("aeputil: projection packet line %d: unsupported packet %s", lineNum, line)
+			}
+			continue
+		}
+		if p == nil {
+			return nil, fmt.Errorf("aeputil: projection packet line %d: data is not in a packet", lineNum)
+		}
+		fields := strings.Split(line, ",")
+		for i, f := range fields {
+			fields[i] = strings.Trim(f, "\" ")
+		}
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("aeputil: projection packet line %d: %d fields but should have at least 4", lineNum, len(fields))
+		}
+		for len(fields) < 5 {
+			fields = append(fields, "")
+		}
+		f, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil || f < 0 {
+			return nil, fmt.Errorf("aeputil: projection packet line %d: invalid growth factor '%s'", lineNum, fields[3])
+		}
+		p.factors[parseProjectionKey(fields)] = f
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("aeputil: reading projection packets: %v", err)
+	}
+	return o, nil
+}
+
+// parseProjectionKey parses the FIPS, SCC, pollutant, and NAICS
+// fields of a projection packet line. The codes are padded in the same
+// way as codes read from emissions inventory files, and codes that are
+// blank or only zeros match all records.
+func parseProjectionKey(fields []string) projectionKey {
+	k := projectionKey{pol: fields[2]}
+	var sd aep.SourceData
+	if strings.Trim(fields[0], "0") != "" {
+		sd.SetFIPS(fields[0])
+		k.fips = sd.FIPS
+	}
+	if strings.Trim(fields[1], "0") != "" {
+		sd.SetSCC(fields[1])
+		k.scc = sd.SCC
+	}
+	if strings.Trim(fields[4], "0") != "" {
+		var ed aep.EconomicData
+		ed.SetNAICS(fields[4])
+		k.naics = ed.NAICS
+	}
+	return k
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package aeputil
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spatialmodel/inmap/emissions/aep"
+)
+
+func TestScaleProjection(t *testing.T) {
+	const packets = `# Test projection packets
+/PROJECTION 2014 2025/
+, , NOX, 0.8
+36000, , NOX, 0.5
+36061, 2280003010, , 1.2
+, , , 2, 325110
+, 22800, VOC, 3
+/END/
+/PROJECTION 2025 2035/
+, , , 0.9
+/END/
+`
+	f, err := ScaleProjection(2014, 2035, strings.NewReader(packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &aep.PolygonRecord{SourceDataLocation: aep.SourceDataLocation{
+		SourceData: aep.SourceData{FIPS: "36061", SCC: "2280003010"}}}
+	b := &aep.PointRecord{
+		SourceData:   aep.SourceData{FIPS: "06001", SCC: "0010100101"},
+		EconomicData: aep.EconomicData{NAICS: "325110"},
+	}
+	// The short SCC in the packet is padded in the same way as SCCs
+	// in inventory files.
+	c := &aep.PolygonRecord{SourceDataLocation: aep.SourceDataLocation{
+		SourceData: aep.SourceData{FIPS: "06037", SCC: "0022800000"}}}
+	for _, test := range []struct {
+		rec  aep.Record
+		pol  string
+		want float64
+	}{
+		{rec: a, pol: "NOX", want: 0.45},
+		{rec: a, pol: "SO2", want: 1.08},
+		{rec: b, pol: "NOX", want: 0.72},
+		{rec: b, pol: "SO2", want: 1.8},
+		{rec: c, pol: "VOC", want: 2.7},
+		{rec: c, pol: "NOX", want: 0.72},
+	} {
+		have, err := f(test.rec, aep.Pollutant{Name: test.pol})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(have-test.want) > 1.e-10 {
+			t.Errorf("%s %s: want %g but have %g", test.rec.GetFIPS(), test.pol, test.want, have)
+		}
+	}
+
+	if _, err := ScaleProjection(2014, 2040, strings.NewReader(packets)); err == nil {
+		t.Error("projecting to a year without packets should cause an error")
+	}
+	if _, err := ScaleProjection(2014, 2025, strings.NewReader(packets+packets)); err == nil {
+		t.Error("duplicate packets should cause an error")
+	}
+}
+
+func TestInventory_projection(t *testing.T) {
+	type config struct {
+		Inventory InventoryConfig
+	}
+	r, err := os.Open("testdata/example_config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(config)
+
+	// Read the configuration file into the configuration variable.
+	if _, err = toml.DecodeReader(r, c); err != nil {
+		t.Fatal(err)
+	}
+	c.Inventory.ProjectionFiles = []string{"testdata/projection.txt"}
+	c.Inventory.ProjectionBaseYear = 2014
+	c.Inventory.ProjectionYear = 2035
+
+	_, report, err := c.Inventory.ReadEmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := aep.Table{
+		[]string{"Group", "File", "NH3 (kg)", "NOX (kg)", "PM2_5 (kg)", "SO2 (kg)", "VOC (kg)"},
+		[]string{"othar", "testdata/testemis.csv", "34.056105917699995", "1.9697839276290547e+07", "1.3253413523899838e+06", "1.5806320939220862e+07", "650426.9504917137"},
+		[]string{"othar", "projected to 2035", "34.056105917699995", "1.9697839276290547e+07", "1.3253413523899838e+06", "3.1612641878441725e+07", "650426.9504917137"},
+	}
+	if !reflect.DeepEqual(report.TotalsTable(), want) {
+		t.Errorf("inventory report: have %v, want %v", report.TotalsTable(), want)
+	}
+
+	for _, years := range [][2]int{{0, 0}, {2014, 0}, {0, 2035}, {2014, 2014}} {
+		c.Inventory.ProjectionBaseYear, c.Inventory.ProjectionYear = years[0], years[1]
+		if _, err := c.Inventory.project(nil); err == nil {
+			t.Errorf("projection years %v should cause an error", years)
+		}
+	}
+}
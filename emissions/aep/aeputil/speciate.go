@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -52,6 +53,13 @@ type SpeciateConfig struct {
 
 	Speciation aep.Speciation
 
+	// SpeciesGroups optionally assigns the model species in ChemicalMechanism
+	// to groups, for example to map them to the pollutants used by an air
+	// quality model. The format is map[group name][list of model species].
+	// It is only used for reporting emissions totals and by programs
+	// that need to know which model species belong to which groups.
+	SpeciesGroups map[string][]string
+
 	loadOnce  sync.Once
 	speciator *aep.Speciator
 }
@@ -138,6 +146,51 @@ func (c *SpeciateConfig) lazyLoadSpeciator() error {
 	return err
 }
 
+// SpeciesGroup returns the group in SpeciesGroups that the given model
+// species belongs to, or "" if it does not belong to any group.
+func (c *SpeciateConfig) SpeciesGroup(species string) string {
+	for group, names := range c.SpeciesGroups {
+		for _, name := range names {
+			if name == species {
+				return group
+			}
+		}
+	}
+	return ""
+}
+
+// SpeciesTotals returns a table of the total emissions of each model
+// species in r, which should be the report of an Iterator created by
+// c.Iterator. The columns are the species group (from SpeciesGroups),
+// the species name, the total emissions, and the units of the total.
+// The rows are arranged alphabetically by group and then by species,
+// with species that do not belong to any group listed first.
+func (c *SpeciateConfig) SpeciesTotals(r *aep.InventoryReport) aep.Table {
+	totals := make(map[string]*unit.Unit)
+	for _, d := range r.Data {
+		for pol, val := range d.Totals() {
+			if t, ok := totals[pol.Name]; ok {
+				t.Add(val)
+			} else {
+				totals[pol.Name] = val.Clone()
+			}
+		}
+	}
+	t := aep.Table{{"Group", "Species", "Total", "Units"}}
+	for species, val := range totals {
+		t = append(t, []string{c.SpeciesGroup(species), species,
+			fmt.Sprintf("%g", val.Value()), val.Dimensions().String()})
+	}
+	rows := t[1:]
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i][0] != rows[j][0] {
+			return rows[i][0] < rows[j][0]
+		}
+		return rows[i][1] < rows[j][1]
+	})
+	return t
+}
+
 // Iterator creates a new iterator that consumes records from the
 // given iterators and chemically speciates them.
 func (c *SpeciateConfig) Iterator(parent Iterator) Iterator {
@@ -75,6 +75,78 @@ func TestSpeciate(t *testing.T) {
 	compareTables(droppedTable, droppedWant, 1.0e-14, t)
 }
 
+func TestSpeciesTotals(t *testing.T) {
+	type config struct {
+		Inventory InventoryConfig
+		Speciate  SpeciateConfig
+	}
+	r, err := os.Open("testdata/example_config.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := new(config)
+	if _, err = toml.DecodeReader(r, c); err != nil {
+		t.Fatal(err)
+	}
+	c.Speciate.Speciation = c.Inventory.PolsToKeep
+	c.Speciate.SpeciesGroups = map[string][]string{
+		"VOC": {"ALK3", "ALK4"},
+		"NH3": {"Ammonia"},
+		"SOx": {"Sulfur dioxide", "Sulfate"},
+	}
+
+	emis, _, err := c.Inventory.ReadEmissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	iter := c.Speciate.Iterator(IteratorFromMap(emis))
+	for {
+		if _, err := iter.Next(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+	}
+	table := c.Speciate.SpeciesTotals(iter.Report())
+	want := aep.Table{
+		{"Group", "Species", "Total", "Units"},
+		{"", "Elemental Carbon", "492788.36977318383", "kg"},
+		{"", "Nitrate", "27233.04148746542", "kg"},
+		{"", "Nitrogen Dioxide", "61173.41390152345", "kmol"},
+		{"", "Nitrogen Monoxide (Nitric Oxide)", "562795.4078940157", "kmol"},
+		{"", "Organic carbon", "324202.87485077884", "kg"},
+		{"", "Other Unspeciated PM2.5", "207489.83990449845", "kg"},
+		{"", "Particulate Non-Carbon Organic Matter", "127087.5269415053", "kg"},
+		{"", "Sulfur", "36310.72198328722", "kg"},
+		{"NH3", "Ammonia", "1.9997713398532004", "kmol"},
+		{"SOx", "Sulfate", "110228.97744926481", "kg"},
+		{"SOx", "Sulfur dioxide", "246747.06580861437", "kmol"},
+		{"VOC", "ALK3", "7676.472685560194", "kmol"},
+		{"VOC", "ALK4", "3223.2914382084273", "kmol"},
+	}
+	if len(table) != len(want) {
+		t.Fatalf("have %d rows, want %d: %v", len(table), len(want), table)
+	}
+	for i, row := range want {
+		if table[i][0] != row[0] || table[i][1] != row[1] || table[i][3] != row[3] {
+			t.Errorf("row %d: have %v, want %v", i, table[i], row)
+			continue
+		}
+		if i == 0 {
+			continue
+		}
+		v1, err := strconv.ParseFloat(table[i][2], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2, _ := strconv.ParseFloat(row[2], 64)
+		if different(v1, v2, 1.0e-14) {
+			t.Errorf("%s: %g != %g", row[1], v1, v2)
+		}
+	}
+}
+
 func compareTables(table, want aep.Table, tolerance float64, t *testing.T) {
 	if !reflect.DeepEqual(table[0], want[0]) {
 		t.Errorf("inventory report header: have %v, want %v", table[0], want[0])
@@ -0,0 +1,237 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctessum/sparse"
+	"github.com/ctessum/unit"
+)
+
+// BiogenicEmissionFactors holds the biogenic emission factors for a land
+// cover class in units of μg per m² of land cover per hour at standard
+// conditions: a temperature of 30 °C and photosynthetically active
+// radiation (PAR) of 1000 μmol/m²/s.
+type BiogenicEmissionFactors struct {
+	Isoprene, Monoterpene, SoilNO float64
+}
+
+// ReadBiogenicEmissionFactors reads biogenic emission factors for
+// each land cover class from r. The data should be in CSV format with
+// a header row and the columns "class", "isoprene", "monoterpene", and
+// "soil_no", in any order.
+func ReadBiogenicEmissionFactors(r io.Reader) (map[string]BiogenicEmissionFactors, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("aep.ReadBiogenicEmissionFactors: reading header: %v", err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	var idx [4]int
+	for i, name := range []string{"class", "isoprene", "monoterpene", "soil_no"} {
+		var ok bool
+		if idx[i], ok = cols[name]; !ok {
+			return nil, fmt.Errorf("aep.ReadBiogenicEmissionFactors: missing column %s", name)
+		}
+	}
+	o := make(map[string]BiogenicEmissionFactors)
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aep.ReadBiogenicEmissionFactors: %v", err)
+		}
+		var v [3]float64
+		for i := range v {
+			if v[i], err = strconv.ParseFloat(strings.TrimSpace(rec[idx[i+1]]), 64); err != nil {
+				return nil, fmt.Errorf("aep.ReadBiogenicEmissionFactors: %v", err)
+			}
+		}
+		o[trimString(rec[idx[0]])] = BiogenicEmissionFactors{Isoprene: v[0], Monoterpene: v[1], SoilNO: v[2]}
+	}
+	return o, nil
+}
+
+// Biogenic calculates BEIS-style biogenic emissions of isoprene (ISOP),
+// monoterpenes (TERP), and soil nitric oxide (NO).
+type Biogenic struct {
+	// Grid is the grid that the meteorology data is on. Emissions records
+	// are created for each grid cell. The grid cells should have units
+	// of meters.
+	Grid *GridDef
+
+	// LandCover holds the fraction of each grid cell covered by
+	// each land cover class, in the same order as Grid.Cells. It can
+	// be created from a raster of land cover class codes using
+	// Raster.RegridCategorical, or from a raster with a separate
+	// fractional coverage variable for each class using Raster.Regrid.
+	LandCover map[string][]float64
+
+	// EmissionFactors holds the emission factors for each land cover class.
+	// Land cover classes without emission factors are ignored.
+	EmissionFactors map[string]BiogenicEmissionFactors
+
+	// SourceData is assigned to the emissions records.
+	SourceData SourceData
+}
+
+// Parameters for the temperature and light dependence of biogenic
+// emissions from Guenther et al. (1993) and Williams et al. (1992).
+const (
+	bioAlpha    = 0.0027     // light dependence coefficient
+	bioCL1      = 1.066      // light dependence coefficient
+	bioCT1      = 95000.     // temperature dependence coefficient [J/mol]
+	bioCT2      = 230000.    // temperature dependence coefficient [J/mol]
+	bioTs       = 303.       // standard temperature [K]
+	bioTm       = 314.       // temperature of maximum isoprene emissions [K]
+	bioR        = 8.314      // gas constant [J/K/mol]
+	bioBeta     = 0.09       // monoterpene temperature coefficient [1/K]
+	bioSoilNO   = 0.071      // soil NO temperature coefficient [1/K]
+	bioParPerWm = 4.6 * 0.45 // PAR [μmol/m²/s] per total downwelling radiation [W/m²]
+)
+
+// isopreneFactor returns the ratio of isoprene emissions at temperature t [K]
+// and downwelling radiation rad [W/m²] to those at standard conditions.
+func isopreneFactor(t, rad float64) float64 {
+	par := rad * bioParPerWm
+	cl := bioAlpha * bioCL1 * par / math.Sqrt(1+bioAlpha*bioAlpha*par*par)
+	ct := math.Exp(bioCT1*(t-bioTs)/(bioR*bioTs*t)) /
+		(1 + math.Exp(bioCT2*(t-bioTm)/(bioR*bioTs*t)))
+	return cl * ct
+}
+
+// monoterpeneFactor returns the ratio of monoterpene emissions at temperature
+// t [K] to those at standard conditions.
+func monoterpeneFactor(t float64) float64 {
+	return math.Exp(bioBeta * (t - bioTs))
+}
+
+// soilNOFactor returns the ratio of soil NO emissions at temperature
+// t [K] to those at standard conditions.
+func soilNOFactor(t float64) float64 {
+	return math.Exp(bioSoilNO * (t - bioTs))
+}
+
+// surfaceValue returns the value of the ground-level layer of a
+// 2-D [y, x] or 3-D [z, y, x] array at the given row and column.
+func surfaceValue(a *sparse.DenseArray, row, col int) float64 {
+	if len(a.Shape) == 3 {
+		return a.Get(0, row, col)
+	}
+	return a.Get(row, col)
+}
+
+// Records calculates biogenic emissions for each grid cell using
+// meteorology from the given functions, which return temperature [K]
+// and total downwelling radiation [W/m²] fields for each time step,
+// and return io.EOF after the last time step (for example, the T
+// and RadiationDown methods of an InMAP Preprocessor).
+// Temperature can either be 2-D [y, x] or 3-D [z, y, x], in which case
+// the lowest layer is used. Radiation should be 2-D [y, x].
+// The first time step begins at begin and each step lasts for the
+// given duration. One record is returned for each grid cell with
+// biogenic emissions.
+func (b *Biogenic) Records(temperature, radiationDown func() (*sparse.DenseArray, error), begin time.Time, step time.Duration) ([]Record, error) {
+	// Calculate the emissions at standard conditions in each grid cell [kg/s].
+	const ugPerHourToKgPerS = 1.e-9 / 3600
+	std := make([]BiogenicEmissionFactors, len(b.Grid.Cells))
+	for class, cover := range b.LandCover {
+		ef, ok := b.EmissionFactors[class]
+		if !ok {
+			continue
+		}
+		if len(cover) != len(b.Grid.Cells) {
+			return nil, fmt.Errorf("aep.Biogenic: land cover class %s has %d values but there are %d grid cells",
+				class, len(cover), len(b.Grid.Cells))
+		}
+		for i, c := range b.Grid.Cells {
+			area := c.Area() * cover[i] * ugPerHourToKgPerS
+			std[i].Isoprene += ef.Isoprene * area
+			std[i].Monoterpene += ef.Monoterpene * area
+			std[i].SoilNO += ef.SoilNO * area
+		}
+	}
+
+	recs := make([]*biogenicRecord, len(b.Grid.Cells))
+	for i, c := range b.Grid.Cells {
+		recs[i] = &biogenicRecord{basicPolygonRecord{
+			Polygonal:    c.Polygonal,
+			SR:           b.Grid.SR,
+			SourceData:   b.SourceData,
+			LocationName: fmt.Sprintf("%d_%d", c.Row, c.Col),
+		}}
+	}
+
+	emisDims := unit.Dimensions{unit.MassDim: 1, unit.TimeDim: -1}
+	for periodBegin := begin; ; periodBegin = periodBegin.Add(step) {
+		t, err := temperature()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aep.Biogenic: reading temperature: %v", err)
+		}
+		rad, err := radiationDown()
+		if err != nil {
+			return nil, fmt.Errorf("aep.Biogenic: reading radiation: %v", err)
+		}
+		periodEnd := periodBegin.Add(step)
+		for i, c := range b.Grid.Cells {
+			s := std[i]
+			if s == (BiogenicEmissionFactors{}) {
+				continue
+			}
+			tc := surfaceValue(t, c.Row, c.Col)
+			radc := surfaceValue(rad, c.Row, c.Col)
+			e := &recs[i].Emissions
+			e.Add(periodBegin, periodEnd, "ISOP", "", unit.New(s.Isoprene*isopreneFactor(tc, radc), emisDims))
+			e.Add(periodBegin, periodEnd, "TERP", "", unit.New(s.Monoterpene*monoterpeneFactor(tc), emisDims))
+			e.Add(periodBegin, periodEnd, "NO", "", unit.New(s.SoilNO*soilNOFactor(tc), emisDims))
+		}
+	}
+
+	var o []Record
+	for i, rec := range recs {
+		if std[i] != (BiogenicEmissionFactors{}) {
+			o = append(o, rec)
+		}
+	}
+	return o, nil
+}
+
+// biogenicRecord holds the biogenic emissions in a grid cell.
+type biogenicRecord struct {
+	basicPolygonRecord
+}
+
+// Key returns a unique key for this record.
+func (r *biogenicRecord) Key() string {
+	return r.SourceData.Key() + r.LocationName
+}
@@ -0,0 +1,202 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/sparse"
+)
+
+func TestRaster_Regrid(t *testing.T) {
+	r := &Raster{
+		lats: []float64{0.5, 1.5},
+		lons: []float64{0.5, 1.5},
+		variables: map[string][]float64{
+			"forest": {1, 0, 1, math.NaN()},
+		},
+	}
+	for _, test := range []struct {
+		grid *GridDef
+		want []float64
+	}{
+		{grid: NewGridRegular("fine", 2, 2, 1, 1, 0, 0, longlat), want: []float64{1, 0, 1, 0}},
+		{grid: NewGridRegular("coarse", 1, 1, 2, 2, 0, 0, longlat), want: []float64{0.5}},
+	} {
+		t.Run(test.grid.Name, func(t *testing.T) {
+			o, err := r.Regrid(test.grid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, v := range o["forest"] {
+				if math.Abs(v-test.want[i]) > 1.e-10 {
+					t.Errorf("cell %d: have %g, want %g", i, v, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRaster_RegridCategorical(t *testing.T) {
+	r := &Raster{
+		lats: []float64{0.5, 1.5},
+		lons: []float64{0.5, 1.5},
+		variables: map[string][]float64{
+			"landcover": {41, 71, 41, math.NaN()},
+		},
+	}
+	for _, test := range []struct {
+		grid *GridDef
+		want map[string][]float64
+	}{
+		{
+			grid: NewGridRegular("fine", 2, 2, 1, 1, 0, 0, longlat),
+			want: map[string][]float64{"41": {1, 0, 1, 0}, "71": {0, 1, 0, 0}},
+		},
+		{
+			grid: NewGridRegular("coarse", 1, 1, 2, 2, 0, 0, longlat),
+			want: map[string][]float64{"41": {0.5}, "71": {0.25}},
+		},
+	} {
+		t.Run(test.grid.Name, func(t *testing.T) {
+			o, err := r.RegridCategorical(test.grid, "landcover")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(o) != len(test.want) {
+				t.Errorf("want %d classes but have %d", len(test.want), len(o))
+			}
+			for class, want := range test.want {
+				for i, v := range o[class] {
+					if math.Abs(v-want[i]) > 1.e-10 {
+						t.Errorf("class %s cell %d: have %g, want %g", class, i, v, want[i])
+					}
+				}
+			}
+		})
+	}
+	if _, err := r.RegridCategorical(NewGridRegular("fine", 2, 2, 1, 1, 0, 0, longlat), "xxx"); err == nil {
+		t.Error("a missing variable should cause an error")
+	}
+}
+
+func TestReadBiogenicEmissionFactors(t *testing.T) {
+	r := strings.NewReader(`# Emission factors
+class,isoprene,monoterpene,soil_no
+forest, 1000, 500, 100
+grass, 0, 50, 200
+`)
+	efs, err := ReadBiogenicEmissionFactors(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]BiogenicEmissionFactors{
+		"forest": {Isoprene: 1000, Monoterpene: 500, SoilNO: 100},
+		"grass":  {Monoterpene: 50, SoilNO: 200},
+	}
+	if !reflect.DeepEqual(efs, want) {
+		t.Errorf("have %v, want %v", efs, want)
+	}
+	if _, err := ReadBiogenicEmissionFactors(strings.NewReader("class,isoprene\n")); err == nil {
+		t.Error("missing columns should cause an error")
+	}
+}
+
+func TestBiogenic(t *testing.T) {
+	b := &Biogenic{
+		Grid: NewGridRegular("grid", 3, 1, 1000, 1000, 0, 0, longlat),
+		LandCover: map[string][]float64{
+			"forest": {1, 0.5, 0},
+			"water":  {0, 0.5, 1},
+		},
+		EmissionFactors: map[string]BiogenicEmissionFactors{
+			"forest": {Isoprene: 1000, Monoterpene: 500, SoilNO: 100},
+		},
+		SourceData: SourceData{SCC: "2701000000"},
+	}
+	const (
+		nSteps = 2
+		temp   = 303.
+		rad    = 500.
+	)
+	var tSteps, radSteps int
+	temperature := func() (*sparse.DenseArray, error) {
+		if tSteps == nSteps {
+			return nil, io.EOF
+		}
+		tSteps++
+		a := sparse.ZerosDense(2, 1, 3)
+		for i := range a.Elements {
+			a.Elements[i] = temp
+		}
+		return a, nil
+	}
+	radiation := func() (*sparse.DenseArray, error) {
+		radSteps++
+		a := sparse.ZerosDense(1, 3)
+		for i := range a.Elements {
+			a.Elements[i] = rad
+		}
+		return a, nil
+	}
+	begin := time.Date(2016, time.July, 1, 12, 0, 0, 0, time.UTC)
+	recs, err := b.Records(temperature, radiation, begin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("have %d records, want 2", len(recs))
+	}
+	if recs[0].Key() == recs[1].Key() {
+		t.Errorf("records should have unique keys: %s", recs[0].Key())
+	}
+
+	// Forest area [m²] × hours × μg/kg.
+	const scale = 1.e6 * nSteps * 1.e-9
+	for i, frac := range []float64{1, 0.5} {
+		want := map[string]float64{
+			"ISOP": 1000 * frac * scale * isopreneFactor(temp, rad),
+			"TERP": 500 * frac * scale * monoterpeneFactor(temp),
+			"NO":   100 * frac * scale * soilNOFactor(temp),
+		}
+		totals := recs[i].Totals()
+		if len(totals) != len(want) {
+			t.Errorf("record %d: have %d pollutants, want %d", i, len(totals), len(want))
+		}
+		for pol, v := range totals {
+			if math.Abs(v.Value()-want[pol.Name]) > 1.e-10 {
+				t.Errorf("record %d %s: have %g, want %g", i, pol.Name, v.Value(), want[pol.Name])
+			}
+		}
+		p := recs[i].PeriodTotals(begin.Add(time.Hour), begin.Add(2*time.Hour))[Pollutant{Name: "TERP"}].Value()
+		if math.Abs(p-want["TERP"]/nSteps) > 1.e-10 {
+			t.Errorf("record %d: second period TERP have %g, want %g", i, p, want["TERP"]/nSteps)
+		}
+	}
+	if monoterpeneFactor(bioTs) != 1 || soilNOFactor(bioTs) != 1 {
+		t.Error("temperature factors should be 1 at standard conditions")
+	}
+	if f := isopreneFactor(bioTs, 1000/bioParPerWm); math.Abs(f-1) > 0.05 {
+		t.Errorf("isoprene factor at standard conditions should be about 1 but is %g", f)
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ctessum/cdf"
+)
+
+// IOAPI grid and vertical coordinate types.
+const (
+	ioapiLatLon   = 1
+	ioapiLambert  = 2
+	ioapiMercator = 3
+
+	// ioapiWRFSigma is the WRF-NMM/ARW hydrostatic sigma-P
+	// vertical coordinate type.
+	ioapiWRFSigma = 7
+)
+
+// CMAQWriter writes gridded emissions to CMAQ-ready IOAPI-format
+// NetCDF files.
+type CMAQWriter struct {
+	// Grid is the regular grid the emissions are written for.
+	// It should use a Lambert conformal conic ("lcc"), Mercator ("merc"),
+	// or longitude-latitude ("longlat") projection.
+	Grid *GridDef
+
+	// GridIndex is the index of Grid in the SpatialProcessor that
+	// the RecordGridded emissions records were created with.
+	GridIndex int
+
+	// Vertical allocates emissions among vertical layers. If it is nil,
+	// all emissions are allocated to a single layer.
+	Vertical VerticalAllocator
+
+	// VGLVLS are the values of the vertical coordinate at the layer
+	// boundaries, from the ground up. There should be one more
+	// value than there are layers.
+	VGLVLS []float64
+
+	// VGTYP is the IOAPI vertical coordinate type. If it is zero,
+	// the WRF sigma-pressure coordinate (7) is used.
+	VGTYP int
+
+	// VGTOP is the model top pressure [Pa].
+	VGTOP float64
+}
+
+// ioapiName returns name padded with spaces to the given length.
+func ioapiName(name string, length int) string {
+	return fmt.Sprintf("%-*s", length, name)
+}
+
+// ioapiDate returns t as IOAPI date (YYYYDDD) and time (HHMMSS) values.
+func ioapiDate(t time.Time) (int32, int32) {
+	t = t.UTC()
+	return int32(t.Year()*1000 + t.YearDay()), int32(t.Hour()*10000 + t.Minute()*100 + t.Second())
+}
+
+// Write writes hourly emissions from recs between begin and end to the
+// given file name. The records should be speciated so that gas emissions
+// are in units of moles. Gas emissions are written in units of moles/s
+// and particle emissions are written in units of g/s.
+func (w *CMAQWriter) Write(fileName string, recs []RecordGridded, begin, end time.Time) error {
+	grid := w.Grid
+	if grid.IrregularGrid {
+		return fmt.Errorf("aep.CMAQWriter: grid %s is not a regular grid", grid.Name)
+	}
+	nz := 1
+	if w.Vertical != nil {
+		nz = w.Vertical.Layers()
+	}
+	if len(w.VGLVLS) != nz+1 {
+		return fmt.Errorf("aep.CMAQWriter: there are %d layers so there should be %d VGLVLS but there are %d",
+			nz, nz+1, len(w.VGLVLS))
+	}
+	vgtyp := w.VGTYP
+	if vgtyp == 0 {
+		vgtyp = ioapiWRFSigma
+	}
+	species, err := outputSpecies(recs)
+	if err != nil {
+		return fmt.Errorf("aep.CMAQWriter: %v", err)
+	}
+	names := sortedSpecies(species)
+	for _, name := range names {
+		if len(name) > 16 {
+			return fmt.Errorf("aep.CMAQWriter: species name %s is longer than 16 characters", name)
+		}
+	}
+	nt := int(math.Ceil(end.Sub(begin).Hours()))
+
+	const rad2deg = 180 / math.Pi
+	var gdtyp int32
+	var pAlp, pBet, pGam, xCent, yCent float64
+	switch grid.SR.Name {
+	case "lcc":
+		gdtyp = ioapiLambert
+		pAlp, pBet, pGam = grid.SR.Lat1*rad2deg, grid.SR.Lat2*rad2deg, grid.SR.Long0*rad2deg
+		xCent, yCent = grid.SR.Long0*rad2deg, grid.SR.Lat0*rad2deg
+	case "merc":
+		gdtyp = ioapiMercator
+		pAlp, pGam = grid.SR.Lat0*rad2deg, grid.SR.Long0*rad2deg
+		xCent, yCent = grid.SR.Long0*rad2deg, grid.SR.Lat0*rad2deg
+	case "longlat":
+		gdtyp = ioapiLatLon
+	default:
+		return fmt.Errorf("aep.CMAQWriter: unsupported projection %s", grid.SR.Name)
+	}
+
+	h := cdf.NewHeader([]string{"TSTEP", "DATE-TIME", "LAY", "VAR", "ROW", "COL"},
+		[]int{0, 2, nz, len(names), grid.Ny, grid.Nx})
+	sdate, stime := ioapiDate(begin)
+	now := time.Now()
+	wdate, wtime := ioapiDate(now)
+	h.AddAttribute("", "IOAPI_VERSION", ioapiName("ioapi-3.2", 80))
+	h.AddAttribute("", "EXEC_ID", ioapiName("aep", 80))
+	h.AddAttribute("", "FTYPE", []int32{1})
+	h.AddAttribute("", "CDATE", []int32{wdate})
+	h.AddAttribute("", "CTIME", []int32{wtime})
+	h.AddAttribute("", "WDATE", []int32{wdate})
+	h.AddAttribute("", "WTIME", []int32{wtime})
+	h.AddAttribute("", "SDATE", []int32{sdate})
+	h.AddAttribute("", "STIME", []int32{stime})
+	h.AddAttribute("", "TSTEP", []int32{10000})
+	h.AddAttribute("", "NTHIK", []int32{1})
+	h.AddAttribute("", "NCOLS", []int32{int32(grid.Nx)})
+	h.AddAttribute("", "NROWS", []int32{int32(grid.Ny)})
+	h.AddAttribute("", "NLAYS", []int32{int32(nz)})
+	h.AddAttribute("", "NVARS", []int32{int32(len(names))})
+	h.AddAttribute("", "GDTYP", []int32{gdtyp})
+	h.AddAttribute("", "P_ALP", []float64{pAlp})
+	h.AddAttribute("", "P_BET", []float64{pBet})
+	h.AddAttribute("", "P_GAM", []float64{pGam})
+	h.AddAttribute("", "XCENT", []float64{xCent})
+	h.AddAttribute("", "YCENT", []float64{yCent})
+	h.AddAttribute("", "XORIG", []float64{grid.X0})
+	h.AddAttribute("", "YORIG", []float64{grid.Y0})
+	h.AddAttribute("", "XCELL", []float64{grid.Dx})
+	h.AddAttribute("", "YCELL", []float64{grid.Dy})
+	h.AddAttribute("", "VGTYP", []int32{int32(vgtyp)})
+	h.AddAttribute("", "VGTOP", []float32{float32(w.VGTOP)})
+	vglvls := make([]float32, len(w.VGLVLS))
+	for i, v := range w.VGLVLS {
+		vglvls[i] = float32(v)
+	}
+	h.AddAttribute("", "VGLVLS", vglvls)
+	h.AddAttribute("", "GDNAM", ioapiName(grid.Name, 16))
+	h.AddAttribute("", "UPNAM", ioapiName("AEP", 16))
+	varList := make([]string, len(names))
+	for i, name := range names {
+		varList[i] = ioapiName(name, 16)
+	}
+	h.AddAttribute("", "VAR-LIST", strings.Join(varList, ""))
+	h.AddAttribute("", "FILEDESC", ioapiName("Model-ready emissions created by AEP", 80))
+	h.AddAttribute("", "HISTORY", " ")
+
+	h.AddVariable("TFLAG", []string{"TSTEP", "VAR", "DATE-TIME"}, []int32{0})
+	h.AddAttribute("TFLAG", "units", ioapiName("<YYYYDDD,HHMMSS>", 16))
+	h.AddAttribute("TFLAG", "long_name", ioapiName("TFLAG", 16))
+	h.AddAttribute("TFLAG", "var_desc", ioapiName("Timestep-valid flags:  (1) YYYYDDD or (2) HHMMSS", 80))
+	for _, name := range names {
+		h.AddVariable(name, []string{"TSTEP", "LAY", "ROW", "COL"}, []float32{0})
+		h.AddAttribute(name, "long_name", ioapiName(name, 16))
+		if species[name] {
+			h.AddAttribute(name, "units", ioapiName("moles/s", 16))
+		} else {
+			h.AddAttribute(name, "units", ioapiName("g/s", 16))
+		}
+		h.AddAttribute(name, "var_desc", ioapiName(fmt.Sprintf("Model species %s", name), 80))
+	}
+	h.Define()
+	if errs := h.Check(); len(errs) > 0 {
+		return fmt.Errorf("aep.CMAQWriter: %v", errs[0])
+	}
+
+	ff, err := os.Create(fileName)
+	if err != nil {
+		return fmt.Errorf("aep.CMAQWriter: %v", err)
+	}
+	defer ff.Close()
+	f, err := cdf.Create(ff, h)
+	if err != nil {
+		return fmt.Errorf("aep.CMAQWriter: %v", err)
+	}
+
+	for t := 0; t < nt; t++ {
+		hour := begin.Add(time.Duration(t) * time.Hour)
+		date, tm := ioapiDate(hour)
+		tflag := make([]int32, 2*len(names))
+		for i := range names {
+			tflag[2*i], tflag[2*i+1] = date, tm
+		}
+		if _, err := f.Writer("TFLAG", []int{t, 0, 0}, nil).Write(tflag); err != nil {
+			return fmt.Errorf("aep.CMAQWriter: %v", err)
+		}
+		emis, err := GriddedLayers(recs, w.Vertical, hour, hour.Add(time.Hour), w.GridIndex, grid.Nx, grid.Ny, nz)
+		if err != nil {
+			return fmt.Errorf("aep.CMAQWriter: %v", err)
+		}
+		for _, name := range names {
+			data := make([]float32, nz*grid.Ny*grid.Nx)
+			if d, ok := emis[name]; ok {
+				// kmol/hour → mol/s or kg/hour → g/s
+				for i, v := range d.Elements {
+					data[i] = float32(v * 1000 / 3600)
+				}
+			}
+			if _, err := f.Writer(name, []int{t, 0, 0, 0}, nil).Write(data); err != nil {
+				return fmt.Errorf("aep.CMAQWriter: %v", err)
+			}
+		}
+	}
+	if err := cdf.UpdateNumRecs(ff); err != nil {
+		return fmt.Errorf("aep.CMAQWriter: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ctessum/cdf"
+)
+
+func TestCMAQWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cmaq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	begin := time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(3 * time.Hour)
+	cfg, recs := outputTestRecords(t, begin, end)
+	w := &CMAQWriter{
+		Grid:     cfg.Grids()[0],
+		Vertical: &StackLayers{LayerHeights: []float64{50, 500}},
+		VGLVLS:   []float64{1, 0.995, 0.99},
+		VGTOP:    5000,
+	}
+	file := filepath.Join(dir, "emis.ncf")
+	if err = w.Write(file, recs, begin, end); err != nil {
+		t.Fatal(err)
+	}
+
+	ff, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ff.Close()
+	f, err := cdf.Open(ff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Header.NumRecs(fileSize(t, ff)); n != 3 {
+		t.Errorf("want 3 time steps but have %d", n)
+	}
+	for att, want := range map[string]interface{}{
+		"GDTYP":    []int32{ioapiLambert},
+		"NLAYS":    []int32{2},
+		"NVARS":    []int32{2},
+		"SDATE":    []int32{2016183},
+		"VAR-LIST": "NO              PM2_5           ",
+	} {
+		if have := f.Header.GetAttribute("", att); !reflect.DeepEqual(have, want) {
+			t.Errorf("%s: have %v, want %v", att, have, want)
+		}
+	}
+	if have := f.Header.GetAttribute("", "P_ALP").([]float64); math.Abs(have[0]-33) > 1.e-10 {
+		t.Errorf("P_ALP: have %g, want 33", have[0])
+	}
+
+	tflag := readNCFRecord(t, f, "TFLAG", 2).([]int32)
+	if tflag[0] != 2016183 || tflag[1] != 20000 {
+		t.Errorf("TFLAG: %v", tflag)
+	}
+
+	// The grid has 4 columns and 3 rows.
+	idx := func(layer, row, col int) int { return layer*12 + row*4 + col }
+	no := readNCFRecord(t, f, "NO", 2).([]float32)
+	pm := readNCFRecord(t, f, "PM2_5", 2).([]float32)
+	for i := range no {
+		var wantNO, wantPM float64
+		if i == idx(1, 1, 1) {
+			wantNO = 1000. / 3600 // mol/s
+		}
+		if i == idx(0, 0, 3) {
+			wantPM = 1 // g/s
+		}
+		if math.Abs(float64(no[i])-wantNO) > 1.e-6 {
+			t.Errorf("NO %d: have %g, want %g", i, no[i], wantNO)
+		}
+		if math.Abs(float64(pm[i])-wantPM) > 1.e-6 {
+			t.Errorf("PM2_5 %d: have %g, want %g", i, pm[i], wantPM)
+		}
+	}
+
+	w.VGLVLS = []float64{1, 0.99}
+	if err = w.Write(file, recs, begin, end); err == nil {
+		t.Error("a mismatch between VGLVLS and the number of layers should cause an error")
+	}
+}
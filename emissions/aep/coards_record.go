@@ -23,6 +23,7 @@ import (
 	"math"
 	"os"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/ctessum/cdf"
@@ -167,6 +168,100 @@ func (r *Raster) RecordGenerator(b *geom.Bounds) func() (Record, error) {
 	return generator
 }
 
+// Regrid returns the area-weighted average of each of the variables in the
+// receiver within each cell of the given grid, in the same order as
+// grid.Cells. Missing values are treated as zero.
+func (r *Raster) Regrid(grid *GridDef) (map[string][]float64, error) {
+	o := make(map[string][]float64)
+	for name := range r.variables {
+		o[name] = make([]float64, len(grid.Cells))
+	}
+	err := r.regrid(grid, func(cell, index int, frac float64) {
+		for name, data := range r.variables {
+			if v := data[index]; !math.IsNaN(v) {
+				o[name][cell] += v * frac
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// RegridCategorical returns the fraction of each cell of the given grid
+// covered by each of the classes in the given variable of the receiver,
+// in the same order as grid.Cells. This is appropriate for variables such
+// as land cover type, where each value is a class code rather than a
+// quantity that can be averaged. The output is keyed by the class codes,
+// formatted as in strconv.FormatFloat(code, 'g', -1, 64), so an integer
+// code such as 41 becomes "41". Missing values are not assigned to any class.
+// Because ReadCOARDSFile only reads floating point variables, the class
+// codes must be stored as floating point values.
+func (r *Raster) RegridCategorical(grid *GridDef, variable string) (map[string][]float64, error) {
+	data, ok := r.variables[variable]
+	if !ok {
+		return nil, fmt.Errorf("aep: regridding raster: missing variable %s", variable)
+	}
+	o := make(map[string][]float64)
+	err := r.regrid(grid, func(cell, index int, frac float64) {
+		v := data[index]
+		if math.IsNaN(v) {
+			return
+		}
+		class := strconv.FormatFloat(v, 'g', -1, 64)
+		if _, ok := o[class]; !ok {
+			o[class] = make([]float64, len(grid.Cells))
+		}
+		o[class][cell] += frac
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// regrid calls f with the index of each grid cell in grid.Cells, the index
+// of each raster cell that overlaps it, and the fraction of the grid cell
+// area covered by the overlap.
+func (r *Raster) regrid(grid *GridDef, f func(cell, index int, frac float64)) error {
+	ct, err := longlat.NewTransform(grid.SR)
+	if err != nil {
+		return fmt.Errorf("aep: regridding raster: %v", err)
+	}
+	cellIndex := make(map[[2]int]int)
+	cellArea := make([]float64, len(grid.Cells))
+	for i, c := range grid.Cells {
+		cellIndex[[2]int{c.Row, c.Col}] = i
+		cellArea[i] = c.Area()
+	}
+	for j, y := range r.lats {
+		dy := math.Abs(gridPointsToGridSpacing(r.lats, j))
+		for i, x := range r.lons {
+			dx := gridPointsToGridSpacing(r.lons, i)
+			cell := geom.Polygon{{
+				{X: x - dx/2, Y: y - dy/2}, {X: x + dx/2, Y: y - dy/2},
+				{X: x + dx/2, Y: y + dy/2}, {X: x - dx/2, Y: y + dy/2},
+				{X: x - dx/2, Y: y - dy/2},
+			}}
+			g, err := cell.Transform(ct)
+			if err != nil {
+				return fmt.Errorf("aep: regridding raster: %v", err)
+			}
+			rows, cols, fracs, inGrid, _ := grid.GetIndex(g)
+			if !inGrid {
+				continue
+			}
+			area := g.(geom.Polygonal).Area()
+			for k, row := range rows {
+				ci := cellIndex[[2]int{row, cols[k]}]
+				f(ci, len(r.lons)*j+i, fracs[k]*area/cellArea[ci])
+			}
+		}
+	}
+	return nil
+}
+
 // ReadCOARDSFile reads a COARDS-compliant NetCDF file
 // (NetCDF 4 and greater not supported) and returns a record generator.
 // The generator will return io.EOF after the last record.
@@ -0,0 +1,453 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/unit"
+)
+
+// ControlKey specifies which emissions records a control packet applies to.
+// Blank fields match all records. A FIPS code ending in "000" matches all
+// counties in the given state.
+type ControlKey struct {
+	FIPS, SCC, Pollutant, PlantID string
+}
+
+// ControlPacket specifies a control measure to be applied to
+// emissions records.
+type ControlPacket struct {
+	ControlKey
+
+	// CEff, REff, and RPen are the control efficiency, rule effectiveness,
+	// and rule penetration percentages (0-100) of the control measure.
+	CEff, REff, RPen float64
+
+	// Replace specifies whether the control measure replaces any
+	// control that is already reported in the emissions record. If it is
+	// false, the control measure is applied in addition to any existing
+	// control.
+	Replace bool
+}
+
+// factor returns the fraction of emissions that remain after
+// the control is applied, given the existing control information
+// for the record, which may be nil.
+func (c *ControlPacket) factor(existing *ControlData, point bool) float64 {
+	f := 1 - c.CEff*c.REff*c.RPen/1.e6
+	if !c.Replace || existing == nil {
+		return f
+	}
+	reff := existing.REff
+	if reff == 0 {
+		// A blank rule effectiveness is treated as 100%, as in SMOKE.
+		reff = 100
+	}
+	rpen := existing.RPen
+	if point && rpen == 0 {
+		// Rule penetration is not reported for point sources.
+		rpen = 100
+	}
+	fOld := 1 - existing.CEff*reff*rpen/1.e6
+	if fOld <= 0 {
+		// The existing control removes all emissions, so we can't
+		// determine the uncontrolled emissions.
+		return 0
+	}
+	return f / fOld
+}
+
+// AllowablePacket specifies a projection and either an emissions cap or
+// a replacement emissions value to be applied to emissions records.
+type AllowablePacket struct {
+	ControlKey
+
+	// ProjectionFactor is multiplied by the emissions before the
+	// cap is applied. A value of zero is treated as one.
+	ProjectionFactor float64
+
+	// Cap, if not nil, is the maximum total emissions mass
+	// for each matching record and pollutant.
+	Cap *unit.Unit
+
+	// Replacement, if not nil, is the total emissions mass that
+	// each matching record and pollutant will be set to.
+	Replacement *unit.Unit
+}
+
+// factor returns the emissions scaling factor for a record whose total
+// emissions after control measures are applied is total.
+func (a *AllowablePacket) factor(total *unit.Unit) (float64, error) {
+	f := a.ProjectionFactor
+	if f == 0 {
+		f = 1
+	}
+	if total == nil || total.Value() == 0 {
+		return f, nil
+	}
+	if a.Replacement != nil {
+		if !total.Dimensions().Matches(a.Replacement.Dimensions()) {
+			return 1, fmt.Errorf("aep: control strategy replacement units (%v) don't match emissions units (%v)",
+				a.Replacement.Dimensions(), total.Dimensions())
+		}
+		return a.Replacement.Value() / total.Value(), nil
+	}
+	if a.Cap != nil {
+		if !total.Dimensions().Matches(a.Cap.Dimensions()) {
+			return 1, fmt.Errorf("aep: control strategy cap units (%v) don't match emissions units (%v)",
+				a.Cap.Dimensions(), total.Dimensions())
+		}
+		if projected := total.Value() * f; projected > a.Cap.Value() {
+			return a.Cap.Value() / total.Value(), nil
+		}
+	}
+	return f, nil
+}
+
+// ControlStrategy applies control measures and emissions caps to
+// emissions records.
+type ControlStrategy struct {
+	controls   map[ControlKey]*ControlPacket
+	allowables map[ControlKey]*AllowablePacket
+}
+
+// ReadControlStrategy reads a control strategy from the given SMOKE-like
+// packet files, where units specifies the units of any emissions
+// caps or replacements.
+// The files contain "/CONTROL/" and "/ALLOWABLE/" packets, each of which
+// ends with an "/END/" line. Lines starting with '#' are comments.
+// Each line in a /CONTROL/ packet has the comma-separated fields:
+//
+//	FIPS, SCC, pollutant, plant ID, control efficiency, rule effectiveness, rule penetration, flag
+//
+// where blank rule effectiveness and rule penetration values are treated
+// as 100%, and a flag of "R" specifies that the control replaces any existing
+// control reported in the inventory.
+// Each line in an /ALLOWABLE/ packet has the comma-separated fields:
+//
+//	FIPS, SCC, pollutant, plant ID, projection factor, cap, replacement
+//
+// where the projection factor is applied to emissions after any controls
+// and emissions are then limited to the cap, if it is not blank.
+// If the replacement is not blank, emissions are instead set to the
+// replacement value. Caps and replacements are total emissions for each
+// record and pollutant.
+// For each record and pollutant, only the most specific matching
+// line in each packet type is used, where matching pollutant is the
+// most important, followed by plant ID, SCC, county, and state.
+// If more than one line has the same key, the last one is used.
+func ReadControlStrategy(units InputUnits, files ...io.Reader) (*ControlStrategy, error) {
+	cs := &ControlStrategy{
+		controls:   make(map[ControlKey]*ControlPacket),
+		allowables: make(map[ControlKey]*AllowablePacket),
+	}
+	conv := units.Conversion(1)
+	for _, f := range files {
+		packet := ""
+		scanner := bufio.NewScanner(f)
+		lineNum := 0
+		for scanner.Scan() {
+			lineNum++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			if line[0] == '/' {
+				switch p := strings.ToUpper(line); p {
+				case "/CONTROL/", "/ALLOWABLE/":
+					packet = p
+				case "/END/":
+					packet = ""
+				default:
+					return nil, fmt.Errorf("aep.ReadControlStrategy: line %d: unsupported packet %s", lineNum, line)
+				}
+				continue
+			}
+			fields := strings.Split(line, ",")
+			for i, fld := range fields {
+				fields[i] = trimString(fld)
+			}
+			var err error
+			switch packet {
+			case "/CONTROL/":
+				err = cs.addControl(fields)
+			case "/ALLOWABLE/":
+				err = cs.addAllowable(fields, conv)
+			default:
+				err = fmt.Errorf("data is not in a packet")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("aep.ReadControlStrategy: line %d: %v", lineNum, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("aep.ReadControlStrategy: %v", err)
+		}
+	}
+	return cs, nil
+}
+
+// parseControlKey parses the first four fields of a packet line.
+func parseControlKey(fields []string) ControlKey {
+	k := ControlKey{Pollutant: fields[2], PlantID: fields[3]}
+	if strings.Trim(fields[0], "0") != "" {
+		var sd SourceData
+		sd.parseFIPS(fields[0])
+		k.FIPS = sd.FIPS
+	}
+	if strings.Trim(fields[1], "0") != "" {
+		var sd SourceData
+		sd.parseSCC(fields[1])
+		k.SCC = sd.SCC
+	}
+	return k
+}
+
+// parsePercent parses a percentage, returning def if s is blank.
+func parsePercent(s string, def float64) (float64, error) {
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v < 0 || v > 100 {
+		return 0, fmt.Errorf("percentage %g is not between 0 and 100", v)
+	}
+	return v, nil
+}
+
+func (cs *ControlStrategy) addControl(fields []string) error {
+	if len(fields) < 5 {
+		return fmt.Errorf("control packet line has %d fields but should have at least 5", len(fields))
+	}
+	for len(fields) < 8 {
+		fields = append(fields, "")
+	}
+	c := &ControlPacket{ControlKey: parseControlKey(fields)}
+	var err error
+	if c.CEff, err = parsePercent(fields[4], 0); err != nil {
+		return err
+	}
+	if c.REff, err = parsePercent(fields[5], 100); err != nil {
+		return err
+	}
+	if c.RPen, err = parsePercent(fields[6], 100); err != nil {
+		return err
+	}
+	switch strings.ToUpper(fields[7]) {
+	case "R":
+		c.Replace = true
+	case "", "A":
+	default:
+		return fmt.Errorf("invalid control flag %q", fields[7])
+	}
+	cs.controls[c.ControlKey] = c
+	return nil
+}
+
+func (cs *ControlStrategy) addAllowable(fields []string, conv func(float64) *unit.Unit) error {
+	if len(fields) < 5 {
+		return fmt.Errorf("allowable packet line has %d fields but should have at least 5", len(fields))
+	}
+	for len(fields) < 7 {
+		fields = append(fields, "")
+	}
+	a := &AllowablePacket{ControlKey: parseControlKey(fields)}
+	if fields[4] != "" {
+		var err error
+		if a.ProjectionFactor, err = strconv.ParseFloat(fields[4], 64); err != nil {
+			return err
+		}
+		if a.ProjectionFactor < 0 {
+			return fmt.Errorf("negative projection factor %g", a.ProjectionFactor)
+		}
+	}
+	for i, u := range []**unit.Unit{&a.Cap, &a.Replacement} {
+		if s := fields[5+i]; s != "" {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			if v < 0 {
+				return fmt.Errorf("negative emissions value %g", v)
+			}
+			*u = conv(v)
+		}
+	}
+	cs.allowables[a.ControlKey] = a
+	return nil
+}
+
+// controlMatchKeys returns the keys that could match the given record
+// and pollutant, in decreasing order of specificity.
+func controlMatchKeys(fips, scc, pol, plantID string) []ControlKey {
+	type candidate struct {
+		k     ControlKey
+		score int
+	}
+	var c []candidate
+	fipsOptions := []string{fips, "", ""}
+	fipsScores := []int{2, 1, 0}
+	if len(fips) == 5 {
+		fipsOptions[1] = fips[0:2] + "000"
+	}
+	for _, usePol := range []bool{true, false} {
+		for _, usePlant := range []bool{true, false} {
+			for _, useSCC := range []bool{true, false} {
+				for i, f := range fipsOptions {
+					if i == 1 && f == "" {
+						continue
+					}
+					var k ControlKey
+					score := fipsScores[i]
+					k.FIPS = f
+					if usePol {
+						k.Pollutant = pol
+						score += 16
+					}
+					if usePlant {
+						if plantID == "" {
+							continue
+						}
+						k.PlantID = plantID
+						score += 8
+					}
+					if useSCC {
+						k.SCC = scc
+						score += 4
+					}
+					c = append(c, candidate{k: k, score: score})
+				}
+			}
+		}
+	}
+	sort.SliceStable(c, func(i, j int) bool { return c[i].score > c[j].score })
+	o := make([]ControlKey, len(c))
+	for i, cc := range c {
+		o[i] = cc.k
+	}
+	return o
+}
+
+// Apply applies the control strategy to the emissions in the given record.
+func (cs *ControlStrategy) Apply(rec Record) error {
+	var plantID string
+	p, point := rec.(interface {
+		GetPointSourceData() *PointSourceData
+	})
+	if point {
+		plantID = p.GetPointSourceData().PlantID
+	}
+	totals := rec.Totals()
+	return rec.GetEmissions().Scale(func(pol Pollutant) (float64, error) {
+		var existing *ControlData
+		if cd, ok := rec.(interface {
+			GetControlData(string) *ControlData
+		}); ok {
+			existing = cd.GetControlData(pol.Name)
+		}
+		keys := controlMatchKeys(rec.GetFIPS(), rec.GetSCC(), pol.Name, plantID)
+		f := 1.
+		for _, k := range keys {
+			if c, ok := cs.controls[k]; ok {
+				f = c.factor(existing, point)
+				break
+			}
+		}
+		for _, k := range keys {
+			if a, ok := cs.allowables[k]; ok {
+				var total *unit.Unit
+				if t, ok := totals[pol]; ok {
+					total = unit.Mul(t, unit.New(f, unit.Dimless))
+				}
+				fa, err := a.factor(total)
+				if err != nil {
+					return 1, err
+				}
+				f *= fa
+				break
+			}
+		}
+		return f, nil
+	})
+}
+
+// ApplyAll applies the control strategy to the given emissions
+// records, which are grouped by sector, and returns a report of the
+// emissions totals in each sector before and after the strategy is
+// applied.
+func (cs *ControlStrategy) ApplyAll(emis map[string][]Record) (*InventoryReport, error) {
+	sectors := make([]string, 0, len(emis))
+	for sector := range emis {
+		sectors = append(sectors, sector)
+	}
+	sort.Strings(sectors)
+	report := new(InventoryReport)
+	for _, sector := range sectors {
+		before := &controlTotaler{group: sector, name: "before controls"}
+		after := &controlTotaler{group: sector, name: "after controls"}
+		for _, rec := range emis[sector] {
+			before.add(rec)
+			if err := cs.Apply(rec); err != nil {
+				return nil, err
+			}
+			after.add(rec)
+		}
+		report.AddData(before, after)
+	}
+	return report, nil
+}
+
+// controlTotaler holds the total emissions from a group of records.
+type controlTotaler struct {
+	group, name string
+	totals      map[Pollutant]*unit.Unit
+}
+
+func (t *controlTotaler) add(rec Record) {
+	if t.totals == nil {
+		t.totals = make(map[Pollutant]*unit.Unit)
+	}
+	for pol, v := range rec.Totals() {
+		if tt, ok := t.totals[pol]; ok {
+			tt.Add(v)
+		} else {
+			t.totals[pol] = v.Clone()
+		}
+	}
+}
+
+// Totals returns the total emissions in the receiver.
+func (t *controlTotaler) Totals() map[Pollutant]*unit.Unit { return t.totals }
+
+// DroppedTotals returns nil because control strategies do not drop emissions.
+func (t *controlTotaler) DroppedTotals() map[Pollutant]*unit.Unit { return nil }
+
+// Group returns the sector of the receiver.
+func (t *controlTotaler) Group() string { return t.group }
+
+// Name returns the name of the receiver.
+func (t *controlTotaler) Name() string { return t.name }
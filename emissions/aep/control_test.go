@@ -0,0 +1,147 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/unit"
+)
+
+func TestControlStrategy(t *testing.T) {
+	const packets = `# Test control strategy
+/CONTROL/
+06000, , NOX, , 50, , ,
+, , , P1, 80, 100, 100, R
+/END/
+/ALLOWABLE/
+36061, 2104008000, NOX, , 1.5, 120,
+, 2104008000, VOC, , 2, ,
+06001, , SO2, , , , 10
+/END/
+`
+	cs, err := ReadControlStrategy(Kg, strings.NewReader(packets))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	begin := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(100 * time.Second)
+	rate := unit.New(1, unit.Dimensions{unit.MassDim: 1, unit.TimeDim: -1})
+	addEmis := func(e *Emissions, pols ...string) {
+		for _, pol := range pols {
+			e.Add(begin, end, pol, "", rate)
+		}
+	}
+
+	point := &PointRecord{
+		SourceData:      SourceData{FIPS: "06001", SCC: "0010100101"},
+		PointSourceData: PointSourceData{PlantID: "P1"},
+		ControlData:     ControlData{CEff: 50, REff: 100},
+	}
+	addEmis(&point.Emissions, "NOX", "SO2")
+	area1 := &PolygonRecord{SourceDataLocation: SourceDataLocation{
+		SourceData: SourceData{FIPS: "06037", SCC: "2104008000"}}}
+	addEmis(&area1.Emissions, "NOX", "VOC")
+	area2 := &PolygonRecord{SourceDataLocation: SourceDataLocation{
+		SourceData: SourceData{FIPS: "36061", SCC: "2104008000"}}}
+	addEmis(&area2.Emissions, "NOX")
+
+	report, err := cs.ApplyAll(map[string][]Record{
+		"point": {point},
+		"area":  {area1, area2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkTotals := func(name string, have map[Pollutant]*unit.Unit, want map[string]float64) {
+		t.Helper()
+		if len(have) != len(want) {
+			t.Errorf("%s: want %d pollutants but have %d", name, len(want), len(have))
+		}
+		for pol, v := range have {
+			if w := want[pol.Name]; math.Abs(v.Value()-w) > 1.e-10 {
+				t.Errorf("%s %s: want %g but have %g", name, pol.Name, w, v.Value())
+			}
+		}
+	}
+	checkTotals("point", point.Totals(), map[string]float64{"NOX": 50, "SO2": 10})
+	checkTotals("area1", area1.Totals(), map[string]float64{"NOX": 50, "VOC": 200})
+	checkTotals("area2", area2.Totals(), map[string]float64{"NOX": 120})
+
+	if len(report.Data) != 4 {
+		t.Fatalf("report should have 4 entries but has %d", len(report.Data))
+	}
+	for i, want := range []struct {
+		group, name string
+		totals      map[string]float64
+	}{
+		{group: "area", name: "before controls", totals: map[string]float64{"NOX": 200, "VOC": 100}},
+		{group: "area", name: "after controls", totals: map[string]float64{"NOX": 170, "VOC": 200}},
+		{group: "point", name: "before controls", totals: map[string]float64{"NOX": 100, "SO2": 100}},
+		{group: "point", name: "after controls", totals: map[string]float64{"NOX": 50, "SO2": 10}},
+	} {
+		d := report.Data[i]
+		if d.Group() != want.group || d.Name() != want.name {
+			t.Errorf("report %d: want %s %s but have %s %s", i, want.group, want.name, d.Group(), d.Name())
+		}
+		checkTotals(want.group+" "+want.name, d.Totals(), want.totals)
+	}
+}
+
+func TestControlStrategy_invalid(t *testing.T) {
+	for _, packets := range []string{
+		"/CONTROL/\n06000, , NOX, , 150\n/END/\n",
+		"/CONTROL/\n06000, , NOX, , 50, , , X\n/END/\n",
+		"/ALLOWABLE/\n06000, , NOX, , -1\n/END/\n",
+		"/PROJECTION/\n/END/\n",
+		"06000, , NOX, , 50\n",
+	} {
+		if _, err := ReadControlStrategy(Kg, strings.NewReader(packets)); err == nil {
+			t.Errorf("packets %q should cause an error", packets)
+		}
+	}
+}
+
+func TestControlPacket_factor(t *testing.T) {
+	c := &ControlPacket{CEff: 80, REff: 100, RPen: 100, Replace: true}
+	for _, test := range []struct {
+		name     string
+		existing *ControlData
+		point    bool
+		want     float64
+	}{
+		{name: "no existing", want: 0.2},
+		{name: "complete", existing: &ControlData{CEff: 50, REff: 100, RPen: 100}, want: 0.4},
+		{name: "blank REff", existing: &ControlData{CEff: 50, RPen: 100}, want: 0.4},
+		{name: "blank RPen point", existing: &ControlData{CEff: 50, REff: 100}, point: true, want: 0.4},
+		{name: "blank REff and RPen point", existing: &ControlData{CEff: 50}, point: true, want: 0.4},
+		{name: "blank RPen area", existing: &ControlData{CEff: 50, REff: 100}, want: 0.2},
+		{name: "full control", existing: &ControlData{CEff: 100, REff: 100, RPen: 100}, want: 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if have := c.factor(test.existing, test.point); math.Abs(have-test.want) > 1.e-10 {
+				t.Errorf("want %g but have %g", test.want, have)
+			}
+		})
+	}
+}
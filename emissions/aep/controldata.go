@@ -66,3 +66,10 @@ func (r *ControlData) setRPen(s string) error {
 	r.RPen, err = stringToFloat(s)
 	return err
 }
+
+// GetControlData returns the control information for the given pollutant,
+// which is the receiver for records that don't have pollutant-specific
+// control information.
+func (r *ControlData) GetControlData(pol string) *ControlData {
+	return r
+}
@@ -42,6 +42,10 @@ func (r *EconomicData) parseNAICS(NAICS string) {
 	}
 }
 
+// SetNAICS sets the NAICS code of the receiver, padding it with zeros
+// in the same way as NAICS codes read from emissions inventory files.
+func (r *EconomicData) SetNAICS(NAICS string) { r.parseNAICS(NAICS) }
+
 // clean up SIC code so it either has 0 or 4 characters
 func (r *EconomicData) parseSIC(SIC string) {
 	r.SIC = trimString(SIC)
@@ -0,0 +1,221 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/unit"
+)
+
+const (
+	// fireHeatContent is the heat of combustion of dry biomass fuel [J/kg].
+	fireHeatContent = 18.e6
+
+	// fireConvectiveFraction is the fraction of the heat released by
+	// a fire that is carried upward by the plume.
+	fireConvectiveFraction = 0.7
+
+	// fireBurnHours is the default number of hours over which the fuel
+	// in a fire is assumed to burn.
+	fireBurnHours = 8.
+
+	// fireStartHour is the default local solar hour at which a fire
+	// starts burning. Along with fireBurnHours, it places the burning
+	// between 10:00 and 18:00, when most daily fire activity occurs.
+	fireStartHour = 10.
+
+	// firePlumeTemp and fireStackHeight are the exit temperature [K] and
+	// height [m] of the pseudo-stack used to represent fire plumes.
+	firePlumeTemp   = 1000.
+	fireStackHeight = 10.
+
+	// fireAmbientTemp [K], fireAirDensity [kg/m3], and fireAirHeatCapacity
+	// [J/kg/K] are reference air properties used to convert fire heat release
+	// to buoyancy flux.
+	fireAmbientTemp     = 293.
+	fireAirDensity      = 1.2
+	fireAirHeatCapacity = 1005.
+
+	gravity = 9.80665 // gravitational acceleration [m/s2]
+)
+
+// fireStack returns the diameter [m] and exit velocity [m/s] of a
+// pseudo-stack that represents a fire with the given area [m2] and
+// convective heat release rate [W].
+// The diameter is that of a circle with the same area as the fire, and
+// the velocity is chosen so that the buoyancy flux of the stack
+// matches that of the fire (Briggs, 1969).
+func fireStack(area, heat float64) (diam, vel float64) {
+	flux := gravity * heat / (math.Pi * fireAirDensity * fireAirHeatCapacity * fireAmbientTemp)
+	r2 := area / math.Pi
+	diam = 2 * math.Sqrt(r2)
+	if r2 == 0 {
+		return diam, 0
+	}
+	vel = flux * firePlumeTemp / (gravity * r2 * (firePlumeTemp - fireAmbientTemp))
+	return diam, vel
+}
+
+// ReadFires reads FINN/BlueSky-style fire data in CSV format from r,
+// and converts it to point emissions records with the given source data
+// (for example, SCC "2810001000" for wildfires).
+// The CSV data should have a header row, and the following columns,
+// in any order, where column names are not case sensitive:
+//
+//	latitude, longitude: the location of the fire in decimal degrees (required);
+//	area: the area burned [m²] (required);
+//	fuel_loading: the dry fuel mass available to burn [kg/m²] (required);
+//	combustion_completeness: the fraction of fuel that burns (optional, default 1);
+//	ef_<pollutant>: emission factors for each pollutant [g emitted/kg fuel burned];
+//	id: a fire identifier (optional);
+//	date: the day the fire burned, in "2006-01-02" format (optional, default
+//	    the day of begin in UTC);
+//	start_hour: the local solar hour of the day (0-24) that the fire started
+//	    burning (optional, default 10);
+//	burn_hours: the number of hours the fire burned for (optional, default 8).
+//
+// The emissions of each fire occur at a constant rate over the burn_hours
+// after start_hour on the day it burned, where local solar time is
+// calculated from the longitude of the fire, and fires that did not burn
+// between begin and end are skipped.
+// The stack parameters of the records represent the plume rise caused by
+// the heat released by each fire, as a pseudo-stack with the same area as
+// the fire and a buoyancy flux calculated from the fuel burned over the
+// same burn_hours.
+func ReadFires(r io.Reader, sourceData SourceData, begin, end time.Time) ([]Record, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("aep.ReadFires: reading header: %v", err)
+	}
+	cols := make(map[string]int)
+	efs := make(map[string]int) // Emission factor columns, by pollutant.
+	for i, h := range header {
+		h = strings.TrimSpace(h)
+		if len(h) > 3 && strings.ToLower(h[0:3]) == "ef_" {
+			efs[h[3:]] = i
+			continue
+		}
+		cols[strings.ToLower(h)] = i
+	}
+	for _, c := range []string{"latitude", "longitude", "area", "fuel_loading"} {
+		if _, ok := cols[c]; !ok {
+			return nil, fmt.Errorf("aep.ReadFires: missing column %s", c)
+		}
+	}
+	if len(efs) == 0 {
+		return nil, fmt.Errorf("aep.ReadFires: no emission factor columns")
+	}
+	emisDims := unit.Dimensions{unit.MassDim: 1, unit.TimeDim: -1}
+
+	var recs []Record
+	for row := 1; ; row++ {
+		line, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aep.ReadFires: %v", err)
+		}
+		val := func(col string, def float64) (float64, error) {
+			i, ok := cols[col]
+			if !ok || strings.TrimSpace(line[i]) == "" {
+				return def, nil
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(line[i]), 64)
+			if err != nil {
+				return math.NaN(), fmt.Errorf("aep.ReadFires: row %d column %s: %v", row, col, err)
+			}
+			return v, nil
+		}
+		var vals [7]float64
+		for i, c := range []struct {
+			name string
+			def  float64
+		}{
+			{name: "latitude"}, {name: "longitude"}, {name: "area"}, {name: "fuel_loading"},
+			{name: "combustion_completeness", def: 1}, {name: "burn_hours", def: fireBurnHours},
+			{name: "start_hour", def: fireStartHour},
+		} {
+			if vals[i], err = val(c.name, c.def); err != nil {
+				return nil, err
+			}
+		}
+		lat, lon, area, fuel, completeness, burnHours, startHour := vals[0], vals[1], vals[2], vals[3], vals[4], vals[5], vals[6]
+		if burnHours <= 0 {
+			return nil, fmt.Errorf("aep.ReadFires: row %d: burn_hours must be positive", row)
+		}
+		if startHour < 0 || startHour > 24 {
+			return nil, fmt.Errorf("aep.ReadFires: row %d: start_hour must be between 0 and 24", row)
+		}
+
+		day := begin.UTC().Truncate(24 * time.Hour)
+		if i, ok := cols["date"]; ok && strings.TrimSpace(line[i]) != "" {
+			day, err = time.Parse("2006-01-02", strings.TrimSpace(line[i]))
+			if err != nil {
+				return nil, fmt.Errorf("aep.ReadFires: row %d: %v", row, err)
+			}
+		}
+		// Convert the start hour from local solar time to UTC.
+		fireBegin := day.Add(time.Duration((startHour - lon/15) * float64(time.Hour)))
+		fireEnd := fireBegin.Add(time.Duration(burnHours * float64(time.Hour)))
+		if !fireEnd.After(begin) || !end.After(fireBegin) {
+			continue
+		}
+
+		rec := &PointRecord{SourceData: sourceData}
+		rec.PlantID = strconv.Itoa(row)
+		if i, ok := cols["id"]; ok {
+			rec.PlantID = trimString(line[i])
+		}
+		rec.Point = geom.Point{X: lon, Y: lat}
+		rec.SR = longlat
+
+		fuelBurned := area * fuel * completeness // kg
+		seconds := fireEnd.Sub(fireBegin).Seconds()
+		for pol, i := range efs {
+			ef, err := stringToFloat(line[i])
+			if err != nil {
+				return nil, fmt.Errorf("aep.ReadFires: row %d column ef_%s: %v", row, pol, err)
+			}
+			pol, prefix := splitPol(pol)
+			rec.Emissions.Add(fireBegin, fireEnd, pol, prefix,
+				unit.New(fuelBurned*ef/1000/seconds, emisDims))
+		}
+
+		heat := fuelBurned * fireHeatContent * fireConvectiveFraction / (burnHours * 3600)
+		diam, vel := fireStack(area, heat)
+		rec.StackHeight = unit.New(fireStackHeight, unit.Meter)
+		rec.StackDiameter = unit.New(diam, unit.Meter)
+		rec.StackTemp = unit.New(firePlumeTemp, unit.Kelvin)
+		rec.StackVelocity = unit.New(vel, unit.Dimensions{unit.LengthDim: 1, unit.TimeDim: -1})
+		rec.StackFlow = unit.Mul(rec.StackVelocity, circleArea(rec.StackDiameter))
+
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/atmos/plumerise"
+)
+
+func TestReadFires(t *testing.T) {
+	const fires = `id,latitude,longitude,area,fuel_loading,combustion_completeness,burn_hours,start_hour,date,EF_PM2_5,EF_NOX
+big,39.5,-121.2,1e6,2,0.5,,,2019-08-01,10,3
+small,39.6,-121.3,1e4,2,,4,14,2019-08-02,10,3
+late,39.6,-121.3,1e4,2,,4,,2019-09-02,10,3
+nodate,39.6,-121.3,1e4,2,,4,,,10,3
+`
+	begin := time.Date(2019, time.August, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2019, time.September, 1, 0, 0, 0, 0, time.UTC)
+	recs, err := ReadFires(strings.NewReader(fires), SourceData{SCC: "2810001000", Country: USA}, begin, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("want 3 records but have %d", len(recs))
+	}
+
+	layerHeights := make([]float64, 51)
+	temperature := make([]float64, 50)
+	windSpeed := make([]float64, 50)
+	sClass := make([]float64, 50)
+	s1 := make([]float64, 50)
+	for i := range temperature {
+		layerHeights[i+1] = layerHeights[i] + 100
+		temperature[i] = 295
+		windSpeed[i] = 5
+	}
+
+	hours := func(h float64) time.Duration { return time.Duration(h * float64(time.Hour)) }
+	var plumeHeights []float64
+	for i, test := range []struct {
+		id        string
+		start     time.Time // start time in UTC
+		burnHours float64
+		pm25      float64
+	}{
+		{id: "big", start: begin.Add(hours(10 + 121.2/15)), burnHours: 8, pm25: 1e4},
+		{id: "small", start: begin.Add(hours(24 + 14 + 121.3/15)), burnHours: 4, pm25: 200},
+		{id: "nodate", start: begin.Add(hours(10 + 121.3/15)), burnHours: 4, pm25: 200},
+	} {
+		rec := recs[i].(*PointRecord)
+		if rec.PlantID != test.id {
+			t.Errorf("record %d: want id %s but have %s", i, test.id, rec.PlantID)
+		}
+		// All of the emissions should occur at a constant rate within
+		// the burn period.
+		burnEnd := test.start.Add(hours(test.burnHours))
+		burnTotals := rec.PeriodTotals(test.start, burnEnd)
+		if v := burnTotals[Pollutant{Name: "PM2_5"}].Value(); math.Abs(v-test.pm25) > 1.e-8 {
+			t.Errorf("%s PM2_5: want %g but have %g", test.id, test.pm25, v)
+		}
+		if v := burnTotals[Pollutant{Name: "NOX"}].Value(); math.Abs(v-test.pm25*0.3) > 1.e-8 {
+			t.Errorf("%s NOX: want %g but have %g", test.id, test.pm25*0.3, v)
+		}
+		hourTotals := rec.PeriodTotals(test.start, test.start.Add(time.Hour))
+		if v, want := hourTotals[Pollutant{Name: "PM2_5"}].Value(), test.pm25/test.burnHours; math.Abs(v-want) > 1.e-8 {
+			t.Errorf("%s first hour PM2_5: want %g but have %g", test.id, want, v)
+		}
+		if rec.GroundLevel() {
+			t.Errorf("%s should be elevated", test.id)
+		}
+		h, d, temp, _, v := rec.StackParameters()
+		_, plumeHeight, err := plumerise.ASME(h.Value(), d.Value(), temp.Value(), v.Value(),
+			layerHeights, temperature, windSpeed, sClass, s1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plumeHeights = append(plumeHeights, plumeHeight)
+	}
+	if !(plumeHeights[0] > plumeHeights[1] && plumeHeights[1] > fireStackHeight) {
+		t.Errorf("plume heights %v should be above the stack and highest for the largest fire", plumeHeights)
+	}
+}
+
+func TestReadFires_invalid(t *testing.T) {
+	for _, fires := range []string{
+		"latitude,longitude,area,ef_PM2_5\n39.5,-121.2,1e6,10\n",
+		"latitude,longitude,area,fuel_loading\n39.5,-121.2,1e6,2\n",
+		"latitude,longitude,area,fuel_loading,ef_PM2_5\n39.5,-121.2,x,2,10\n",
+		"latitude,longitude,area,fuel_loading,start_hour,ef_PM2_5\n39.5,-121.2,1e6,2,25,10\n",
+	} {
+		if _, err := ReadFires(strings.NewReader(fires), SourceData{}, time.Time{}, time.Time{}.Add(time.Hour)); err == nil {
+			t.Errorf("%q should cause an error", fires)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TIFF tags used for reading GeoTIFF files.
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffRowsPerStrip    = 278
+	tiffStripByteCounts = 279
+	tiffPredictor       = 317
+	tiffTileWidth       = 322
+	tiffTileLength      = 323
+	tiffTileOffsets     = 324
+	tiffTileByteCounts  = 325
+	tiffSampleFormat    = 339
+	tiffModelPixelScale = 33550
+	tiffModelTiepoint   = 33922
+	tiffGDALNoData      = 42113
+)
+
+// rasterData holds single-band raster data.
+type rasterData struct {
+	// xEdges and yEdges are the coordinates of the pixel edges.
+	// They can be either increasing or decreasing.
+	xEdges, yEdges []float64
+
+	// values holds the pixel values in row-major order, where
+	// row j is between yEdges[j] and yEdges[j+1]. Missing
+	// values are NaN.
+	values []float64
+}
+
+// readGeoTIFF reads the first band of the first image in a GeoTIFF file.
+// Only uncompressed and DEFLATE-compressed data with no predictor or
+// horizontal differencing are supported. The image must be north-up
+// (i.e., it must be georeferenced with a tie point and pixel scale).
+// Pixels equal to the GDAL no-data value are set to NaN.
+func readGeoTIFF(r io.ReaderAt) (*rasterData, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return nil, fmt.Errorf("aep: reading GeoTIFF header: %v", err)
+	}
+	var bo binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("aep: invalid GeoTIFF byte order %q", header[:2])
+	}
+	if bo.Uint16(header[2:4]) != 42 {
+		return nil, fmt.Errorf("aep: invalid TIFF file (BigTIFF is not supported)")
+	}
+	tags, err := readTIFFTags(r, bo, int64(bo.Uint32(header[4:8])))
+	if err != nil {
+		return nil, err
+	}
+
+	first := func(tag int, def float64) float64 {
+		if v, ok := tags[tag]; ok && len(v.nums) > 0 {
+			return v.nums[0]
+		}
+		return def
+	}
+	nx, ny := int(first(tiffImageWidth, 0)), int(first(tiffImageLength, 0))
+	if nx == 0 || ny == 0 {
+		return nil, fmt.Errorf("aep: GeoTIFF is missing image dimensions")
+	}
+	bits := int(first(tiffBitsPerSample, 8))
+	format := int(first(tiffSampleFormat, 1))
+	spp := int(first(tiffSamplesPerPixel, 1))
+	compression := int(first(tiffCompression, 1))
+	predictor := int(first(tiffPredictor, 1))
+	if compression != 1 && compression != 8 && compression != 32946 {
+		return nil, fmt.Errorf("aep: unsupported GeoTIFF compression type %d", compression)
+	}
+	if predictor != 1 && (predictor != 2 || format == 3) {
+		return nil, fmt.Errorf("aep: unsupported GeoTIFF predictor %d", predictor)
+	}
+	sampleBytes := bits / 8
+	if bits%8 != 0 || sampleBytes == 0 || sampleBytes > 8 {
+		return nil, fmt.Errorf("aep: unsupported GeoTIFF bits per sample %d", bits)
+	}
+	pixelBytes := sampleBytes * spp
+
+	// Determine the layout of the data blocks.
+	var offsets, counts []float64
+	blockW, blockH := nx, int(first(tiffRowsPerStrip, float64(ny)))
+	if _, ok := tags[tiffTileOffsets]; ok {
+		blockW, blockH = int(first(tiffTileWidth, 0)), int(first(tiffTileLength, 0))
+		offsets, counts = tags[tiffTileOffsets].nums, tags[tiffTileByteCounts].nums
+	} else {
+		offsets, counts = tags[tiffStripOffsets].nums, tags[tiffStripByteCounts].nums
+	}
+	if blockW <= 0 || blockH <= 0 || len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, fmt.Errorf("aep: invalid GeoTIFF data layout")
+	}
+	blocksAcross := (nx + blockW - 1) / blockW
+
+	values := make([]float64, nx*ny)
+	for b := range offsets {
+		buf := make([]byte, int(counts[b]))
+		if _, err := r.ReadAt(buf, int64(offsets[b])); err != nil {
+			return nil, fmt.Errorf("aep: reading GeoTIFF data: %v", err)
+		}
+		if compression != 1 {
+			zr, err := zlib.NewReader(bytes.NewReader(buf))
+			if err != nil {
+				return nil, fmt.Errorf("aep: decompressing GeoTIFF data: %v", err)
+			}
+			if buf, err = ioutil.ReadAll(zr); err != nil {
+				return nil, fmt.Errorf("aep: decompressing GeoTIFF data: %v", err)
+			}
+		}
+		row0, col0 := (b/blocksAcross)*blockH, (b%blocksAcross)*blockW
+		for j := 0; j < blockH && row0+j < ny; j++ {
+			line := buf[j*blockW*pixelBytes:]
+			if len(line) < blockW*pixelBytes {
+				return nil, fmt.Errorf("aep: GeoTIFF data block is too short")
+			}
+			line = line[:blockW*pixelBytes]
+			if predictor == 2 {
+				undoHorizontalDifferencing(line, bo, sampleBytes, spp)
+			}
+			for i := 0; i < blockW && col0+i < nx; i++ {
+				values[(row0+j)*nx+col0+i] = tiffSample(line[i*pixelBytes:], bo, sampleBytes, format)
+			}
+		}
+	}
+
+	if nd, ok := tags[tiffGDALNoData]; ok {
+		noData, err := strconv.ParseFloat(strings.TrimSpace(strings.Trim(nd.ascii, "\x00")), 64)
+		if err != nil {
+			return nil, fmt.Errorf("aep: parsing GeoTIFF no-data value: %v", err)
+		}
+		for i, v := range values {
+			if v == noData {
+				values[i] = math.NaN()
+			}
+		}
+	}
+
+	scale, tie := tags[tiffModelPixelScale].nums, tags[tiffModelTiepoint].nums
+	if len(scale) < 2 || len(tie) < 6 {
+		return nil, fmt.Errorf("aep: GeoTIFF is missing ModelPixelScale or ModelTiepoint georeferencing")
+	}
+	x0 := tie[3] - tie[0]*scale[0]
+	y0 := tie[4] + tie[1]*scale[1]
+	o := &rasterData{
+		xEdges: make([]float64, nx+1),
+		yEdges: make([]float64, ny+1),
+		values: values,
+	}
+	for i := range o.xEdges {
+		o.xEdges[i] = x0 + float64(i)*scale[0]
+	}
+	for j := range o.yEdges {
+		o.yEdges[j] = y0 - float64(j)*scale[1]
+	}
+	return o, nil
+}
+
+// tiffTag holds the value of a TIFF tag.
+type tiffTag struct {
+	nums  []float64
+	ascii string
+}
+
+// readTIFFTags reads the tags in the image file directory at offset.
+func readTIFFTags(r io.ReaderAt, bo binary.ByteOrder, offset int64) (map[int]tiffTag, error) {
+	var n [2]byte
+	if _, err := r.ReadAt(n[:], offset); err != nil {
+		return nil, fmt.Errorf("aep: reading GeoTIFF directory: %v", err)
+	}
+	entries := make([]byte, 12*int(bo.Uint16(n[:])))
+	if _, err := r.ReadAt(entries, offset+2); err != nil {
+		return nil, fmt.Errorf("aep: reading GeoTIFF directory: %v", err)
+	}
+	typeSizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+	tags := make(map[int]tiffTag)
+	for e := 0; e < len(entries); e += 12 {
+		tag := int(bo.Uint16(entries[e:]))
+		typ := bo.Uint16(entries[e+2:])
+		count := int(bo.Uint32(entries[e+4:]))
+		size, ok := typeSizes[typ]
+		if !ok {
+			continue
+		}
+		data := entries[e+8 : e+12]
+		if count*size > 4 {
+			data = make([]byte, count*size)
+			if _, err := r.ReadAt(data, int64(bo.Uint32(entries[e+8:]))); err != nil {
+				return nil, fmt.Errorf("aep: reading GeoTIFF tag %d: %v", tag, err)
+			}
+		}
+		var t tiffTag
+		if typ == 2 {
+			t.ascii = string(data[:count])
+			tags[tag] = t
+			continue
+		}
+		t.nums = make([]float64, count)
+		for i := range t.nums {
+			d := data[i*size:]
+			switch typ {
+			case 1, 7:
+				t.nums[i] = float64(d[0])
+			case 6:
+				t.nums[i] = float64(int8(d[0]))
+			case 3:
+				t.nums[i] = float64(bo.Uint16(d))
+			case 8:
+				t.nums[i] = float64(int16(bo.Uint16(d)))
+			case 4:
+				t.nums[i] = float64(bo.Uint32(d))
+			case 9:
+				t.nums[i] = float64(int32(bo.Uint32(d)))
+			case 5:
+				t.nums[i] = float64(bo.Uint32(d)) / float64(bo.Uint32(d[4:]))
+			case 10:
+				t.nums[i] = float64(int32(bo.Uint32(d))) / float64(int32(bo.Uint32(d[4:])))
+			case 11:
+				t.nums[i] = float64(math.Float32frombits(bo.Uint32(d)))
+			case 12:
+				t.nums[i] = math.Float64frombits(bo.Uint64(d))
+			}
+		}
+		tags[tag] = t
+	}
+	return tags, nil
+}
+
+// tiffSample returns the value of the sample at the beginning of b, which
+// has the given size in bytes and TIFF sample format (1=unsigned integer,
+// 2=signed integer, 3=floating point).
+func tiffSample(b []byte, bo binary.ByteOrder, size, format int) float64 {
+	var u uint64
+	switch size {
+	case 1:
+		u = uint64(b[0])
+	case 2:
+		u = uint64(bo.Uint16(b))
+	case 4:
+		u = uint64(bo.Uint32(b))
+	case 8:
+		u = bo.Uint64(b)
+	}
+	switch {
+	case format == 3 && size == 4:
+		return float64(math.Float32frombits(uint32(u)))
+	case format == 3 && size == 8:
+		return math.Float64frombits(u)
+	case format == 2:
+		shift := uint(64 - 8*size)
+		return float64(int64(u<<shift) >> shift)
+	default:
+		return float64(u)
+	}
+}
+
+// undoHorizontalDifferencing reverses TIFF predictor 2 for a row of
+// integer samples.
+func undoHorizontalDifferencing(line []byte, bo binary.ByteOrder, size, spp int) {
+	stride := size * spp
+	for i := stride; i+size <= len(line); i += size {
+		prev := i - stride
+		switch size {
+		case 1:
+			line[i] += line[prev]
+		case 2:
+			bo.PutUint16(line[i:], bo.Uint16(line[i:])+bo.Uint16(line[prev:]))
+		case 4:
+			bo.PutUint32(line[i:], bo.Uint32(line[i:])+bo.Uint32(line[prev:]))
+		case 8:
+			bo.PutUint64(line[i:], bo.Uint64(line[i:])+bo.Uint64(line[prev:]))
+		}
+	}
+}
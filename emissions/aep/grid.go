@@ -138,7 +138,7 @@ func NewGridIrregular(Name string, g []geom.Polygonal, inputSR, outputSR *proj.S
 
 // GetIndex gets the returns the row and column indices of geometry g in the grid.
 // withinGrid is false if point (X,Y) is not within the grid.
-// g can be a Point, Line, or Polygon.
+// g can be a Point, Linear, or Polygonal geometry.
 // For lines and polygons, the fraction of g that is in each grid cell is returned
 // as fracs.
 // If g is a point, usually
@@ -166,8 +166,8 @@ func (grid *GridDef) GetIndex(g geom.Geom) (rows, cols []int, fracs []float64, i
 			fracs[i] = 1.0 / float64(len(rows))
 		}
 		return
-	case geom.LineString:
-		l := g.(geom.LineString)
+	case geom.Linear:
+		l := g.(geom.Linear)
 		length := l.Length()
 		var lengthSum float64
 		for _, cI := range grid.rtree.SearchIntersect(l.Bounds()) {
@@ -42,6 +42,12 @@ func (r *pointRecordIDA) Key() string {
 	return r.SourceData.Key() + r.PointSourceData.Key()
 }
 
+// GetControlData returns the control information for the given pollutant,
+// or nil if there is none.
+func (r *pointRecordIDA) GetControlData(pol string) *ControlData {
+	return controlDataIDA(r.ControlData, pol)
+}
+
 // polyonRecordIDA holds information about an emissions source that has a polygon
 // location. IDA records have pollutant-specific control information.
 type polygonRecordIDA struct {
@@ -54,6 +60,19 @@ type polygonRecordIDA struct {
 // nil because this is not a point source.
 func (r *polygonRecordIDA) PointData() *PointSourceData { return nil }
 
+// GetControlData returns the control information for the given pollutant,
+// or nil if there is none.
+func (r *polygonRecordIDA) GetControlData(pol string) *ControlData {
+	return controlDataIDA(r.ControlData, pol)
+}
+
+func controlDataIDA(cd map[string]ControlData, pol string) *ControlData {
+	if c, ok := cd[pol]; ok {
+		return &c
+	}
+	return nil
+}
+
 // mobilePolygonRecordIDA holds information about an emissions source that has a polygon
 // location and only has source and emissions data.
 type mobilePolygonRecordIDA struct {
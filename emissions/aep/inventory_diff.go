@@ -0,0 +1,316 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ctessum/unit"
+)
+
+// DiffStatus specifies how an emissions source differs between
+// two inventories.
+type DiffStatus string
+
+// These are the possible differences between emissions sources.
+const (
+	SourceAdded     DiffStatus = "added"     // The source is only in the comparison inventory.
+	SourceRemoved   DiffStatus = "removed"   // The source is only in the base inventory.
+	SourceChanged   DiffStatus = "changed"   // The emissions of at least one pollutant are different.
+	SourceUnchanged DiffStatus = "unchanged" // The emissions of all pollutants are the same.
+)
+
+// SourceDiff holds the differences in the emissions from a single
+// emissions source between a base inventory and a comparison inventory.
+type SourceDiff struct {
+	// Sector is the inventory sector the source belongs to.
+	Sector string
+
+	// Country, FIPS, and SCC identify the location and type of the source.
+	Country   Country
+	FIPS, SCC string
+
+	// Facility is the plant ID of point sources. It is empty for
+	// other sources.
+	Facility string
+
+	// Key is the unique key of the emissions record.
+	Key string
+
+	// Status specifies how the source differs between the inventories.
+	Status DiffStatus
+
+	// Base and Compare hold the total emissions of each pollutant from
+	// the source in the base and comparison inventories.
+	Base, Compare map[string]float64
+
+	// Units holds the units of each pollutant.
+	Units map[string]unit.Dimensions
+}
+
+// Difference returns the comparison minus the base emissions of pol.
+func (s *SourceDiff) Difference(pol string) float64 {
+	return s.Compare[pol] - s.Base[pol]
+}
+
+// pollutants returns the names of the pollutants emitted by the source
+// in either inventory, in alphabetical order.
+func (s *SourceDiff) pollutants() []string {
+	pols := make([]string, 0, len(s.Units))
+	for pol := range s.Units {
+		pols = append(pols, pol)
+	}
+	sort.Strings(pols)
+	return pols
+}
+
+// polStatus returns how the emissions of pol from the source differ
+// between the inventories.
+func (s *SourceDiff) polStatus(pol string, tolerance float64) DiffStatus {
+	b, bok := s.Base[pol]
+	c, cok := s.Compare[pol]
+	switch {
+	case !bok && cok && s.Status == SourceAdded:
+		return SourceAdded
+	case bok && !cok && s.Status == SourceRemoved:
+		return SourceRemoved
+	case math.Abs(c-b) > tolerance*math.Max(math.Abs(b), math.Abs(c)):
+		return SourceChanged
+	default:
+		return SourceUnchanged
+	}
+}
+
+// InventoryDiff holds the differences between two emissions inventories.
+type InventoryDiff struct {
+	// Sources holds the differences for each source in either inventory,
+	// sorted by sector and then by key.
+	Sources []*SourceDiff
+
+	// Tolerance is the relative difference in the emissions of a pollutant
+	// that is considered to be a change.
+	Tolerance float64
+}
+
+// DiffInventories compares the emissions in inventories base and compare,
+// which are in the format map[sector][records], such as from
+// InventoryConfig.ReadEmissions in package aeputil.
+// Records are matched by their sector, country, FIPS code,
+// SCC code, facility (for point sources), and key, and the emissions of
+// records in the same inventory that match are added together.
+// The emissions of a pollutant are considered to have changed if their
+// absolute difference is larger than tolerance times the larger of the
+// absolute base and comparison emissions. The units of each pollutant must
+// be the same in both inventories.
+func DiffInventories(base, compare map[string][]Record, tolerance float64) (*InventoryDiff, error) {
+	sources := make(map[string]*SourceDiff)
+	add := func(inventory map[string][]Record, isBase bool) error {
+		for sector, recs := range inventory {
+			for _, rec := range recs {
+				var facility string
+				if p, ok := rec.(interface {
+					GetPointSourceData() *PointSourceData
+				}); ok && p.GetPointSourceData() != nil {
+					facility = p.GetPointSourceData().PlantID
+				}
+				key := fmt.Sprintf("%s|%d|%s|%s|%s|%s", sector, rec.GetCountry(),
+					rec.GetFIPS(), rec.GetSCC(), facility, rec.Key())
+				s, ok := sources[key]
+				if !ok {
+					s = &SourceDiff{
+						Sector:   sector,
+						Country:  rec.GetCountry(),
+						FIPS:     rec.GetFIPS(),
+						SCC:      rec.GetSCC(),
+						Facility: facility,
+						Key:      rec.Key(),
+						Base:     make(map[string]float64),
+						Compare:  make(map[string]float64),
+						Units:    make(map[string]unit.Dimensions),
+						Status:   SourceAdded,
+					}
+					if isBase {
+						s.Status = SourceRemoved
+					}
+					sources[key] = s
+				} else if (isBase && s.Status == SourceAdded) || (!isBase && s.Status == SourceRemoved) {
+					s.Status = SourceChanged // Will be checked below.
+				}
+				totals := s.Compare
+				if isBase {
+					totals = s.Base
+				}
+				for pol, v := range rec.Totals() {
+					name := pol.String()
+					if d, ok := s.Units[name]; ok && !d.Matches(v.Dimensions()) {
+						return fmt.Errorf("aep.DiffInventories: source %s pollutant %s units mismatch: %v != %v",
+							rec.Key(), name, d, v.Dimensions())
+					}
+					s.Units[name] = v.Dimensions()
+					totals[name] += v.Value()
+				}
+			}
+		}
+		return nil
+	}
+	if err := add(base, true); err != nil {
+		return nil, err
+	}
+	if err := add(compare, false); err != nil {
+		return nil, err
+	}
+
+	d := &InventoryDiff{Tolerance: tolerance}
+	for _, s := range sources {
+		if s.Status == SourceChanged {
+			s.Status = SourceUnchanged
+			for _, pol := range s.pollutants() {
+				if s.polStatus(pol, tolerance) == SourceChanged {
+					s.Status = SourceChanged
+					break
+				}
+			}
+		}
+		d.Sources = append(d.Sources, s)
+	}
+	sort.Slice(d.Sources, func(i, j int) bool {
+		if d.Sources[i].Sector != d.Sources[j].Sector {
+			return d.Sources[i].Sector < d.Sources[j].Sector
+		}
+		return d.Sources[i].Key < d.Sources[j].Key
+	})
+	return d, nil
+}
+
+// SourcesTable returns a table of the sources that were added, removed,
+// or changed, with one row for each source and pollutant whose emissions
+// are different between the inventories.
+func (d *InventoryDiff) SourcesTable() Table {
+	t := Table{{"Status", "Sector", "Country", "FIPS", "SCC", "Facility", "Key",
+		"Pollutant", "Units", "Base", "Compare", "Difference"}}
+	for _, s := range d.Sources {
+		if s.Status == SourceUnchanged {
+			continue
+		}
+		for _, pol := range s.pollutants() {
+			if s.polStatus(pol, d.Tolerance) == SourceUnchanged {
+				continue
+			}
+			t = append(t, []string{string(s.Status), s.Sector, s.Country.String(), s.FIPS, s.SCC,
+				s.Facility, s.Key, pol, s.Units[pol].String(), fmt.Sprintf("%g", s.Base[pol]),
+				fmt.Sprintf("%g", s.Compare[pol]), fmt.Sprintf("%g", s.Difference(pol))})
+		}
+	}
+	return t
+}
+
+// Summary returns a table of the total emissions of each pollutant in the
+// base and comparison inventories and their difference, aggregated by the
+// group that groupFunc assigns each source to (for example, DiffBySector),
+// along with the number of sources in the group that were added, removed,
+// or changed for each pollutant. The rows are arranged alphabetically by
+// group and then by pollutant.
+func (d *InventoryDiff) Summary(groupFunc func(*SourceDiff) string) Table {
+	type row struct {
+		group, pol              string
+		units                   unit.Dimensions
+		base, compare           float64
+		added, removed, changed int
+	}
+	rows := make(map[[2]string]*row)
+	for _, s := range d.Sources {
+		group := groupFunc(s)
+		for _, pol := range s.pollutants() {
+			r, ok := rows[[2]string{group, pol}]
+			if !ok {
+				r = &row{group: group, pol: pol, units: s.Units[pol]}
+				rows[[2]string{group, pol}] = r
+			}
+			r.base += s.Base[pol]
+			r.compare += s.Compare[pol]
+			switch s.polStatus(pol, d.Tolerance) {
+			case SourceAdded:
+				r.added++
+			case SourceRemoved:
+				r.removed++
+			case SourceChanged:
+				r.changed++
+			}
+		}
+	}
+	sorted := make([]*row, 0, len(rows))
+	for _, r := range rows {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].group != sorted[j].group {
+			return sorted[i].group < sorted[j].group
+		}
+		return sorted[i].pol < sorted[j].pol
+	})
+	t := Table{{"Group", "Pollutant", "Units", "Base", "Compare", "Difference", "Added", "Removed", "Changed"}}
+	for _, r := range sorted {
+		t = append(t, []string{r.group, r.pol, r.units.String(), fmt.Sprintf("%g", r.base),
+			fmt.Sprintf("%g", r.compare), fmt.Sprintf("%g", r.compare-r.base),
+			fmt.Sprint(r.added), fmt.Sprint(r.removed), fmt.Sprint(r.changed)})
+	}
+	return t
+}
+
+// DiffBySector groups sources by their sector, for use with
+// InventoryDiff.Summary.
+func DiffBySector(s *SourceDiff) string { return s.Sector }
+
+// DiffByState groups sources by their country and state (the first
+// two digits of the FIPS code), for use with InventoryDiff.Summary.
+func DiffByState(s *SourceDiff) string {
+	if len(s.FIPS) < 2 {
+		return s.Country.String()
+	}
+	return s.Country.String() + s.FIPS[0:2]
+}
+
+// DiffBySCCTier returns a function that groups sources by the given tier
+// (1 through 4) of their SCC code, for use with InventoryDiff.Summary.
+// See SCCTier for more information.
+func DiffBySCCTier(tier int) func(*SourceDiff) string {
+	return func(s *SourceDiff) string { return SCCTier(s.SCC, tier) }
+}
+
+// SCCTier returns the given tier (1 through 4) of the hierarchical
+// SCC code scc, which is the SCC truncated to the digits that identify
+// that tier. SCCs for point sources, which have eight digits and are
+// zero-padded to ten digits, have tiers of 1, 3, 6, and 8 digits,
+// and other ten-digit SCCs have tiers of 2, 4, 7, and 10 digits.
+// If tier is out of range, the whole SCC is returned.
+func SCCTier(scc string, tier int) string {
+	if tier < 1 || tier > 4 {
+		return scc
+	}
+	digits := []int{2, 4, 7, 10}
+	if len(scc) == 10 && scc[0:2] == "00" {
+		scc = scc[2:]
+		digits = []int{1, 3, 6, 8}
+	}
+	if n := digits[tier-1]; n < len(scc) {
+		return scc[0:n]
+	}
+	return scc
+}
@@ -0,0 +1,153 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ctessum/unit"
+)
+
+func TestDiffInventories(t *testing.T) {
+	begin := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(100 * time.Second)
+	addEmis := func(e *Emissions, rate float64, pols ...string) {
+		for _, pol := range pols {
+			e.Add(begin, end, pol, "", unit.New(rate, unit.Dimensions{unit.MassDim: 1, unit.TimeDim: -1}))
+		}
+	}
+	point := func(rate float64) *PointRecord {
+		r := &PointRecord{
+			SourceData:      SourceData{FIPS: "06001", SCC: "0010100101"},
+			PointSourceData: PointSourceData{PlantID: "P1", PointID: "1"},
+		}
+		addEmis(&r.Emissions, rate, "NOX")
+		addEmis(&r.Emissions, 1, "SO2")
+		return r
+	}
+	area := func(fips string, pols ...string) *PolygonRecord {
+		r := &PolygonRecord{SourceDataLocation: SourceDataLocation{
+			SourceData: SourceData{FIPS: fips, SCC: "2104008000"}}}
+		addEmis(&r.Emissions, 1, pols...)
+		return r
+	}
+
+	base := map[string][]Record{
+		"point": {point(1)},
+		"area":  {area("06037", "NOX", "VOC"), area("36061", "NOX")},
+	}
+	compare := map[string][]Record{
+		"point": {point(2)},
+		"area":  {area("36061", "NOX"), area("06059", "VOC")},
+	}
+	d, err := DiffInventories(base, compare, 1.0e-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := make(map[string]DiffStatus)
+	for _, s := range d.Sources {
+		status[s.Sector+s.FIPS] = s.Status
+	}
+	wantStatus := map[string]DiffStatus{
+		"point06001": SourceChanged,
+		"area06037":  SourceRemoved,
+		"area36061":  SourceUnchanged,
+		"area06059":  SourceAdded,
+	}
+	if !reflect.DeepEqual(status, wantStatus) {
+		t.Errorf("status: %v != %v", status, wantStatus)
+	}
+
+	wantSources := Table{
+		{"Status", "Sector", "Country", "FIPS", "SCC", "Facility", "Key", "Pollutant", "Units", "Base", "Compare", "Difference"},
+		{"removed", "area", "USA", "06037", "2104008000", "", "0603721040080000", "NOX", "kg", "100", "0", "-100"},
+		{"removed", "area", "USA", "06037", "2104008000", "", "0603721040080000", "VOC", "kg", "100", "0", "-100"},
+		{"added", "area", "USA", "06059", "2104008000", "", "0605921040080000", "VOC", "kg", "0", "100", "100"},
+		{"changed", "point", "USA", "06001", "0010100101", "P1", "0600100101001010P11", "NOX", "kg", "100", "200", "100"},
+	}
+	if sources := d.SourcesTable(); !reflect.DeepEqual(sources, wantSources) {
+		t.Errorf("sources:\n%v\n!=\n%v", sources, wantSources)
+	}
+
+	wantSector := Table{
+		{"Group", "Pollutant", "Units", "Base", "Compare", "Difference", "Added", "Removed", "Changed"},
+		{"area", "NOX", "kg", "200", "100", "-100", "0", "1", "0"},
+		{"area", "VOC", "kg", "100", "100", "0", "1", "1", "0"},
+		{"point", "NOX", "kg", "100", "200", "100", "0", "0", "1"},
+		{"point", "SO2", "kg", "100", "100", "0", "0", "0", "0"},
+	}
+	if summary := d.Summary(DiffBySector); !reflect.DeepEqual(summary, wantSector) {
+		t.Errorf("sector summary:\n%v\n!=\n%v", summary, wantSector)
+	}
+
+	wantState := Table{
+		{"Group", "Pollutant", "Units", "Base", "Compare", "Difference", "Added", "Removed", "Changed"},
+		{"USA06", "NOX", "kg", "200", "200", "0", "0", "1", "1"},
+		{"USA06", "SO2", "kg", "100", "100", "0", "0", "0", "0"},
+		{"USA06", "VOC", "kg", "100", "100", "0", "1", "1", "0"},
+		{"USA36", "NOX", "kg", "100", "100", "0", "0", "0", "0"},
+	}
+	if summary := d.Summary(DiffByState); !reflect.DeepEqual(summary, wantState) {
+		t.Errorf("state summary:\n%v\n!=\n%v", summary, wantState)
+	}
+
+	wantTier := Table{
+		{"Group", "Pollutant", "Units", "Base", "Compare", "Difference", "Added", "Removed", "Changed"},
+		{"1", "NOX", "kg", "100", "200", "100", "0", "0", "1"},
+		{"1", "SO2", "kg", "100", "100", "0", "0", "0", "0"},
+		{"21", "NOX", "kg", "200", "100", "-100", "0", "1", "0"},
+		{"21", "VOC", "kg", "100", "100", "0", "1", "1", "0"},
+	}
+	if summary := d.Summary(DiffBySCCTier(1)); !reflect.DeepEqual(summary, wantTier) {
+		t.Errorf("SCC tier summary:\n%v\n!=\n%v", summary, wantTier)
+	}
+
+	b := new(bytes.Buffer)
+	if err := wantTier[0:2].CSV(b); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Group,Pollutant,Units,Base,Compare,Difference,Added,Removed,Changed\n1,NOX,kg,100,200,100,0,0,1\n"; b.String() != want {
+		t.Errorf("CSV: %q != %q", b.String(), want)
+	}
+}
+
+func TestSCCTier(t *testing.T) {
+	for _, test := range []struct {
+		scc  string
+		tier int
+		want string
+	}{
+		{"0010100101", 1, "1"},
+		{"0010100101", 2, "101"},
+		{"0010100101", 3, "101001"},
+		{"0010100101", 4, "10100101"},
+		{"2104008000", 1, "21"},
+		{"2104008000", 2, "2104"},
+		{"2104008000", 3, "2104008"},
+		{"2104008000", 4, "2104008000"},
+		{"2104008000", 5, "2104008000"},
+	} {
+		if have := SCCTier(test.scc, test.tier); have != test.want {
+			t.Errorf("SCCTier(%s, %d): %s != %s", test.scc, test.tier, have, test.want)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ctessum/sparse"
+	"github.com/ctessum/unit"
+)
+
+// MetData holds time series of gridded surface meteorology.
+type MetData struct {
+	// Begin is the beginning of the first time step.
+	Begin time.Time
+
+	// Step is the length of each time step.
+	Step time.Duration
+
+	// Temperature is surface air temperature [K] in each
+	// [row, column] grid cell for each time step.
+	Temperature []*sparse.DenseArray
+
+	// QRain is the surface mass fraction of rain [kg/kg] in each
+	// [row, column] grid cell for each time step.
+	QRain []*sparse.DenseArray
+
+	// WindSpeed is the surface wind speed [m/s] in each [row, column]
+	// grid cell for each time step.
+	WindSpeed []*sparse.DenseArray
+}
+
+// ReadMetData reads meteorology from the given functions, which return
+// temperature [K], rain mass fraction [kg/kg], and West-East and South-North
+// wind speed [m/s] fields for each time step, and return io.EOF after the
+// last time step (for example, the T, QRain, U, and V methods of an
+// InMAP Preprocessor). The fields can either be 2-D [y, x] or 3-D [z, y, x],
+// in which case the lowest layer is used. Wind speeds can be staggered
+// (with one more column or row than the temperature field), in which case
+// they are averaged to the grid cell centers.
+// The first time step begins at begin and each step lasts for the given
+// duration.
+func ReadMetData(temperature, qRain, u, v func() (*sparse.DenseArray, error), begin time.Time, step time.Duration) (*MetData, error) {
+	m := &MetData{Begin: begin, Step: step}
+	for {
+		t, err := temperature()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aep.ReadMetData: reading temperature: %v", err)
+		}
+		q, err := qRain()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadMetData: reading rain: %v", err)
+		}
+		uu, err := u()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadMetData: reading U: %v", err)
+		}
+		vv, err := v()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadMetData: reading V: %v", err)
+		}
+		ny, nx := t.Shape[len(t.Shape)-2], t.Shape[len(t.Shape)-1]
+		ts := sparse.ZerosDense(ny, nx)
+		qs := sparse.ZerosDense(ny, nx)
+		ws := sparse.ZerosDense(ny, nx)
+		for j := 0; j < ny; j++ {
+			for i := 0; i < nx; i++ {
+				ts.Set(surfaceValue(t, j, i), j, i)
+				qs.Set(surfaceValue(q, j, i), j, i)
+				ucell := unstaggeredValue(uu, j, i, nx, 1)
+				vcell := unstaggeredValue(vv, j, i, ny, 0)
+				ws.Set(math.Hypot(ucell, vcell), j, i)
+			}
+		}
+		m.Temperature = append(m.Temperature, ts)
+		m.QRain = append(m.QRain, qs)
+		m.WindSpeed = append(m.WindSpeed, ws)
+	}
+	return m, nil
+}
+
+// unstaggeredValue returns the ground-level value of a, which may be staggered
+// in the given dimension (0 for rows or 1 for columns), at the center of the
+// cell at the given row and column. n is the unstaggered length of the
+// staggered dimension.
+func unstaggeredValue(a *sparse.DenseArray, row, col, n, dim int) float64 {
+	if a.Shape[len(a.Shape)-2+dim] != n+1 {
+		return surfaceValue(a, row, col)
+	}
+	if dim == 0 {
+		return (surfaceValue(a, row, col) + surfaceValue(a, row+1, col)) / 2
+	}
+	return (surfaceValue(a, row, col) + surfaceValue(a, row, col+1)) / 2
+}
+
+// MetAdjuster is an interface for types that provide gridded adjustments
+// to emissions that vary over time with the meteorology.
+type MetAdjuster interface {
+	// MetAdjustment returns the factors [row, column] that emissions
+	// of pol during the time step that includes t should be multiplied by.
+	// It returns nil if emissions of pol should not be adjusted.
+	MetAdjustment(pol Pollutant, t time.Time) (*sparse.DenseArray, error)
+}
+
+// MetAdjust is a MetAdjuster that calculates emissions adjustments as
+// a function of the meteorology in each grid cell and time step.
+type MetAdjust struct {
+	// Met is the meteorology data.
+	Met *MetData
+
+	// Pollutants holds the names of the pollutants to be adjusted.
+	// If it is empty, all pollutants are adjusted.
+	Pollutants []string
+
+	// Factor returns the adjustment factor for a given temperature [K],
+	// rain mass fraction [kg/kg], and wind speed [m/s].
+	Factor func(temperature, qRain, windSpeed float64) float64
+
+	// Normalize specifies whether the adjustment factors in each grid cell
+	// should be divided by their average over all time steps, so that
+	// the adjustment changes the timing but not the total amount of
+	// emissions over the meteorology period. If the factors in a grid cell
+	// are all zero, all of the normalized factors in that cell are one.
+	Normalize bool
+
+	once    sync.Once
+	factors []*sparse.DenseArray
+}
+
+// MetAdjustment returns the adjustment factors for emissions of pol
+// during the time step that includes t.
+func (m *MetAdjust) MetAdjustment(pol Pollutant, t time.Time) (*sparse.DenseArray, error) {
+	if len(m.Pollutants) > 0 {
+		found := false
+		for _, p := range m.Pollutants {
+			if p == pol.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, nil
+		}
+	}
+	m.once.Do(m.calculate)
+	i := int(t.Sub(m.Met.Begin) / m.Met.Step)
+	if t.Before(m.Met.Begin) || i >= len(m.factors) {
+		return nil, fmt.Errorf("aep.MetAdjust: no meteorology data for %v", t)
+	}
+	return m.factors[i], nil
+}
+
+// calculate calculates the adjustment factors for each time step.
+func (m *MetAdjust) calculate() {
+	m.factors = make([]*sparse.DenseArray, len(m.Met.Temperature))
+	for i, t := range m.Met.Temperature {
+		f := sparse.ZerosDense(t.Shape...)
+		for j, tv := range t.Elements {
+			f.Elements[j] = m.Factor(tv, m.Met.QRain[i].Elements[j], m.Met.WindSpeed[i].Elements[j])
+		}
+		m.factors[i] = f
+	}
+	if !m.Normalize || len(m.factors) == 0 {
+		return
+	}
+	sum := sparse.ZerosDense(m.factors[0].Shape...)
+	for _, f := range m.factors {
+		sum.AddDense(f)
+	}
+	n := float64(len(m.factors))
+	for _, f := range m.factors {
+		for j, v := range f.Elements {
+			if sum.Elements[j] == 0 {
+				f.Elements[j] = 1
+			} else {
+				f.Elements[j] = v * n / sum.Elements[j]
+			}
+		}
+	}
+}
+
+// EvaporativeAdjuster returns a MetAdjuster for evaporative VOC emissions,
+// which increase exponentially with temperature by a factor of
+// exp(beta × (T - 293.15 K)). A typical value of beta is 0.05 K⁻¹.
+// The adjustments are normalized so that they do not change total
+// emissions over the meteorology period.
+func EvaporativeAdjuster(met *MetData, pollutants []string, beta float64) *MetAdjust {
+	return &MetAdjust{
+		Met:        met,
+		Pollutants: pollutants,
+		Factor: func(t, _, _ float64) float64 {
+			return math.Exp(beta * (t - 293.15))
+		},
+		Normalize: true,
+	}
+}
+
+// RoadDustAdjuster returns a MetAdjuster for road dust emissions, which
+// are reduced by the given fraction (between 0 and 1) in grid cells and
+// time steps where the surface rain mass fraction is at least
+// qRainThreshold [kg/kg]. The adjustments are not normalized, so
+// they reduce total emissions.
+func RoadDustAdjuster(met *MetData, pollutants []string, qRainThreshold, suppression float64) *MetAdjust {
+	return &MetAdjust{
+		Met:        met,
+		Pollutants: pollutants,
+		Factor: func(_, q, _ float64) float64 {
+			if q >= qRainThreshold {
+				return 1 - suppression
+			}
+			return 1
+		},
+	}
+}
+
+// WoodSmokeAdjuster returns a MetAdjuster for residential wood combustion
+// emissions, which are allocated in time in proportion to heating degrees:
+// the amount by which the temperature is below baseTemperature [K]
+// (typically 291.48 K, or 65 °F). The adjustments are normalized so that
+// they do not change total emissions over the meteorology period.
+func WoodSmokeAdjuster(met *MetData, pollutants []string, baseTemperature float64) *MetAdjust {
+	return &MetAdjust{
+		Met:        met,
+		Pollutants: pollutants,
+		Factor: func(t, _, _ float64) float64 {
+			return math.Max(0, baseTemperature-t)
+		},
+		Normalize: true,
+	}
+}
+
+// RecordGriddedMetAdjusted wraps around a RecordGridded to provide
+// adjustments to its gridded emissions that vary by grid cell and
+// time step according to the meteorology.
+// Only the GriddedEmissions method is adjusted; the ungridded
+// totals are not.
+type RecordGriddedMetAdjusted struct {
+	// RecordGridded is the record to be adjusted.
+	RecordGridded
+
+	// MetAdjuster specifies the adjustment to occur.
+	MetAdjuster
+}
+
+// GriddedEmissions calls the GriddedEmissions method of the contained Record
+// and adjusts the output using the MetAdjuster field. The emissions are
+// calculated separately for each hour between begin and end.
+func (r *RecordGriddedMetAdjusted) GriddedEmissions(begin, end time.Time, gi int) (
+	emis map[Pollutant]*sparse.SparseArray, units map[Pollutant]unit.Dimensions, err error) {
+
+	gridSrg, _, _, err := r.GridFactors(gi)
+	if err != nil || gridSrg == nil {
+		return
+	}
+
+	emis = make(map[Pollutant]*sparse.SparseArray)
+	units = make(map[Pollutant]unit.Dimensions)
+	for t := begin; t.Before(end); t = t.Add(time.Hour) {
+		tEnd := t.Add(time.Hour)
+		if tEnd.After(end) {
+			tEnd = end
+		}
+		for pol, data := range r.PeriodTotals(t, tEnd) {
+			e := gridSrg.ScaleCopy(data.Value())
+			adj, err := r.MetAdjustment(pol, t)
+			if err != nil {
+				return nil, nil, err
+			}
+			if adj != nil {
+				if !reflect.DeepEqual(e.Shape, adj.Shape) {
+					return nil, nil, fmt.Errorf("aep.RecordGriddedMetAdjusted: adjustment shape (%v) doesn't match grid shape (%v)", adj.Shape, e.Shape)
+				}
+				for i, v := range e.Elements {
+					e.Elements[i] = v * adj.Elements[i]
+				}
+			}
+			if _, ok := emis[pol]; !ok {
+				emis[pol] = e
+				units[pol] = data.Dimensions()
+			} else {
+				emis[pol].AddSparse(e)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ctessum/sparse"
+)
+
+// testMetFunc returns a function that returns 3-D [z, y, x] fields
+// with the given values for each time step, followed by io.EOF.
+func testMetFunc(nz, ny, nx int, vals ...float64) func() (*sparse.DenseArray, error) {
+	i := 0
+	return func() (*sparse.DenseArray, error) {
+		if i == len(vals) {
+			return nil, io.EOF
+		}
+		a := sparse.ZerosDense(nz, ny, nx)
+		for j := range a.Elements {
+			a.Elements[j] = vals[i]
+		}
+		i++
+		return a, nil
+	}
+}
+
+func TestReadMetData(t *testing.T) {
+	begin := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	met, err := ReadMetData(
+		testMetFunc(2, 3, 4, 280, 290),
+		testMetFunc(2, 3, 4, 0, 1.e-4),
+		testMetFunc(2, 3, 5, 3, 0), // staggered
+		testMetFunc(2, 4, 4, 4, 1), // staggered
+		begin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(met.Temperature) != 2 || len(met.QRain) != 2 || len(met.WindSpeed) != 2 {
+		t.Fatalf("wrong number of time steps: %d, %d, %d", len(met.Temperature), len(met.QRain), len(met.WindSpeed))
+	}
+	want := []struct{ t, q, w float64 }{{280, 0, 5}, {290, 1.e-4, 1}}
+	for i, w := range want {
+		if s := met.Temperature[i].Shape; len(s) != 2 || s[0] != 3 || s[1] != 4 {
+			t.Errorf("step %d: wrong shape %v", i, s)
+		}
+		if v := met.Temperature[i].Get(2, 3); v != w.t {
+			t.Errorf("step %d temperature: have %g, want %g", i, v, w.t)
+		}
+		if v := met.QRain[i].Get(2, 3); v != w.q {
+			t.Errorf("step %d rain: have %g, want %g", i, v, w.q)
+		}
+		if v := met.WindSpeed[i].Get(2, 3); math.Abs(v-w.w) > 1.e-10 {
+			t.Errorf("step %d wind speed: have %g, want %g", i, v, w.w)
+		}
+	}
+}
+
+func TestRecordGriddedMetAdjusted(t *testing.T) {
+	begin := time.Date(2016, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(3 * time.Hour)
+	cfg, recs := outputTestRecords(t, begin, end)
+	grid := cfg.Grids()[0]
+	met, err := ReadMetData(
+		testMetFunc(1, grid.Ny, grid.Nx, 280, 290, 300),
+		testMetFunc(1, grid.Ny, grid.Nx, 0, 1.e-4, 0),
+		testMetFunc(1, grid.Ny, grid.Nx, 1, 1, 1),
+		testMetFunc(1, grid.Ny, grid.Nx, 1, 1, 1),
+		begin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := Pollutant{Name: "PM2_5"}
+	no := Pollutant{Name: "NO"}
+
+	// hourlySums returns the total gridded emissions of pol from r in each hour.
+	hourlySums := func(r RecordGridded, pol Pollutant) []float64 {
+		var o []float64
+		for h := begin; h.Before(end); h = h.Add(time.Hour) {
+			e, _, err := r.GriddedEmissions(h, h.Add(time.Hour), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var s float64
+			if ee, ok := e[pol]; ok {
+				s = ee.Sum()
+			}
+			o = append(o, s)
+		}
+		return o
+	}
+	unadjusted := hourlySums(recs[1], pm)[0]
+
+	tests := []struct {
+		name string
+		adj  MetAdjuster
+		rec  RecordGridded
+		pol  Pollutant
+		want []float64 // multiplied by unadjusted
+	}{
+		{
+			name: "wood smoke",
+			adj:  WoodSmokeAdjuster(met, []string{"PM2_5"}, 291.48),
+			rec:  recs[1],
+			pol:  pm,
+			want: []float64{3 * 11.48 / 12.96, 3 * 1.48 / 12.96, 0},
+		},
+		{
+			name: "road dust",
+			adj:  RoadDustAdjuster(met, []string{"PM2_5"}, 1.e-5, 0.75),
+			rec:  recs[1],
+			pol:  pm,
+			want: []float64{1, 0.25, 1},
+		},
+		{
+			name: "evaporative",
+			adj:  EvaporativeAdjuster(met, nil, 0.05),
+			rec:  recs[1],
+			pol:  pm,
+			want: func() []float64 {
+				e := []float64{math.Exp(0.05 * -13.15), math.Exp(0.05 * -3.15), math.Exp(0.05 * 6.85)}
+				s := e[0] + e[1] + e[2]
+				return []float64{3 * e[0] / s, 3 * e[1] / s, 3 * e[2] / s}
+			}(),
+		},
+		{
+			name: "other pollutant",
+			adj:  WoodSmokeAdjuster(met, []string{"PM2_5"}, 291.48),
+			rec:  recs[0],
+			pol:  no,
+			want: []float64{1, 1, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := unadjusted
+			if test.pol == no {
+				base = hourlySums(test.rec, no)[0]
+			}
+			r := &RecordGriddedMetAdjusted{RecordGridded: test.rec, MetAdjuster: test.adj}
+			have := hourlySums(r, test.pol)
+			for i, w := range test.want {
+				if math.Abs(have[i]-w*base) > 1.e-10*base {
+					t.Errorf("hour %d: have %g, want %g", i, have[i], w*base)
+				}
+			}
+
+			// Emissions over multiple hours should be the sum of the hourly emissions.
+			e, _, err := r.GriddedEmissions(begin, end, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := e[test.pol].Sum(); math.Abs(s-(have[0]+have[1]+have[2])) > 1.e-10*base {
+				t.Errorf("total: have %g, want %g", s, have[0]+have[1]+have[2])
+			}
+		})
+	}
+
+	t.Run("out of range", func(t *testing.T) {
+		short := &MetData{
+			Begin:       met.Begin,
+			Step:        met.Step,
+			Temperature: met.Temperature[:2],
+			QRain:       met.QRain[:2],
+			WindSpeed:   met.WindSpeed[:2],
+		}
+		r := &RecordGriddedMetAdjusted{RecordGridded: recs[1], MetAdjuster: WoodSmokeAdjuster(short, nil, 291.48)}
+		if _, _, err := r.GriddedEmissions(begin, end, 0); err == nil {
+			t.Error("there should be an error when there is no meteorology data")
+		}
+	})
+}
@@ -0,0 +1,317 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/unit"
+)
+
+// OnroadRates holds MOVES-style onroad emission rates, in units of
+// mass per vehicle-mile, by road type, average speed bin, and pollutant.
+type OnroadRates struct {
+	// rates holds emission rates in kg per vehicle-mile, sorted by
+	// speed, for each road type and pollutant.
+	rates map[string]map[string][]speedRate
+}
+
+type speedRate struct {
+	speed, rate float64
+}
+
+// movesSpeedBinSpeed returns the average speed in miles per hour
+// of the given MOVES average speed bin ID (1-16).
+func movesSpeedBinSpeed(bin int) (float64, error) {
+	if bin < 1 || bin > 16 {
+		return 0, fmt.Errorf("invalid speed bin %d; it should be between 1 and 16", bin)
+	}
+	if bin == 1 {
+		return 2.5, nil
+	}
+	return 5 * float64(bin-1), nil
+}
+
+// ReadOnroadRates reads a MOVES-style emission rate lookup table from r.
+// The table should be in CSV format, with a header row and columns
+// "RoadType", "SpeedBin", "Pollutant", and "Rate", in any order.
+// RoadType is the road type identifier (for example, the MOVES roadTypeID),
+// SpeedBin is the MOVES average speed bin ID (1-16), and Rate
+// is the emission rate in mass per vehicle-mile, where the
+// mass is in the given units.
+func ReadOnroadRates(r io.Reader, units InputUnits) (*OnroadRates, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("aep.ReadOnroadRates: reading header: %v", err)
+	}
+	cols := make(map[string]int)
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	var idx [4]int
+	for i, name := range []string{"roadtype", "speedbin", "pollutant", "rate"} {
+		var ok bool
+		if idx[i], ok = cols[name]; !ok {
+			return nil, fmt.Errorf("aep.ReadOnroadRates: missing column %s", name)
+		}
+	}
+	conv := units.Conversion(1)
+	o := &OnroadRates{rates: make(map[string]map[string][]speedRate)}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aep.ReadOnroadRates: %v", err)
+		}
+		roadType, pol := trimString(rec[idx[0]]), trimString(rec[idx[2]])
+		bin, err := strconv.Atoi(trimString(rec[idx[1]]))
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadOnroadRates: %v", err)
+		}
+		speed, err := movesSpeedBinSpeed(bin)
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadOnroadRates: %v", err)
+		}
+		rate, err := stringToFloat(rec[idx[3]])
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadOnroadRates: %v", err)
+		}
+		if _, ok := o.rates[roadType]; !ok {
+			o.rates[roadType] = make(map[string][]speedRate)
+		}
+		o.rates[roadType][pol] = append(o.rates[roadType][pol],
+			speedRate{speed: speed, rate: conv(rate).Value()})
+	}
+	for _, pols := range o.rates {
+		for _, sr := range pols {
+			sort.Slice(sr, func(i, j int) bool { return sr[i].speed < sr[j].speed })
+		}
+	}
+	return o, nil
+}
+
+// Rates returns the emission rates in kg per vehicle-mile for each
+// pollutant for the given road type and average speed in miles per
+// hour. Rates are linearly interpolated between the average speeds of
+// the speed bins in the table, and speeds outside of the range of
+// the table are assigned the rate of the closest speed bin.
+func (o *OnroadRates) Rates(roadType string, speed float64) (map[string]float64, error) {
+	pols, ok := o.rates[roadType]
+	if !ok {
+		return nil, fmt.Errorf("aep: no onroad emission rates for road type '%s'", roadType)
+	}
+	rates := make(map[string]float64)
+	for pol, sr := range pols {
+		i := sort.Search(len(sr), func(i int) bool { return sr[i].speed >= speed })
+		switch {
+		case i == 0:
+			rates[pol] = sr[0].rate
+		case i == len(sr):
+			rates[pol] = sr[len(sr)-1].rate
+		default:
+			lo, hi := sr[i-1], sr[i]
+			rates[pol] = lo.rate + (hi.rate-lo.rate)*(speed-lo.speed)/(hi.speed-lo.speed)
+		}
+	}
+	return rates, nil
+}
+
+// OnroadLinks specifies a shapefile of road links that carry
+// vehicle activity information.
+type OnroadLinks struct {
+	// ShapeFile is the path to the shapefile holding the road link
+	// line geometries. There should be a corresponding ".prj" file.
+	ShapeFile string
+
+	// LinkIDField, FIPSField, and RoadTypeField are the names of the
+	// shapefile attributes holding the link identifier, the five-digit
+	// FIPS code, and the road type of each link. LinkIDField and FIPSField
+	// are optional; if LinkIDField is blank, links are identified by their
+	// row number.
+	LinkIDField, FIPSField, RoadTypeField string
+
+	// VMTFields are the names of the shapefile attributes holding the vehicle
+	// miles traveled (VMT) on each link. If there is one field, it holds the
+	// total VMT over the period that emissions are calculated for. If there
+	// are 24 fields, they hold the VMT during each hour of a typical day
+	// in the period, where the first field is for the hour starting at the
+	// beginning of the period.
+	VMTFields []string
+
+	// SpeedFields are the names of the shapefile attributes holding the
+	// average vehicle speed in miles per hour on each link. There can either
+	// be one field or the same number of fields as VMTFields.
+	SpeedFields []string
+
+	// SCC and Country are assigned to the emissions records.
+	SCC     string
+	Country Country
+}
+
+// ReadRecords calculates emissions from the receiver's road links between
+// begin and end using the given emission rates, and returns one record
+// for each link with nonzero emissions. The records have line geometries,
+// so they can be allocated to a grid by a SpatialProcessor without a
+// spatial surrogate. When the receiver has hourly VMT fields, the period
+// between begin and end must be a whole number of days.
+func (l *OnroadLinks) ReadRecords(rates *OnroadRates, begin, end time.Time) ([]Record, error) {
+	hourly := len(l.VMTFields) == 24
+	if !hourly && len(l.VMTFields) != 1 {
+		return nil, fmt.Errorf("aep.OnroadLinks: there should be 1 or 24 VMT fields but there are %d", len(l.VMTFields))
+	}
+	if len(l.SpeedFields) != 1 && len(l.SpeedFields) != len(l.VMTFields) {
+		return nil, fmt.Errorf("aep.OnroadLinks: there should be 1 or %d speed fields but there are %d",
+			len(l.VMTFields), len(l.SpeedFields))
+	}
+	if !end.After(begin) {
+		return nil, fmt.Errorf("aep.OnroadLinks: end (%v) must be after begin (%v)", end, begin)
+	}
+	if hourly && end.Sub(begin)%(24*time.Hour) != 0 {
+		return nil, fmt.Errorf("aep.OnroadLinks: the period between %v and %v is not a whole number of days", begin, end)
+	}
+
+	d, err := shp.NewDecoder(l.ShapeFile)
+	if err != nil {
+		return nil, fmt.Errorf("aep.OnroadLinks: %v", err)
+	}
+	defer d.Close()
+	sr, err := d.SR()
+	if err != nil {
+		return nil, fmt.Errorf("aep.OnroadLinks: %v", err)
+	}
+
+	fieldNames := append([]string{l.RoadTypeField}, l.VMTFields...)
+	fieldNames = append(fieldNames, l.SpeedFields...)
+	for _, f := range []string{l.LinkIDField, l.FIPSField} {
+		if f != "" {
+			fieldNames = append(fieldNames, f)
+		}
+	}
+
+	var sourceData SourceData
+	sourceData.parseSCC(l.SCC)
+	sourceData.Country = l.Country
+
+	emisDims := unit.Dimensions{unit.MassDim: 1, unit.TimeDim: -1}
+	var recs []Record
+	for row := 0; ; row++ {
+		g, fields, more := d.DecodeRowFields(fieldNames...)
+		if !more {
+			break
+		}
+		line, ok := g.(geom.Linear)
+		if !ok {
+			return nil, fmt.Errorf("aep.OnroadLinks: row %d: geometry type %T is not a line", row, g)
+		}
+		rec := &onroadLinkRecord{
+			SourceData: sourceData,
+			Geom:       line,
+			SR:         sr,
+			LinkID:     strconv.Itoa(row),
+			RoadType:   trimString(fields[l.RoadTypeField]),
+		}
+		if l.LinkIDField != "" {
+			rec.LinkID = trimString(fields[l.LinkIDField])
+		}
+		if l.FIPSField != "" {
+			rec.parseFIPS(fields[l.FIPSField])
+		}
+
+		for i, vmtField := range l.VMTFields {
+			vmt, err := stringToFloat(fields[vmtField])
+			if err != nil {
+				return nil, fmt.Errorf("aep.OnroadLinks: link %s: %v", rec.LinkID, err)
+			}
+			if vmt == 0 {
+				continue
+			}
+			speedField := l.SpeedFields[0]
+			if len(l.SpeedFields) > 1 {
+				speedField = l.SpeedFields[i]
+			}
+			speed, err := stringToFloat(fields[speedField])
+			if err != nil {
+				return nil, fmt.Errorf("aep.OnroadLinks: link %s: %v", rec.LinkID, err)
+			}
+			polRates, err := rates.Rates(rec.RoadType, speed)
+			if err != nil {
+				return nil, fmt.Errorf("aep.OnroadLinks: link %s: %v", rec.LinkID, err)
+			}
+			for pol, rate := range polRates {
+				pol, prefix := splitPol(pol)
+				if !hourly {
+					emis := unit.New(vmt*rate/end.Sub(begin).Seconds(), emisDims)
+					rec.Emissions.Add(begin, end, pol, prefix, emis)
+					continue
+				}
+				emis := unit.New(vmt*rate/time.Hour.Seconds(), emisDims)
+				for day := begin; day.Before(end); day = day.Add(24 * time.Hour) {
+					hour := day.Add(time.Duration(i) * time.Hour)
+					rec.Emissions.Add(hour, hour.Add(time.Hour), pol, prefix, emis)
+				}
+			}
+		}
+		if len(rec.Emissions.e) > 0 {
+			recs = append(recs, rec)
+		}
+	}
+	if err := d.Error(); err != nil {
+		return nil, fmt.Errorf("aep.OnroadLinks: %v", err)
+	}
+	return recs, nil
+}
+
+// onroadLinkRecord holds emissions from vehicles traveling
+// on a road link.
+type onroadLinkRecord struct {
+	SourceData
+	Emissions
+
+	// Geom and SR are the link geometry and its spatial reference.
+	Geom geom.Linear
+	SR   *proj.SR
+
+	LinkID, RoadType string
+}
+
+// Key returns a unique key for this record.
+func (r *onroadLinkRecord) Key() string {
+	return r.SourceData.Key() + r.LinkID
+}
+
+// Location returns the line representing the location of emissions.
+func (r *onroadLinkRecord) Location() *Location {
+	return &Location{Geom: r.Geom, SR: r.SR, Name: r.LinkID}
+}
+
+// PointData exists to fulfill the Record interface but always returns
+// nil because this is not a point source.
+func (r *onroadLinkRecord) PointData() *PointSourceData { return nil }
@@ -0,0 +1,215 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+	goshp "github.com/jonas-p/go-shp"
+)
+
+const onroadRatesTest = `RoadType,SpeedBin,Pollutant,Rate
+4,3,NOX,2
+4,5,NOX,4
+5,1,EXH__VOC,1
+`
+
+// writeOnroadLinksTest writes a road link shapefile to dir and returns its path.
+func writeOnroadLinksTest(t *testing.T, dir string) string {
+	fields := []goshp.Field{
+		goshp.StringField("ID", 10),
+		goshp.StringField("RTYPE", 2),
+		goshp.FloatField("VMT", 12, 3),
+		goshp.FloatField("SPEED", 12, 3),
+	}
+	for h := 0; h < 24; h++ {
+		fields = append(fields, goshp.FloatField(fmt.Sprintf("VMT%02d", h), 12, 3))
+	}
+	file := filepath.Join(dir, "links.shp")
+	e, err := shp.NewEncoderFromFields(file, goshp.POLYLINE, fields...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []struct {
+		id, roadType string
+		line         geom.MultiLineString
+		vmt, speed   float64
+		hourly       map[int]float64
+	}{
+		{
+			id: "A", roadType: "4", vmt: 100, speed: 15, hourly: map[int]float64{0: 10, 12: 20},
+			line: geom.MultiLineString{{{X: 500, Y: 500}, {X: 1500, Y: 500}}},
+		},
+		{
+			id: "B", roadType: "5", vmt: 0, speed: 30,
+			line: geom.MultiLineString{{{X: 500, Y: 200}, {X: 600, Y: 200}}},
+		},
+	} {
+		vals := []interface{}{link.id, link.roadType, link.vmt, link.speed}
+		for h := 0; h < 24; h++ {
+			vals = append(vals, link.hourly[h])
+		}
+		if err = e.EncodeFields(link.line, vals...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+	prj, err := ioutil.ReadFile("testdata/county_lu2k.prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "links.prj"), prj, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestOnroadRates(t *testing.T) {
+	rates, err := ReadOnroadRates(strings.NewReader(onroadRatesTest), Kg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		roadType string
+		speed    float64
+		want     map[string]float64
+	}{
+		{roadType: "4", speed: 5, want: map[string]float64{"NOX": 2}},
+		{roadType: "4", speed: 15, want: map[string]float64{"NOX": 3}},
+		{roadType: "4", speed: 60, want: map[string]float64{"NOX": 4}},
+		{roadType: "5", speed: 30, want: map[string]float64{"EXH__VOC": 1}},
+	} {
+		have, err := rates.Rates(test.roadType, test.speed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(have) != len(test.want) {
+			t.Errorf("road type %s speed %g: want %v but have %v", test.roadType, test.speed, test.want, have)
+		}
+		for pol, w := range test.want {
+			if have[pol] != w {
+				t.Errorf("road type %s speed %g %s: want %g but have %g", test.roadType, test.speed, pol, w, have[pol])
+			}
+		}
+	}
+	if _, err := rates.Rates("1", 30); err == nil {
+		t.Error("missing road type should cause an error")
+	}
+}
+
+func TestOnroadLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "onroad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeOnroadLinksTest(t, dir)
+
+	rates, err := ReadOnroadRates(strings.NewReader(onroadRatesTest), Kg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prj, err := ioutil.ReadFile("testdata/county_lu2k.prj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := proj.Parse(string(prj))
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid := NewGridRegular("test", 2, 1, 1000, 1000, 0, 0, sr)
+	sp := NewSpatialProcessor(NewSrgSpecs(), []*GridDef{grid}, &GridRef{}, sr, true)
+
+	begin := time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(48 * time.Hour)
+	nox := Pollutant{Name: "NOX"}
+
+	t.Run("total", func(t *testing.T) {
+		links := &OnroadLinks{
+			ShapeFile:     file,
+			LinkIDField:   "ID",
+			RoadTypeField: "RTYPE",
+			VMTFields:     []string{"VMT"},
+			SpeedFields:   []string{"SPEED"},
+			SCC:           "2201001000",
+			Country:       USA,
+		}
+		recs, err := links.ReadRecords(rates, begin, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != 1 {
+			t.Fatalf("want 1 record but have %d", len(recs))
+		}
+		if rec := recs[0]; rec.Key() != "22010010000A" {
+			t.Errorf("key: %s", rec.Key())
+		}
+		if total := recs[0].Totals()[nox].Value(); math.Abs(total-300) > 1.e-8 {
+			t.Errorf("total: want 300 but have %g", total)
+		}
+		emis, _, err := sp.GridRecord(recs[0]).GriddedEmissions(begin, end, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range emis[nox].Elements {
+			if math.Abs(v-150) > 1.e-6 {
+				t.Errorf("grid cell %d: want 150 but have %g", i, v)
+			}
+		}
+	})
+
+	t.Run("hourly", func(t *testing.T) {
+		links := &OnroadLinks{
+			ShapeFile:     file,
+			RoadTypeField: "RTYPE",
+			SpeedFields:   []string{"SPEED"},
+			SCC:           "2201001000",
+			Country:       USA,
+		}
+		for h := 0; h < 24; h++ {
+			links.VMTFields = append(links.VMTFields, fmt.Sprintf("VMT%02d", h))
+		}
+		recs, err := links.ReadRecords(rates, begin, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) != 1 {
+			t.Fatalf("want 1 record but have %d", len(recs))
+		}
+		if total := recs[0].Totals()[nox].Value(); math.Abs(total-180) > 1.e-8 {
+			t.Errorf("total: want 180 but have %g", total)
+		}
+		noon := begin.Add(36 * time.Hour)
+		if v := recs[0].PeriodTotals(noon, noon.Add(time.Hour))[nox].Value(); math.Abs(v-60) > 1.e-8 {
+			t.Errorf("noon: want 60 but have %g", v)
+		}
+		if _, err := links.ReadRecords(rates, begin, begin.Add(36*time.Hour)); err == nil {
+			t.Error("partial days should cause an error")
+		}
+	})
+}
@@ -0,0 +1,328 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ctessum/sparse"
+	"github.com/ctessum/unit"
+)
+
+// PlumeRiseMet holds time series of gridded 3-D meteorology for
+// calculating plume rise.
+type PlumeRiseMet struct {
+	// Begin is the beginning of the first time step.
+	Begin time.Time
+
+	// Step is the length of each time step.
+	Step time.Duration
+
+	// LayerHeights is the height [m] above ground of the bottom of each
+	// vertical layer and the top of the top layer ([layer+1, row, column])
+	// for each time step.
+	LayerHeights []*sparse.DenseArray
+
+	// Temperature is air temperature [K] in each [layer, row, column]
+	// grid cell for each time step.
+	Temperature []*sparse.DenseArray
+
+	// WindSpeed is horizontal wind speed [m/s] in each [layer, row, column]
+	// grid cell for each time step.
+	WindSpeed []*sparse.DenseArray
+
+	// SClass is the stability class (0 for unstable or 1 for stable)
+	// in each [layer, row, column] grid cell for each time step.
+	SClass []*sparse.DenseArray
+
+	// S1 is the stability parameter (the vertical potential temperature
+	// gradient divided by the potential temperature [1/m]) in each
+	// [layer, row, column] grid cell for each time step.
+	S1 []*sparse.DenseArray
+}
+
+// ReadPlumeRiseMet reads meteorology from the given functions, which return
+// 3-D [z, y, x] fields of layer height above ground [m] (staggered in the
+// vertical direction, with one more layer than the other fields),
+// temperature [K], pressure [Pa], and West-East and South-North wind speed
+// [m/s] for each time step, and return io.EOF after the last time step
+// (for example, the Height, T, P, U, and V methods of an InMAP Preprocessor).
+// Wind speeds can be staggered (with one more column or row than the
+// temperature field), in which case they are averaged to the grid cell
+// centers. The stability class and parameter are calculated the same way
+// as in the InMAP preprocessor.
+// The first time step begins at begin and each step lasts for the given
+// duration.
+func ReadPlumeRiseMet(height, temperature, pressure, u, v func() (*sparse.DenseArray, error), begin time.Time, step time.Duration) (*PlumeRiseMet, error) {
+	m := &PlumeRiseMet{Begin: begin, Step: step}
+	for {
+		h, err := height()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: reading height: %v", err)
+		}
+		t, err := temperature()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: reading temperature: %v", err)
+		}
+		p, err := pressure()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: reading pressure: %v", err)
+		}
+		uu, err := u()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: reading U: %v", err)
+		}
+		vv, err := v()
+		if err != nil {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: reading V: %v", err)
+		}
+		if len(t.Shape) != 3 {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: temperature has %d dimensions but it should have 3", len(t.Shape))
+		}
+		nz, ny, nx := t.Shape[0], t.Shape[1], t.Shape[2]
+		if len(h.Shape) != 3 || h.Shape[0] != nz+1 || h.Shape[1] != ny || h.Shape[2] != nx {
+			return nil, fmt.Errorf("aep.ReadPlumeRiseMet: height shape %v should be [%d %d %d]", h.Shape, nz+1, ny, nx)
+		}
+		ws := sparse.ZerosDense(nz, ny, nx)
+		s1 := sparse.ZerosDense(nz, ny, nx)
+		sClass := sparse.ZerosDense(nz, ny, nx)
+		for k := 0; k < nz; k++ {
+			for j := 0; j < ny; j++ {
+				for i := 0; i < nx; i++ {
+					ucell := unstaggeredLayerValue(uu, k, j, i, nx, 1)
+					vcell := unstaggeredLayerValue(vv, k, j, i, ny, 0)
+					ws.Set(math.Hypot(ucell, vcell), k, j, i)
+
+					theta := potentialTemperature(t.Get(k, j, i), p.Get(k, j, i))
+					var dThetaDz float64 // potential temperature gradient [K/m]
+					if k < nz-1 {
+						thetaAbove := potentialTemperature(t.Get(k+1, j, i), p.Get(k+1, j, i))
+						dThetaDz = (thetaAbove - theta) / (h.Get(k+1, j, i) - h.Get(k, j, i))
+					}
+					s1.Set(dThetaDz/theta, k, j, i)
+					if dThetaDz >= 0.005 {
+						sClass.Set(1, k, j, i)
+					}
+				}
+			}
+		}
+		m.LayerHeights = append(m.LayerHeights, h)
+		m.Temperature = append(m.Temperature, t)
+		m.WindSpeed = append(m.WindSpeed, ws)
+		m.S1 = append(m.S1, s1)
+		m.SClass = append(m.SClass, sClass)
+	}
+	return m, nil
+}
+
+// unstaggeredLayerValue returns the value of 3-D array a, which may be
+// staggered in the given horizontal dimension (0 for rows or 1 for columns),
+// at the center of the cell at the given layer, row, and column.
+// n is the unstaggered length of the staggered dimension.
+func unstaggeredLayerValue(a *sparse.DenseArray, layer, row, col, n, dim int) float64 {
+	if a.Shape[1+dim] != n+1 {
+		return a.Get(layer, row, col)
+	}
+	if dim == 0 {
+		return (a.Get(layer, row, col) + a.Get(layer, row+1, col)) / 2
+	}
+	return (a.Get(layer, row, col) + a.Get(layer, row, col+1)) / 2
+}
+
+// potentialTemperature returns the potential temperature [K] of air with
+// temperature t [K] and pressure p [Pa].
+func potentialTemperature(t, p float64) float64 {
+	const (
+		po    = 101300. // Pa, reference pressure
+		kappa = 0.2854  // R/cp for air
+	)
+	return t / math.Pow(p/po, kappa)
+}
+
+// minPlumeWindSpeed is the minimum wind speed [m/s] used in plume rise
+// calculations, to avoid infinite plume rise under calm conditions.
+const minPlumeWindSpeed = 0.5
+
+// PlumeRise is a VerticalAllocator that allocates the emissions from
+// elevated sources to the layer that contains the top of their plume,
+// calculated from their stack parameters and the meteorology in their
+// grid cell using the Briggs plume rise equations.
+// All other emissions are allocated to the ground-level layer.
+// Plumes that rise above the top layer are allocated to the top layer.
+// The meteorology grid must be the same as the emissions grid.
+type PlumeRise struct {
+	// Met is the meteorology data.
+	Met *PlumeRiseMet
+}
+
+// Layers returns the number of vertical layers.
+func (p *PlumeRise) Layers() int {
+	if len(p.Met.Temperature) == 0 {
+		return 0
+	}
+	return p.Met.Temperature[0].Shape[0]
+}
+
+// LayerFractions returns the fraction of the emissions from rec that are
+// released into each layer. The plume height is calculated separately for
+// each grid cell the emissions are allocated to and each hour between
+// begin and end, and the fractions are weighted by the grid cell
+// allocation factors and averaged over the hours.
+func (p *PlumeRise) LayerFractions(rec RecordGridded, begin, end time.Time, gi int) ([]float64, error) {
+	nz := p.Layers()
+	if nz == 0 {
+		return nil, fmt.Errorf("aep.PlumeRise: no meteorology data")
+	}
+	o := make([]float64, nz)
+	e, ok := rec.Parent().(RecordElevated)
+	if !ok || e.GroundLevel() {
+		o[0] = 1
+		return o, nil
+	}
+	height, diam, temp, _, vel := e.StackParameters()
+	if height == nil {
+		o[0] = 1
+		return o, nil
+	}
+	for name, v := range map[string]struct {
+		v *unit.Unit
+		d unit.Dimensions
+	}{
+		"height":      {height, unit.Meter},
+		"diameter":    {diam, unit.Meter},
+		"temperature": {temp, unit.Kelvin},
+		"velocity":    {vel, unit.Dimensions{unit.LengthDim: 1, unit.TimeDim: -1}},
+	} {
+		if v.v == nil {
+			return nil, fmt.Errorf("aep.PlumeRise: record %s is missing stack %s", rec.Key(), name)
+		}
+		if err := v.v.Check(v.d); err != nil {
+			return nil, fmt.Errorf("aep.PlumeRise: stack %s: %v", name, err)
+		}
+	}
+	gridSrg, _, _, err := rec.GridFactors(gi)
+	if err != nil {
+		return nil, err
+	}
+	if gridSrg == nil || len(gridSrg.Elements) == 0 {
+		o[0] = 1
+		return o, nil
+	}
+	shape := p.Met.Temperature[0].Shape
+	if len(gridSrg.Shape) != 2 || gridSrg.Shape[0] != shape[1] || gridSrg.Shape[1] != shape[2] {
+		return nil, fmt.Errorf("aep.PlumeRise: grid shape (%v) doesn't match meteorology shape (%v)", gridSrg.Shape, shape[1:])
+	}
+	srgTotal := gridSrg.Sum()
+
+	var nHours int
+	for t := begin; t.Before(end); t = t.Add(time.Hour) {
+		i := int(t.Sub(p.Met.Begin) / p.Met.Step)
+		if t.Before(p.Met.Begin) || i >= len(p.Met.Temperature) {
+			return nil, fmt.Errorf("aep.PlumeRise: no meteorology data for %v", t)
+		}
+		for cell, f := range gridSrg.Elements {
+			row, col := cell/shape[2], cell%shape[2]
+			k, err := p.plumeLayer(i, row, col, height.Value(), diam.Value(), temp.Value(), vel.Value())
+			if err != nil {
+				return nil, fmt.Errorf("aep.PlumeRise: record %s: %v", rec.Key(), err)
+			}
+			o[k] += f / srgTotal
+		}
+		nHours++
+	}
+	if nHours == 0 {
+		o[0] = 1
+		return o, nil
+	}
+	for k := range o {
+		o[k] /= float64(nHours)
+	}
+	return o, nil
+}
+
+// plumeLayer returns the index of the layer that contains the top of the
+// plume from a stack with the given height [m], diameter [m], temperature [K],
+// and exit velocity [m/s] in the given grid cell during time step i.
+// The meteorology in the layer that contains the top of the stack is used.
+func (p *PlumeRise) plumeLayer(i, row, col int, height, diam, temp, vel float64) (int, error) {
+	nz := p.Layers()
+	heights := p.Met.LayerHeights[i]
+	layer := func(h float64) int {
+		k := sort.Search(nz, func(k int) bool { return heights.Get(k+1, row, col) > h })
+		if k == nz {
+			return nz - 1 // Plumes above the top layer go in the top layer.
+		}
+		return k
+	}
+	k := layer(height)
+	airTemp := p.Met.Temperature[i].Get(k, row, col)
+	windSpeed := math.Max(p.Met.WindSpeed[i].Get(k, row, col), minPlumeWindSpeed)
+	stable := p.Met.SClass[i].Get(k, row, col) > 0.5
+	s := gravity * p.Met.S1[i].Get(k, row, col)
+	dh := briggsPlumeRise(diam, temp, vel, airTemp, windSpeed, s, stable)
+	if math.IsNaN(dh) {
+		return 0, fmt.Errorf("plume rise is NaN: stack diameter: %g, temperature: %g, "+
+			"velocity: %g; air temperature: %g, wind speed: %g, stability: %g",
+			diam, temp, vel, airTemp, windSpeed, s)
+	}
+	return layer(height + dh), nil
+}
+
+// briggsPlumeRise returns the final rise [m] of a plume from a stack with
+// the given diameter [m], gas temperature [K], and exit velocity [m/s]
+// into air with the given temperature [K], wind speed [m/s], and
+// stability parameter s (g/θ × dθ/dz [1/s²]), using the
+// Briggs (1969, 1971, 1975) plume rise equations as implemented in
+// the EPA ISC3 model (EPA-454/B-95-003b, section 1.1.4).
+// The plume is buoyancy dominated unless the stack gas is
+// less than the crossover temperature difference warmer than the air.
+func briggsPlumeRise(diam, temp, vel, airTemp, windSpeed, s float64, stable bool) float64 {
+	dT := temp - airTemp
+	// Buoyancy flux [m⁴/s³]
+	fb := math.Max(gravity*vel*diam*diam*dT/(4*temp), 0)
+	// Momentum flux [m⁴/s²]
+	fm := vel * vel * diam * diam * airTemp / (4 * temp)
+	// Momentum-dominated rise under neutral or unstable conditions.
+	neutralMomentum := 3 * diam * vel / windSpeed
+	if stable && s > 0 {
+		dTc := 0.019582 * temp * vel * math.Sqrt(s)
+		if dT >= dTc {
+			return 2.6 * math.Cbrt(fb/(windSpeed*s))
+		}
+		return math.Min(1.5*math.Cbrt(fm/(windSpeed*math.Sqrt(s))), neutralMomentum)
+	}
+	var dTc float64
+	if fb < 55 {
+		dTc = 0.0297 * temp * math.Cbrt(vel) / math.Pow(diam, 2./3)
+	} else {
+		dTc = 0.00575 * temp * math.Pow(vel, 2./3) / math.Cbrt(diam)
+	}
+	if dT < dTc {
+		return neutralMomentum
+	}
+	if fb < 55 {
+		return 21.425 * math.Pow(fb, 0.75) / windSpeed
+	}
+	return 38.71 * math.Pow(fb, 0.6) / windSpeed
+}
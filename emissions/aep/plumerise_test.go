@@ -0,0 +1,175 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ctessum/sparse"
+)
+
+// testLayerMetFunc returns a function that returns 3-D [z, y, x] fields
+// with the given values in each layer for each time step, followed by io.EOF.
+func testLayerMetFunc(ny, nx int, steps ...[]float64) func() (*sparse.DenseArray, error) {
+	i := 0
+	return func() (*sparse.DenseArray, error) {
+		if i == len(steps) {
+			return nil, io.EOF
+		}
+		a := sparse.ZerosDense(len(steps[i]), ny, nx)
+		for k, v := range steps[i] {
+			for j := 0; j < ny; j++ {
+				for ii := 0; ii < nx; ii++ {
+					a.Set(v, k, j, ii)
+				}
+			}
+		}
+		i++
+		return a, nil
+	}
+}
+
+func TestPlumeRise(t *testing.T) {
+	begin := time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(3 * time.Hour)
+	cfg, recs := outputTestRecords(t, begin, end)
+	grid := cfg.Grids()[0]
+
+	heights := []float64{0, 100, 210, 240, 280, 2000}
+	uniform := []float64{290, 290, 290, 290, 290}
+	stable := []float64{290, 290.5, 291.5, 292, 292.5}
+	pressure := []float64{1.e5, 1.e5, 1.e5, 1.e5, 1.e5}
+	wind := func(v float64) []float64 { return []float64{v, v, v, v, v} }
+	met, err := ReadPlumeRiseMet(
+		testLayerMetFunc(grid.Ny, grid.Nx, heights, heights, heights),
+		testLayerMetFunc(grid.Ny, grid.Nx, uniform, uniform, stable),
+		testLayerMetFunc(grid.Ny, grid.Nx, pressure, pressure, pressure),
+		testLayerMetFunc(grid.Ny, grid.Nx+1, wind(3), wind(30), wind(3)), // staggered
+		testLayerMetFunc(grid.Ny+1, grid.Nx, wind(4), wind(40), wind(4)), // staggered
+		begin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(met.Temperature) != 3 {
+		t.Fatalf("wrong number of time steps: %d", len(met.Temperature))
+	}
+	if v := met.WindSpeed[1].Get(4, 1, 1); math.Abs(v-50) > 1.e-10 {
+		t.Errorf("wind speed: have %g, want 50", v)
+	}
+	if v := met.SClass[0].Get(1, 1, 1); v != 0 {
+		t.Errorf("unstable stability class: have %g, want 0", v)
+	}
+	if v := met.SClass[2].Get(1, 1, 1); v != 1 {
+		t.Errorf("stable stability class: have %g, want 1", v)
+	}
+
+	p := &PlumeRise{Met: met}
+	if p.Layers() != 5 {
+		t.Fatalf("layers: have %d, want 5", p.Layers())
+	}
+
+	// The plume from the elevated source rises into layer 2 in the first
+	// hour, into layer 1 in the second (windier) hour, and into layer 3
+	// in the third (stable) hour. The ground-level source stays at ground
+	// level.
+	tests := []struct {
+		name       string
+		rec        RecordGridded
+		begin, end time.Time
+		want       []float64
+	}{
+		{name: "unstable", rec: recs[0], begin: begin, end: begin.Add(time.Hour), want: []float64{0, 0, 1, 0, 0}},
+		{name: "windy", rec: recs[0], begin: begin.Add(time.Hour), end: begin.Add(2 * time.Hour), want: []float64{0, 1, 0, 0, 0}},
+		{name: "stable", rec: recs[0], begin: begin.Add(2 * time.Hour), end: end, want: []float64{0, 0, 0, 1, 0}},
+		{name: "all hours", rec: recs[0], begin: begin, end: end, want: []float64{0, 1. / 3, 1. / 3, 1. / 3, 0}},
+		{name: "ground", rec: recs[1], begin: begin, end: end, want: []float64{1, 0, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			have, err := p.LayerFractions(test.rec, test.begin, test.end, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(have) != len(test.want) {
+				t.Fatalf("have %v, want %v", have, test.want)
+			}
+			for k, w := range test.want {
+				if math.Abs(have[k]-w) > 1.e-10 {
+					t.Errorf("have %v, want %v", have, test.want)
+					break
+				}
+			}
+		})
+	}
+
+	t.Run("gridded", func(t *testing.T) {
+		emis, err := GriddedLayers(recs, p, begin, end, 0, grid.Nx, grid.Ny, p.Layers())
+		if err != nil {
+			t.Fatal(err)
+		}
+		no := emis["NO"]
+		if s := no.Shape; len(s) != 3 || s[0] != 5 || s[1] != grid.Ny || s[2] != grid.Nx {
+			t.Fatalf("wrong shape %v", s)
+		}
+		total := no.Sum()
+		for k, w := range []float64{0, 1. / 3, 1. / 3, 1. / 3, 0} {
+			var s float64
+			for j := 0; j < grid.Ny; j++ {
+				for i := 0; i < grid.Nx; i++ {
+					s += no.Get(k, j, i)
+				}
+			}
+			if math.Abs(s-w*total) > 1.e-10*total {
+				t.Errorf("layer %d: have %g, want %g", k, s, w*total)
+			}
+		}
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		if _, err := p.LayerFractions(recs[0], end, end.Add(time.Hour), 0); err == nil {
+			t.Error("there should be an error when there is no meteorology data")
+		}
+	})
+}
+
+func TestBriggsPlumeRise(t *testing.T) {
+	tests := []struct {
+		name                                   string
+		diam, temp, vel, airTemp, windSpeed, s float64
+		stable                                 bool
+		want                                   float64
+	}{
+		{name: "buoyant", diam: 1, temp: 400, vel: 20, airTemp: 290, windSpeed: 5, want: 30.152155},
+		{name: "large buoyant", diam: 5, temp: 450, vel: 20, airTemp: 290, windSpeed: 5, want: 296.794645},
+		{name: "momentum", diam: 1, temp: 300, vel: 20, airTemp: 290, windSpeed: 5, want: 12},
+		{name: "stable buoyant", diam: 1, temp: 400, vel: 20, airTemp: 290, windSpeed: 5, s: 1.e-3, stable: true, want: 36.190213},
+		{name: "stable momentum", diam: 1, temp: 300, vel: 20, airTemp: 290, windSpeed: 5, s: 1.e-2, stable: true, want: 8.673482},
+		{name: "cold", diam: 1, temp: 280, vel: 0, airTemp: 290, windSpeed: 5, want: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			have := briggsPlumeRise(test.diam, test.temp, test.vel, test.airTemp, test.windSpeed, test.s, test.stable)
+			if math.Abs(have-test.want) > 1.e-6 {
+				t.Errorf("have %g, want %g", have, test.want)
+			}
+		})
+	}
+}
@@ -142,6 +142,12 @@ func (r *PointSourceData) Key() string {
 	return r.PlantID + r.PointID + r.StackID + r.Segment
 }
 
+// GetPointSourceData returns the point source information associated
+// with this record.
+func (r *PointSourceData) GetPointSourceData() *PointSourceData {
+	return r
+}
+
 func (r *PointSourceData) setStackParams(height, diam, temp, flow, vel string) error {
 	sh, err := stringToFloat(height)
 	if err != nil {
@@ -161,6 +161,15 @@ func (t Table) Tabbed(w io.Writer) (n int, err error) {
 	return
 }
 
+// CSV writes the table in CSV format.
+func (t Table) CSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.WriteAll(t); err != nil {
+		return fmt.Errorf("aep: writing CSV table: %v", err)
+	}
+	return nil
+}
+
 // SCCDescription reads a SMOKE sccdesc file, which gives descriptions
 // for each SCC code. The returned data is in the form map[SCC]description.
 func SCCDescription(r io.Reader) (map[string]string, error) {
@@ -106,6 +106,14 @@ func (r *SourceData) parseSCC(SCC string) {
 	}
 }
 
+// SetFIPS sets the FIPS code of the receiver, padding it with zeros
+// in the same way as FIPS codes read from emissions inventory files.
+func (r *SourceData) SetFIPS(FIPS string) { r.parseFIPS(FIPS) }
+
+// SetSCC sets the SCC code of the receiver, padding it with zeros
+// in the same way as SCC codes read from emissions inventory files.
+func (r *SourceData) SetSCC(SCC string) { r.parseSCC(SCC) }
+
 // Key returns a unique key for this record.
 func (r *SourceData) Key() string {
 	return fmt.Sprintf("%s%s%d", r.FIPS, r.SCC, r.Country)
@@ -141,7 +149,11 @@ func (sdl *sourceDataLocator) Locate(sd *SourceDataLocation) error {
 		return err
 	}
 	if _, ok := sdl.inputShapes[srgSpec]; !ok {
-		sdl.inputShapes[srgSpec], err = srgSpec.(*SrgSpecSMOKE).InputShapes()
+		smoke, ok := srgSpec.(*SrgSpecSMOKE)
+		if !ok {
+			return fmt.Errorf("aep: spatial surrogate %s%s does not have input shapes; only SMOKE surrogates do", srgSpec.region(), srgSpec.code())
+		}
+		sdl.inputShapes[srgSpec], err = smoke.InputShapes()
 		if err != nil {
 			return err
 		}
@@ -279,45 +279,54 @@ func (r *recordGridded) Parent() Record { return r.Record }
 // same copy is used over and over again. The second return value indicates
 // whether the shape corresponding to fips is completely covered by the grid.
 func (sp *SpatialProcessor) Surrogate(srgSpec SrgSpec, grid *GridDef, loc *Location) (*sparse.SparseArray, bool, error) {
+	srg, coveredByGrid, _, _, err := sp.surrogate(srgSpec, grid, loc)
+	return srg, coveredByGrid, err
+}
+
+// surrogate gets the specified spatial surrogate, trying the backup surrogates
+// if necessary. In addition to the outputs of Surrogate, it returns the
+// gridded surrogate data that was used (which is nil if the emissions were
+// allocated evenly across the grid cells that overlap loc), and
+// the surrogates that were tried but had no weight in loc.
+func (sp *SpatialProcessor) surrogate(srgSpec SrgSpec, grid *GridDef, loc *Location) (*sparse.SparseArray, bool, *GriddedSrgData, []SrgSpec, error) {
 	var err error
 	sp.lazyLoad.Do(func() {
 		err = sp.load()
 	})
 	if err != nil {
-		return nil, false, err
+		return nil, false, nil, nil, err
 	}
 
-	s := &srgGrid{srg: srgSpec, gridData: grid, loc: loc, sp: sp, msgChan: sp.MsgChan}
-	req := sp.cache.NewRequestRecursive(context.Background(), s)
-	result := new(GriddedSrgData)
-	if err := req.Result((*griddedSrgDataHolder)(result)); err != nil {
-		return nil, false, err
-	}
-	srg, coveredByGrid := result.ToGrid()
-	if srg != nil {
-		return srg, coveredByGrid, nil
-	}
-	// if srg was nil, try backup surrogates.
-	for _, newName := range srgSpec.backupSurrogateNames() {
-		newSrgSpec, err := sp.SrgSpecs.GetByName(srgSpec.region(), newName)
-		if err != nil {
-			return nil, false, err
-		}
-		s := &srgGrid{srg: newSrgSpec, gridData: grid, loc: loc, sp: sp, msgChan: sp.MsgChan}
-		result := new(GriddedSrgData)
+	var tried []SrgSpec
+	backupNames := srgSpec.backupSurrogateNames()
+	spec := srgSpec
+	for {
+		s := &srgGrid{srg: spec, gridData: grid, loc: loc, sp: sp, msgChan: sp.MsgChan}
 		req := sp.cache.NewRequestRecursive(context.Background(), s)
+		result := new(GriddedSrgData)
 		if err := req.Result((*griddedSrgDataHolder)(result)); err != nil {
-			return nil, false, err
+			return nil, false, nil, nil, err
 		}
 		srg, coveredByGrid := result.ToGrid()
 		if srg != nil {
-			return srg, coveredByGrid, nil
+			return srg, coveredByGrid, result, tried, nil
+		}
+		tried = append(tried, spec)
+		if len(backupNames) == 0 {
+			break
 		}
+		// if srg was nil, try the next backup surrogate. Backup surrogates
+		// are only looked up when they are needed.
+		spec, err = sp.SrgSpecs.GetByName(srgSpec.region(), backupNames[0])
+		if err != nil {
+			return nil, false, nil, nil, err
+		}
+		backupNames = backupNames[1:]
 	}
 	// If there are no backup surrogates, allocate emissions evenly across
 	// all relevant grid cells.
-	srg, coveredByGrid, _, err = sp.recordToGrid(loc, grid)
-	return srg, coveredByGrid, err
+	srg, coveredByGrid, _, err := sp.recordToGrid(loc, grid)
+	return srg, coveredByGrid, nil, tried, err
 }
 
 type srgRequest struct {
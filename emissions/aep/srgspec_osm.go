@@ -31,6 +31,7 @@ import (
 	"github.com/ctessum/geom/encoding/wkb"
 	"github.com/ctessum/geom/index/rtree"
 	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/requestcache/v2"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -61,6 +62,11 @@ type SrgSpecOSM struct {
 	connectPostGISOnce sync.Once
 	postGISURL string
 	conn *pgxpool.Pool
+
+	// osmFile and cache are used instead of the PostGIS database
+	// when the surrogate data is read from a local OpenStreetMap file.
+	osmFile string
+	cache   *requestcache.Cache
 }
 
 // ReadSrgSpec reads a OpenStreetMap surrogate specification formated as a
@@ -124,6 +130,10 @@ func (srg *SrgSpecOSM) mergeMultipliers() []float64    { return srg.MergeMultipl
 // getSrgData returns the spatial surrogate information for this
 // surrogate definition and location, where tol is tolerance for geometry simplification.
 func (srg *SrgSpecOSM) getSrgData(gridData *GridDef, inputLoc *Location, tol float64) (SearchIntersecter, error) {
+	if srg.osmFile != "" {
+		return srg.getSrgDataPBF(gridData, tol)
+	}
+
 	// Calculate the area of interest for our surrogate data.
 	srgSR, err := proj.Parse("+proj=longlat")
 	if err != nil {
@@ -0,0 +1,212 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/osm"
+	"github.com/ctessum/geom/index/rtree"
+	"github.com/ctessum/geom/proj"
+)
+
+func init() {
+	gob.Register(geom.Point{})
+	gob.Register(geom.MultiPoint{})
+	gob.Register(geom.LineString{})
+	gob.Register(geom.MultiLineString{})
+	gob.Register(geom.MultiPolygon{})
+}
+
+// ReadSrgSpecOSMPBF reads a OpenStreetMap surrogate specification formated as a
+// JSON array of SrgSpecOSM objects, where the surrogate data is read directly
+// from the local OpenStreetMap file osmFile (in .osm.pbf or .osm format)
+// rather than from a PostGIS database. The "osm_table" field of each surrogate
+// is used to choose the geometry type to keep: tables ending in "_point"
+// keep points, tables ending in "_line" or "_roads" keep lines, and tables
+// ending in "_polygon" keep polygons. If "osm_table" is empty, the most
+// common geometry type among the matching features is kept.
+//
+// The surrogate geometry for each surrogate is extracted from osmFile the
+// first time it is needed.
+// diskCachePath specifies a path to a directory where an on-disk cache of
+// the extracted geometry should be created (if "", no cache will be created),
+// and memCacheSize specifies the number of surrogate data entries to hold
+// in an in-memory cache.
+func ReadSrgSpecOSMPBF(r io.Reader, osmFile, diskCachePath string, memCacheSize int) (*SrgSpecs, error) {
+	d := json.NewDecoder(r)
+	var o []*SrgSpecOSM
+	if err := d.Decode(&o); err != nil {
+		return nil, fmt.Errorf("aep.ReadSrgSpecOSMPBF: %v", err)
+	}
+	cache, err := newCacheV2(diskCachePath, memCacheSize, marshalSrgHolders, unmarshalSrgHolders)
+	if err != nil {
+		return nil, fmt.Errorf("aep.ReadSrgSpecOSMPBF: %v", err)
+	}
+
+	srgs := NewSrgSpecs()
+	for _, s := range o {
+		if _, err := s.geomType(nil); s.OSMTable != "" && err != nil {
+			return nil, fmt.Errorf("aep.ReadSrgSpecOSMPBF: %v", err)
+		}
+		s.osmFile = osmFile
+		s.cache = cache
+		srgs.Add(s)
+	}
+	return srgs, nil
+}
+
+// geomType returns the type of geometry that should be kept for this
+// surrogate, based on the name of its OSM table or, if the table name is
+// empty, the most common type in gt.
+func (srg *SrgSpecOSM) geomType(gt []*osm.GeomTags) (osm.GeomType, error) {
+	switch {
+	case srg.OSMTable == "":
+		if len(gt) == 0 {
+			return osm.Point, nil
+		}
+		return osm.DominantType(gt)
+	case strings.HasSuffix(srg.OSMTable, "_point"):
+		return osm.Point, nil
+	case strings.HasSuffix(srg.OSMTable, "_line"), strings.HasSuffix(srg.OSMTable, "_roads"):
+		return osm.Line, nil
+	case strings.HasSuffix(srg.OSMTable, "_polygon"):
+		return osm.Poly, nil
+	default:
+		return -1, fmt.Errorf("surrogate %s: unable to determine geometry type of OSM table %s", srg.Name, srg.OSMTable)
+	}
+}
+
+// getSrgDataPBF returns the spatial surrogate information for this
+// surrogate definition from the local OpenStreetMap file.
+func (srg *SrgSpecOSM) getSrgDataPBF(gridData *GridDef, tol float64) (SearchIntersecter, error) {
+	in := &readSrgDataOSMPBFInput{gridData: gridData, tol: tol, srg: srg}
+	request := srg.cache.NewRequest(context.TODO(), in)
+	srgs, err := request.Result()
+	if err != nil {
+		return nil, err
+	}
+	return srgs.(readSrgDataOutput).index, nil
+}
+
+type readSrgDataOSMPBFInput struct {
+	gridData *GridDef
+	tol      float64
+	srg      *SrgSpecOSM
+}
+
+func (s *readSrgDataOSMPBFInput) Key() string {
+	return fmt.Sprintf("osm_srgdata_%s_%s%s_%s_%g", strings.TrimSuffix(filepath.Base(s.srg.osmFile), ".osm.pbf"),
+		s.srg.region(), s.srg.code(), s.gridData.SR.Name, s.tol)
+}
+
+// Run returns all of the spatial surrogate information for this
+// surrogate definition.
+func (input *readSrgDataOSMPBFInput) Run(ctx context.Context) (interface{}, error) {
+	srg := input.srg
+	log.Printf("processing surrogate `%s` spatial data from %s", srg.Name, srg.osmFile)
+
+	data, err := osm.ExtractFile(ctx, srg.osmFile, osm.KeepTags(srg.Tags), false)
+	if err != nil {
+		return nil, fmt.Errorf("aep: reading surrogate data: %v", err)
+	}
+	gt, err := data.Geom()
+	if err != nil {
+		return nil, fmt.Errorf("aep: reading surrogate data: %v", err)
+	}
+	geomType, err := srg.geomType(gt)
+	if err != nil {
+		return nil, err
+	}
+
+	srgSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		panic(err)
+	}
+	srgCT, err := srgSR.NewTransform(input.gridData.SR)
+	if err != nil {
+		return nil, err
+	}
+
+	srgs := readSrgDataOutput{
+		index: rtree.NewTree(25, 50),
+	}
+	for _, f := range gt {
+		g := f.Geom
+		if p, ok := g.(geom.Polygon); ok && geomType == osm.Line {
+			// Closed ways, such as roundabouts, are read as polygons.
+			l := make(geom.MultiLineString, len(p))
+			for i, r := range p {
+				l[i] = geom.LineString(r)
+			}
+			g = l
+		}
+		g, err = osmGeometry(g, geomType)
+		if err != nil {
+			return nil, fmt.Errorf("aep: surrogate %s: %v", srg.Name, err)
+		}
+		if osmGeomEmpty(g) {
+			continue
+		}
+		g, err = g.Transform(srgCT)
+		if err != nil {
+			return nil, fmt.Errorf("aep: transforming surrogate data: %v", err)
+		}
+		if input.tol > 0 {
+			switch gs := g.(type) {
+			case geom.Simplifier:
+				g = gs.Simplify(input.tol)
+			}
+		}
+		srgH := &srgHolder{
+			Geom:   g,
+			Weight: 1,
+		}
+		srgs.srgs = append(srgs.srgs, srgH)
+		srgs.index.Insert(srgH)
+	}
+	return srgs, nil
+}
+
+// osmGeomEmpty returns whether g, which is the output of osmGeometry,
+// contains no features.
+func osmGeomEmpty(g geom.Geom) bool {
+	switch t := g.(type) {
+	case nil:
+		return true
+	case geom.MultiPoint:
+		return len(t) == 0
+	case geom.MultiLineString:
+		return len(t) == 0
+	case geom.MultiPolygon:
+		return len(t) == 0
+	case geom.LineString:
+		return len(t) == 0
+	case geom.Polygon:
+		return len(t) == 0 || len(t[0]) == 0
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/proj"
+)
+
+func TestCreateSurrogates_osmPBF(t *testing.T) {
+	inputSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gridRef, err := ReadGridRef(strings.NewReader(`000007;0010101011;001
+000007;0010101012;002
+000007;0010101013;003
+  `), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grid := NewGridRegular("test grid", 4, 4, 0.1, 0.1, -158, 21.25, inputSR)
+
+	d, err := shp.NewDecoder("testdata/honolulu_hawaii.shp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, _, _ := d.DecodeRowFields()
+	if err := d.Error(); err != nil {
+		t.Fatal(err)
+	}
+	sr, err := d.SR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+
+	inputLoc := &Location{Geom: g, SR: sr, Name: "input1"}
+
+	cacheDir, err := ioutil.TempDir("", "srgspec_osm_pbf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	want := []map[int]float64{
+		{0: 0.04886323779213095, 1: 0.4234115998508295, 2: 0.15919387877688768, 3: 0.08945252047016032, 4: 0.18993456550450022, 5: 0.008311450956844888, 6: 0.07115494071078621},
+		{1: 0.6011955358239497, 3: 0.035471039348746576, 4: 0.03985223587634336, 6: 0.32348118895096034},
+		{0: 0.017937219730941704, 1: 0.8834080717488813, 2: 0.04484304932735426, 3: 0.013452914798206277, 4: 0.020179372197309416, 6: 0.020179372197309416},
+	}
+
+	// The second time through, the OSM file doesn't exist, so the
+	// surrogate data must be read from the disk cache.
+	for _, osmFile := range []string{"testdata/honolulu_hawaii.osm.pbf", "testdata/cached/honolulu_hawaii.osm.pbf"} {
+		t.Run(osmFile, func(t *testing.T) {
+			f, err := os.Open("testdata/srgspec_osm.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			srgSpecs, err := ReadSrgSpecOSMPBF(f, osmFile, cacheDir, 1)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sp := NewSpatialProcessor(srgSpecs, []*GridDef{grid}, gridRef, inputSR, true)
+			sp.load()
+
+			for i, code := range []string{"001", "002", "003"} {
+				srgSpec, err := srgSpecs.GetByCode(Global, code)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sg := &srgGrid{srg: srgSpec, gridData: grid, loc: inputLoc, sp: sp}
+				srgs := new(GriddedSrgData)
+				if err := sg.Run(context.Background(), nil, (*griddedSrgDataHolder)(srgs)); err != nil {
+					t.Fatalf("creating surrogate %s: %v", code, err)
+				}
+				griddedSrg, covered := srgs.ToGrid()
+				if covered {
+					t.Errorf("srg %s should not cover", code)
+				}
+				sparseCompare(want[i], griddedSrg.Elements, t, 1.0e-8)
+			}
+		})
+	}
+}
+
+func TestReadSrgSpecOSMPBF_badTable(t *testing.T) {
+	_, err := ReadSrgSpecOSMPBF(strings.NewReader(`[{"name": "x", "code": "001", "osm_table": "xxx", "tags": {"highway": []}}]`),
+		"testdata/honolulu_hawaii.osm.pbf", "", 1)
+	if err == nil {
+		t.Error("invalid OSM table should cause an error")
+	}
+}
@@ -0,0 +1,261 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/index/rtree"
+	"github.com/ctessum/geom/proj"
+)
+
+// SrgSpecRaster holds spatial surrogate specification information for
+// surrogates that are based on raster data, such as population density,
+// nighttime lights, or land cover. The surrogate is calculated by
+// area-weighted aggregation of the raster pixels.
+type SrgSpecRaster struct {
+	Region Country `json:"region"`
+	Name   string  `json:"name"`
+	Code   string  `json:"code"`
+
+	// File is the path to the raster data file, which can either
+	// be a GeoTIFF file (with extension ".tif" or ".tiff") or a
+	// COARDS-compliant NetCDF file (with any other extension).
+	// It can contain environment variables.
+	File string `json:"file"`
+
+	// Variable is the name of the variable in a COARDS file that holds
+	// the surrogate data. It is not used for GeoTIFF files, where
+	// the first band is used.
+	Variable string `json:"variable"`
+
+	// SR is the spatial reference of the raster data in Proj4 format.
+	// If it is empty, the data is assumed to be in longitude-latitude
+	// coordinates. COARDS data is always in longitude-latitude coordinates.
+	SR string `json:"sr"`
+
+	// Filter specifies which pixels should be used, in the same format
+	// as a SMOKE surrogate FILTER_FUNCTION (e.g., "VALUE=21,22,23" or
+	// "VALUE!=0"). The column name is ignored and the values are compared
+	// numerically to the pixel values. If it is empty, all pixels are used.
+	Filter string `json:"filter"`
+
+	// Categorical specifies whether the pixel values are categories, such
+	// as land cover classes, that are only used for filtering. If it is
+	// true, all pixels that pass the filter are weighted by their area.
+	// If it is false, the pixel values are amounts per pixel (for example,
+	// population counts) and each pixel is weighted by its value.
+	Categorical bool `json:"categorical"`
+
+	// BackupSurrogateNames specifies names of surrogates to use if this
+	// one doesn't have data for the desired location.
+	BackupSurrogateNames []string `json:"backup_surrogate_names"`
+
+	// MergeNames specify names of other surrogates that should be combined
+	// to create this surrogate.
+	MergeNames []string `json:"merge_names"`
+	// MergeMultipliers specifies multipliers associated with the surrogates
+	// in MergeNames.
+	MergeMultipliers []float64 `json:"merge_multipliers"`
+
+	loadOnce sync.Once
+	data     *rasterData
+	loadErr  error
+}
+
+// ReadSrgSpecRaster reads a raster surrogate specification formated as a
+// JSON array of SrgSpecRaster objects. Relative file paths in the
+// specification are interpreted relative to dir.
+func ReadSrgSpecRaster(r io.Reader, dir string) (*SrgSpecs, error) {
+	d := json.NewDecoder(r)
+	var o []*SrgSpecRaster
+	if err := d.Decode(&o); err != nil {
+		return nil, fmt.Errorf("aep.ReadSrgSpecRaster: %v", err)
+	}
+
+	srgs := NewSrgSpecs()
+	for _, s := range o {
+		s.File = os.ExpandEnv(s.File)
+		if !filepath.IsAbs(s.File) {
+			s.File = filepath.Join(dir, s.File)
+		}
+		if s.Filter != "" && ParseSurrogateFilter(s.Filter) == nil {
+			return nil, fmt.Errorf("aep.ReadSrgSpecRaster: surrogate %s: invalid filter %q", s.Name, s.Filter)
+		}
+		srgs.Add(s)
+	}
+	return srgs, nil
+}
+
+func (srg *SrgSpecRaster) backupSurrogateNames() []string { return srg.BackupSurrogateNames }
+func (srg *SrgSpecRaster) region() Country                { return srg.Region }
+func (srg *SrgSpecRaster) code() string                   { return srg.Code }
+func (srg *SrgSpecRaster) name() string                   { return srg.Name }
+func (srg *SrgSpecRaster) mergeNames() []string           { return srg.MergeNames }
+func (srg *SrgSpecRaster) mergeMultipliers() []float64    { return srg.MergeMultipliers }
+
+// load reads the raster data.
+func (srg *SrgSpecRaster) load() (*rasterData, error) {
+	switch strings.ToLower(filepath.Ext(srg.File)) {
+	case ".tif", ".tiff":
+		f, err := os.Open(srg.File)
+		if err != nil {
+			return nil, fmt.Errorf("aep: opening raster surrogate file: %v", err)
+		}
+		defer f.Close()
+		return readGeoTIFF(f)
+	default:
+		r, err := ReadCOARDSFile(srg.File, time.Time{}, time.Time{}, Kg, SourceData{})
+		if err != nil {
+			return nil, err
+		}
+		v, ok := r.variables[srg.Variable]
+		if !ok {
+			return nil, fmt.Errorf("aep: raster surrogate file %s does not contain floating point variable %s", srg.File, srg.Variable)
+		}
+		o := &rasterData{
+			xEdges: make([]float64, len(r.lons)+1),
+			yEdges: make([]float64, len(r.lats)+1),
+			values: v,
+		}
+		for i, x := range r.lons {
+			o.xEdges[i] = x - gridPointsToGridSpacing(r.lons, i)/2
+		}
+		o.xEdges[len(r.lons)] = r.lons[len(r.lons)-1] + gridPointsToGridSpacing(r.lons, len(r.lons)-1)/2
+		for j, y := range r.lats {
+			o.yEdges[j] = y - gridPointsToGridSpacing(r.lats, j)/2
+		}
+		o.yEdges[len(r.lats)] = r.lats[len(r.lats)-1] + gridPointsToGridSpacing(r.lats, len(r.lats)-1)/2
+		return o, nil
+	}
+}
+
+// filterFunc returns a function that returns whether a pixel with
+// the given value should be used.
+func (srg *SrgSpecRaster) filterFunc() (func(float64) bool, error) {
+	f := ParseSurrogateFilter(srg.Filter)
+	if f == nil {
+		return func(float64) bool { return true }, nil
+	}
+	vals := make([]float64, len(f.Values))
+	for i, s := range f.Values {
+		var err error
+		if vals[i], err = strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("aep: surrogate %s: invalid filter value: %v", srg.Name, err)
+		}
+	}
+	notEqual := f.EqualNotEqual == "NotEqual"
+	return func(v float64) bool {
+		for _, fv := range vals {
+			if v == fv {
+				return !notEqual
+			}
+		}
+		return notEqual
+	}, nil
+}
+
+// edgeRange returns the range of pixel indices [begin, end) whose
+// edges overlap the range [min, max].
+func edgeRange(edges []float64, min, max float64) (begin, end int) {
+	begin, end = len(edges)-1, 0
+	for i := 0; i < len(edges)-1; i++ {
+		lo, hi := math.Min(edges[i], edges[i+1]), math.Max(edges[i], edges[i+1])
+		if hi >= min && lo <= max {
+			if i < begin {
+				begin = i
+			}
+			end = i + 1
+		}
+	}
+	return begin, end
+}
+
+func (srg *SrgSpecRaster) getSrgData(gridData *GridDef, inputLoc *Location, tol float64) (SearchIntersecter, error) {
+	srg.loadOnce.Do(func() {
+		srg.data, srg.loadErr = srg.load()
+	})
+	if srg.loadErr != nil {
+		return nil, srg.loadErr
+	}
+	srText := srg.SR
+	if srText == "" {
+		srText = "+proj=longlat"
+	}
+	srgSR, err := proj.Parse(srText)
+	if err != nil {
+		return nil, fmt.Errorf("aep: surrogate %s: %v", srg.Name, err)
+	}
+	inputShapeT, err := inputLoc.Reproject(srgSR) // Convert input shape to surrogate SR.
+	if err != nil {
+		return nil, err
+	}
+	b := inputShapeT.Bounds()
+	srgCT, err := srgSR.NewTransform(gridData.SR)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := srg.filterFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	d := srg.data
+	nx := len(d.xEdges) - 1
+	i0, i1 := edgeRange(d.xEdges, b.Min.X, b.Max.X)
+	j0, j1 := edgeRange(d.yEdges, b.Min.Y, b.Max.Y)
+	srgs := rtree.NewTree(25, 50)
+	for j := j0; j < j1; j++ {
+		y0, y1 := math.Min(d.yEdges[j], d.yEdges[j+1]), math.Max(d.yEdges[j], d.yEdges[j+1])
+		for i := i0; i < i1; i++ {
+			v := d.values[j*nx+i]
+			if math.IsNaN(v) || !keep(v) {
+				continue
+			}
+			if !srg.Categorical && v <= 0 {
+				continue
+			}
+			x0, x1 := math.Min(d.xEdges[i], d.xEdges[i+1]), math.Max(d.xEdges[i], d.xEdges[i+1])
+			pixel := geom.Polygon{{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}, {X: x0, Y: y0}}}
+			g, err := pixel.Transform(srgCT)
+			if err != nil {
+				return nil, fmt.Errorf("aep: transforming surrogate data: %v", err)
+			}
+			weight := 1.
+			if !srg.Categorical {
+				area := g.(geom.Polygonal).Area()
+				if area == 0 {
+					continue
+				}
+				weight = v / area
+			}
+			srgs.Insert(&srgHolder{Geom: g, Weight: weight})
+		}
+	}
+	return srgs, nil
+}
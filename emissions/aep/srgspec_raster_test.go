@@ -0,0 +1,243 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom"
+)
+
+// writeTestGeoTIFF writes a single-strip, float32 GeoTIFF file with
+// the given values, upper-left corner, and pixel size.
+func writeTestGeoTIFF(t *testing.T, file string, nx, ny int, values []float32, x0, y0, dx, dy float64, compress bool, noData string) {
+	data := new(bytes.Buffer)
+	binary.Write(data, binary.LittleEndian, values)
+	compression := uint32(1)
+	if compress {
+		compression = 8
+		b := new(bytes.Buffer)
+		w := zlib.NewWriter(b)
+		w.Write(data.Bytes())
+		w.Close()
+		data = b
+	}
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    uint32 // or offset, for extra
+		extra    []byte
+	}
+	f64 := func(v ...float64) []byte {
+		b := new(bytes.Buffer)
+		binary.Write(b, binary.LittleEndian, v)
+		return b.Bytes()
+	}
+	entries := []entry{
+		{tag: tiffImageWidth, typ: 4, count: 1, value: uint32(nx)},
+		{tag: tiffImageLength, typ: 4, count: 1, value: uint32(ny)},
+		{tag: tiffBitsPerSample, typ: 3, count: 1, value: 32},
+		{tag: tiffCompression, typ: 3, count: 1, value: compression},
+		{tag: tiffStripOffsets, typ: 4, count: 1},
+		{tag: tiffSamplesPerPixel, typ: 3, count: 1, value: 1},
+		{tag: tiffRowsPerStrip, typ: 4, count: 1, value: uint32(ny)},
+		{tag: tiffStripByteCounts, typ: 4, count: 1, value: uint32(data.Len())},
+		{tag: tiffSampleFormat, typ: 3, count: 1, value: 3},
+		{tag: tiffModelPixelScale, typ: 12, count: 3, extra: f64(dx, dy, 0)},
+		{tag: tiffModelTiepoint, typ: 12, count: 6, extra: f64(0, 0, 0, x0, y0, 0)},
+	}
+	if noData != "" {
+		entries = append(entries, entry{tag: tiffGDALNoData, typ: 2, count: uint32(len(noData) + 1), extra: append([]byte(noData), 0)})
+	}
+	offset := uint32(8 + 2 + 12*len(entries) + 4)
+	for i, e := range entries {
+		if e.extra != nil {
+			entries[i].value = offset
+			offset += uint32(len(e.extra))
+		}
+	}
+	entries[4].value = offset
+
+	b := new(bytes.Buffer)
+	b.WriteString("II")
+	binary.Write(b, binary.LittleEndian, uint16(42))
+	binary.Write(b, binary.LittleEndian, uint32(8))
+	binary.Write(b, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(b, binary.LittleEndian, e.tag)
+		binary.Write(b, binary.LittleEndian, e.typ)
+		binary.Write(b, binary.LittleEndian, e.count)
+		if e.typ == 3 && e.extra == nil {
+			binary.Write(b, binary.LittleEndian, uint16(e.value))
+			binary.Write(b, binary.LittleEndian, uint16(0))
+		} else {
+			binary.Write(b, binary.LittleEndian, e.value)
+		}
+	}
+	binary.Write(b, binary.LittleEndian, uint32(0))
+	for _, e := range entries {
+		b.Write(e.extra)
+	}
+	b.Write(data.Bytes())
+	if err := ioutil.WriteFile(file, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadGeoTIFF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geotiff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, compress := range []bool{false, true} {
+		file := filepath.Join(dir, "test.tif")
+		writeTestGeoTIFF(t, file, 3, 2, []float32{1, 2, 3, 4, -9999, 6}, -100, 40, 0.5, 0.25, compress, "-9999")
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := readGeoTIFF(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantX := []float64{-100, -99.5, -99, -98.5}
+		wantY := []float64{40, 39.75, 39.5}
+		for i, w := range wantX {
+			if d.xEdges[i] != w {
+				t.Errorf("compress=%v: x edge %d: have %g, want %g", compress, i, d.xEdges[i], w)
+			}
+		}
+		for i, w := range wantY {
+			if d.yEdges[i] != w {
+				t.Errorf("compress=%v: y edge %d: have %g, want %g", compress, i, d.yEdges[i], w)
+			}
+		}
+		for i, w := range []float64{1, 2, 3, 4, math.NaN(), 6} {
+			if v := d.values[i]; v != w && !(math.IsNaN(v) && math.IsNaN(w)) {
+				t.Errorf("compress=%v: value %d: have %g, want %g", compress, i, v, w)
+			}
+		}
+	}
+}
+
+func TestSrgSpecRaster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srgspec_raster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A 4×4 raster with 0.5° pixels covering a 2×2 grid with 1° cells.
+	vals := make([]float32, 16)
+	for i := range vals {
+		vals[i] = float32(i + 1)
+	}
+	writeTestGeoTIFF(t, filepath.Join(dir, "srg.tif"), 4, 4, vals, 0, 2, 0.5, 0.5, true, "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := `[
+	{"region": 0, "name": "Population", "code": "100", "file": "srg.tif"},
+	{"region": 0, "name": "Urban land", "code": "200", "file": "srg.tif", "filter": "VALUE=1,16", "categorical": true},
+	{"region": 0, "name": "COARDS", "code": "300", "file": "` + filepath.Join(wd, "testdata", "emis_coards_hawaii.nc") + `", "variable": "NOx"}
+]`
+	srgSpecs, err := ReadSrgSpecRaster(strings.NewReader(spec), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid := NewGridRegular("raster test", 2, 2, 1, 1, 0, 0, longlat)
+	sp := NewSpatialProcessor(srgSpecs, []*GridDef{grid}, &GridRef{}, longlat, true)
+
+	loc := &Location{
+		Geom: geom.Polygon{{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 2, Y: 2}, {X: 0, Y: 2}, {X: 0, Y: 0}}},
+		SR:   longlat,
+		Name: "all",
+	}
+
+	tests := []struct {
+		code string
+		want [2][2]float64 // [row][col]
+	}{
+		{
+			// Row 0 of the grid is at the bottom; row 0 of the raster is at the top.
+			code: "100",
+			want: [2][2]float64{{(9 + 10 + 13 + 14) / 136., (11 + 12 + 15 + 16) / 136.},
+				{(1 + 2 + 5 + 6) / 136., (3 + 4 + 7 + 8) / 136.}},
+		},
+		{
+			code: "200",
+			want: [2][2]float64{{0, 0.5}, {0.5, 0}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.code, func(t *testing.T) {
+			srgSpec, err := srgSpecs.GetByCode(USA, test.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srg, _, err := sp.Surrogate(srgSpec, grid, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for row := 0; row < 2; row++ {
+				for col := 0; col < 2; col++ {
+					if v := srg.Get(row, col); math.Abs(v-test.want[row][col]) > 1.e-10 {
+						t.Errorf("row %d col %d: have %g, want %g", row, col, v, test.want[row][col])
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("coards", func(t *testing.T) {
+		hawaii := NewGridRegular("hawaii", 4, 4, 0.1, 0.1, -158, 21.25, longlat)
+		sp := NewSpatialProcessor(srgSpecs, []*GridDef{hawaii}, &GridRef{}, longlat, true)
+		srgSpec, err := srgSpecs.GetByCode(USA, "300")
+		if err != nil {
+			t.Fatal(err)
+		}
+		loc := &Location{
+			Geom: geom.Polygon{{{X: -158, Y: 21.25}, {X: -157.6, Y: 21.25}, {X: -157.6, Y: 21.65}, {X: -158, Y: 21.65}, {X: -158, Y: 21.25}}},
+			SR:   longlat,
+			Name: "oahu",
+		}
+		srg, _, err := sp.Surrogate(srgSpec, hawaii, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if srg == nil {
+			t.Fatal("surrogate should not be empty")
+		}
+		if s := srg.Sum(); math.Abs(s-1) > 1.e-10 {
+			t.Errorf("surrogate should sum to 1 but sums to %g", s)
+		}
+	})
+}
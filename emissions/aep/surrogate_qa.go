@@ -0,0 +1,158 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SurrogateQA holds quality assurance information about the gridding
+// surrogate for one surrogate specification and input location.
+type SurrogateQA struct {
+	// Region, Code, and Name identify the surrogate specification.
+	Region     Country
+	Code, Name string
+
+	// Location is the ID of the input location (for example,
+	// a county FIPS code).
+	Location string
+
+	// Used is the name of the surrogate that was used to allocate
+	// emissions for this location, which is either Name or one of
+	// its backup surrogates. It is empty if none of the surrogates had
+	// any weight in the location, in which case emissions are allocated
+	// evenly across the grid cells that overlap the location.
+	Used string
+
+	// ZeroWeight holds the names of the surrogates that were tried
+	// but had no weight in the location, in the order they were tried.
+	ZeroWeight []string
+
+	// CoveredByGrid indicates whether the location is completely
+	// covered by the grid.
+	CoveredByGrid bool
+
+	// Coverage is the fraction of the location's surrogate weight
+	// (or area, if Used is empty) that is within the grid.
+	Coverage float64
+}
+
+// Fallback returns whether a backup surrogate or even allocation was used
+// instead of the requested surrogate.
+func (q *SurrogateQA) Fallback() bool { return q.Used != q.Name }
+
+// GenerateSurrogates creates the gridding surrogates for grid for every
+// surrogate specification in sp.SrgSpecs and every input location, rather
+// than waiting for them to be created as they are needed by emissions records.
+// Surrogates are processed in order of region and code, and locations
+// are processed in order of ID. Surrogate specifications without their
+// own input shapes (i.e., those that are not SMOKE surrogates) use the
+// input shapes of the first SMOKE surrogate in the same region, and
+// are skipped if there is no such surrogate.
+//
+// f is called for each surrogate and location with QA information and
+// the gridded surrogate data that was used, which is nil if the
+// emissions are allocated evenly across the grid cells.
+// f must not edit the gridded surrogate data.
+func (sp *SpatialProcessor) GenerateSurrogates(grid *GridDef, f func(*SurrogateQA, *GriddedSrgData) error) error {
+	var specs []SrgSpec
+	for _, byCode := range sp.SrgSpecs.byCode {
+		for _, spec := range byCode {
+			specs = append(specs, spec)
+		}
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		if specs[i].region() != specs[j].region() {
+			return specs[i].region() < specs[j].region()
+		}
+		return specs[i].code() < specs[j].code()
+	})
+
+	// Read the input shapes, only reading each shapefile once.
+	shapefileShapes := make(map[string]map[string]*Location)
+	inputShapes := func(smoke *SrgSpecSMOKE) (map[string]*Location, error) {
+		key := smoke.dataShapefile() + "_" + smoke.dataAttribute()
+		if shapes, ok := shapefileShapes[key]; ok {
+			return shapes, nil
+		}
+		shapes, err := smoke.InputShapes()
+		if err != nil {
+			return nil, fmt.Errorf("aep.GenerateSurrogates: surrogate %s%s: %v", smoke.region(), smoke.code(), err)
+		}
+		shapefileShapes[key] = shapes
+		return shapes, nil
+	}
+	regionShapes := make(map[Country]*SrgSpecSMOKE)
+	for _, spec := range specs {
+		if smoke, ok := spec.(*SrgSpecSMOKE); ok {
+			if _, ok := regionShapes[spec.region()]; !ok {
+				regionShapes[spec.region()] = smoke
+			}
+		}
+	}
+
+	for _, spec := range specs {
+		smoke, ok := spec.(*SrgSpecSMOKE)
+		if !ok {
+			if smoke, ok = regionShapes[spec.region()]; !ok {
+				continue
+			}
+		}
+		shapes, err := inputShapes(smoke)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(shapes))
+		for id := range shapes {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			srg, coveredByGrid, data, zeroWeight, err := sp.surrogate(spec, grid, shapes[id])
+			if err != nil {
+				return fmt.Errorf("aep.GenerateSurrogates: surrogate %s%s location %s: %v", spec.region(), spec.code(), id, err)
+			}
+			qa := &SurrogateQA{
+				Region:        spec.region(),
+				Code:          spec.code(),
+				Name:          spec.name(),
+				Location:      id,
+				CoveredByGrid: coveredByGrid,
+			}
+			for _, z := range zeroWeight {
+				qa.ZeroWeight = append(qa.ZeroWeight, z.name())
+			}
+			if data != nil {
+				qa.Used = spec.name()
+				if len(zeroWeight) > 0 {
+					qa.Used = spec.backupSurrogateNames()[len(zeroWeight)-1]
+				}
+				for _, cell := range data.Cells {
+					qa.Coverage += cell.Weight
+				}
+			} else if srg != nil {
+				qa.Coverage = srg.Sum()
+			}
+			if err := f(qa, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/geom/proj"
+)
+
+func TestGenerateSurrogates(t *testing.T) {
+	srgSpecs, err := ReadSrgSpecSMOKE(strings.NewReader(`"REGION","SURROGATE","SURROGATE CODE","DATA SHAPEFILE","DATA ATTRIBUTE","WEIGHT SHAPEFILE","WEIGHT ATTRIBUTE","WEIGHT FUNCTION","FILTER FUNCTION","MERGE FUNCTION","SECONDARY SURROGATE","TERTIARY SURROGATE","QUARTERNARY SURROGATE","DETAILS","COMMENTS"
+"USA","Urban Primary Road Miles",200,"cty_pophu2k_revised","FIPSSTCO","rd_ps_tiger2010","NONE",,"RDTYPE = 1",,"Total Road Miles",,,"Road Miles of Urban Primary Roads"," "
+"USA","Total Road Miles",240,"cty_pophu2k_revised","FIPSSTCO","rd_ps_tiger2010","NONE",,,,,,,"Total Road Miles"," "
+`), "testdata", true, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid, err := createGrid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := NewSpatialProcessor(srgSpecs, []*GridDef{grid}, nil, inputSR, false)
+	var fallbacks []string
+	n := 0
+	err = sp.GenerateSurrogates(grid, func(qa *SurrogateQA, data *GriddedSrgData) error {
+		n++
+		if qa.Fallback() {
+			fallbacks = append(fallbacks, fmt.Sprintf("%s_%s:%s->%s", qa.Code, qa.Location, strings.Join(qa.ZeroWeight, ","), qa.Used))
+		}
+		if (data == nil) != (qa.Used == "") {
+			t.Errorf("%s %s: data should be nil if and only if no surrogate was used", qa.Code, qa.Location)
+		}
+		if covered, ok := coveredByGrid[qa.Location]; !ok || covered != qa.CoveredByGrid {
+			t.Errorf("%s %s: covered by grid should be %v", qa.Code, qa.Location, covered)
+		}
+		if qa.CoveredByGrid && math.Abs(qa.Coverage-1) > 1.0e-10 {
+			t.Errorf("%s %s: coverage should be 1 but is %g", qa.Code, qa.Location, qa.Coverage)
+		} else if qa.Coverage <= 0 || qa.Coverage > 1+1.0e-10 {
+			t.Errorf("%s %s: coverage %g is out of range", qa.Code, qa.Location, qa.Coverage)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 2 * len(coveredByGrid); n != want {
+		t.Errorf("generated %d surrogates; want %d", n, want)
+	}
+	wantFallbacks := []string{
+		"200_09001:Urban Primary Road Miles->Total Road Miles",
+		"200_36103:Urban Primary Road Miles->Total Road Miles",
+	}
+	if !reflect.DeepEqual(fallbacks, wantFallbacks) {
+		t.Errorf("fallbacks: have %v, want %v", fallbacks, wantFallbacks)
+	}
+}
+
+// Backup surrogates should only be looked up if they are needed, so a
+// missing backup surrogate should not cause an error when the primary
+// surrogate has weight.
+func TestGenerateSurrogates_missingBackup(t *testing.T) {
+	srgSpecs, err := ReadSrgSpecSMOKE(strings.NewReader(`"REGION","SURROGATE","SURROGATE CODE","DATA SHAPEFILE","DATA ATTRIBUTE","WEIGHT SHAPEFILE","WEIGHT ATTRIBUTE","WEIGHT FUNCTION","FILTER FUNCTION","MERGE FUNCTION","SECONDARY SURROGATE","TERTIARY SURROGATE","QUARTERNARY SURROGATE","DETAILS","COMMENTS"
+"USA","Total Road Miles",240,"cty_pophu2k_revised","FIPSSTCO","rd_ps_tiger2010","NONE",,,,"Nonexistent Surrogate",,,"Total Road Miles"," "
+`), "testdata", true, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputSR, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid, err := createGrid()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp := NewSpatialProcessor(srgSpecs, []*GridDef{grid}, nil, inputSR, false)
+	n := 0
+	err = sp.GenerateSurrogates(grid, func(qa *SurrogateQA, data *GriddedSrgData) error {
+		n++
+		if qa.Fallback() {
+			t.Errorf("%s %s: unexpected fallback to %q", qa.Code, qa.Location, qa.Used)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(coveredByGrid) {
+		t.Errorf("generated %d surrogates; want %d", n, len(coveredByGrid))
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/ctessum/sparse"
+	"github.com/ctessum/unit"
+)
+
+// VerticalAllocator allocates emissions among the vertical layers
+// of an air quality model.
+type VerticalAllocator interface {
+	// Layers returns the number of vertical layers.
+	Layers() int
+
+	// LayerFractions returns the fraction of the emissions from rec
+	// between begin and end in grid index gi that are released into
+	// each vertical layer, starting from the ground.
+	LayerFractions(rec RecordGridded, begin, end time.Time, gi int) ([]float64, error)
+}
+
+// StackLayers is a VerticalAllocator that allocates the emissions from
+// elevated sources to the layer that contains the top of their stack,
+// and all other emissions to the ground-level layer. It does not
+// account for plume rise.
+type StackLayers struct {
+	// LayerHeights are the heights [m] above ground of the tops of
+	// each layer, in increasing order.
+	LayerHeights []float64
+}
+
+// Layers returns the number of vertical layers.
+func (s *StackLayers) Layers() int { return len(s.LayerHeights) }
+
+// LayerFractions returns the fraction of the emissions from rec that are
+// released into each layer. Stacks taller than the top layer are allocated
+// to the top layer.
+func (s *StackLayers) LayerFractions(rec RecordGridded, begin, end time.Time, gi int) ([]float64, error) {
+	if len(s.LayerHeights) == 0 {
+		return nil, fmt.Errorf("aep.StackLayers: no layers")
+	}
+	o := make([]float64, len(s.LayerHeights))
+	e, ok := rec.Parent().(RecordElevated)
+	if !ok || e.GroundLevel() {
+		o[0] = 1
+		return o, nil
+	}
+	height, _, _, _, _ := e.StackParameters()
+	if height == nil {
+		o[0] = 1
+		return o, nil
+	}
+	if err := height.Check(unit.Meter); err != nil {
+		return nil, fmt.Errorf("aep.StackLayers: stack height: %v", err)
+	}
+	i := sort.SearchFloat64s(s.LayerHeights, height.Value())
+	if i == len(o) {
+		i--
+	}
+	o[i] = 1
+	return o, nil
+}
+
+// GriddedLayers returns the emissions from recs between begin and end in grid
+// index gi, which has nx columns and ny rows, allocated to nz vertical
+// layers using va, as [layer, row, column] arrays by pollutant name.
+// Emissions are in units of kmol for gases and kg for particles, and
+// emissions with different prefixes are combined. If va is nil, all
+// emissions are allocated to the ground-level layer.
+func GriddedLayers(recs []RecordGridded, va VerticalAllocator, begin, end time.Time, gi, nx, ny, nz int) (map[string]*sparse.DenseArray, error) {
+	o := make(map[string]*sparse.DenseArray)
+	for _, rec := range recs {
+		emis, _, err := rec.GriddedEmissions(begin, end, gi)
+		if err != nil {
+			return nil, err
+		}
+		if len(emis) == 0 {
+			continue
+		}
+		fracs := []float64{1}
+		if va != nil {
+			if fracs, err = va.LayerFractions(rec, begin, end, gi); err != nil {
+				return nil, err
+			}
+			if len(fracs) != nz {
+				return nil, fmt.Errorf("aep: record %s has %d vertical layers but there should be %d", rec.Key(), len(fracs), nz)
+			}
+		}
+		for pol, e := range emis {
+			d, ok := o[pol.Name]
+			if !ok {
+				d = sparse.ZerosDense(nz, ny, nx)
+				o[pol.Name] = d
+			}
+			for i, v := range e.Elements {
+				// The elements of e are in [row, column] order.
+				row, col := i/nx, i%nx
+				for k, f := range fracs {
+					if f != 0 {
+						d.AddVal(v*f, k, row, col)
+					}
+				}
+			}
+		}
+	}
+	return o, nil
+}
+
+// isGas returns whether total emissions of pol with the given units
+// are a gas (in units of moles) rather than a particle (in units of mass).
+func isGas(pol Pollutant, units unit.Dimensions) (bool, error) {
+	switch {
+	case units.Matches(unit.Dimensions{kiloMol: 1}):
+		return true, nil
+	case units.Matches(unit.Dimensions{unit.MassDim: 1}):
+		return false, nil
+	default:
+		return false, fmt.Errorf("aep: pollutant %s has units of %v; it should have units of mass or moles", pol, units)
+	}
+}
+
+// outputSpecies returns the names of the pollutants emitted by recs and
+// whether each is a gas.
+func outputSpecies(recs []RecordGridded) (map[string]bool, error) {
+	o := make(map[string]bool)
+	for _, rec := range recs {
+		for pol, v := range rec.Totals() {
+			gas, err := isGas(pol, v.Dimensions())
+			if err != nil {
+				return nil, err
+			}
+			if g, ok := o[pol.Name]; ok && g != gas {
+				return nil, fmt.Errorf("aep: pollutant %s has inconsistent units", pol.Name)
+			}
+			o[pol.Name] = gas
+		}
+	}
+	return o, nil
+}
+
+// cellAreas returns the area [m²] of each [row, column] cell in grid,
+// which must be a regular grid. If the grid has a longitude-latitude projection,
+// the grid spacing is assumed to be in degrees; otherwise it is assumed
+// to be in meters.
+func cellAreas(grid *GridDef) *sparse.DenseArray {
+	o := sparse.ZerosDense(grid.Ny, grid.Nx)
+	for j := 0; j < grid.Ny; j++ {
+		a := grid.Dx * grid.Dy
+		if grid.SR != nil && grid.SR.Name == "longlat" {
+			const deg2rad = math.Pi / 180
+			s := (grid.Y0 + float64(j)*grid.Dy) * deg2rad
+			n := s + grid.Dy*deg2rad
+			a = EarthRadius * EarthRadius * grid.Dx * deg2rad * (math.Sin(n) - math.Sin(s))
+		}
+		for i := 0; i < grid.Nx; i++ {
+			o.Set(a, j, i)
+		}
+	}
+	return o
+}
+
+// sortedSpecies returns the names in d in sorted order.
+func sortedSpecies(d map[string]bool) []string {
+	names := make([]string, 0, len(d))
+	for n := range d {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -73,10 +73,11 @@ func ParseWRFConfig(wpsnamelist, wrfnamelist string) (d *WRFconfigData, err erro
 	return
 }
 
+// EarthRadius is the radius of the spherical earth [m] assumed by WRF.
+const EarthRadius = 6370997.
+
 // projection calculates the spatial projection of a WRF configuration.
 func (d *WRFconfigData) projection(e *wrfErrCat) {
-	const EarthRadius = 6370997.
-
 	var mapProj string
 	switch d.MapProj {
 	case "lambert":
@@ -91,12 +92,13 @@ func (d *WRFconfigData) projection(e *wrfErrCat) {
 			" that are currently supported (your projection is `%v').",
 			d.MapProj))
 	}
+	const deg2rad = math.Pi / 180
 	d.sr = proj.NewSR()
 	d.sr.Name = mapProj
-	d.sr.Lat1 = d.TrueLat1
-	d.sr.Lat2 = d.TrueLat2
-	d.sr.Lat0 = d.RefLat
-	d.sr.Long0 = d.RefLon
+	d.sr.Lat1 = d.TrueLat1 * deg2rad
+	d.sr.Lat2 = d.TrueLat2 * deg2rad
+	d.sr.Lat0 = d.RefLat * deg2rad
+	d.sr.Long0 = d.RefLon * deg2rad
 	d.sr.A = EarthRadius
 	d.sr.B = EarthRadius
 	d.sr.ToMeter = 1.
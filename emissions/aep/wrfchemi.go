@@ -0,0 +1,187 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ctessum/cdf"
+)
+
+// wrfTimeFormat is the format of times in WRF files.
+const wrfTimeFormat = "2006-01-02_15:04:05"
+
+// WRFChemWriter writes gridded emissions to WRF-Chem
+// "wrfchemi_<domain>_<time>" input files.
+type WRFChemWriter struct {
+	// Config is the configuration of the WRF simulation. The
+	// RecordGridded emissions records to be written should have been
+	// created by a SpatialProcessor whose grids are from Config.Grids(), so
+	// that the grid index of each domain matches its index in Config.
+	Config *WRFconfigData
+
+	// Vertical allocates emissions among vertical layers. If it is nil,
+	// all emissions are allocated to the ground-level layer.
+	// The number of layers must match the kemit namelist setting,
+	// if it is specified.
+	Vertical VerticalAllocator
+
+	// Dir is the directory the files are written to.
+	Dir string
+}
+
+// Write writes hourly emissions from recs between begin and end, which
+// should be speciated so that gas emissions are in units of moles.
+// One file is written for each domain and every FramesPerAuxInput5 hours
+// (or every hour if FramesPerAuxInput5 is not specified).
+// Gas emissions are written in units of mol km⁻² hr⁻¹ and particle
+// emissions are written in units of μg m⁻² s⁻¹, in variables named
+// "E_<pollutant>".
+func (w *WRFChemWriter) Write(recs []RecordGridded, begin, end time.Time) error {
+	nz := w.Config.Kemit
+	if w.Vertical != nil {
+		if nz != 0 && nz != w.Vertical.Layers() {
+			return fmt.Errorf("aep.WRFChemWriter: kemit is %d but there are %d vertical layers", nz, w.Vertical.Layers())
+		}
+		nz = w.Vertical.Layers()
+	}
+	if nz == 0 {
+		nz = 1
+	}
+	species, err := outputSpecies(recs)
+	if err != nil {
+		return fmt.Errorf("aep.WRFChemWriter: %v", err)
+	}
+	for gi, grid := range w.Config.Grids() {
+		frames := 1
+		if gi < len(w.Config.FramesPerAuxInput5) && w.Config.FramesPerAuxInput5[gi] > 0 {
+			frames = w.Config.FramesPerAuxInput5[gi]
+		}
+		for fileBegin := begin; fileBegin.Before(end); fileBegin = fileBegin.Add(time.Duration(frames) * time.Hour) {
+			fileEnd := fileBegin.Add(time.Duration(frames) * time.Hour)
+			if fileEnd.After(end) {
+				fileEnd = end
+			}
+			if err := w.writeFile(recs, species, gi, grid, nz, fileBegin, fileEnd); err != nil {
+				return fmt.Errorf("aep.WRFChemWriter: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// wrfVarName returns the WRF-Chem emissions variable name for
+// the given pollutant.
+func wrfVarName(pol string) string {
+	return "E_" + strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(pol))
+}
+
+// writeFile writes a file with hourly emissions between begin and end
+// for grid index gi.
+func (w *WRFChemWriter) writeFile(recs []RecordGridded, species map[string]bool, gi int, grid *GridDef, nz int, begin, end time.Time) error {
+	c := w.Config
+	names := sortedSpecies(species)
+
+	h := cdf.NewHeader([]string{"Time", "DateStrLen", "west_east", "south_north", "emissions_zdim"},
+		[]int{0, len(wrfTimeFormat), grid.Nx, grid.Ny, nz})
+	h.AddAttribute("", "TITLE", "WRF-Chem emissions created by AEP")
+	h.AddAttribute("", "WEST-EAST_GRID_DIMENSION", []int32{int32(grid.Nx + 1)})
+	h.AddAttribute("", "SOUTH-NORTH_GRID_DIMENSION", []int32{int32(grid.Ny + 1)})
+	h.AddAttribute("", "DX", []float32{float32(grid.Dx)})
+	h.AddAttribute("", "DY", []float32{float32(grid.Dy)})
+	h.AddAttribute("", "CEN_LAT", []float32{float32(c.RefLat)})
+	h.AddAttribute("", "CEN_LON", []float32{float32(c.RefLon)})
+	h.AddAttribute("", "TRUELAT1", []float32{float32(c.TrueLat1)})
+	h.AddAttribute("", "TRUELAT2", []float32{float32(c.TrueLat2)})
+	h.AddAttribute("", "STAND_LON", []float32{float32(c.StandLon)})
+	mapProj := map[string]int32{"lambert": 1, "merc": 3, "lat-lon": 6}[c.MapProj]
+	h.AddAttribute("", "MAP_PROJ", []int32{mapProj})
+	h.AddVariable("Times", []string{"Time", "DateStrLen"}, "")
+	for _, name := range names {
+		v := wrfVarName(name)
+		h.AddVariable(v, []string{"Time", "emissions_zdim", "south_north", "west_east"}, []float32{0})
+		h.AddAttribute(v, "FieldType", []int32{104})
+		h.AddAttribute(v, "MemoryOrder", "XYZ")
+		h.AddAttribute(v, "description", fmt.Sprintf("%s emissions", name))
+		if species[name] {
+			h.AddAttribute(v, "units", "mol km^-2 hr^-1")
+		} else {
+			h.AddAttribute(v, "units", "ug m^-2 s^-1")
+		}
+		h.AddAttribute(v, "stagger", "")
+	}
+	h.Define()
+	if errs := h.Check(); len(errs) > 0 {
+		return errs[0]
+	}
+
+	fname := fmt.Sprintf("wrfchemi_%s_%s", c.DomainNames[gi], begin.UTC().Format(wrfTimeFormat))
+	if c.Nocolons {
+		fname = strings.Replace(fname, ":", "_", -1)
+	}
+	ff, err := os.Create(filepath.Join(w.Dir, fname))
+	if err != nil {
+		return err
+	}
+	defer ff.Close()
+	f, err := cdf.Create(ff, h)
+	if err != nil {
+		return err
+	}
+
+	areas := cellAreas(grid)
+	for t, hour := 0, begin; hour.Before(end); t, hour = t+1, hour.Add(time.Hour) {
+		tw := f.Writer("Times", []int{t, 0}, nil)
+		if _, err := tw.Write(hour.UTC().Format(wrfTimeFormat)); err != nil {
+			return err
+		}
+		emis, err := GriddedLayers(recs, w.Vertical, hour, hour.Add(time.Hour), gi, grid.Nx, grid.Ny, nz)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			data := make([]float32, nz*grid.Ny*grid.Nx)
+			if d, ok := emis[name]; ok {
+				for i, v := range d.Elements {
+					a := areas.Elements[i%(grid.Ny*grid.Nx)]
+					if species[name] {
+						// kmol/hour → mol km⁻² hr⁻¹
+						data[i] = float32(v * 1000 / (a / 1.e6))
+					} else {
+						// kg/hour → μg m⁻² s⁻¹
+						data[i] = float32(v * 1.e9 / a / 3600)
+					}
+				}
+			}
+			vw := f.Writer(wrfVarName(name), []int{t, 0, 0, 0}, nil)
+			if _, err := vw.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	return cdf.UpdateNumRecs(ff)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2017 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.*/
+
+package aep
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ctessum/cdf"
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+	"github.com/ctessum/unit"
+)
+
+// outputTestRecords returns an elevated point source of NO at 1 kmol/hour
+// and a ground-level point source of PM2_5 at 3.6 kg/hour between begin
+// and end, gridded to the WRF domains in testdata/wrf.
+func outputTestRecords(t *testing.T, begin, end time.Time) (*WRFconfigData, []RecordGridded) {
+	cfg, err := ParseWRFConfig("testdata/wrf/namelist.wps", "testdata/wrf/namelist.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPoint := func(x, y, height float64) *PointRecord {
+		r := &PointRecord{}
+		r.PlantID = "test"
+		r.Point = geom.Point{X: x, Y: y}
+		r.SR = cfg.sr
+		r.StackHeight = unit.New(height, unit.Meter)
+		r.StackDiameter = unit.New(1, unit.Meter)
+		r.StackTemp = unit.New(400, unit.Kelvin)
+		r.StackVelocity = unit.New(height/10, unit.Dimensions{unit.LengthDim: 1, unit.TimeDim: -1})
+		r.StackFlow = unit.Mul(r.StackVelocity, circleArea(r.StackDiameter))
+		return r
+	}
+	elevated := newPoint(-3000, 3000, 200)
+	elevated.Emissions.Add(begin, end, "NO", "", unit.New(1./3600, unit.Dimensions{kiloMol: 1, unit.TimeDim: -1}))
+	ground := newPoint(20000, -10000, 0)
+	ground.PointID = "ground"
+	ground.Emissions.Add(begin, end, "PM2_5", "", unit.New(1.e-3, unit.Dimensions{unit.MassDim: 1, unit.TimeDim: -1}))
+
+	sp := NewSpatialProcessor(NewSrgSpecs(), cfg.Grids(), &GridRef{}, cfg.sr, true)
+	return cfg, []RecordGridded{sp.GridRecord(elevated), sp.GridRecord(ground)}
+}
+
+// readNCFRecord reads record t of variable v from the NetCDF file f.
+func readNCFRecord(t *testing.T, f *cdf.File, v string, rec int) interface{} {
+	lengths := f.Header.Lengths(v)
+	n := 1
+	for _, l := range lengths[1:] {
+		n *= l
+	}
+	begin := make([]int, len(lengths))
+	begin[0] = rec
+	r := f.Reader(v, begin, nil)
+	buf := r.Zero(n)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("reading %s: %v", v, err)
+	}
+	return buf
+}
+
+func TestWRFChemWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wrfchemi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	begin := time.Date(2016, time.July, 1, 0, 0, 0, 0, time.UTC)
+	end := begin.Add(3 * time.Hour)
+	cfg, recs := outputTestRecords(t, begin, end)
+	w := &WRFChemWriter{
+		Config:   cfg,
+		Vertical: &StackLayers{LayerHeights: []float64{50, 500}},
+		Dir:      dir,
+	}
+	if err = w.Write(recs, begin, end); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		files[i] = filepath.Base(f)
+	}
+	sort.Strings(files)
+	wantFiles := []string{
+		"wrfchemi_d01_2016-07-01_00_00_00", "wrfchemi_d01_2016-07-01_02_00_00",
+		"wrfchemi_d02_2016-07-01_00_00_00", "wrfchemi_d02_2016-07-01_01_00_00", "wrfchemi_d02_2016-07-01_02_00_00",
+	}
+	if len(files) != len(wantFiles) {
+		t.Fatalf("files: have %v, want %v", files, wantFiles)
+	}
+	for i, f := range files {
+		if f != wantFiles[i] {
+			t.Errorf("file %d: have %s, want %s", i, f, wantFiles[i])
+		}
+	}
+
+	ff, err := os.Open(filepath.Join(dir, wantFiles[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ff.Close()
+	f, err := cdf.Open(ff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := f.Header.NumRecs(fileSize(t, ff)); n != 2 {
+		t.Errorf("want 2 time steps but have %d", n)
+	}
+	if times := string(readNCFRecord(t, f, "Times", 1).([]uint8)); times != "2016-07-01_01:00:00" {
+		t.Errorf("times: %s", times)
+	}
+
+	// Grid d01 has 4 columns and 3 rows of 12 km cells.
+	idx := func(layer, row, col int) int { return layer*12 + row*4 + col }
+	no := readNCFRecord(t, f, "E_NO", 1).([]float32)
+	pm := readNCFRecord(t, f, "E_PM2_5", 1).([]float32)
+	for i := range no {
+		var wantNO, wantPM float64
+		if i == idx(1, 1, 1) {
+			wantNO = 1000. / 144 // mol km⁻² hr⁻¹
+		}
+		if i == idx(0, 0, 3) {
+			wantPM = 3.6e9 / 144.e6 / 3600 // μg m⁻² s⁻¹
+		}
+		if math.Abs(float64(no[i])-wantNO) > 1.e-5 {
+			t.Errorf("E_NO %d: have %g, want %g", i, no[i], wantNO)
+		}
+		if math.Abs(float64(pm[i])-wantPM) > 1.e-8 {
+			t.Errorf("E_PM2_5 %d: have %g, want %g", i, pm[i], wantPM)
+		}
+	}
+
+	w.Vertical = &StackLayers{LayerHeights: []float64{50}}
+	if err = w.Write(recs, begin, end); err == nil {
+		t.Error("a mismatch between kemit and the number of layers should cause an error")
+	}
+}
+
+func fileSize(t *testing.T, f *os.File) int64 {
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Size()
+}
+
+func TestWRFConfig_projection(t *testing.T) {
+	cfg, err := ParseWRFConfig("testdata/wrf/namelist.wps", "testdata/wrf/namelist.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ll, err := proj.Parse("+proj=longlat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := cfg.sr.NewTransform(ll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reference point should be at the origin of the projection.
+	x, y, err := ct(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(x-cfg.RefLon) > 1.e-6 || math.Abs(y-cfg.RefLat) > 1.e-6 {
+		t.Errorf("reference point: have (%g, %g), want (%g, %g)", x, y, cfg.RefLon, cfg.RefLat)
+	}
+	if cfg.Kemit != 2 || !cfg.Nocolons {
+		t.Errorf("kemit=%d, nocolons=%v", cfg.Kemit, cfg.Nocolons)
+	}
+}
@@ -95,6 +95,10 @@ type InMAP struct {
 	// To prevent the grid from wrapping, set HorizontalWrap to
 	// NaN.
 	HorizontalWrap float64
+
+	// irregularGrid specifies whether the grid cells are arbitrary polygons
+	// (see VarGridConfig.GridFile) rather than nested rectangles.
+	irregularGrid bool
 }
 
 // Init initializes the simulation by running d.InitFuncs.
@@ -384,6 +388,11 @@ func (d *InMAP) GetGeometry(layer int, webMap bool) []geom.Polygonal {
 }
 
 // Regrid regrids concentration data from one spatial grid to a different one.
+// The grid cells in either grid can be arbitrary polygons, such as the
+// cells of a grid read from VarGridConfig.GridFile, and the value of each new
+// cell is the average of the old values weighted by the area of overlap
+// with each old cell. Old cells that only touch a new cell along an edge
+// do not contribute to its value.
 func Regrid(oldGeom, newGeom []geom.Polygonal, oldData []float64) (newData []float64, err error) {
 	type data struct {
 		geom.Polygonal
@@ -401,6 +410,7 @@ func Regrid(oldGeom, newGeom []geom.Polygonal, oldData []float64) (newData []flo
 	}
 	newData = make([]float64, len(newGeom))
 	for i, g := range newGeom {
+		area := g.Area()
 		for _, dI := range index.SearchIntersect(g.Bounds()) {
 			d := dI.(*data)
 			isect := g.Intersection(d.Polygonal)
@@ -408,8 +418,10 @@ func Regrid(oldGeom, newGeom []geom.Polygonal, oldData []float64) (newData []flo
 				continue
 			}
 			a := isect.Area()
-			frac := a / g.Area()
-			newData[i] += d.data * frac
+			if a == 0 {
+				continue
+			}
+			newData[i] += d.data * a / area
 		}
 	}
 	return newData, nil
@@ -0,0 +1,410 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Knetic/govaluate"
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/index/rtree"
+	"github.com/ctessum/geom/proj"
+)
+
+// AndMutator returns a GridMutator that specifies that a grid cell should
+// be divided only if all of the given mutators specify that it should be divided.
+func AndMutator(mutators ...GridMutator) GridMutator {
+	return func(cell *Cell, totalMass, totalPopulation float64) bool {
+		for _, m := range mutators {
+			if !m(cell, totalMass, totalPopulation) {
+				return false
+			}
+		}
+		return len(mutators) > 0
+	}
+}
+
+// OrMutator returns a GridMutator that specifies that a grid cell should
+// be divided if any of the given mutators specify that it should be divided.
+func OrMutator(mutators ...GridMutator) GridMutator {
+	return func(cell *Cell, totalMass, totalPopulation float64) bool {
+		for _, m := range mutators {
+			if m(cell, totalMass, totalPopulation) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PolygonMutator returns a function that determines that a grid cell
+// should be split if it is in one of the layers below config.HiResLayers
+// and it overlaps any of the given polygons, which could, for example,
+// represent air quality nonattainment areas. The polygons must use
+// the same spatial reference as the InMAP grid.
+func PolygonMutator(config *VarGridConfig, polygons []geom.Polygonal) GridMutator {
+	index := rtree.NewTree(25, 50)
+	for _, p := range polygons {
+		index.Insert(p)
+	}
+	return func(cell *Cell, _, _ float64) bool {
+		if cell.Layer >= config.HiResLayers {
+			return false
+		}
+		for _, pI := range index.SearchIntersect(cell.Bounds()) {
+			p := pI.(geom.Polygonal)
+			if isect := p.Intersection(cell.Polygonal); isect != nil && isect.Area() > 0 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PointMutator returns a function that determines that a grid cell
+// should be split if it is in one of the layers below config.HiResLayers
+// and it is within the given distance of any of the given points,
+// which could, for example, represent the locations of point sources.
+// The points must use the same spatial reference as the InMAP grid, and
+// distance is in the units of the grid spatial reference.
+func PointMutator(config *VarGridConfig, points []geom.Point, distance float64) GridMutator {
+	index := rtree.NewTree(25, 50)
+	for _, p := range points {
+		index.Insert(p)
+	}
+	return func(cell *Cell, _, _ float64) bool {
+		if cell.Layer >= config.HiResLayers {
+			return false
+		}
+		b := cell.Bounds()
+		b = &geom.Bounds{
+			Min: geom.Point{X: b.Min.X - distance, Y: b.Min.Y - distance},
+			Max: geom.Point{X: b.Max.X + distance, Y: b.Max.Y + distance},
+		}
+		for _, pI := range index.SearchIntersect(b) {
+			p := pI.(geom.Point)
+			if w := p.Within(cell.Polygonal); w == geom.Inside || w == geom.OnEdge {
+				return true
+			}
+			if distanceToPolygon(p, cell.Polygonal) <= distance {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// distanceToPolygon returns the distance between point p and the
+// closest edge of polygon poly.
+func distanceToPolygon(p geom.Point, poly geom.Polygonal) float64 {
+	d := -1.
+	for _, pp := range poly.Polygons() {
+		for _, ring := range pp {
+			for i := 0; i < len(ring)-1; i++ {
+				dd := distancePointLineSegment(p, ring[i], ring[i+1])
+				if d < 0 || dd < d {
+					d = dd
+				}
+			}
+		}
+	}
+	return d
+}
+
+// distancePointLineSegment returns the distance between point p and
+// the line segment between points l1 and l2.
+func distancePointLineSegment(p, l1, l2 geom.Point) float64 {
+	dx, dy := l2.X-l1.X, l2.Y-l1.Y
+	var t float64
+	if l := dx*dx + dy*dy; l > 0 {
+		t = ((p.X-l1.X)*dx + (p.Y-l1.Y)*dy) / l
+		if t < 0 {
+			t = 0
+		} else if t > 1 {
+			t = 1
+		}
+	}
+	return math.Hypot(l1.X+t*dx-p.X, l1.Y+t*dy-p.Y)
+}
+
+// EmissionsDensityMutator returns a function that determines that a grid cell
+// should be split if it is in one of the layers below config.HiResLayers and
+// the total emissions of all pollutants in emis that fall within the
+// horizontal footprint of the cell, divided by the horizontal area of the cell,
+// is greater than threshold. Emissions are in units of μg/s, so threshold
+// should be in units of μg/s per square unit of the grid spatial reference
+// (for example, μg/s/m²).
+func EmissionsDensityMutator(config *VarGridConfig, emis *Emissions, threshold float64) GridMutator {
+	return func(cell *Cell, _, _ float64) bool {
+		if cell.Layer >= config.HiResLayers {
+			return false
+		}
+		var total float64
+		for _, eI := range emis.data.SearchIntersect(cell.Bounds()) {
+			e := eI.(*EmisRecord)
+			frac := calcWeightFactor(e.Geom, cell)
+			total += (e.VOC + e.NOx + e.NH3 + e.SOx + e.PM25) * frac
+		}
+		area := cell.Polygonal.Area()
+		return area > 0 && total/area > threshold
+	}
+}
+
+// RefinementCriterion specifies a criterion for dividing grid cells in a
+// static variable resolution grid.
+type RefinementCriterion struct {
+	// Type specifies the type of criterion. Options are:
+	// "polygon", where cells overlapping any polygon in File are divided
+	// (see PolygonMutator);
+	// "point", where cells within Distance of any point in File are divided
+	// (see PointMutator); and
+	// "emissions", where cells where the density of the emissions in File
+	// is greater than Threshold are divided (see EmissionsDensityMutator).
+	Type string
+
+	// File is the path to a shapefile containing the geometry or emissions
+	// for this criterion. If the shapefile includes projection information,
+	// the geometry will be reprojected to the InMAP grid spatial reference.
+	// For the "point" type, the centroids of any polygons are used.
+	File string
+
+	// Distance is the distance, in the units of the grid spatial reference,
+	// used by the "point" criterion type.
+	Distance float64
+
+	// Threshold is the emissions density threshold, in μg/s per
+	// square unit of the grid spatial reference, used by the "emissions"
+	// criterion type.
+	Threshold float64
+
+	// EmissionUnits are the units of the emissions in File for the
+	// "emissions" criterion type. Options are tons/year, kg/year, ug/s, and μg/s.
+	EmissionUnits string
+}
+
+// RefinementMutator returns a GridMutator that combines the
+// criteria in config.RefinementCriteria as specified
+// by config.RefinementRule. The built-in criterion "Population" refers to
+// the function returned by PopulationMutator. If config.RefinementRule is empty,
+// only the population criterion is used. An error is returned if
+// config.RefinementRule is not a valid expression that results in true or false.
+// The returned GridMutator is not safe for concurrent use.
+func (config *VarGridConfig) RefinementMutator(popIndices PopIndices) (GridMutator, error) {
+	rule := strings.TrimSpace(config.RefinementRule)
+	if rule == "" {
+		rule = "Population"
+	}
+	expr, err := govaluate.NewEvaluableExpression(rule)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: parsing RefinementRule: %v", err)
+	}
+
+	gridSR, err := proj.Parse(config.GridProj)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: parsing grid projection: %v", err)
+	}
+
+	mutators := make(map[string]GridMutator)
+	for _, name := range expr.Vars() {
+		if _, ok := mutators[name]; ok {
+			continue
+		}
+		if name == "Population" {
+			if _, ok := config.RefinementCriteria[name]; ok {
+				return nil, fmt.Errorf("inmap: refinement criterion name 'Population' is reserved")
+			}
+			mutators[name], err = PopulationMutator(config, popIndices)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		c, ok := config.RefinementCriteria[name]
+		if !ok {
+			return nil, fmt.Errorf("inmap: RefinementRule refers to undefined refinement criterion '%s'", name)
+		}
+		mutators[name], err = c.mutator(config, gridSR)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: refinement criterion '%s': %v", name, err)
+		}
+	}
+
+	// Make sure the rule can be evaluated and results in true or false,
+	// so that errors are caught here rather than while the grid is being
+	// created.
+	for _, v := range []bool{false, true} {
+		testParams := make(govaluate.MapParameters, len(mutators))
+		for name := range mutators {
+			testParams[name] = v
+		}
+		result, err := expr.Eval(testParams)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: evaluating RefinementRule '%s': %v", config.RefinementRule, err)
+		}
+		if _, ok := result.(bool); !ok {
+			return nil, fmt.Errorf("inmap: RefinementRule '%s' does not evaluate to true or false", config.RefinementRule)
+		}
+	}
+
+	params := &refinementParams{
+		mutators: mutators,
+		values:   make(map[string]bool, len(mutators)),
+	}
+	return func(cell *Cell, totalMass, totalPopulation float64) bool {
+		params.reset(cell, totalMass, totalPopulation)
+		result, err := expr.Eval(params)
+		if err != nil {
+			// This shouldn't happen because the rule was checked above.
+			panic(fmt.Errorf("inmap: evaluating RefinementRule: %v", err))
+		}
+		return result.(bool)
+	}, nil
+}
+
+// refinementParams provides the values of refinement criteria
+// for a single grid cell to a RefinementRule expression. Each criterion
+// is only evaluated if and when the expression requires it, and its
+// value is then stored until the next cell.
+type refinementParams struct {
+	mutators map[string]GridMutator
+	values   map[string]bool
+
+	cell                       *Cell
+	totalMass, totalPopulation float64
+}
+
+// reset prepares the receiver to provide values for the given cell.
+func (p *refinementParams) reset(cell *Cell, totalMass, totalPopulation float64) {
+	for name := range p.values {
+		delete(p.values, name)
+	}
+	p.cell, p.totalMass, p.totalPopulation = cell, totalMass, totalPopulation
+}
+
+// Get returns the value of the criterion with the given name,
+// fulfilling the govaluate.Parameters interface.
+func (p *refinementParams) Get(name string) (interface{}, error) {
+	if v, ok := p.values[name]; ok {
+		return v, nil
+	}
+	m, ok := p.mutators[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined refinement criterion '%s'", name)
+	}
+	v := m(p.cell, p.totalMass, p.totalPopulation)
+	p.values[name] = v
+	return v, nil
+}
+
+// mutator creates a GridMutator from the receiver.
+func (c RefinementCriterion) mutator(config *VarGridConfig, gridSR *proj.SR) (GridMutator, error) {
+	switch c.Type {
+	case "polygon":
+		geoms, err := readGeomShapefile(c.File, gridSR)
+		if err != nil {
+			return nil, err
+		}
+		polygons := make([]geom.Polygonal, 0, len(geoms))
+		for _, g := range geoms {
+			p, ok := g.(geom.Polygonal)
+			if !ok {
+				return nil, fmt.Errorf("geometry type %T in %s is not a polygon", g, c.File)
+			}
+			polygons = append(polygons, p)
+		}
+		return PolygonMutator(config, polygons), nil
+	case "point":
+		if c.Distance < 0 {
+			return nil, fmt.Errorf("Distance=%g but it must not be negative", c.Distance)
+		}
+		geoms, err := readGeomShapefile(c.File, gridSR)
+		if err != nil {
+			return nil, err
+		}
+		points := make([]geom.Point, 0, len(geoms))
+		for _, g := range geoms {
+			switch t := g.(type) {
+			case geom.Point:
+				points = append(points, t)
+			case geom.Polygonal:
+				points = append(points, t.Centroid())
+			default:
+				return nil, fmt.Errorf("unsupported geometry type %T in %s", g, c.File)
+			}
+		}
+		return PointMutator(config, points, c.Distance), nil
+	case "emissions":
+		if c.Threshold <= 0 {
+			return nil, fmt.Errorf("Threshold=%g. It needs to be set to a positive value.", c.Threshold)
+		}
+		emis, err := ReadEmissionShapefiles(gridSR, c.EmissionUnits, nil, nil, c.File)
+		if err != nil {
+			return nil, err
+		}
+		return EmissionsDensityMutator(config, emis, c.Threshold), nil
+	default:
+		return nil, fmt.Errorf("invalid Type '%s'; valid options are 'polygon', 'point', and 'emissions'", c.Type)
+	}
+}
+
+// readGeomShapefile reads the geometry from the given shapefile
+// and converts it to the spatial reference gridSR.
+func readGeomShapefile(filename string, gridSR *proj.SR) ([]geom.Geom, error) {
+	f, err := shp.NewDecoder(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening shapefile: %v", err)
+	}
+	defer f.Close()
+	sr, err := f.SR()
+	if err != nil {
+		return nil, fmt.Errorf("reading projection information for shapefile %s: %v", filename, err)
+	}
+	trans, err := sr.NewTransform(gridSR)
+	if err != nil {
+		return nil, fmt.Errorf("creating spatial reprojector for shapefile %s: %v", filename, err)
+	}
+	sameSR := sr.Equal(gridSR, 0)
+	var o []geom.Geom
+	for {
+		var rec struct{ geom.Geom }
+		if ok := f.DecodeRow(&rec); !ok {
+			break
+		}
+		if rec.Geom == nil {
+			continue
+		}
+		if sameSR {
+			// Avoid introducing round-off errors when no reprojection is needed.
+			o = append(o, rec.Geom)
+			continue
+		}
+		g, err := rec.Transform(trans)
+		if err != nil {
+			return nil, fmt.Errorf("reprojecting shapefile %s: %v", filename, err)
+		}
+		o = append(o, g)
+	}
+	if err := f.Error(); err != nil {
+		return nil, fmt.Errorf("reading shapefile %s: %v", filename, err)
+	}
+	return o, nil
+}
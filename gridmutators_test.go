@@ -0,0 +1,172 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+	"github.com/ctessum/geom/index/rtree"
+)
+
+func TestGridMutators(t *testing.T) {
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+
+	polygon := geom.Polygon{{{X: 1000, Y: 1000}, {X: 1100, Y: 1000}, {X: 1100, Y: 1100}, {X: 1000, Y: 1100}, {X: 1000, Y: 1000}}}
+	inPoint := geom.Point{X: 1050, Y: 1050}
+	// otherPoint is in a different base grid cell than the polygon and inPoint.
+	otherPoint := geom.Point{X: -3000, Y: -3000}
+
+	emis := NewEmissions()
+	emis.Add(&EmisRecord{Geom: inPoint, PM25: 1})
+
+	const (
+		// With a 2x2 base grid with 10 layers, there are 40 cells.
+		nBase = 40
+		// Refining around a single location in the lowest layer
+		// adds 3 cells at each of the 2 nest levels below the base grid.
+		nRefined = nBase + 3*2
+	)
+
+	tests := []struct {
+		name    string
+		mutator GridMutator
+		nCells  int
+	}{
+		{name: "polygon", mutator: PolygonMutator(cfg, []geom.Polygonal{polygon}), nCells: nRefined},
+		{name: "point", mutator: PointMutator(cfg, []geom.Point{inPoint}, 0), nCells: nRefined},
+		{name: "point_other_cell", mutator: PointMutator(cfg, []geom.Point{otherPoint}, 0), nCells: nRefined},
+		{name: "point_distance", mutator: PointMutator(cfg, []geom.Point{{X: 1050, Y: 5000}}, 100), nCells: nBase},
+		{name: "emissions", mutator: EmissionsDensityMutator(cfg, emis, 1.e-9), nCells: nRefined},
+		{name: "emissions_threshold", mutator: EmissionsDensityMutator(cfg, emis, 1), nCells: nBase},
+		{
+			name: "and",
+			mutator: AndMutator(
+				PolygonMutator(cfg, []geom.Polygonal{polygon}),
+				PointMutator(cfg, []geom.Point{otherPoint}, 0),
+			),
+			nCells: nBase,
+		},
+		{
+			name: "or",
+			mutator: OrMutator(
+				PolygonMutator(cfg, []geom.Polygonal{polygon}),
+				PointMutator(cfg, []geom.Point{otherPoint}, 0),
+			),
+			nCells: nBase + 2*3*2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var m Mech
+			d := &InMAP{
+				InitFuncs: []DomainManipulator{
+					cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, &Emissions{data: rtree.NewTree(25, 50)}, m),
+					cfg.MutateGrid(test.mutator, ctmdata, pop, mr, nil, m, nil),
+				},
+			}
+			if err := d.Init(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(d.Cells()); n != test.nCells {
+				t.Errorf("want %d cells but have %d", test.nCells, n)
+			}
+		})
+	}
+}
+
+func TestRefinementMutator(t *testing.T) {
+	cfg, _, _, popIndices, _, _ := VarGridTestData()
+
+	dir, err := ioutil.TempDir("", "inmap_refinement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	polyFile := dir + "/nonattainment.shp"
+	type polyRec struct {
+		geom.Polygon
+		Name string
+	}
+	e, err := shp.NewEncoder(polyFile, polyRec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = e.Encode(polyRec{
+		Polygon: geom.Polygon{{{X: 1000, Y: 1000}, {X: 1100, Y: 1000}, {X: 1100, Y: 1100}, {X: 1000, Y: 1100}, {X: 1000, Y: 1000}}},
+		Name:    "test",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	e.Close()
+	if err = ioutil.WriteFile(dir+"/nonattainment.prj", []byte(cfg.GridProj), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.RefinementCriteria = map[string]RefinementCriterion{
+		"Nonattainment": {Type: "polygon", File: polyFile},
+	}
+
+	cell := &Cell{
+		Polygonal: geom.Polygon{{{X: 0, Y: 0}, {X: 2000, Y: 0}, {X: 2000, Y: 2000}, {X: 0, Y: 2000}, {X: 0, Y: 0}}},
+		Layer:     0,
+	}
+	outsideCell := &Cell{
+		Polygonal: geom.Polygon{{{X: 2000, Y: 0}, {X: 4000, Y: 0}, {X: 4000, Y: 2000}, {X: 2000, Y: 2000}, {X: 2000, Y: 0}}},
+		Layer:     0,
+	}
+
+	for _, test := range []struct {
+		rule   string
+		divide bool
+	}{
+		{rule: "Nonattainment", divide: true},
+		{rule: "!Nonattainment", divide: false},
+		{rule: "Nonattainment || false", divide: true},
+		{rule: "Nonattainment && true", divide: true},
+	} {
+		cfg.RefinementRule = test.rule
+		m, err := cfg.RefinementMutator(popIndices)
+		if err != nil {
+			t.Fatalf("rule %s: %v", test.rule, err)
+		}
+		if divide := m(cell, 0, 0); divide != test.divide {
+			t.Errorf("rule %s: want %v but have %v", test.rule, test.divide, divide)
+		}
+		// The criteria should be evaluated again for each cell.
+		if divide := m(outsideCell, 0, 0); divide != !test.divide {
+			t.Errorf("rule %s outside: want %v but have %v", test.rule, !test.divide, divide)
+		}
+	}
+
+	for _, rule := range []string{
+		"Nonattainment || Missing", // undefined criterion
+		"Nonattainment +",          // invalid syntax
+		"Nonattainment + 1",        // invalid operation
+		"Nonattainment ? 1 : 0",    // non-boolean result
+	} {
+		cfg.RefinementRule = rule
+		if _, err := cfg.RefinementMutator(popIndices); err == nil {
+			t.Errorf("rule %s should cause an error", rule)
+		}
+	}
+}
@@ -20,6 +20,8 @@ package inmaputil
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -61,9 +63,18 @@ func CloudJobStart(ctx context.Context, c cloudrpc.CloudRPCClient, cfg *Cfg) err
 	if err != nil {
 		return err
 	}
+	in.Priority = int32(cfg.GetInt("priority"))
+	restore, err := cloud.RemoveStagedInputs(ctx, c, in)
+	if err != nil {
+		return err
+	}
 	return backoff.RetryNotify(
 		func() error {
 			_, err = c.RunJob(ctx, in)
+			if cloud.IsNotStaged(err) {
+				// Upload all of the input files on the next try.
+				restore()
+			}
 			return err
 		},
 		backoff.NewExponentialBackOff(),
@@ -83,6 +94,43 @@ func CloudJobStatus(ctx context.Context, c cloudrpc.CloudRPCClient, cfg *Cfg) (*
 	return c.Status(ctx, in)
 }
 
+// CloudJobLogs writes the log output of a cloud job to w as it runs,
+// based on the information in cfg. Changes in the job status,
+// such as the job's position in the queue, are also written.
+// It returns the final status of the job.
+func CloudJobLogs(ctx context.Context, c cloudrpc.CloudRPCClient, cfg *Cfg, w io.Writer) (*cloudrpc.JobStatus, error) {
+	in := &cloudrpc.JobName{
+		Version: inmap.Version,
+		Name:    cfg.GetString("job_name"),
+	}
+	stream, err := c.Logs(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	var status *cloudrpc.JobStatus
+	for {
+		l, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if l.Message != "" {
+			fmt.Fprintln(w, l.Message)
+		}
+		if l.Status != nil {
+			status = l.Status
+			if status.Status == cloudrpc.Status_Waiting {
+				fmt.Fprintf(w, "%s: queue position %d\n", status.Status, status.QueuePosition)
+			}
+		}
+	}
+	if status == nil {
+		return nil, fmt.Errorf("inmaputil: no status received for job %s", in.Name)
+	}
+	return status, nil
+}
+
 // CloudJobOutput retrieves and saves the output of a cloud job
 // based on the information in cfg. The files will be saved
 // in `current_dir/job_name`, where current_dir is the directory
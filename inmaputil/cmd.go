@@ -34,6 +34,7 @@ import (
 	"github.com/lnashier/viper"
 	"github.com/skratchdot/open-golang/open"
 	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/cloud/cloudrpc"
 	"github.com/spatialmodel/inmap/science/chem/simplechem"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -51,9 +52,9 @@ type Cfg struct {
 	// files.
 	outputFiles []string
 
-	Root, versionCmd, runCmd, preprocCmd, combineCmd, steadyCmd, gridCmd    *cobra.Command
-	srCmd, srPredictCmd, srStartCmd, srSaveCmd, srCleanCmd                  *cobra.Command
-	cloudCmd, cloudStartCmd, cloudStatusCmd, cloudOutputCmd, cloudDeleteCmd *cobra.Command
+	Root, versionCmd, runCmd, preprocCmd, combineCmd, steadyCmd, gridCmd, gridInfoCmd *cobra.Command
+	srCmd, srPredictCmd, srStartCmd, srSaveCmd, srCleanCmd, srValidateCmd             *cobra.Command
+	cloudCmd, cloudStartCmd, cloudStatusCmd, cloudOutputCmd, cloudDeleteCmd           *cobra.Command
 }
 
 // InputFiles returns the names of the configuration options that are input
@@ -167,7 +168,7 @@ concentrations with no temporal variability.`,
 				return err
 			}
 
-			inventoryConfig, spatialConfig, err := aeputilConfig(cfg.Viper)
+			inventoryConfig, spatialConfig, speciateConfig, err := aeputilConfig(cfg.Viper)
 			if err != nil {
 				return err
 			}
@@ -183,6 +184,7 @@ concentrations with no temporal variability.`,
 				vgc,
 				inventoryConfig,
 				spatialConfig,
+				speciateConfig,
 				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
 				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
 				cfg.GetInt("NumIterations"),
@@ -214,6 +216,34 @@ for future InMAP simulations.`,
 		DisableAutoGenTag: true,
 	}
 
+	// gridInfoCmd is a command that reports statistics about a saved
+	// variable resolution grid.
+	cfg.gridInfoCmd = &cobra.Command{
+		Use:   "info",
+		Short: "Report statistics about a variable resolution grid",
+		Long: `info loads the variable resolution grid in VariableGridData and reports
+the number of grid cells in each layer and nest level, the minimum, maximum,
+and mean grid cell sizes, the total population and the fraction of the
+population in the finest nest level, and the number of grid cells above
+the population density threshold. A map of the ground-level grid cells,
+colored by nest level, is written to GridInfo.MapFile.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			_, err = GridInfo(
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
+				vgc, cmd.OutOrStdout(),
+				os.ExpandEnv(cfg.GetString("GridInfo.MapFile")),
+			)
+			return err
+		},
+		DisableAutoGenTag: true,
+	}
+
 	cfg.preprocCmd = &cobra.Command{
 		Use:   "preproc",
 		Short: "Preprocess CTM output",
@@ -409,7 +439,7 @@ a source-receptor matrix.`,
 		Use:   "start",
 		Short: "Start a job on a Kubernetes cluster.",
 		Long: "Start a job on a Kubernetes cluster. Of the flags available to this command, " +
-			"'cmds', 'storage_gb', and 'memory_gb' relate to the creation of the job." +
+			"'cmds', 'storage_gb', 'memory_gb', and 'priority' relate to the creation of the job." +
 			" All other flags and configuation file information are used to configure the remote simulation.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			c, err := NewCloudClient(cfg)
@@ -432,11 +462,19 @@ a source-receptor matrix.`,
 				return err
 			}
 			ctx := context.Background()
-			status, err := CloudJobStatus(ctx, c, cfg)
+			var status *cloudrpc.JobStatus
+			if cfg.GetBool("follow") {
+				status, err = CloudJobLogs(ctx, c, cfg, os.Stdout)
+			} else {
+				status, err = CloudJobStatus(ctx, c, cfg)
+			}
 			if err != nil {
 				return err
 			}
 			fmt.Println(status.Status)
+			if status.QueuePosition > 0 {
+				fmt.Printf("Queue position: %d\n", status.QueuePosition)
+			}
 			if status.Message != "" {
 				fmt.Println(status.Message)
 			}
@@ -518,6 +556,11 @@ variable specifies the information to be output.`,
 				return err
 			}
 
+			aboveTop, err := checkAboveTop(cfg.GetString("SR.AboveTop"))
+			if err != nil {
+				return err
+			}
+
 			return SRPredict(
 				emisUnits,
 				os.ExpandEnv(cfg.GetString("SR.OutputFile")),
@@ -525,20 +568,83 @@ variable specifies the information to be output.`,
 				outputVars,
 				shapeFiles,
 				mask,
+				aboveTop,
 				vgc,
 			)
 		},
 		DisableAutoGenTag: true,
 	}
 
+	// srValidateCmd is a command that compares SR matrix predictions to
+	// full model simulations.
+	cfg.srValidateCmd = &cobra.Command{
+		Use:   "validate",
+		Short: "Compare SR matrix predictions to full InMAP simulations",
+		Long: `validate quantifies the differences between concentrations predicted by the
+SR matrix specified in the configuration file field SR.OutputFile and
+concentrations calculated by full InMAP simulations using the static
+variable resolution grid in VariableGridData. Each of the emissions shapefiles
+in SR.ValidationScenarios represents one emissions scenario. Normalized mean bias,
+normalized mean error, and correlation for each species are written to
+the file sr_validation.csv in the directory SR.ValidationDir, along with
+shapefiles of the full simulation results, the SR predictions, and the
+differences between them. Emissions with plume heights above the top layer
+of the SR matrix are handled as specified by SR.AboveTop.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outChan := outChan()
+
+			vgc, err := VarGridConfig(cfg.Viper)
+			if err != nil {
+				return err
+			}
+			emisUnits, err := checkEmissionUnits(cfg.GetString("EmissionUnits"))
+			if err != nil {
+				return err
+			}
+
+			scenarios := removeShpSupportFiles(expandStringSlice(cfg.GetStringSlice("SR.ValidationScenarios")))
+			for i := range scenarios {
+				scenarios[i] = maybeDownload(context.TODO(), scenarios[i], outChan)
+			}
+
+			mask, err := parseMask(maybeDownload(context.Background(), cfg.GetString("EmissionMaskGeoJSON"), outChan))
+			if err != nil {
+				return err
+			}
+
+			aboveTop, err := checkAboveTop(cfg.GetString("SR.AboveTop"))
+			if err != nil {
+				return err
+			}
+
+			_, err = SRValidate(
+				cmd,
+				emisUnits,
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("SR.OutputFile")), outChan),
+				aboveTop,
+				os.ExpandEnv(cfg.GetString("SR.ValidationDir")),
+				scenarios,
+				cfg.GetInt("SR.ValidationSample"),
+				mask,
+				vgc,
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("InMAPData")), outChan),
+				maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("VariableGridData")), outChan),
+				cfg.GetInt("NumIterations"),
+			)
+			return err
+		},
+		DisableAutoGenTag: true,
+	}
+
 	// Link the commands together.
 	cfg.Root.AddCommand(cfg.versionCmd)
 	cfg.Root.AddCommand(cfg.runCmd)
 	cfg.runCmd.AddCommand(cfg.steadyCmd)
 	cfg.Root.AddCommand(cfg.gridCmd)
+	cfg.gridCmd.AddCommand(cfg.gridInfoCmd)
 	cfg.Root.AddCommand(cfg.preprocCmd)
 	cfg.Root.AddCommand(cfg.srCmd)
-	cfg.srCmd.AddCommand(cfg.srStartCmd, cfg.srSaveCmd, cfg.srCleanCmd)
+	cfg.srCmd.AddCommand(cfg.srStartCmd, cfg.srSaveCmd, cfg.srCleanCmd, cfg.srValidateCmd)
 	cfg.Root.AddCommand(cfg.srPredictCmd)
 	cfg.Root.AddCommand(cfg.cloudCmd)
 	cfg.cloudCmd.AddCommand(cfg.cloudStartCmd, cfg.cloudStatusCmd, cfg.cloudOutputCmd, cfg.cloudDeleteCmd)
@@ -600,73 +706,73 @@ variable specifies the information to be output.`,
 			usage: `VarGrid.VariableGridXo specifies the X coordinate of the lower-left corner of the InMAP grid.
 `,
 			defaultVal: -4000.0,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name:       "VarGrid.VariableGridYo",
 			usage:      `VarGrid.VariableGridYo specifies the Y coordinate of the lower-left corner of the InMAP grid.`,
 			defaultVal: -4000.0,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.VariableGridDx",
 			usage: `VarGrid.VariableGridDx specifies the X edge lengths of grid cells in the outermost nest, in the units of the grid model spatial projection--typically meters or degrees latitude and longitude.
 `,
 			defaultVal: 4000.0,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.VariableGridDy",
 			usage: `VarGrid.VariableGridDy specifies the Y edge lengths of grid cells in the outermost nest, in the units of the grid model spatial projection--typically meters or degrees latitude and longitude.
 `,
 			defaultVal: 4000.0,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name:       "VarGrid.Xnests",
 			usage:      `Xnests specifies nesting multiples in the X direction.`,
 			defaultVal: []int{2, 2, 2},
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name:       "VarGrid.Ynests",
 			usage:      `Ynests specifies nesting multiples in the Y direction.`,
 			defaultVal: []int{2, 2, 2},
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name:       "VarGrid.GridProj",
 			usage:      `GridProj gives projection info for the CTM grid in Proj4 or WKT format.`,
 			defaultVal: "+proj=lcc +lat_1=33.000000 +lat_2=45.000000 +lat_0=40.000000 +lon_0=-97.000000 +x_0=0 +y_0=0 +a=6370997.000000 +b=6370997.000000 +to_meter=1",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srPredictCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.srValidateCmd.Flags(), cfg.gridInfoCmd.Flags()},
 		},
 		{
 			name: "VarGrid.HiResLayers",
 			usage: `HiResLayers is the number of layers, starting at ground level, to do nesting in. Layers above this will have all grid cells in the lowest spatial resolution. This option is only used with static grids.
 `,
 			defaultVal: 1,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.PopDensityThreshold",
 			usage: `PopDensityThreshold is a limit for people per unit area in a grid cell in units of people / m². If the population density in a grid cell is above this level, the cell in question is a candidate for splitting into smaller cells. This option is only used with static grids.
 `,
 			defaultVal: 0.0055,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.PopThreshold",
 			usage: `PopThreshold is a limit for the total number of people in a grid cell. If the total population in a grid cell is above this level, the cell in question is a candidate for splitting into smaller cells. This option is only used with static grids.
 `,
 			defaultVal: 40000.0,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.PopConcThreshold",
 			usage: `PopConcThreshold is the limit for Σ(|ΔConcentration|)*combinedVolume*|ΔPopulation| / {Σ(|totalMass|)*totalPopulation}. See the documentation for PopConcMutator for more information. This option is only used with dynamic grids.
 `,
 			defaultVal: 0.000000001,
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.CensusFile",
@@ -674,21 +780,21 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testPopulation.shp",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.CensusPopColumns",
 			usage: `VarGrid.CensusPopColumns is a list of the data fields in CensusFile that should be included as population estimates in the model. They can be population of different demographics or for different population scenarios.
 `,
 			defaultVal: []string{"TotalPop", "WhiteNoLat", "Black", "Native", "Asian", "Latino"},
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags(), cfg.gridInfoCmd.Flags()},
 		},
 		{
 			name: "VarGrid.PopGridColumn",
 			usage: `VarGrid.PopGridColumn is the name of the field in CensusFile that contains the data that should be compared to PopThreshold and PopDensityThreshold when determining if a grid cell should be split. It should be one of the fields in CensusPopColumns.
 `,
 			defaultVal: "TotalPop",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.MortalityRateFile",
@@ -696,7 +802,7 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testMortalityRate.shp",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VarGrid.MortalityRateColumns",
@@ -710,7 +816,30 @@ variable specifies the information to be output.`,
 				"AsianMort":  "Asian",
 				"LatinoMort": "Latino",
 			},
-			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets: []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags(), cfg.gridInfoCmd.Flags()},
+		},
+		{
+			name: "VarGrid.RefinementCriteria",
+			usage: `VarGrid.RefinementCriteria specifies criteria, in addition to population, for dividing grid cells in static variable resolution grids. It is a map where the keys are names that can be used in VarGrid.RefinementRule, and the values have the fields "Type", "File", "Distance", "Threshold", and "EmissionUnits". Type can be "polygon" to divide cells that overlap the polygons in shapefile File (for example, nonattainment areas); "point" to divide cells within Distance (in grid units) of the points in shapefile File (for example, point source locations); or "emissions" to divide cells where the density of emissions in the emissions shapefile File is greater than Threshold (in μg/s per square grid unit). EmissionUnits specifies the units of the emissions in File for the "emissions" type. This option is only used with static grids. Example: {"Nonattainment": {"Type": "polygon", "File": "nonattainment.shp"}}
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
+		},
+		{
+			name: "VarGrid.RefinementRule",
+			usage: `VarGrid.RefinementRule is a boolean expression specifying how the criteria in VarGrid.RefinementCriteria and the built-in "Population" criterion (which uses VarGrid.PopThreshold and VarGrid.PopDensityThreshold) are combined to determine whether a grid cell should be divided. Criteria can be combined using "&&" (AND), "||" (OR), and parentheses, for example "Population || (Nonattainment && PointSources)". This option is only used with static grids.
+`,
+			defaultVal: "Population",
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
+		},
+		{
+			name: "VarGrid.GridFile",
+			usage: `VarGrid.GridFile is the path to a shapefile containing polygons, such as census tracts or hexagons, to be used as the horizontal grid cells instead of a regular grid with nested subdivisions. If it is specified, VarGrid.VariableGridXo, VarGrid.VariableGridYo, VarGrid.VariableGridDx, VarGrid.VariableGridDy, VarGrid.Xnests, VarGrid.Ynests, and VarGrid.HiResLayers are ignored, and grid cells are not divided. Grid cells that share an edge are treated as neighbors, so adjacent polygons should have coincident vertices. The path can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "InMAPData",
@@ -718,7 +847,7 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/testInMAPInputData.ncf",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.Flags(), cfg.preprocCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.Flags(), cfg.preprocCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "VariableGridData",
@@ -726,7 +855,15 @@ variable specifies the information to be output.`,
 `,
 			defaultVal:  "${INMAP_ROOT_DIR}/cmd/inmap/testdata/inmapVarGrid.gob",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.PersistentFlags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.gridCmd.Flags(), cfg.srStartCmd.PersistentFlags(), cfg.srValidateCmd.Flags(), cfg.gridInfoCmd.Flags()},
+		},
+		{
+			name: "GridInfo.MapFile",
+			usage: `GridInfo.MapFile is the path where a PNG map of the ground-level grid cells, colored by nest level, should be written. If it is empty, no map is created. The path can include environment variables.
+`,
+			defaultVal:   "grid.png",
+			isOutputFile: true,
+			flagsets:     []*pflag.FlagSet{cfg.gridInfoCmd.Flags()},
 		},
 		{
 			name: "EmissionsShapefiles",
@@ -741,14 +878,14 @@ variable specifies the information to be output.`,
 			usage:       `EmissionMaskGeoJSON is an optional file containing a GeoJSON-formatted polygon string that specifies the area outside of which emissions will be ignored. The mask is assumed to  use the same spatial reference as VarGrid.GridProj. Example="{\"type\": \"Polygon\",\"coordinates\": [ [ [-4000, -4000], [4000, -4000], [4000, 4000], [-4000, 4000] ] ] }"`,
 			defaultVal:  "",
 			isInputFile: true,
-			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:    []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "EmissionUnits",
 			usage: `EmissionUnits gives the units that the input emissions are in. Acceptable values are 'tons/year', 'kg/year', 'ug/s', and 'μg/s'.
 `,
 			defaultVal: "tons/year",
-			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.runCmd.PersistentFlags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "OutputFile",
@@ -788,7 +925,7 @@ variable specifies the information to be output.`,
 			usage: `NumIterations is the number of iterations to calculate. If < 1, convergence is automatically calculated.
 `,
 			defaultVal: 0,
-			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags(), cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "aep.InventoryConfig.NEIFiles",
@@ -819,6 +956,36 @@ variable specifies the information to be output.`,
 			defaultVal: "no_default",
 			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
+		{
+			name: "aep.InventoryConfig.ProjectionFiles",
+			usage: `ProjectionFiles lists files holding SMOKE-style projection packets (/PROJECTION <from year> <to year>/) used to project the emissions records from ProjectionBaseYear to ProjectionYear after they are read. The file names can include environment variables.
+`,
+			defaultVal:  []string{},
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.InventoryConfig.ProjectionBaseYear",
+			usage: `ProjectionBaseYear specifies the year of the input emissions when ProjectionFiles is specified.
+`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.InventoryConfig.ProjectionYear",
+			usage: `ProjectionYear specifies the year the input emissions should be projected to when ProjectionFiles is specified. It must be different from ProjectionBaseYear.
+`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.InventoryConfig.ControlFiles",
+			usage: `ControlFiles lists files holding SMOKE-style control strategy packets (/CONTROL/ and /ALLOWABLE/) to be applied to the emissions records after they are read. The file names can include environment variables. Emissions caps and replacements are in the units specified by InputUnits.
+`,
+			defaultVal:  []string{},
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
 		{
 			name: "aep.SrgSpecSMOKE",
 			usage: `SrgSpecSMOKE gives the location of the SMOKE-format surrogate specification file, if any. It is used for assigning spatial locations to emissions records.
@@ -830,6 +997,14 @@ variable specifies the information to be output.`,
 		{
 			name: "aep.SrgSpecOSM",
 			usage: `SrgSpecOSM gives the location of the OSM-format surrogate specification file, if any. It is used for assigning spatial locations to emissions records.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SrgSpecRaster",
+			usage: `SrgSpecRaster gives the location of the raster surrogate specification file, if any. It is used for assigning spatial locations to emissions records using GeoTIFF or COARDS NetCDF raster data. Raster file paths in the specification are relative to the directory containing it. For cloud runs, local raster files are uploaded along with the specification.
 `,
 			defaultVal:  "",
 			isInputFile: true,
@@ -851,6 +1026,14 @@ loading the data.`,
 			defaultVal: "",
 			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
+		{
+			name: "aep.OSMFile",
+			usage: `OSMFile gives the location of a local OpenStreetMap data file (in .osm.pbf or .osm format) to read OSM surrogate data from. If it is specified, it is used instead of the PostGIS database specified by PostGISURL, and the surrogate geometry extracted from it is cached in SrgDataCache.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
 		{
 			name: "aep.SrgShapefileDirectory",
 			usage: `SrgShapefileDirectory gives the location of the directory holding the shapefiles used for creating spatial surrogates. It is used for assigning spatial locations to emissions records. It is only used when SrgSpecType == "SMOKE".
@@ -873,6 +1056,100 @@ loading the data.`,
 			defaultVal: true,
 			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
 		},
+		{
+			name: "aep.SpeciateConfig.SpecRef",
+			usage: `SpecRef gives the location of the SMOKE speciation cross-reference file, which assigns speciation profiles to emissions source types. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.SpecRefCombo",
+			usage: `SpecRefCombo gives the location of the SMOKE combination speciation profile file, which assigns combinations of speciation profiles to emissions source types in specific locations. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.SpeciesProperties",
+			usage: `SpeciesProperties gives the location of the SPECIATE database species properties table. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.GasProfile",
+			usage: `GasProfile gives the location of the SPECIATE database gas profile table. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.GasSpecies",
+			usage: `GasSpecies gives the location of the SPECIATE database gas species table. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.OtherGasSpecies",
+			usage: `OtherGasSpecies gives the location of the SPECIATE database other gas species table. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.PMSpecies",
+			usage: `PMSpecies gives the location of the SPECIATE database PM species table. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.MechAssignment",
+			usage: `MechAssignment gives the location of the table that assigns SPECIATE species to the model species of each chemical mechanism. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.MolarWeight",
+			usage: `MolarWeight gives the location of the table of the molar weights of the model species of each chemical mechanism. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.SpeciesInfo",
+			usage: `SpeciesInfo gives the location of the table of chemical mechanism information for each SPECIATE species. It is only used if ChemicalMechanism is specified. It can include environment variables.
+`,
+			defaultVal:  "",
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.ChemicalMechanism",
+			usage: `ChemicalMechanism specifies the chemical mechanism (for example, "CB6", "SAPRC07", or "RACM2") whose model species AEP emissions should be speciated into. It must be one of the mechanisms in the MechAssignment and MolarWeight tables. If it is empty, AEP emissions are not speciated, and the inventory pollutants VOC, NOx, NH3, SOx, and PM2_5 are used directly as the corresponding InMAP species. If it is specified, the speciated emissions total of each model species is written to the log file.
+`,
+			defaultVal: "",
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name: "aep.SpeciateConfig.SpeciesGroups",
+			usage: `SpeciesGroups specifies which model species in ChemicalMechanism should be included in each of the InMAP species VOC, NOx, NH3, SOx, and PM2_5. The format is map[InMAP species][list of model species]. Model species that are not included in any group are ignored. It is only used if ChemicalMechanism is specified.
+`,
+			defaultVal: map[string][]string{},
+			flagsets:   []*pflag.FlagSet{cfg.steadyCmd.Flags(), cfg.cloudStartCmd.Flags()},
+		},
 		{
 			name: "aep.SpatialConfig.InputSR",
 			usage: `InputSR specifies the input emissions spatial reference in Proj4 format.
@@ -914,7 +1191,36 @@ loading the data.`,
 			defaultVal:   "${INMAP_ROOT_DIR}/cmd/inmap/testdata/output_${InMAPRunType}.shp",
 			isOutputFile: false,
 			isInputFile:  false,
-			flagsets:     []*pflag.FlagSet{cfg.srSaveCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags()},
+			flagsets:     []*pflag.FlagSet{cfg.srSaveCmd.Flags(), cfg.srPredictCmd.Flags(), cfg.cloudStartCmd.Flags(), cfg.srValidateCmd.Flags()},
+		},
+		{
+			name: "SR.AboveTop",
+			usage: `SR.AboveTop specifies how emissions with plume heights above the top layer of the SR matrix are handled when making predictions with the SR matrix, including when validating it. Options are "error", where the emissions are allocated to the top layer and a warning is logged; "clamp", where the emissions are allocated to the top layer without a warning; and "extrapolate", where the impacts of the emissions are linearly extrapolated from the two highest layers in the SR matrix based on the plume height.
+`,
+			defaultVal: "error",
+			flagsets:   []*pflag.FlagSet{cfg.srPredictCmd.Flags(), cfg.srValidateCmd.Flags()},
+		},
+		{
+			name: "SR.ValidationScenarios",
+			usage: `SR.ValidationScenarios are the paths to emissions shapefiles, each of which represents an emissions scenario to be used for comparing SR matrix predictions to full InMAP simulations. The shapefile format is the same as for EmissionsShapefiles. Can include environment variables.
+`,
+			defaultVal:  []string{"${INMAP_ROOT_DIR}/cmd/inmap/testdata/testEmisSR.shp"},
+			isInputFile: true,
+			flagsets:    []*pflag.FlagSet{cfg.srValidateCmd.Flags()},
+		},
+		{
+			name: "SR.ValidationSample",
+			usage: `SR.ValidationSample specifies the number of scenarios in SR.ValidationScenarios to randomly sample for comparison. If it is less than 1, all of the scenarios will be used.
+`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.srValidateCmd.Flags()},
+		},
+		{
+			name: "SR.ValidationDir",
+			usage: `SR.ValidationDir is the directory where SR matrix validation results should be written. It can contain environment variables.
+`,
+			defaultVal: "sr_validation",
+			flagsets:   []*pflag.FlagSet{cfg.srValidateCmd.Flags()},
 		},
 		{
 			name: "Preproc.CTMType",
@@ -1078,6 +1384,18 @@ loading the data.`,
 			defaultVal: 20,
 			flagsets:   []*pflag.FlagSet{cfg.cloudStartCmd.Flags(), cfg.srStartCmd.Flags()},
 		},
+		{
+			name:       "priority",
+			usage:      `priority specifies the priority of this job in the job queue. Jobs with higher priorities are run first.`,
+			defaultVal: 0,
+			flagsets:   []*pflag.FlagSet{cfg.cloudStartCmd.Flags()},
+		},
+		{
+			name:       "follow",
+			usage:      `follow specifies that the log output of the job should be displayed as the job runs, and that the command should not exit until the job has finished.`,
+			defaultVal: false,
+			flagsets:   []*pflag.FlagSet{cfg.cloudStatusCmd.Flags()},
+		},
 		{
 			name:       "version",
 			usage:      `version specifies the version of the InMAP Docker container to use, such as "latest" or "v1.7.2".`,
@@ -37,6 +37,7 @@ import (
 	"github.com/spatialmodel/inmap/cloud"
 	"github.com/spatialmodel/inmap/emissions/aep"
 	"github.com/spatialmodel/inmap/emissions/aep/aeputil"
+	"github.com/spatialmodel/inmap/sr"
 	"github.com/spf13/cast"
 )
 
@@ -121,6 +122,23 @@ func checkEmissionUnits(u string) (string, error) {
 	return u, nil
 }
 
+// checkAboveTop expands any environment variables in the SR matrix
+// above-top-layer handling mode and converts it to an sr.AboveTopMode.
+func checkAboveTop(m string) (sr.AboveTopMode, error) {
+	m = os.ExpandEnv(m)
+	switch strings.ToLower(m) {
+	case "error":
+		return sr.AboveTopError, nil
+	case "clamp":
+		return sr.AboveTopClamp, nil
+	case "extrapolate":
+		return sr.AboveTopExtrapolate, nil
+	default:
+		return sr.AboveTopError, fmt.Errorf("the SR.AboveTop variable in the configuration file "+
+			"needs to be set to either error, clamp, or extrapolate, but is currently set to `%s`", m)
+	}
+}
+
 // spatialRef returns the spatial reference associated with config,
 // as defined by the GridProj field.
 func spatialRef(config *inmap.VarGridConfig) (*proj.SR, error) {
@@ -164,6 +182,17 @@ func VarGridConfig(cfg *viper.Viper) (*inmap.VarGridConfig, error) {
 		MortalityRateFile:    maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.MortalityRateFile")), outChan()),
 		MortalityRateColumns: GetStringMapString("VarGrid.MortalityRateColumns", cfg),
 		GridProj:             os.ExpandEnv(cfg.GetString("VarGrid.GridProj")),
+		RefinementRule:       cfg.GetString("VarGrid.RefinementRule"),
+		GridFile:             maybeDownload(ctx, os.ExpandEnv(cfg.GetString("VarGrid.GridFile")), outChan()),
+	}
+
+	c.RefinementCriteria, err = getRefinementCriteria("VarGrid.RefinementCriteria", cfg)
+	if err != nil {
+		return nil, fmt.Errorf("VarGrid.RefinementCriteria: %v", err)
+	}
+	for k, v := range c.RefinementCriteria {
+		v.File = maybeDownload(ctx, os.ExpandEnv(v.File), outChan())
+		c.RefinementCriteria[k] = v
 	}
 
 	vars := []float64{c.VariableGridDx, c.VariableGridDy}
@@ -192,13 +221,15 @@ func VarGridConfig(cfg *viper.Viper) (*inmap.VarGridConfig, error) {
 	return &c, nil
 }
 
-// aeputilConfig unmarshals an aeputil inventory and spatial configuration.
-func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.SpatialConfig, error) {
+// aeputilConfig unmarshals an aeputil inventory, spatial, and speciation
+// configuration. The speciation configuration is nil if no chemical
+// mechanism is specified.
+func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.SpatialConfig, *aeputil.SpeciateConfig, error) {
 	outChan := outChan()
 
 	neiFiles, err := getStringMapStringSlice("aep.InventoryConfig.NEIFiles", cfg)
 	if err != nil {
-		return nil, nil, fmt.Errorf("inmaputil: parsing config variable aep.InventoryConfig.NEIFiles: %v", err)
+		return nil, nil, nil, fmt.Errorf("inmaputil: parsing config variable aep.InventoryConfig.NEIFiles: %v", err)
 	}
 	for k, vs := range neiFiles {
 		for i, v := range vs {
@@ -208,7 +239,7 @@ func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.Spatial
 
 	coardsFiles, err := getStringMapStringSlice("aep.InventoryConfig.COARDSFiles", cfg)
 	if err != nil {
-		return nil, nil, fmt.Errorf("inmaputil: parsing config variable aep.InventoryConfig.COARDSFiles: %v", err)
+		return nil, nil, nil, fmt.Errorf("inmaputil: parsing config variable aep.InventoryConfig.COARDSFiles: %v", err)
 	}
 	for k, vs := range coardsFiles {
 		for i, v := range vs {
@@ -218,10 +249,20 @@ func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.Spatial
 
 	srgSpecSMOKE := maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("aep.SrgSpecSMOKE")), outChan)
 	srgSpecOSM := maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("aep.SrgSpecOSM")), outChan)
+	srgSpecRaster := maybeDownloadSrgSpecRaster(context.TODO(), os.ExpandEnv(cfg.GetString("aep.SrgSpecRaster")), outChan)
+	osmFile := maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("aep.OSMFile")), outChan)
 	var gridRef []string
 	for _, g := range cfg.GetStringSlice("aep.GridRef") {
 		gridRef = append(gridRef, maybeDownload(context.TODO(), g, outChan))
 	}
+	var projectionFiles []string
+	for _, f := range cfg.GetStringSlice("aep.InventoryConfig.ProjectionFiles") {
+		projectionFiles = append(projectionFiles, maybeDownload(context.TODO(), os.ExpandEnv(f), outChan))
+	}
+	var controlFiles []string
+	for _, f := range cfg.GetStringSlice("aep.InventoryConfig.ControlFiles") {
+		controlFiles = append(controlFiles, maybeDownload(context.TODO(), os.ExpandEnv(f), outChan))
+	}
 
 	i := &aeputil.InventoryConfig{
 		NEIFiles:              neiFiles,
@@ -231,9 +272,14 @@ func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.Spatial
 		SrgSpecSMOKE:          srgSpecSMOKE,
 		SrgSpecOSM:            srgSpecOSM,
 		PostGISURL:            os.ExpandEnv(cfg.GetString("aep.PostGISURL")),
+		OSMFile:               osmFile,
 		SrgShapefileDirectory: cfg.GetString("aep.SrgShapefileDirectory"),
 		GridRef:               gridRef,
 		SCCExactMatch:         cfg.GetBool("aep.SCCExactMatch"),
+		ProjectionFiles:       projectionFiles,
+		ProjectionBaseYear:    cfg.GetInt("aep.InventoryConfig.ProjectionBaseYear"),
+		ProjectionYear:        cfg.GetInt("aep.InventoryConfig.ProjectionYear"),
+		ControlFiles:          controlFiles,
 	}
 	i.PolsToKeep = aep.Speciation{
 		"VOC":   {},
@@ -246,7 +292,9 @@ func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.Spatial
 	s := &aeputil.SpatialConfig{
 		SrgSpecSMOKE:          srgSpecSMOKE,
 		SrgSpecOSM:            srgSpecOSM,
+		SrgSpecRaster:         srgSpecRaster,
 		PostGISURL:            os.ExpandEnv(cfg.GetString("aep.PostGISURL")),
+		OSMFile:               osmFile,
 		SrgShapefileDirectory: cfg.GetString("aep.SrgShapefileDirectory"),
 		SCCExactMatch:         cfg.GetBool("aep.SCCExactMatch"),
 		GridRef:               gridRef,
@@ -258,7 +306,81 @@ func aeputilConfig(cfg *viper.Viper) (*aeputil.InventoryConfig, *aeputil.Spatial
 		GridName:              cfg.GetString("aep.SpatialConfig.GridName"),
 	}
 
-	return i, s, nil
+	if cfg.GetString("aep.SpeciateConfig.ChemicalMechanism") == "" {
+		return i, s, nil, nil
+	}
+	sp, err := speciateConfig(cfg, i.PolsToKeep, outChan)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return i, s, sp, nil
+}
+
+// inmapSpecies are the InMAP species that AEP emissions can be
+// mapped to.
+var inmapSpecies = []string{"VOC", "NOx", "NH3", "SOx", "PM2_5"}
+
+// speciateConfig unmarshals an aeputil speciation configuration and sets
+// polsToKeep to speciate the inventory pollutants into the
+// configured chemical mechanism.
+func speciateConfig(cfg *viper.Viper, polsToKeep aep.Speciation, outChan chan string) (*aeputil.SpeciateConfig, error) {
+	file := func(name string) string {
+		return maybeDownload(context.TODO(), os.ExpandEnv(cfg.GetString("aep.SpeciateConfig."+name)), outChan)
+	}
+	groups, err := getStringMapStringSlice("aep.SpeciateConfig.SpeciesGroups", cfg)
+	if err != nil {
+		return nil, fmt.Errorf("inmaputil: parsing config variable aep.SpeciateConfig.SpeciesGroups: %v", err)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("inmaputil: aep.SpeciateConfig.SpeciesGroups must be specified when aep.SpeciateConfig.ChemicalMechanism is specified")
+	}
+	for g := range groups {
+		var ok bool
+		for _, s := range inmapSpecies {
+			if g == s {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("inmaputil: invalid aep.SpeciateConfig.SpeciesGroups group %q; valid groups are %v", g, inmapSpecies)
+		}
+	}
+
+	// Speciate VOC, NOx, and PM2.5 using the speciation profiles, and
+	// assign NH3 and SOx to their SPECIATE species.
+	setPol := func(name string, specType aep.SpeciationType, specName string) {
+		p := polsToKeep[name]
+		p.SpecType = specType
+		if specName != "" {
+			p.SpecNames.Names = []string{specName}
+		}
+		polsToKeep[name] = p
+	}
+	setPol("VOC", aep.VOC, "")
+	setPol("NOx", aep.NOx, "")
+	setPol("PM2_5", aep.PM25, "")
+	setPol("NH3", "", "Ammonia")
+	setPol("SOx", "", "Sulfur dioxide")
+
+	return &aeputil.SpeciateConfig{
+		SpecRef:           file("SpecRef"),
+		SpecRefCombo:      file("SpecRefCombo"),
+		SpeciesProperties: file("SpeciesProperties"),
+		GasProfile:        file("GasProfile"),
+		GasSpecies:        file("GasSpecies"),
+		OtherGasSpecies:   file("OtherGasSpecies"),
+		PMSpecies:         file("PMSpecies"),
+		MechAssignment:    file("MechAssignment"),
+		MolarWeight:       file("MolarWeight"),
+		SpeciesInfo:       file("SpeciesInfo"),
+		ChemicalMechanism: cfg.GetString("aep.SpeciateConfig.ChemicalMechanism"),
+		// InMAP emissions must be in units of mass.
+		MassSpeciation: true,
+		SCCExactMatch:  cfg.GetBool("aep.SCCExactMatch"),
+		Speciation:     polsToKeep,
+		SpeciesGroups:  groups,
+	}, nil
 }
 
 func toIntSliceE(s interface{}) ([]int, error) {
@@ -325,6 +447,37 @@ func getStringMapStringSlice(varName string, cfg *viper.Viper) (map[string][]str
 	}
 }
 
+// getRefinementCriteria returns a map[string]inmap.RefinementCriterion from
+// a viper configuration, accounting for the fact that it might be a json
+// object if it was set from a command line argument.
+func getRefinementCriteria(varName string, cfg *viper.Viper) (map[string]inmap.RefinementCriterion, error) {
+	o := make(map[string]inmap.RefinementCriterion)
+	var b []byte
+	switch i := cfg.Get(varName).(type) {
+	case nil:
+		return o, nil
+	case map[string]inmap.RefinementCriterion:
+		return i, nil
+	case map[string]interface{}:
+		var err error
+		b, err = json.Marshal(i)
+		if err != nil {
+			return nil, err
+		}
+	case string:
+		if i == "" {
+			return o, nil
+		}
+		b = []byte(i)
+	default:
+		return nil, fmt.Errorf("invalid type %T", i)
+	}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
 // parseMask returns a mask polygon represented by the
 // given GeoJSON file.
 func parseMask(maskGeoJSONFile string) (geom.Polygon, error) {
@@ -25,6 +25,7 @@ import (
 	"testing"
 
 	"github.com/ctessum/geom"
+	"github.com/spatialmodel/inmap/emissions/aep"
 )
 
 func TestParseMask(t *testing.T) {
@@ -65,3 +66,64 @@ func TestParseMask(t *testing.T) {
 	})
 
 }
+
+func TestAeputilConfig_speciate(t *testing.T) {
+	cfg := InitializeConfig()
+	_, _, sp, err := aeputilConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp != nil {
+		t.Errorf("speciation should be nil without a chemical mechanism")
+	}
+	wantPols := map[string][]aep.Pollutant{
+		"VOC": {{Name: "VOC"}}, "NOx": {{Name: "NOx"}}, "NH3": {{Name: "NH3"}},
+		"SOx": {{Name: "SOx"}}, "PM2_5": {{Name: "PM2_5"}},
+	}
+	if pols := aepSpecies(sp); !reflect.DeepEqual(pols, wantPols) {
+		t.Errorf("unspeciated pollutants: %v != %v", pols, wantPols)
+	}
+
+	const dir = "${INMAP_ROOT_DIR}/emissions/aep/aeputil/testdata/"
+	cfg.Set("aep.SpeciateConfig.ChemicalMechanism", "SAPRC99")
+	cfg.Set("aep.SpeciateConfig.MechAssignment", dir+"mech_assignment.csv")
+	cfg.Set("aep.SpeciateConfig.SpeciesGroups", `{"VOC":["ALK3","ALK4"],"SOx":["Sulfur dioxide","Sulfate"]}`)
+	i, _, sp, err := aeputilConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp == nil {
+		t.Fatal("speciation should not be nil")
+	}
+	if sp.ChemicalMechanism != "SAPRC99" || !sp.MassSpeciation {
+		t.Errorf("mechanism %s, mass speciation %v", sp.ChemicalMechanism, sp.MassSpeciation)
+	}
+	if want := os.ExpandEnv(dir + "mech_assignment.csv"); sp.MechAssignment != want {
+		t.Errorf("MechAssignment: %s != %s", sp.MechAssignment, want)
+	}
+	for pol, wantType := range map[string]aep.SpeciationType{"VOC": aep.VOC, "NOx": aep.NOx, "PM2_5": aep.PM25, "NH3": aep.SingleSpecies, "SOx": aep.SingleSpecies} {
+		typ, err := i.PolsToKeep.Type(aep.Pollutant{Name: pol})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != wantType {
+			t.Errorf("%s speciation type: %s != %s", pol, typ, wantType)
+		}
+	}
+	wantPols = map[string][]aep.Pollutant{
+		"VOC": {{Name: "ALK3"}, {Name: "ALK4"}},
+		"SOx": {{Name: "Sulfur dioxide"}, {Name: "Sulfate"}},
+	}
+	if pols := aepSpecies(sp); !reflect.DeepEqual(pols, wantPols) {
+		t.Errorf("speciated pollutants: %v != %v", pols, wantPols)
+	}
+
+	cfg.Set("aep.SpeciateConfig.SpeciesGroups", `{"CO":["CO"]}`)
+	if _, _, _, err := aeputilConfig(cfg.Viper); err == nil {
+		t.Errorf("invalid species group should cause an error")
+	}
+	cfg.Set("aep.SpeciateConfig.SpeciesGroups", map[string][]string{})
+	if _, _, _, err := aeputilConfig(cfg.Viper); err == nil {
+		t.Errorf("missing species groups should cause an error")
+	}
+}
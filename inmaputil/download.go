@@ -19,7 +19,9 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package inmaputil
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -59,6 +61,72 @@ func maybeDownload(ctx context.Context, path string, c chan string) string {
 	return path
 }
 
+// maybeDownloadSrgSpecRaster is like maybeDownload, but for raster surrogate
+// specification files. It also downloads any of the raster files
+// referred to by the specification that are URLs or, if the specification
+// was itself downloaded, that have paths relative to the specification's URL.
+// If any files were downloaded, it returns the path to a copy of the
+// specification that refers to the downloaded files instead.
+func maybeDownloadSrgSpecRaster(ctx context.Context, path string, c chan string) string {
+	localPath := maybeDownload(ctx, path, c)
+	downloaded := localPath != path
+	b, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		// The error will be reported when the specification is read.
+		return localPath
+	}
+	var specs []map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&specs); err != nil {
+		return localPath
+	}
+	rasterDownloaded := false
+	for _, spec := range specs {
+		file, ok := spec["file"].(string)
+		if !ok || file == "" {
+			continue
+		}
+		file = os.ExpandEnv(file)
+		switch {
+		case isRemote(file):
+		case downloaded && !filepath.IsAbs(file):
+			file = path[0:strings.LastIndex(path, "/")+1] + file
+		case !filepath.IsAbs(file):
+			file = filepath.Join(filepath.Dir(localPath), file)
+		}
+		if f := maybeDownload(ctx, file, c); f != file {
+			file = f
+			rasterDownloaded = true
+		}
+		spec["file"] = file
+	}
+	if !rasterDownloaded {
+		return localPath
+	}
+	if !downloaded {
+		// Write a copy rather than changing the local file.
+		dir, err := ioutil.TempDir("", "inmap")
+		if err != nil {
+			panic(fmt.Errorf("inmaputil: failed creating temporary download directory: %v", err))
+		}
+		localPath = filepath.Join(dir, filepath.Base(localPath))
+	}
+	b, err = json.Marshal(specs)
+	if err != nil {
+		panic(err)
+	}
+	if err := ioutil.WriteFile(localPath, b, 0644); err != nil {
+		panic(fmt.Errorf("inmaputil: failed writing raster surrogate specification: %v", err))
+	}
+	return localPath
+}
+
+// isRemote returns whether path is a URL or a blob.
+func isRemote(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || IsBlob(path)
+}
+
 // downloadHTTP downloads a file from the specified URL and returns
 // the path to the downloaded file.
 func downloadHTTP(path string, c chan string) string {
@@ -92,9 +160,10 @@ func downloadHTTP(path string, c chan string) string {
 }
 
 // IsBlob returns whether the given filename represents a blob.
-// (i.e., if it starts with `gs://`, 's3://', or 'file://').
+// (i.e., if it starts with `gs://`, 's3://', 'azblob://', or 'file://').
 func IsBlob(path string) bool {
-	return strings.HasPrefix(path, "gs://") || strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "file://")
+	return strings.HasPrefix(path, "gs://") || strings.HasPrefix(path, "s3://") ||
+		strings.HasPrefix(path, "azblob://") || strings.HasPrefix(path, "file://")
 }
 
 // downloadBlob download the specified file from blob storage.
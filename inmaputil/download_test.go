@@ -20,13 +20,17 @@ package inmaputil
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/spatialmodel/inmap/cloud"
 	"gocloud.dev/blob"
 	"gocloud.dev/blob/fileblob"
 )
@@ -93,3 +97,112 @@ func TestMaybeDownload_bucket(t *testing.T) {
 		t.Errorf("inproperly downloaded: %s", path)
 	}
 }
+
+func TestMaybeDownload_s3Compatible(t *testing.T) {
+	srv := httptest.NewServer(cloud.NewFakeS3())
+	defer srv.Close()
+	for k, v := range map[string]string{
+		"AWS_ENDPOINT_URL_S3":   srv.URL,
+		"AWS_ACCESS_KEY_ID":     "minio",
+		"AWS_SECRET_ACCESS_KEY": "minio123",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+
+	// Upload a file and then download it again.
+	var u uploader
+	path := u.maybeUpload("s3://testbucket/dir/test.txt")
+	if err := ioutil.WriteFile(path, []byte("This is a test file."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.uploadOutput(nil); err != nil {
+		t.Fatal(err)
+	}
+	path = maybeDownload(context.Background(), "s3://testbucket/dir/test.txt", helperLog(t))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "This is a test file." {
+		t.Errorf("downloaded %q", b)
+	}
+}
+
+func TestMaybeDownloadSrgSpecRaster_bucket(t *testing.T) {
+	dir := "download_srgspec_test"
+	if err := os.Mkdir(dir, os.ModePerm); err != nil {
+		t.Error(err)
+	}
+	defer os.RemoveAll(dir)
+	bucket, err := fileblob.OpenBucket(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stage a specification and the raster it refers to,
+	// the way cloud.JobSpec does.
+	ctx := context.Background()
+	for key, data := range map[string]string{
+		"inputs/srgspec.json": `[{"region": 0, "name": "Population", "code": "100", "file": "srg.tif"}]`,
+		"inputs/srg.tif":      "raster data",
+	} {
+		w, err := bucket.NewWriter(ctx, key, &blob.WriterOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(w, data)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := maybeDownloadSrgSpecRaster(ctx, "file://"+dir+"/inputs/srgspec.json", helperLog(t))
+	if strings.HasPrefix(path, "file://") {
+		t.Fatalf("specification not downloaded: %s", path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var specs []struct {
+		Name string
+		File string
+	}
+	if err := json.Unmarshal(b, &specs); err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 1 || specs[0].Name != "Population" {
+		t.Fatalf("invalid specification %s", b)
+	}
+	raster, err := ioutil.ReadFile(specs[0].File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raster) != "raster data" {
+		t.Errorf("downloaded raster %q", raster)
+	}
+}
+
+func TestMaybeDownloadSrgSpecRaster_local(t *testing.T) {
+	dir, err := ioutil.TempDir("", "srgspec_raster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "srgspec.json")
+	spec := []byte(`[{"region": 0, "name": "Population", "code": "100", "file": "srg.tif"}]`)
+	if err := ioutil.WriteFile(path, spec, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := maybeDownloadSrgSpecRaster(context.Background(), path, helperLog(t)); p != path {
+		t.Errorf("path: %s != %s", p, path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(spec) {
+		t.Errorf("local specification was changed to %s", b)
+	}
+}
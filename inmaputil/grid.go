@@ -64,7 +64,7 @@ func Grid(InMAPData, VariableGridData string, VarGrid *inmap.VarGridConfig) erro
 
 	msgLog <- "Creating grid"
 
-	mutator, err := inmap.PopulationMutator(VarGrid, popIndices)
+	mutator, err := VarGrid.RefinementMutator(popIndices)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,255 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/carto"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/science/chem/simplechem"
+	"gonum.org/v1/plot/vg"
+	vgdraw "gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+)
+
+// GridLayerStats holds statistics about the grid cells in one
+// vertical layer of a variable resolution grid.
+type GridLayerStats struct {
+	// Layer is the vertical layer index, where 0 is ground level.
+	Layer int
+
+	// NumCells is the number of grid cells in the layer.
+	NumCells int
+
+	// NestLevelCells holds the number of cells at each nest level,
+	// where nest level 0 is the outermost (coarsest) nest.
+	NestLevelCells []int
+
+	// MinSize, MaxSize, and MeanSize are the minimum, maximum, and mean
+	// horizontal cell sizes, calculated as the square root of the
+	// horizontal cell area [m].
+	MinSize, MaxSize, MeanSize float64
+}
+
+// GridStats holds statistics about a variable resolution grid.
+type GridStats struct {
+	// NumCells is the total number of grid cells.
+	NumCells int
+
+	// Layers holds statistics for each vertical layer.
+	Layers []*GridLayerStats
+
+	// Population is the total population in the grid of each
+	// population type.
+	Population map[string]float64
+
+	// FinestNestPopulation is the population of each type that lives in
+	// ground-level grid cells at the finest nest level present in the grid.
+	FinestNestPopulation map[string]float64
+
+	// AboveDensityThreshold is the number of ground-level grid cells
+	// that are above the population density threshold.
+	AboveDensityThreshold int
+}
+
+// GridInfo loads the variable resolution grid in VariableGridData and
+// calculates statistics about it. If w is not nil, a summary of the statistics
+// will be written to it. If MapFile is not empty, a PNG map of the
+// ground-level grid cells, colored by nest level, will be written to it.
+//
+// VarGrid provides information for specifying the variable resolution grid.
+func GridInfo(VariableGridData string, VarGrid *inmap.VarGridConfig, w io.Writer, MapFile string) (*GridStats, error) {
+	f, err := os.Open(VariableGridData)
+	if err != nil {
+		return nil, fmt.Errorf("inmap: opening variable grid data: %v", err)
+	}
+	defer f.Close()
+
+	var m simplechem.Mechanism
+	d := &inmap.InMAP{
+		InitFuncs: []inmap.DomainManipulator{
+			inmap.Load(f, VarGrid, nil, m),
+		},
+	}
+	if err = d.Init(); err != nil {
+		return nil, err
+	}
+	cells := d.Cells()
+
+	s := gridStats(cells, d.PopIndices)
+	if w != nil {
+		if err = s.write(w); err != nil {
+			return nil, err
+		}
+	}
+	if MapFile != "" {
+		if err = gridMap(cells, MapFile); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// gridStats calculates statistics about the given grid cells.
+func gridStats(cells []*inmap.Cell, popIndices map[string]int) *GridStats {
+	s := &GridStats{
+		NumCells:             len(cells),
+		Population:           make(map[string]float64),
+		FinestNestPopulation: make(map[string]float64),
+	}
+	finestNest := 0
+	for _, c := range cells {
+		for c.Layer >= len(s.Layers) {
+			s.Layers = append(s.Layers, &GridLayerStats{
+				Layer:   len(s.Layers),
+				MinSize: math.Inf(1),
+				MaxSize: math.Inf(-1),
+			})
+		}
+		l := s.Layers[c.Layer]
+		nest := len(c.Index) - 1
+		if nest < 0 {
+			nest = 0
+		}
+		for nest >= len(l.NestLevelCells) {
+			l.NestLevelCells = append(l.NestLevelCells, 0)
+		}
+		l.NestLevelCells[nest]++
+		l.NumCells++
+		size := math.Sqrt(c.Dx * c.Dy)
+		l.MinSize = math.Min(l.MinSize, size)
+		l.MaxSize = math.Max(l.MaxSize, size)
+		l.MeanSize += size
+		if c.Layer == 0 {
+			if nest > finestNest {
+				finestNest = nest
+			}
+			if c.AboveDensityThreshold {
+				s.AboveDensityThreshold++
+			}
+			for name, i := range popIndices {
+				s.Population[name] += c.PopData[i]
+			}
+		}
+	}
+	for _, l := range s.Layers {
+		if l.NumCells > 0 {
+			l.MeanSize /= float64(l.NumCells)
+		}
+	}
+	for _, c := range cells {
+		if c.Layer == 0 && len(c.Index)-1 == finestNest {
+			for name, i := range popIndices {
+				s.FinestNestPopulation[name] += c.PopData[i]
+			}
+		}
+	}
+	return s
+}
+
+// write writes a summary of the receiver to w.
+func (s *GridStats) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Total grid cells:\t%d\n", s.NumCells)
+	fmt.Fprintf(tw, "Ground-level cells above population density threshold:\t%d\n\n", s.AboveDensityThreshold)
+
+	fmt.Fprintln(tw, "Layer\tCells\tCells per nest level\tMin. size (m)\tMax. size (m)\tMean size (m)")
+	for _, l := range s.Layers {
+		fmt.Fprintf(tw, "%d\t%d\t%v\t%.4g\t%.4g\t%.4g\n", l.Layer, l.NumCells, l.NestLevelCells,
+			l.MinSize, l.MaxSize, l.MeanSize)
+	}
+
+	popTypes := make([]string, 0, len(s.Population))
+	for p := range s.Population {
+		popTypes = append(popTypes, p)
+	}
+	sort.Strings(popTypes)
+	fmt.Fprintln(tw, "\nPopulation type\tTotal population\tFraction in finest nest level")
+	for _, p := range popTypes {
+		var frac float64
+		if s.Population[p] > 0 {
+			frac = s.FinestNestPopulation[p] / s.Population[p]
+		}
+		fmt.Fprintf(tw, "%s\t%.6g\t%.3f\n", p, s.Population[p], frac)
+	}
+	return tw.Flush()
+}
+
+// gridMap draws a map of the ground-level grid cells, colored by nest level,
+// and writes it to a PNG file.
+func gridMap(cells []*inmap.Cell, filename string) error {
+	cmap := carto.NewColorMap(carto.Linear)
+	b := geom.NewBounds()
+	var groundCells []*inmap.Cell
+	var nests []float64
+	for _, c := range cells {
+		if c.Layer != 0 {
+			continue
+		}
+		groundCells = append(groundCells, c)
+		nests = append(nests, float64(len(c.Index)-1))
+		b.Extend(c.Bounds())
+	}
+	if len(groundCells) == 0 {
+		return fmt.Errorf("inmap: no ground-level grid cells to map")
+	}
+	cmap.AddArray(nests)
+	cmap.Set()
+
+	const width = 1000
+	height := int(float64(width) * (b.Max.Y - b.Min.Y) / (b.Max.X - b.Min.X))
+	if height < 1 {
+		height = 1
+	}
+	img := draw.Image(image.NewRGBA(image.Rect(0, 0, width, height)))
+	c := vgimg.NewWith(vgimg.UseImage(img))
+	dc := vgdraw.New(c)
+
+	m := carto.NewCanvas(b.Max.Y, b.Min.Y, b.Max.X, b.Min.X, dc)
+	ls := vgdraw.LineStyle{
+		Width: 0.1 * vg.Millimeter,
+		Color: color.Black,
+	}
+	for i, cell := range groundCells {
+		if err := m.DrawVector(geom.MultiPolygon(cell.Polygons()), cmap.GetColor(nests[i]), ls, vgdraw.GlyphStyle{}); err != nil {
+			return fmt.Errorf("inmap: drawing grid map: %v", err)
+		}
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("inmap: creating grid map file: %v", err)
+	}
+	png := vgimg.PngCanvas{Canvas: c}
+	if _, err := png.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("inmap: writing grid map: %v", err)
+	}
+	return f.Close()
+}
@@ -19,6 +19,7 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package inmaputil
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
@@ -106,6 +107,10 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 //
 // VarGrid provides information for specifying the variable resolution grid.
 //
+// inventoryConfig, spatialConfig, and speciateConfig specify how AEP
+// emissions should be read, spatially allocated, and chemically speciated.
+// If speciateConfig is nil, the emissions are not speciated.
+//
 // InMAPData is the path to location of baseline meteorology and pollutant data.
 //
 // VariableGridData is the path to the location of the variable-resolution gridded
@@ -125,7 +130,7 @@ var DefaultScienceFuncs = []inmap.CellManipulator{
 // (e.g., if the grid is in degrees latitude/longitude.)
 func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputAllLayers bool, OutputVariables map[string]string,
 	EmissionUnits string, EmissionsShapefiles []string, EmissionsMask geom.Polygon, VarGrid *inmap.VarGridConfig,
-	inventoryConfig *aeputil.InventoryConfig, spatialConfig *aeputil.SpatialConfig,
+	inventoryConfig *aeputil.InventoryConfig, spatialConfig *aeputil.SpatialConfig, speciateConfig *aeputil.SpeciateConfig,
 	InMAPData, VariableGridData string, NumIterations int,
 	dynamic, createGrid bool, scienceFuncs []inmap.CellManipulator, addInit, addRun, addCleanup []inmap.DomainManipulator,
 	m inmap.Mechanism) error {
@@ -198,7 +203,7 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 		return err
 	}
 
-	aepSetEmis := setEmissionsAEP(inventoryConfig, spatialConfig, emis, EmissionsMask)
+	aepSetEmis := setEmissionsAEP(inventoryConfig, spatialConfig, speciateConfig, emis, EmissionsMask)
 
 	// Only load the population if we're creating the grid.
 	var pop *inmap.Population
@@ -225,7 +230,7 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 	if !dynamic {
 		if createGrid {
 			var mutator inmap.GridMutator
-			mutator, err = inmap.PopulationMutator(VarGrid, popIndices)
+			mutator, err = VarGrid.RefinementMutator(popIndices)
 			if err != nil {
 				return err
 			}
@@ -328,11 +333,31 @@ func Run(CobraCommand *cobra.Command, LogFile string, OutputFile string, OutputA
 	return nil
 }
 
+// aepSpecies returns the AEP pollutants that make up each InMAP species.
+// If speciateConfig is nil, each InMAP species is the inventory
+// pollutant with the same name.
+func aepSpecies(speciateConfig *aeputil.SpeciateConfig) map[string][]aep.Pollutant {
+	pols := make(map[string][]aep.Pollutant)
+	for _, s := range inmapSpecies {
+		if speciateConfig == nil {
+			pols[s] = []aep.Pollutant{{Name: s}}
+			continue
+		}
+		for _, name := range speciateConfig.SpeciesGroups[s] {
+			pols[s] = append(pols[s], aep.Pollutant{Name: name})
+		}
+	}
+	return pols
+}
+
 // setEmissionsAEP adds AEP-processed emissions flux to an existing grid.
 // The returned DomainManipulator must be run after each time the grid changes.
+// If speciateConfig is not nil, the emissions are chemically speciated
+// and each InMAP species is the sum of the model species in its
+// speciateConfig.SpeciesGroups group.
 // extraEmis specifies any extra emissions that should be added. It is ignored
 // if nil.
-func setEmissionsAEP(inventoryConfig *aeputil.InventoryConfig, spatialConfig *aeputil.SpatialConfig, extraEmis *inmap.Emissions, mask geom.Polygon) func(d *inmap.InMAP) error {
+func setEmissionsAEP(inventoryConfig *aeputil.InventoryConfig, spatialConfig *aeputil.SpatialConfig, speciateConfig *aeputil.SpeciateConfig, extraEmis *inmap.Emissions, mask geom.Polygon) func(d *inmap.InMAP) error {
 	// Read in emissions records and save in memory.
 	recs := make(map[string][]aep.Record)
 	var err error
@@ -374,10 +399,16 @@ func setEmissionsAEP(inventoryConfig *aeputil.InventoryConfig, spatialConfig *ae
 			}
 		}
 
-		iter := spatialConfig.Iterator(aeputil.IteratorFromMap(recs), 0)
+		var iter aeputil.Iterator = aeputil.IteratorFromMap(recs)
+		var speciateIter aeputil.Iterator
+		if speciateConfig != nil {
+			speciateIter = speciateConfig.Iterator(iter)
+			iter = speciateIter
+		}
+		spatialIter := spatialConfig.Iterator(iter, 0)
 		var spatialRecs []aep.RecordGridded
 		for {
-			rec, err := iter.Next()
+			rec, err := spatialIter.Next()
 			if err == io.EOF {
 				break
 			} else if err != nil {
@@ -393,19 +424,23 @@ func setEmissionsAEP(inventoryConfig *aeputil.InventoryConfig, spatialConfig *ae
 			spatialRecs = append(spatialRecs, rec.(aep.RecordGridded))
 		}
 
+		if speciateIter != nil {
+			b := new(bytes.Buffer)
+			if _, err := speciateConfig.SpeciesTotals(speciateIter.Report()).Tabbed(b); err != nil {
+				return err
+			}
+			log.Printf("Speciated AEP emissions totals:\n%s", b.String())
+		}
+
 		var emisRecs []*inmap.EmisRecord
 		if len(spatialRecs) > 0 {
 			sp, err := spatialConfig.SpatialProcessor()
 			if err != nil {
 				return err
 			}
+			pols := aepSpecies(speciateConfig)
 			emisRecs, err = inmap.FromAEP(spatialRecs, sp.Grids, 0,
-				[]aep.Pollutant{{Name: "VOC"}},
-				[]aep.Pollutant{{Name: "NOx"}},
-				[]aep.Pollutant{{Name: "NH3"}},
-				[]aep.Pollutant{{Name: "SOx"}},
-				[]aep.Pollutant{{Name: "PM2_5"}},
-			)
+				pols["VOC"], pols["NOx"], pols["NH3"], pols["SOx"], pols["PM2_5"])
 			if err != nil {
 				return err
 			}
@@ -19,12 +19,14 @@ along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
 package inmaputil
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/spatialmodel/inmap"
@@ -45,6 +47,51 @@ func TestCreateGrid(t *testing.T) {
 	}
 }
 
+func TestGridInfo(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	mapFile := os.ExpandEnv("$INMAP_ROOT_DIR/cmd/inmap/testdata/grid_info.png")
+	defer os.Remove(mapFile)
+	out := new(bytes.Buffer)
+	cfg.Root.SetOutput(out)
+	cfg.Root.SetArgs([]string{"grid", "info", "--GridInfo.MapFile=" + mapFile})
+	if err := cfg.Root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Total grid cells:") {
+		t.Errorf("missing grid statistics in output: %s", out.String())
+	}
+	if _, err := os.Stat(mapFile); err != nil {
+		t.Error(err)
+	}
+
+	vgc, err := VarGridConfig(cfg.Viper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := GridInfo(os.ExpandEnv(cfg.GetString("VariableGridData")), vgc, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for _, l := range s.Layers {
+		var nn int
+		for _, c := range l.NestLevelCells {
+			nn += c
+		}
+		if nn != l.NumCells {
+			t.Errorf("layer %d: nest level cells %d != cells %d", l.Layer, nn, l.NumCells)
+		}
+		n += l.NumCells
+	}
+	if n != s.NumCells {
+		t.Errorf("layer cells %d != total cells %d", n, s.NumCells)
+	}
+	if s.Population["TotalPop"] <= 0 {
+		t.Errorf("total population should be > 0")
+	}
+}
+
 func TestInMAPStaticCreateGrid(t *testing.T) {
 	cfg := InitializeConfig()
 	cfg.Set("static", true)
@@ -114,8 +114,10 @@ func CleanSR(ctx context.Context, jobName, VariableGridData string, VarGrid *inm
 // masked by emissionMask), outputting the
 // results specified by outputVaraibles in OutputFile.
 // EmissionUnits specifies the units
-// of the emissions. VarGrid specifies the variable resolution grid.
-func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables map[string]string, EmissionsShapefiles []string, emissionMask geom.Polygon, VarGrid *inmap.VarGridConfig) error {
+// of the emissions. aboveTop specifies how emissions with plume heights
+// above the top layer of the SR matrix are handled.
+// VarGrid specifies the variable resolution grid.
+func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables map[string]string, EmissionsShapefiles []string, emissionMask geom.Polygon, aboveTop sr.AboveTopMode, VarGrid *inmap.VarGridConfig) error {
 	msgLog := make(chan string)
 	go func() {
 		for {
@@ -140,7 +142,8 @@ func SRPredict(EmissionUnits, SROutputFile, OutputFile string, outputVariables m
 	if err != nil {
 		return err
 	}
-	conc, err := r.Concentrations(emis.EmisRecords()...)
+	r.AboveTop = aboveTop
+	conc, err := r.ConcentrationsBatch(emis.EmisRecords()...)
 	if err != nil {
 		if _, ok := err.(sr.AboveTopErr); ok {
 			log.Printf("%v; calculating concentrations for emissions in SR matrix top layer.", err)
@@ -20,7 +20,9 @@ package inmaputil
 
 import (
 	"context"
+	"encoding/csv"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/spatialmodel/inmap"
@@ -106,7 +108,67 @@ func TestSRPredictAboveTop(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := SRPredict(cfg.GetString("EmissionUnits"), cfg.GetString("SR.OutputFile"), cfg.GetString("OutputFile"), outputVars, cfg.GetStringSlice("EmissionsShapefiles"), mask, vcfg); err != nil {
+	for _, mode := range []string{"error", "clamp", "extrapolate"} {
+		t.Run(mode, func(t *testing.T) {
+			aboveTop, err := checkAboveTop(mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := SRPredict(cfg.GetString("EmissionUnits"), cfg.GetString("SR.OutputFile"), cfg.GetString("OutputFile"), outputVars, cfg.GetStringSlice("EmissionsShapefiles"), mask, aboveTop, vcfg); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	t.Run("command", func(t *testing.T) {
+		cfg.Set("SR.AboveTop", "extrapolate")
+		cfg.Root.SetArgs([]string{"srpredict"})
+		if err := cfg.Root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		cfg.Set("SR.AboveTop", "ignore")
+		cfg.Root.SetArgs([]string{"srpredict"})
+		if err := cfg.Root.Execute(); err == nil {
+			t.Error("there should be an error for an invalid SR.AboveTop mode")
+		}
+	})
+}
+
+func TestSRValidate(t *testing.T) {
+	cfg := InitializeConfig()
+	cfg.Set("config", "../cmd/inmap/configExample.toml")
+	cfg.Set("SR.OutputFile", "../cmd/inmap/testdata/testSR_golden.ncf")
+	cfg.Set("SR.ValidationScenarios", []string{"../cmd/inmap/testdata/testEmisSR.shp"})
+	cfg.Set("SR.ValidationDir", "../cmd/inmap/testdata/sr_validation")
+	cfg.Set("SR.AboveTop", "extrapolate")
+	defer os.RemoveAll("../cmd/inmap/testdata/sr_validation")
+	cfg.Root.SetArgs([]string{"sr", "validate"})
+	if err := cfg.Root.Execute(); err != nil {
 		t.Fatal(err)
 	}
+	f, err := os.Open("../cmd/inmap/testdata/sr_validation/sr_validation.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 7 {
+		t.Fatalf("want 7 records but have %d", len(recs))
+	}
+	for _, rec := range recs[1:] {
+		if rec[1] != "TotalPM25" {
+			continue
+		}
+		r, err := strconv.ParseFloat(rec[4], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r < 0.5 {
+			t.Errorf("TotalPM25 correlation is too low: %g", r)
+		}
+	}
 }
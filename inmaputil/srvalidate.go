@@ -0,0 +1,276 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmaputil
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/GaryBoone/GoStats/stats"
+	"github.com/ctessum/atmos/evalstats"
+	"github.com/ctessum/geom"
+	"github.com/gonum/floats"
+	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/emissions/aep/aeputil"
+	"github.com/spatialmodel/inmap/science/chem/simplechem"
+	"github.com/spatialmodel/inmap/sr"
+	"github.com/spf13/cobra"
+)
+
+// srValidationVars are the output variables that are compared when
+// validating an SR matrix. The keys are short enough to be used
+// as shapefile field names.
+var srValidationVars = map[string]string{
+	"PrimPM25":  "PrimaryPM25",
+	"PNH4":      "pNH4",
+	"PNO3":      "pNO3",
+	"PSO4":      "pSO4",
+	"SOA":       "SOA",
+	"TotalPM25": "PrimaryPM25 + pNH4 + pSO4 + pNO3 + SOA",
+}
+
+// SRValidationStats holds statistics comparing SR matrix predictions
+// to full InMAP simulation results for a single emissions
+// scenario and species.
+type SRValidationStats struct {
+	// Scenario is the name of the emissions scenario.
+	Scenario string
+
+	// Species is the name of the pollutant species.
+	Species string
+
+	// NMB is the normalized mean bias of the SR predictions
+	// relative to the full simulation results.
+	NMB float64
+
+	// NME is the normalized mean error of the SR predictions
+	// relative to the full simulation results.
+	NME float64
+
+	// R is the Pearson correlation coefficient between the
+	// SR predictions and the full simulation results.
+	R float64
+}
+
+// SRValidate quantifies the differences between concentrations predicted
+// by an SR matrix and concentrations calculated by full InMAP simulations.
+//
+// cmd is the command that is being run, which is used for logging.
+//
+// EmissionUnits specifies the units of the emissions.
+//
+// SROutputFile is the path to the SR matrix. aboveTop specifies how
+// emissions with plume heights above the top layer of the SR matrix
+// are handled when making the SR predictions.
+//
+// OutputDir is the directory where the full simulation results,
+// the SR predictions, difference maps (SR prediction minus full simulation),
+// and the statistics file "sr_validation.csv" should be written.
+//
+// Scenarios is a list of emissions shapefiles, where each shapefile represents
+// one emissions scenario. If sample > 0, only a random sample of
+// sample scenarios will be evaluated.
+//
+// emissionMask, VarGrid, InMAPData, VariableGridData, and NumIterations
+// are used as described in the documentation for the Run function.
+// The full InMAP simulations are run using the static grid in VariableGridData,
+// which should be the same grid that was used to create the SR matrix.
+func SRValidate(cmd *cobra.Command, EmissionUnits, SROutputFile string, aboveTop sr.AboveTopMode, OutputDir string, Scenarios []string, sample int,
+	emissionMask geom.Polygon, VarGrid *inmap.VarGridConfig, InMAPData, VariableGridData string,
+	NumIterations int) ([]*SRValidationStats, error) {
+
+	if sample > 0 && sample < len(Scenarios) {
+		// Use a fixed seed so that the sample is repeatable.
+		rnd := rand.New(rand.NewSource(1))
+		s := make([]string, sample)
+		for i, j := range rnd.Perm(len(Scenarios))[:sample] {
+			s[i] = Scenarios[j]
+		}
+		Scenarios = s
+	}
+
+	if err := os.MkdirAll(OutputDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("inmap: creating SR validation output directory: %v", err)
+	}
+
+	vgsr, err := spatialRef(VarGrid)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(SROutputFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := sr.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	r.AboveTop = aboveTop
+
+	species := make([]string, 0, len(srValidationVars))
+	for s := range srValidationVars {
+		species = append(species, s)
+	}
+	sort.Strings(species)
+
+	var m simplechem.Mechanism
+	var result []*SRValidationStats
+	for _, scenario := range Scenarios {
+		name := strings.TrimSuffix(filepath.Base(scenario), filepath.Ext(scenario))
+		log.Printf("SR validation: processing scenario %s", name)
+
+		// Run the full model.
+		var inmapResults map[string][]float64
+		getResults := func(d *inmap.InMAP) error {
+			o, err := inmap.NewOutputter("", false, srValidationVars, nil, m)
+			if err != nil {
+				return err
+			}
+			inmapResults, err = d.Results(o)
+			return err
+		}
+		err = Run(cmd, filepath.Join(OutputDir, name+"_inmap.log"),
+			filepath.Join(OutputDir, name+"_inmap.shp"), false, srValidationVars,
+			EmissionUnits, []string{scenario}, emissionMask, VarGrid,
+			&aeputil.InventoryConfig{}, &aeputil.SpatialConfig{}, nil,
+			InMAPData, VariableGridData, NumIterations, false, false,
+			DefaultScienceFuncs, nil, nil, []inmap.DomainManipulator{getResults}, m)
+		if err != nil {
+			return nil, fmt.Errorf("inmap: running full simulation for SR validation scenario %s: %v", name, err)
+		}
+
+		// Make the SR prediction.
+		msgLog := make(chan string)
+		go func() {
+			for msg := range msgLog {
+				log.Println(msg)
+			}
+		}()
+		emis, err := inmap.ReadEmissionShapefiles(vgsr, EmissionUnits, msgLog, emissionMask, scenario)
+		close(msgLog)
+		if err != nil {
+			return nil, err
+		}
+		conc, err := r.ConcentrationsBatch(emis.EmisRecords()...)
+		if err != nil {
+			if _, ok := err.(sr.AboveTopErr); ok {
+				log.Printf("%v; calculating concentrations for emissions in SR matrix top layer.", err)
+			} else {
+				return nil, err
+			}
+		}
+		if err = r.SetConcentrations(conc); err != nil {
+			return nil, err
+		}
+		if err = r.Output(filepath.Join(OutputDir, name+"_sr.shp"), srValidationVars, nil, vgsr); err != nil {
+			return nil, err
+		}
+		srResults := map[string][]float64{
+			"PrimPM25":  conc.PrimaryPM25,
+			"PNH4":      conc.PNH4,
+			"PNO3":      conc.PNO3,
+			"PSO4":      conc.PSO4,
+			"SOA":       conc.SOA,
+			"TotalPM25": conc.TotalPM25(),
+		}
+
+		// Calculate statistics.
+		for _, s := range species {
+			if len(srResults[s]) != len(inmapResults[s]) {
+				return nil, fmt.Errorf("inmap: SR matrix has %d grid cells but full simulation has %d; "+
+					"make sure they were created using the same variable resolution grid", len(srResults[s]), len(inmapResults[s]))
+			}
+			result = append(result, srValidationStats(name, s, inmapResults[s], srResults[s]))
+		}
+
+		// Write a map of the differences.
+		diff := &sr.Concentrations{
+			PrimaryPM25: srValidationDiff(conc.PrimaryPM25, inmapResults["PrimPM25"]),
+			PNH4:        srValidationDiff(conc.PNH4, inmapResults["PNH4"]),
+			PNO3:        srValidationDiff(conc.PNO3, inmapResults["PNO3"]),
+			PSO4:        srValidationDiff(conc.PSO4, inmapResults["PSO4"]),
+			SOA:         srValidationDiff(conc.SOA, inmapResults["SOA"]),
+		}
+		if err = r.SetConcentrations(diff); err != nil {
+			return nil, err
+		}
+		if err = r.Output(filepath.Join(OutputDir, name+"_diff.shp"), srValidationVars, nil, vgsr); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeSRValidationStats(filepath.Join(OutputDir, "sr_validation.csv"), result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// srValidationStats compares the SR predictions srVals to the full simulation
+// results inmapVals.
+func srValidationStats(scenario, species string, inmapVals, srVals []float64) *SRValidationStats {
+	s := &SRValidationStats{Scenario: scenario, Species: species}
+	inmapSum := floats.Sum(inmapVals)
+	if inmapSum != 0 {
+		s.NMB = evalstats.MB(inmapVals, srVals) * float64(len(inmapVals)) / inmapSum
+		s.NME = evalstats.ME(inmapVals, srVals) * float64(len(inmapVals)) / inmapSum
+	}
+	slope, _, r2, _, _, _ := stats.LinearRegression(inmapVals, srVals)
+	s.R = math.Sqrt(r2)
+	if slope < 0 {
+		s.R = -s.R
+	}
+	if math.IsNaN(s.R) {
+		s.R = 0
+	}
+	return s
+}
+
+// srValidationDiff returns srVals - inmapVals.
+func srValidationDiff(srVals, inmapVals []float64) []float64 {
+	o := make([]float64, len(srVals))
+	floats.SubTo(o, srVals, inmapVals)
+	return o
+}
+
+// writeSRValidationStats writes s to a CSV file.
+func writeSRValidationStats(filename string, s []*SRValidationStats) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("inmap: creating SR validation statistics file: %v", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"Scenario", "Species", "NMB", "NME", "R"})
+	for _, ss := range s {
+		w.Write([]string{ss.Scenario, ss.Species,
+			fmt.Sprint(ss.NMB), fmt.Sprint(ss.NME), fmt.Sprint(ss.R)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("inmap: writing SR validation statistics file: %v", err)
+	}
+	return f.Close()
+}
@@ -592,6 +592,59 @@ func TestRegrid(t *testing.T) {
 	}
 }
 
+// Test whether mass is conserved when regridding from hexagons
+// to rectangles and to irregular quadrilaterals.
+func TestRegrid_hexagon(t *testing.T) {
+	hexagons := hexagonGrid()
+	oldGeom := make([]geom.Polygonal, len(hexagons))
+	oldData := make([]float64, len(hexagons))
+	var oldMass float64
+	for i, h := range hexagons {
+		oldGeom[i] = h
+		oldData[i] = float64(i + 1)
+		oldMass += oldData[i] * h.Area()
+	}
+
+	var rects []geom.Polygonal
+	for x := -4000.; x < 4000; x += 1000 {
+		for y := -3000.; y < 4000; y += 1000 {
+			rects = append(rects, rect(x, y, x+1000, y+1000))
+		}
+	}
+	// Split the center hexagon into two irregular quadrilaterals.
+	center := hexagons[7][0]
+	split := append([]geom.Polygonal{}, oldGeom...)
+	split[7] = geom.Polygon{{center[1], center[2], center[3], center[4], center[1]}}
+	split = append(split, geom.Polygon{{center[4], center[5], center[0], center[1], center[4]}})
+
+	for name, newGeom := range map[string][]geom.Polygonal{"rectangles": rects, "split": split} {
+		t.Run(name, func(t *testing.T) {
+			newData, err := Regrid(oldGeom, newGeom, oldData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var newMass float64
+			for i, g := range newGeom {
+				newMass += newData[i] * g.Area()
+			}
+			if different(newMass, oldMass, 1.e-10) {
+				t.Errorf("mass: have %g, want %g", newMass, oldMass)
+			}
+		})
+	}
+	t.Run("identical", func(t *testing.T) {
+		newData, err := Regrid(oldGeom, oldGeom, oldData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range newData {
+			if different(v, oldData[i], 1.e-10) {
+				t.Errorf("cell %d: have %g, want %g", i, v, oldData[i])
+			}
+		}
+	})
+}
+
 func TestCellIntersections(t *testing.T) {
 	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
 
@@ -0,0 +1,361 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/proj"
+)
+
+// edgeTolerance is the distance, relative to the size of a grid cell,
+// within which two cell edges are considered to be coincident.
+const edgeTolerance = 1.e-8
+
+// loadGridFile reads the polygons that make up the horizontal
+// grid from config.GridFile and stores them in config.
+func (config *VarGridConfig) loadGridFile() error {
+	if config.gridPolygons != nil {
+		return nil
+	}
+	gridSR, err := proj.Parse(config.GridProj)
+	if err != nil {
+		return fmt.Errorf("inmap: while parsing GridProj: %v", err)
+	}
+	geoms, err := readGeomShapefile(config.GridFile, gridSR)
+	if err != nil {
+		return fmt.Errorf("inmap: loading GridFile: %v", err)
+	}
+	if len(geoms) == 0 {
+		return fmt.Errorf("inmap: GridFile %s does not contain any polygons", config.GridFile)
+	}
+	polygons := make([]geom.Polygonal, len(geoms))
+	for i, g := range geoms {
+		p, ok := g.(geom.Polygonal)
+		if !ok {
+			return fmt.Errorf("inmap: geometry type %T in GridFile %s is not a polygon", g, config.GridFile)
+		}
+		if p.Area() <= 0 {
+			return fmt.Errorf("inmap: polygon %d in GridFile %s has zero area", i, config.GridFile)
+		}
+		polygons[i] = p
+	}
+	config.gridPolygons = polygons
+	return nil
+}
+
+// irregularNeighbors finds the neighbors of cell c in a grid where the
+// grid cells are arbitrary polygons. Horizontal neighbors are cells in the
+// same layer that share an edge with c, and vertical neighbors are cells
+// in adjacent layers whose horizontal footprints overlap with c.
+// Each shared edge segment contributes the length of its projection onto
+// the y-axis to the flux between east-west neighbors and the length of its
+// projection onto the x-axis to the flux between north-south neighbors.
+// Any portion of the perimeter of c that is not shared with another cell
+// is treated as a domain boundary.
+func (d *InMAP) irregularNeighbors(c *Cell, m Mechanism) {
+	c.west, c.east, c.south, c.north = new(cellList), new(cellList), new(cellList), new(cellList)
+	c.above, c.below, c.groundLevel = new(cellList), new(cellList), new(cellList)
+
+	b := c.Bounds()
+	tol := edgeTolerance * math.Max(b.Max.X-b.Min.X, b.Max.Y-b.Min.Y)
+	box := &geom.Bounds{
+		Min: geom.Point{X: b.Min.X - tol, Y: b.Min.Y - tol},
+		Max: geom.Point{X: b.Max.X + tol, Y: b.Max.Y + tol},
+	}
+	for _, ccI := range d.index.SearchIntersect(box) {
+		cc := ccI.(*Cell)
+		if cc == c {
+			continue
+		}
+		if cc.Layer == c.Layer {
+			for dir, l := range sharedEdges(c.Polygonal, cc.Polygonal) {
+				if l > 0 {
+					d.linkIrregular(c, cc, neighborAlignment(dir), l, m)
+				}
+			}
+			continue
+		}
+		var isectArea float64
+		if cc.Layer == c.Layer+1 || cc.Layer == c.Layer-1 || cc.Layer == 0 || c.Layer == 0 {
+			isect := c.Polygonal.Intersection(cc.Polygonal)
+			if isect == nil {
+				continue
+			}
+			if isectArea = isect.Area(); isectArea <= tol*tol {
+				continue
+			}
+		}
+		switch cc.Layer {
+		case c.Layer + 1:
+			neighborInfoIrregularAboveBelow(c.above.add(cc), cc.below.add(c), isectArea)
+		case c.Layer - 1:
+			removeBoundary(cc.above, d.topBoundary)
+			neighborInfoIrregularAboveBelow(c.below.add(cc), cc.above.add(c), isectArea)
+		}
+		if cc.Layer == 0 && c.Layer > 0 {
+			c.groundLevel.add(cc).info = &neighborInfo{coverFrac: min(isectArea/c.Area(), 1.)}
+		} else if c.Layer == 0 && cc.Layer > 0 {
+			cc.groundLevel.add(c).info = &neighborInfo{coverFrac: min(isectArea/cc.Area(), 1.)}
+		}
+	}
+	if c.Layer == 0 {
+		ref := c.below.add(c) // Reflective boundary at ground level.
+		neighborInfoBoundaryTopBottom(ref)
+		c.groundLevel.add(c).info = &neighborInfo{coverFrac: 1}
+	}
+	for _, dir := range []neighborAlignment{west, east, north, south} {
+		d.setIrregularBoundary(c, dir, m)
+	}
+	if c.above.len() == 0 {
+		d.addTopBoundary(c, m)
+	}
+}
+
+// linkIrregular makes c and cc neighbors of each other, where cc is in
+// direction dir from c and the projected length of the edges they share is l.
+func (d *InMAP) linkIrregular(c, cc *Cell, dir neighborAlignment, l float64, m Mechanism) {
+	opposite := map[neighborAlignment]neighborAlignment{
+		west: east, east: west, north: south, south: north,
+	}[dir]
+	cr1 := c.neighborList(dir).add(cc)
+	cr2 := cc.neighborList(opposite).add(c)
+	if dir == west || dir == east {
+		cr1.info = &neighborInfo{
+			centerDistance: (c.Dx + cc.Dx) / 2,
+			coverFrac:      l / c.perimeterScale(dir),
+			diff:           harmonicMean(c.Kxxyy, cc.Kxxyy),
+		}
+	} else {
+		cr1.info = &neighborInfo{
+			centerDistance: (c.Dy + cc.Dy) / 2,
+			coverFrac:      l / c.perimeterScale(dir),
+			diff:           harmonicMean(c.Kxxyy, cc.Kxxyy),
+		}
+	}
+	cr2.info = &neighborInfo{
+		centerDistance: cr1.info.centerDistance,
+		coverFrac:      l / cc.perimeterScale(opposite),
+		diff:           cr1.info.diff,
+	}
+	d.setIrregularBoundary(cc, opposite, m)
+}
+
+// perimeterScale returns the factor that an edge length in the given direction
+// should be divided by to get the fraction of the cell covered by a neighbor,
+// as the fraction is used in the advection and mixing calculations.
+// The calculations divide fluxes by c.Dx (or c.Dy) and multiply them by
+// c.Volume, which is equal to c.Dx * c.Dy * c.Dz, so dividing the edge length
+// by c.Dy (or c.Dx) ensures that the mass transferred between two
+// neighbors is proportional to the length of the edge they share regardless
+// of the shapes of the cells, which is required for mass to be conserved.
+// For a rectangular cell, the factor is equal to the length of the cell edge
+// in the given direction.
+func (c *Cell) perimeterScale(dir neighborAlignment) float64 {
+	if dir == west || dir == east {
+		return c.Dy
+	}
+	return c.Dx
+}
+
+// neighborList returns the list of neighbors of c in direction dir.
+func (c *Cell) neighborList(dir neighborAlignment) *cellList {
+	switch dir {
+	case west:
+		return c.west
+	case east:
+		return c.east
+	case north:
+		return c.north
+	case south:
+		return c.south
+	default:
+		panic(fmt.Errorf("inmap: invalid neighbor direction %d", dir))
+	}
+}
+
+// boundaryList returns the list of boundary cells in direction dir.
+func (d *InMAP) boundaryList(dir neighborAlignment) *cellList {
+	switch dir {
+	case west:
+		return d.westBoundary
+	case east:
+		return d.eastBoundary
+	case north:
+		return d.northBoundary
+	case south:
+		return d.southBoundary
+	default:
+		panic(fmt.Errorf("inmap: invalid neighbor direction %d", dir))
+	}
+}
+
+// setIrregularBoundary adds a boundary cell in direction dir of c that
+// covers the portion of the perimeter of c that is not shared with any
+// other cell, replacing any existing boundary cell in that direction.
+func (d *InMAP) setIrregularBoundary(c *Cell, dir neighborAlignment, m Mechanism) {
+	neighbors := c.neighborList(dir)
+	removeBoundary(neighbors, d.boundaryList(dir))
+
+	var shared float64
+	for _, n := range *neighbors {
+		shared += n.info.coverFrac
+	}
+	exposed := exposedEdges(c.Polygonal)[dir] / c.perimeterScale(dir)
+	remaining := exposed - shared
+	if remaining <= edgeTolerance*exposed {
+		return
+	}
+	bc := c.boundaryCopy(m)
+	ref := neighbors.add(bc)
+	d.boundaryList(dir).add(bc)
+	if dir == west || dir == east {
+		neighborInfoBoundaryEastWest(ref)
+	} else {
+		neighborInfoBoundarySouthNorth(ref)
+	}
+	ref.info.coverFrac = remaining
+}
+
+// removeBoundary removes any boundary cells in l from both l and boundary.
+func removeBoundary(l, boundary *cellList) {
+	var bcs []*cellRef
+	for _, n := range *l {
+		if n.boundary {
+			bcs = append(bcs, n)
+		}
+	}
+	for _, bc := range bcs {
+		boundary.delete(bc)
+		l.delete(bc)
+	}
+}
+
+// neighborInfoIrregularAboveBelow calculates information about the
+// relationship between two cells that neighbor in the up-down direction
+// and whose horizontal footprints overlap by area isectArea, where
+// cr1 is the first cell's reference to the second cell, and
+// cr2 is the second cell's reference to the first cell.
+func neighborInfoIrregularAboveBelow(cr1, cr2 *cellRef, isectArea float64) {
+	cr1.info = &neighborInfo{
+		centerDistance: (cr2.Dz + cr1.Dz) / 2,
+		coverFrac:      min(isectArea/cr2.Area(), 1.),
+		diff:           harmonicMean(cr2.Kzz, cr1.Kzz),
+	}
+	cr2.info = &neighborInfo{
+		centerDistance: cr1.info.centerDistance,
+		coverFrac:      min(isectArea/cr1.Area(), 1.),
+		diff:           cr1.info.diff,
+	}
+}
+
+// polygonEdge is an edge of a polygon, along with the
+// direction that points away from the interior of the polygon.
+type polygonEdge struct {
+	a, b   geom.Point
+	normal geom.Point
+}
+
+// polygonEdges returns the edges of p.
+func polygonEdges(p geom.Polygonal) []polygonEdge {
+	var o []polygonEdge
+	for _, poly := range p.Polygons() {
+		for _, ring := range poly {
+			for i := range ring {
+				a, b := ring[i], ring[(i+1)%len(ring)]
+				dx, dy := b.X-a.X, b.Y-a.Y
+				l := math.Hypot(dx, dy)
+				if l == 0 {
+					continue
+				}
+				// Check which side of the edge is outside of the polygon.
+				n := geom.Point{X: dy / l, Y: -dx / l}
+				test := geom.Point{
+					X: (a.X+b.X)/2 + n.X*l*1.e-6,
+					Y: (a.Y+b.Y)/2 + n.Y*l*1.e-6,
+				}
+				if test.Within(p) == geom.Inside {
+					n.X, n.Y = -n.X, -n.Y
+				}
+				o = append(o, polygonEdge{a: a, b: b, normal: n})
+			}
+		}
+	}
+	return o
+}
+
+// addProjectedLength adds the projections of the segment with vector
+// (sx, sy) and outward direction normal to o, which is indexed by
+// neighborAlignment.
+func addProjectedLength(o *[4]float64, sx, sy float64, normal geom.Point) {
+	const small = 1.e-10
+	if normal.X > small {
+		o[east] += math.Abs(sy)
+	} else if normal.X < -small {
+		o[west] += math.Abs(sy)
+	}
+	if normal.Y > small {
+		o[north] += math.Abs(sx)
+	} else if normal.Y < -small {
+		o[south] += math.Abs(sx)
+	}
+}
+
+// exposedEdges returns the total projected lengths of the edges of p
+// facing each direction, indexed by neighborAlignment.
+func exposedEdges(p geom.Polygonal) [4]float64 {
+	var o [4]float64
+	for _, e := range polygonEdges(p) {
+		addProjectedLength(&o, e.b.X-e.a.X, e.b.Y-e.a.Y, e.normal)
+	}
+	return o
+}
+
+// sharedEdges returns the projected lengths of the edges shared by
+// p1 and p2, indexed by the neighborAlignment of p2 relative to p1.
+func sharedEdges(p1, p2 geom.Polygonal) [4]float64 {
+	var o [4]float64
+	b := p1.Bounds()
+	tol := edgeTolerance * math.Max(b.Max.X-b.Min.X, b.Max.Y-b.Min.Y)
+	edges2 := polygonEdges(p2)
+	for _, e1 := range polygonEdges(p1) {
+		dx, dy := e1.b.X-e1.a.X, e1.b.Y-e1.a.Y
+		l2 := dx*dx + dy*dy
+		l := math.Sqrt(l2)
+		for _, e2 := range edges2 {
+			// Check whether the edges are collinear.
+			if math.Abs(dx*(e2.a.Y-e1.a.Y)-dy*(e2.a.X-e1.a.X))/l > tol ||
+				math.Abs(dx*(e2.b.Y-e1.a.Y)-dy*(e2.b.X-e1.a.X))/l > tol {
+				continue
+			}
+			// Find the overlapping segment.
+			ta := ((e2.a.X-e1.a.X)*dx + (e2.a.Y-e1.a.Y)*dy) / l2
+			tb := ((e2.b.X-e1.a.X)*dx + (e2.b.Y-e1.a.Y)*dy) / l2
+			t0 := math.Max(0, math.Min(ta, tb))
+			t1 := math.Min(1, math.Max(ta, tb))
+			if (t1-t0)*l <= tol {
+				continue
+			}
+			addProjectedLength(&o, (t1-t0)*dx, (t1-t0)*dy, e1.normal)
+		}
+	}
+	return o
+}
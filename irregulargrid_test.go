@@ -0,0 +1,322 @@
+/*
+Copyright © 2013 the InMAP authors.
+This file is part of InMAP.
+
+InMAP is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+InMAP is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with InMAP.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ctessum/geom"
+	"github.com/ctessum/geom/encoding/shp"
+)
+
+// writeGridFile writes polygons to a shapefile in directory dir.
+func writeGridFile(dir, gridProj string, polygons []geom.Polygon, t *testing.T) string {
+	type rec struct {
+		geom.Polygon
+		ID int
+	}
+	fname := filepath.Join(dir, "grid.shp")
+	e, err := shp.NewEncoder(fname, rec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range polygons {
+		if err = e.Encode(rec{Polygon: p, ID: i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.Close()
+	if err = ioutil.WriteFile(filepath.Join(dir, "grid.prj"), []byte(gridProj), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+// rect returns a rectangular polygon.
+func rect(x0, y0, x1, y1 float64) geom.Polygon {
+	return geom.Polygon{{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}, {X: x0, Y: y0}}}
+}
+
+func TestIrregularGrid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_irregular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	// A tall cell on the west side and two square cells on the east side.
+	cfg.GridFile = writeGridFile(dir, cfg.GridProj, []geom.Polygon{
+		rect(-4000, -4000, 0, 4000),
+		rect(0, -4000, 4000, 0),
+		rect(0, 0, 4000, 4000),
+	}, t)
+
+	var m Mech
+	buf := new(bytes.Buffer)
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+			cfg.MutateGrid(func(*Cell, float64, float64) bool { return true }, ctmdata, pop, mr, nil, m, nil),
+			Save(buf),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.cells.len(); n != 30 {
+		t.Errorf("want 30 cells but have %d", n)
+	}
+	d.TestCellAlignment2(t)
+
+	cells := d.cells.array()
+	west, se, ne := cells[0], cells[1], cells[2]
+	if n := west.east.len(); n != 2 {
+		t.Fatalf("west cell should have 2 east neighbors but has %d", n)
+	}
+	for _, e := range *west.east {
+		if e.boundary {
+			t.Errorf("west cell should not have an east boundary")
+		}
+		if different(e.info.coverFrac, 0.5, 1.e-10) {
+			t.Errorf("east coverFrac: want 0.5 but have %g", e.info.coverFrac)
+		}
+	}
+	if n := se.north.len(); n != 1 || se.north.array()[0] != ne {
+		t.Errorf("incorrect north neighbor for southeast cell")
+	}
+	if n := se.west.len(); n != 1 || se.west.array()[0] != west {
+		t.Errorf("incorrect west neighbor for southeast cell")
+	}
+	if pop := west.PopData[popIndices["TotalPop"]] + se.PopData[popIndices["TotalPop"]] +
+		ne.PopData[popIndices["TotalPop"]]; pop <= 0 {
+		t.Errorf("population should be greater than zero")
+	}
+
+	d2 := &InMAP{
+		InitFuncs: []DomainManipulator{
+			Load(buf, cfg, nil, m),
+		},
+	}
+	if err := d2.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if !d2.irregularGrid {
+		t.Errorf("loaded grid should be irregular")
+	}
+	if n := d2.cells.array()[0].east.len(); n != 2 {
+		t.Errorf("loaded west cell should have 2 east neighbors but has %d", n)
+	}
+	d2.TestCellAlignment2(t)
+}
+
+func TestSharedEdges(t *testing.T) {
+	// Two right triangles that make up a square, sharing the diagonal.
+	lower := geom.Polygon{{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 2, Y: 2}, {X: 0, Y: 0}}}
+	upper := geom.Polygon{{{X: 0, Y: 0}, {X: 2, Y: 2}, {X: 0, Y: 2}, {X: 0, Y: 0}}}
+
+	// The upper triangle is to the northwest of the lower triangle.
+	want := [4]float64{west: 2, north: 2}
+	if have := sharedEdges(lower, upper); have != want {
+		t.Errorf("lower to upper: want %v but have %v", want, have)
+	}
+	want = [4]float64{east: 2, south: 2}
+	if have := sharedEdges(upper, lower); have != want {
+		t.Errorf("upper to lower: want %v but have %v", want, have)
+	}
+
+	// Squares that partially share an edge.
+	a := rect(0, 0, 2, 2)
+	b := rect(2, 1, 4, 5)
+	want = [4]float64{east: 1}
+	if have := sharedEdges(a, b); have != want {
+		t.Errorf("partial: want %v but have %v", want, have)
+	}
+	want = [4]float64{west: 4, east: 4, north: 2, south: 2}
+	if have := exposedEdges(b); have != want {
+		t.Errorf("exposed: want %v but have %v", want, have)
+	}
+}
+
+// hexagon returns a hexagon with flat tops and bottoms, with the given
+// center and distance from the center to each vertex.
+func hexagon(x, y, r float64) geom.Polygon {
+	h := r * math.Sqrt(3) / 2
+	return geom.Polygon{{
+		{X: x + r, Y: y}, {X: x + r/2, Y: y + h}, {X: x - r/2, Y: y + h},
+		{X: x - r, Y: y}, {X: x - r/2, Y: y - h}, {X: x + r/2, Y: y - h}, {X: x + r, Y: y},
+	}}
+}
+
+// hexagonGrid returns a grid of 5 columns and 3 rows of hexagons
+// within the domain of VarGridTestData.
+func hexagonGrid() []geom.Polygon {
+	const r = 1000.
+	h := r * math.Sqrt(3)
+	var o []geom.Polygon
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 3; j++ {
+			x := -3000 + 1.5*r*float64(i)
+			y := -h + h*float64(j)
+			if i%2 == 1 {
+				y += h / 2
+			}
+			o = append(o, hexagon(x, y, r))
+		}
+	}
+	return o
+}
+
+func TestIrregularGrid_hexagon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmap_irregular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	cfg.GridFile = writeGridFile(dir, cfg.GridProj, hexagonGrid(), t)
+
+	var m Mech
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, NewEmissions(), m),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// The cell in the center of the grid.
+	c := d.cells.array()[7]
+	if different(c.Dx*c.Dy, c.Area(), 1.e-10) {
+		t.Errorf("cell horizontal area: want %g but have %g", c.Area(), c.Dx*c.Dy)
+	}
+	// Dy is set so that Dx*Dy equals the area of the hexagon, so the
+	// east-west neighbor fractions add up to more than one.
+	for _, test := range []struct {
+		dir       neighborAlignment
+		neighbors []float64 // sorted coverFrac of each neighbor
+	}{
+		// The northeast and southeast neighbors each share an edge
+		// with a projected length of h/2 = Dy*2/3.
+		{dir: east, neighbors: []float64{2. / 3, 2. / 3}},
+		{dir: west, neighbors: []float64{2. / 3, 2. / 3}},
+		// The north neighbor shares an edge with a projected length of
+		// Dx/2, and the northeast and northwest neighbors each share an
+		// edge with a projected length of Dx/4.
+		{dir: north, neighbors: []float64{0.25, 0.25, 0.5}},
+		{dir: south, neighbors: []float64{0.25, 0.25, 0.5}},
+	} {
+		l := c.neighborList(test.dir)
+		if l.len() != len(test.neighbors) {
+			t.Errorf("direction %d: want %d neighbors but have %d", test.dir, len(test.neighbors), l.len())
+			continue
+		}
+		var have []float64
+		for _, n := range *l {
+			if n.boundary {
+				t.Errorf("direction %d: there should not be a boundary", test.dir)
+			}
+			have = append(have, n.info.coverFrac)
+		}
+		sort.Float64s(have)
+		for i, want := range test.neighbors {
+			if different(have[i], want, 1.e-8) {
+				t.Errorf("direction %d: want coverFrac %g but have %g", test.dir, want, have[i])
+			}
+		}
+	}
+}
+
+// Test whether mass is conserved by advection and mixing in a grid
+// made up of differently-shaped polygons.
+func TestIrregularGrid_massConservation(t *testing.T) {
+	const (
+		testTolerance = 1.e-8
+		numTimesteps  = 20
+	)
+	dir, err := ioutil.TempDir("", "inmap_irregular")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg, ctmdata, pop, popIndices, mr, mortIndices := VarGridTestData()
+	// Split the center hexagon into two irregular quadrilaterals.
+	polygons := hexagonGrid()
+	center := polygons[7][0]
+	polygons[7] = geom.Polygon{{center[1], center[2], center[3], center[4], center[1]}}
+	polygons = append(polygons, geom.Polygon{{center[4], center[5], center[0], center[1], center[4]}})
+	cfg.GridFile = writeGridFile(dir, cfg.GridProj, polygons, t)
+
+	emis := NewEmissions()
+	emis.Add(&EmisRecord{
+		PM25: E,
+		Geom: geom.Point{X: -200, Y: 300},
+	}) // ground level emissions in the center of the grid
+
+	var m Mech
+	d := &InMAP{
+		InitFuncs: []DomainManipulator{
+			cfg.RegularGrid(ctmdata, pop, popIndices, mr, mortIndices, emis, m),
+			SetTimestepCFL(),
+		},
+		RunFuncs: []DomainManipulator{
+			Calculations(AddEmissionsFlux()),
+			Calculations(UpwindAdvection(), Mixing(), MeanderMixing()),
+			SteadyStateConvergenceCheck(numTimesteps, cfg.PopGridColumn, m, nil),
+		},
+	}
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	// The convective mixing coefficients of cells that do not line up
+	// with the CTM grid cells are area-weighted averages that are not
+	// exactly balanced, so turn convection off to isolate the
+	// neighbor relationships.
+	for _, c := range d.Cells() {
+		c.M2u, c.M2d = 0, 0
+	}
+	if err := d.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sum, expectedMass float64
+	for _, group := range []*cellList{d.cells, d.westBoundary, d.eastBoundary,
+		d.northBoundary, d.southBoundary, d.topBoundary} {
+		for _, c := range *group {
+			sum += c.Cf[iPM2_5] * c.Volume
+			if c.EmisFlux != nil {
+				expectedMass += c.EmisFlux[iPM2_5] * c.Volume * d.Dt * numTimesteps
+			}
+		}
+	}
+	if different(sum, expectedMass, testTolerance) {
+		t.Errorf("sum=%g (it should equal %g)", sum, expectedMass)
+	}
+	if other := d.Cells()[15]; other.Cf[iPM2_5] <= 0 {
+		t.Errorf("mass should have been transported to the other half of the split cell")
+	}
+}
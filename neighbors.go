@@ -25,6 +25,10 @@ import (
 )
 
 func (d *InMAP) setNeighbors(c *Cell, m Mechanism) {
+	if d.irregularGrid {
+		d.irregularNeighbors(c, m)
+		return
+	}
 	d.neighbors(c)
 	d.setBoundaryNeighbors(c, m)
 }
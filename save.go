@@ -44,6 +44,7 @@ type versionCells struct {
 	DataVersion    string
 	Cells          []*Cell
 	HorizontalWrap float64
+	IrregularGrid  bool
 }
 
 // Save returns a function that saves the data in d to a gob file
@@ -60,6 +61,7 @@ func Save(w io.Writer) DomainManipulator {
 			DataVersion:    VarGridDataVersion,
 			Cells:          d.cells.array(),
 			HorizontalWrap: d.HorizontalWrap,
+			IrregularGrid:  d.irregularGrid,
 		}
 
 		e := gob.NewEncoder(w)
@@ -80,6 +82,7 @@ func Load(r io.Reader, config *VarGridConfig, emis *Emissions, m Mechanism) Doma
 		if err := dec.Decode(&data); err != nil {
 			return fmt.Errorf("inmap.InMAP.Load: %v", err)
 		}
+		d.irregularGrid = data.IrregularGrid
 		if err := d.initFromCells(data.Cells, emis, config, m); err != nil {
 			return err
 		}
@@ -158,12 +158,22 @@ func (sr *SR) Start(ctx context.Context, jobName, version string, layers []int,
 		if err != nil {
 			return err
 		}
+		// Input files that are shared among jobs only need to be uploaded once.
+		restore, err := cloud.RemoveStagedInputs(ctx, sr.client, js)
+		if err != nil {
+			return err
+		}
 
 		err = backoff.RetryNotify(
 			func() error {
 				// Start the simulation.
 				_, err = sr.client.RunJob(ctx, js)
 				if err != nil {
+					if cloud.IsNotStaged(err) {
+						// Upload all of the input files on the next try.
+						restore()
+						return err
+					}
 					if strings.Contains(err.Error(), "already exists") {
 						log.Println(err)
 					} else {
@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 
@@ -53,6 +54,11 @@ type Reader struct {
 	// concentrations for the first time.
 	CacheSize int
 
+	// AboveTop specifies how emissions with plume heights above the
+	// top layer in the SR matrix should be handled. The default is
+	// AboveTopError.
+	AboveTop AboveTopMode
+
 	// sourceCache is a cache for SR records.
 	sourceCache *requestcache.Cache
 	// sourceInit is used to initialize sourceCache.
@@ -248,20 +254,14 @@ func (c *Concentrations) TotalPM25() []float64 {
 // Concentrations returns the change in Total PM2.5 concentrations caused
 // by the emissions specified by e, after accounting for plume rise.
 // If the emission plume height is above the highest layer in the SR
-// matrix, the function will allocate the emissions to the top layer
-// and an error of type AboveTopErr will be returned. In some cases it
-// may be appropriate to ignore errors of this type.
+// matrix, the emissions will be handled as specified by the AboveTop
+// attribute of the receiver. By default, the function will allocate the
+// emissions to the top layer and an error of type AboveTopErr will be
+// returned. In some cases it may be appropriate to ignore errors of this type.
 // As specified in the EmisRecord documentation,
 // emission units should be in μg/s.
 func (sr *Reader) Concentrations(emis ...*inmap.EmisRecord) (*Concentrations, error) {
-
-	out := &Concentrations{
-		PNH4:        make([]float64, sr.nCellsGroundLevel),
-		PNO3:        make([]float64, sr.nCellsGroundLevel),
-		PSO4:        make([]float64, sr.nCellsGroundLevel),
-		SOA:         make([]float64, sr.nCellsGroundLevel),
-		PrimaryPM25: make([]float64, sr.nCellsGroundLevel),
-	}
+	out := sr.newConcentrations()
 
 	// stickyErr is used for errors that shouldn't immediately
 	// cause the function to fail but should be returned with the
@@ -269,69 +269,199 @@ func (sr *Reader) Concentrations(emis ...*inmap.EmisRecord) (*Concentrations, er
 	var stickyErr error
 
 	for _, e := range emis {
-		cells, fractions := sr.d.CellIntersections(e.Geom)
-		for i, c := range cells {
-			// Figure out if this cell is the right layer.
-			var plumeHeight float64
-			if e.Height != 0 {
-				var in bool
-				var err error
-				in, plumeHeight, err = c.IsPlumeIn(e.Height, e.Diam, e.Temp, e.Velocity)
-				if err != nil {
-					return nil, err
-				}
-				if !in {
-					continue
-				}
-			} else { // ground-level emissions
-				if c.Layer != 0 {
-					continue
-				}
+		err := sr.allocate(e, func(loc srLocation, frac float64) error {
+			return sr.addSource(out, loc, frac, [5]float64{e.NH3, e.NOx, e.SOx, e.VOC, e.PM25})
+		})
+		if err != nil {
+			switch err.(type) {
+			case AboveTopErr:
+				stickyErr = err
+			default:
+				return nil, err
 			}
-			frac := fractions[i]
-			index := sr.indices[c]
+		}
+	}
+	return out, stickyErr
+}
 
-			layers, layerfracs, err := sr.layerFracs(c, plumeHeight)
-			if err != nil {
-				switch err.(type) {
-				case AboveTopErr:
-					stickyErr = err
-				default:
-					return nil, err
-				}
+// ConcentrationsBatch is the same as Concentrations, except that
+// the emissions are first grouped by the SR matrix
+// layer and grid cell they are allocated to after accounting
+// for plume rise, so that each SR matrix record only needs to be
+// read and applied once regardless of the number of emissions
+// records that are allocated to it. The grouped records are
+// then processed in parallel. This is much faster than
+// Concentrations when there are many emissions records, for example
+// when e holds a large inventory of point sources.
+// Results may differ from those of Concentrations by
+// floating-point rounding error.
+func (sr *Reader) ConcentrationsBatch(emis ...*inmap.EmisRecord) (*Concentrations, error) {
+	var stickyErr error
+	groups := make(map[srLocation]*[5]float64)
+	for _, e := range emis {
+		err := sr.allocate(e, func(loc srLocation, frac float64) error {
+			g, ok := groups[loc]
+			if !ok {
+				g = new([5]float64)
+				groups[loc] = g
+			}
+			for i, v := range [5]float64{e.NH3, e.NOx, e.SOx, e.VOC, e.PM25} {
+				g[i] += v * frac
 			}
+			return nil
+		})
+		if err != nil {
+			switch err.(type) {
+			case AboveTopErr:
+				stickyErr = err
+			default:
+				return nil, err
+			}
+		}
+	}
 
-			for i, layer := range layers {
-				layerfrac := layerfracs[i]
-
-				for i, emis := range []float64{e.NH3, e.NOx, e.SOx, e.VOC, e.PM25} {
-					if emis != 0 {
-						v, err := sr.Source(polNames[i], layer, index)
-						if err != nil {
-							return nil, err
-						}
-						switch polNames[i] {
-						case "pNH4":
-							floats.AddScaled(out.PNH4, emis*frac*layerfrac, v)
-						case "pNO3":
-							floats.AddScaled(out.PNO3, emis*frac*layerfrac, v)
-						case "pSO4":
-							floats.AddScaled(out.PSO4, emis*frac*layerfrac, v)
-						case "SOA":
-							floats.AddScaled(out.SOA, emis*frac*layerfrac, v)
-						case "PrimaryPM25":
-							floats.AddScaled(out.PrimaryPM25, emis*frac*layerfrac, v)
-						default:
-							panic(fmt.Errorf("invalid pollutant %s", polNames[i]))
-						}
-					}
+	locs := make([]srLocation, 0, len(groups))
+	for loc := range groups {
+		locs = append(locs, loc)
+	}
+	// Sort the locations so that results are as repeatable as possible.
+	sort.Slice(locs, func(i, j int) bool {
+		if locs[i].layer != locs[j].layer {
+			return locs[i].layer < locs[j].layer
+		}
+		return locs[i].index < locs[j].index
+	})
+
+	nprocs := runtime.GOMAXPROCS(-1)
+	results := make([]*Concentrations, nprocs)
+	errs := make([]error, nprocs)
+	var wg sync.WaitGroup
+	wg.Add(nprocs)
+	for p := 0; p < nprocs; p++ {
+		go func(p int) {
+			defer wg.Done()
+			results[p] = sr.newConcentrations()
+			for i := p; i < len(locs); i += nprocs {
+				if err := sr.addSource(results[p], locs[i], 1, *groups[locs[i]]); err != nil {
+					errs[p] = err
+					return
 				}
 			}
+		}(p)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
 	}
+	out := results[0]
+	for _, r := range results[1:] {
+		floats.Add(out.PNH4, r.PNH4)
+		floats.Add(out.PNO3, r.PNO3)
+		floats.Add(out.PSO4, r.PSO4)
+		floats.Add(out.SOA, r.SOA)
+		floats.Add(out.PrimaryPM25, r.PrimaryPM25)
+	}
 	return out, stickyErr
 }
 
+// newConcentrations returns a new zero-valued Concentrations variable
+// with the same number of cells as the ground-level SR matrix.
+func (sr *Reader) newConcentrations() *Concentrations {
+	return &Concentrations{
+		PNH4:        make([]float64, sr.nCellsGroundLevel),
+		PNO3:        make([]float64, sr.nCellsGroundLevel),
+		PSO4:        make([]float64, sr.nCellsGroundLevel),
+		SOA:         make([]float64, sr.nCellsGroundLevel),
+		PrimaryPM25: make([]float64, sr.nCellsGroundLevel),
+	}
+}
+
+// srLocation is the location of an emissions source in the SR matrix.
+type srLocation struct {
+	layer, index int // SR layer index and horizontal grid cell index
+}
+
+// allocate determines the locations in the SR matrix that emissions
+// record e should be allocated to, after accounting for plume rise,
+// and calls f for each location with the fraction of the emissions
+// allocated to that location. If the plume height is above the top
+// layer of the SR matrix and the AboveTop attribute of the receiver
+// is AboveTopError, allocation will be completed and an error of type
+// AboveTopErr will be returned.
+func (sr *Reader) allocate(e *inmap.EmisRecord, f func(loc srLocation, frac float64) error) error {
+	var stickyErr error
+	cells, fractions := sr.d.CellIntersections(e.Geom)
+	for i, c := range cells {
+		// Figure out if this cell is the right layer.
+		var plumeHeight float64
+		if e.Height != 0 {
+			var in bool
+			var err error
+			in, plumeHeight, err = c.IsPlumeIn(e.Height, e.Diam, e.Temp, e.Velocity)
+			if err != nil {
+				return err
+			}
+			if !in {
+				continue
+			}
+		} else { // ground-level emissions
+			if c.Layer != 0 {
+				continue
+			}
+		}
+		frac := fractions[i]
+		index := sr.indices[c]
+
+		layers, layerfracs, err := sr.layerFracs(c, plumeHeight)
+		if err != nil {
+			switch err.(type) {
+			case AboveTopErr:
+				stickyErr = err
+			default:
+				return err
+			}
+		}
+		for i, layer := range layers {
+			if err := f(srLocation{layer: layer, index: index}, frac*layerfracs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return stickyErr
+}
+
+// addSource adds the impacts of emissions emis of each of the
+// pollutants in polNames at SR matrix location loc, multiplied
+// by frac, to out.
+func (sr *Reader) addSource(out *Concentrations, loc srLocation, frac float64, emis [5]float64) error {
+	for i, e := range emis {
+		if e == 0 {
+			continue
+		}
+		v, err := sr.Source(polNames[i], loc.layer, loc.index)
+		if err != nil {
+			return err
+		}
+		switch polNames[i] {
+		case "pNH4":
+			floats.AddScaled(out.PNH4, e*frac, v)
+		case "pNO3":
+			floats.AddScaled(out.PNO3, e*frac, v)
+		case "pSO4":
+			floats.AddScaled(out.PSO4, e*frac, v)
+		case "SOA":
+			floats.AddScaled(out.SOA, e*frac, v)
+		case "PrimaryPM25":
+			floats.AddScaled(out.PrimaryPM25, e*frac, v)
+		default:
+			panic(fmt.Errorf("invalid pollutant %s", polNames[i]))
+		}
+	}
+	return nil
+}
+
 // SetConcentrations set the `Cf` concentration field of the underlying
 // InMAP data structure to the specified values. This is not
 // concurrency-safe.
@@ -428,11 +558,52 @@ func (sr *Reader) layerFracs(c *inmap.Cell, plumeHeight float64) ([]int, []float
 	}
 
 	if c.Layer > sr.layers[len(sr.layers)-1] {
-		return []int{len(sr.layers) - 1}, []float64{1.}, AboveTopErr{PlumeHeight: plumeHeight}
+		top := len(sr.layers) - 1
+		switch sr.AboveTop {
+		case AboveTopError:
+			return []int{top}, []float64{1.}, AboveTopErr{PlumeHeight: plumeHeight}
+		case AboveTopClamp:
+			return []int{top}, []float64{1.}, nil
+		case AboveTopExtrapolate:
+			if top == 0 {
+				// We need at least two layers to extrapolate.
+				return []int{top}, []float64{1.}, nil
+			}
+			below := layerHeights[sr.layers[top-1]]
+			above := layerHeights[sr.layers[top]]
+			frac := (plumeHeight - below) / (above - below)
+			return []int{top - 1, top}, []float64{1 - frac, frac}, nil
+		default:
+			return nil, nil, fmt.Errorf("sr: invalid AboveTop mode %d", sr.AboveTop)
+		}
 	}
 	panic("problem in layerFracs")
 }
 
+// AboveTopMode specifies how emissions with plume heights above the
+// top layer in the SR matrix are handled.
+type AboveTopMode int
+
+const (
+	// AboveTopError specifies that emissions above the top layer
+	// should be allocated to the top layer and an error of type
+	// AboveTopErr should be returned. This is the default.
+	AboveTopError AboveTopMode = iota
+
+	// AboveTopClamp specifies that emissions above the top layer
+	// should be allocated to the top layer without returning an error.
+	AboveTopClamp
+
+	// AboveTopExtrapolate specifies that the impacts of emissions above
+	// the top layer should be linearly extrapolated from the impacts
+	// of emissions in the two highest layers in the SR matrix, based on
+	// the plume height. Extrapolating far above the top layer can
+	// result in negative concentrations at some receptors, so this option
+	// should be used with care. If the SR matrix only has one layer,
+	// this option is equivalent to AboveTopClamp.
+	AboveTopExtrapolate
+)
+
 // AboveTopErr is returned when the plume height of an emissions
 // source is above the top layer in the SR matrix.
 type AboveTopErr struct {
@@ -31,6 +31,7 @@ import (
 	"github.com/ctessum/geom/encoding/shp"
 	"github.com/ctessum/geom/proj"
 	"github.com/spatialmodel/inmap"
+	"github.com/spatialmodel/inmap/science/chem/simplechem"
 )
 
 func TestLayerFracs(t *testing.T) {
@@ -331,6 +332,117 @@ func TestConcentrations(t *testing.T) {
 	}
 }
 
+func TestConcentrationsAboveTop(t *testing.T) {
+	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &inmap.EmisRecord{
+		Geom:   geom.Point{X: -3500, Y: -3500},
+		PM25:   1,
+		Height: 800,
+	}
+
+	sr.AboveTop = AboveTopError
+	want, err := sr.Concentrations(e)
+	if _, ok := err.(AboveTopErr); !ok {
+		t.Fatalf("want AboveTopErr but have %v", err)
+	}
+
+	sr.AboveTop = AboveTopClamp
+	c, err := sr.Concentrations(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(want.TotalPM25(), c.TotalPM25()) {
+		t.Errorf("clamp: want %v but have %v", want.TotalPM25(), c.TotalPM25())
+	}
+
+	sr.AboveTop = AboveTopExtrapolate
+	c, err = sr.Concentrations(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(want.TotalPM25(), c.TotalPM25()) {
+		t.Errorf("extrapolated concentrations should be different from clamped concentrations")
+	}
+
+	// The extrapolated concentrations should be a linear extrapolation
+	// of the concentrations caused by emissions at the heights of
+	// the two highest layers in the SR matrix.
+	var m simplechem.Mechanism
+	heights, _, err := sr.d.VerticalProfile("WindSpeed", e.Geom.(geom.Point), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	below, above := heights[sr.layers[len(sr.layers)-2]], heights[sr.layers[len(sr.layers)-1]]
+	eBelow, eAbove := *e, *e
+	eBelow.Height, eAbove.Height = below, above
+	cBelow, err := sr.Concentrations(&eBelow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cAbove, err := sr.Concentrations(&eAbove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frac := (e.Height - below) / (above - below)
+	if frac <= 1 {
+		t.Fatalf("emissions should be above the top layer, but the fraction is %g", frac)
+	}
+	pmBelow, pmAbove := cBelow.TotalPM25(), cAbove.TotalPM25()
+	for i, v := range c.TotalPM25() {
+		w := (1-frac)*pmBelow[i] + frac*pmAbove[i]
+		if math.Abs(w-v) > 1.e-8*math.Abs(w) {
+			t.Errorf("extrapolate row %d: want %g but have %g", i, w, v)
+		}
+	}
+}
+
+func TestConcentrationsBatch(t *testing.T) {
+	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr.AboveTop = AboveTopClamp
+
+	rnd := rand.New(rand.NewSource(1))
+	e := make([]*inmap.EmisRecord, 500)
+	for i := range e {
+		e[i] = &inmap.EmisRecord{
+			Geom:   geom.Point{X: rnd.Float64()*7000 - 3500, Y: rnd.Float64()*7000 - 3500},
+			PM25:   rnd.Float64(),
+			NOx:    rnd.Float64(),
+			NH3:    rnd.Float64(),
+			SOx:    rnd.Float64(),
+			VOC:    rnd.Float64(),
+			Height: rnd.Float64() * 400,
+		}
+	}
+	want, err := sr.Concentrations(e...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := sr.ConcentrationsBatch(e...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPM, havePM := want.TotalPM25(), have.TotalPM25()
+	for i, w := range wantPM {
+		if v := havePM[i]; math.Abs(w-v)*2/(w+v) > 1.e-8 {
+			t.Errorf("row %d: want %v but have %v", i, w, v)
+		}
+	}
+}
+
 func BenchmarkConcentrations(b *testing.B) {
 	r, err := os.Open("../cmd/inmap/testdata/testSR_golden.ncf")
 	if err != nil {
@@ -360,6 +472,12 @@ func BenchmarkConcentrations(b *testing.B) {
 				b.Fatal(err)
 			}
 		})
+		b.Run(fmt.Sprintf("batch_%d", n), func(b *testing.B) {
+			_, err := sr.ConcentrationsBatch(r...)
+			if err != nil {
+				b.Fatal(err)
+			}
+		})
 	}
 }
 
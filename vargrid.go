@@ -72,9 +72,47 @@ type VarGridConfig struct {
 	MortalityRateColumns map[string]string
 
 	GridProj string // projection info for CTM grid; Proj4 format
+
+	// RefinementCriteria specifies criteria, in addition to population,
+	// that can be used for dividing grid cells in static variable resolution
+	// grids. The map keys are names for the criteria that can be
+	// referred to in RefinementRule.
+	RefinementCriteria map[string]RefinementCriterion
+
+	// RefinementRule is a boolean expression specifying how the criteria in
+	// RefinementCriteria and the built-in "Population" criterion
+	// should be combined to determine whether a grid cell in a static
+	// grid should be divided. Criteria can be combined using "&&" (AND),
+	// "||" (OR), and parentheses, for example:
+	// "Population || (Nonattainment && PointSources)". If RefinementRule
+	// is empty, only the "Population" criterion is used.
+	// See the documentation for RefinementMutator for more information.
+	RefinementRule string
+
+	// GridFile is the path to a shapefile containing polygons, such as
+	// census tracts or hexagons, to be used as the horizontal grid cells
+	// instead of a regular grid with nested subdivisions. If GridFile is
+	// specified, VariableGridXo, VariableGridYo, VariableGridDx,
+	// VariableGridDy, Xnests, Ynests, and HiResLayers are ignored and
+	// the grid cannot be mutated. Grid cells are considered to be neighbors
+	// if they share an edge, so the polygons should not overlap and
+	// adjacent polygons should have coincident vertices. If the shapefile
+	// includes projection information, the polygons will be reprojected to
+	// the InMAP grid spatial reference.
+	GridFile string
+
+	// gridPolygons holds the polygons loaded from GridFile.
+	gridPolygons []geom.Polygonal
 }
 
 func (c *VarGridConfig) bounds() *geom.Bounds {
+	if c.gridPolygons != nil {
+		b := geom.NewBounds()
+		for _, p := range c.gridPolygons {
+			b.Extend(p.Bounds())
+		}
+		return b
+	}
 	return &geom.Bounds{
 		Min: geom.Point{X: c.VariableGridXo, Y: c.VariableGridYo},
 		Max: geom.Point{
@@ -399,6 +437,11 @@ func (config *VarGridConfig) LoadPopMort() (*Population, PopIndices, *MortalityR
 	if err != nil {
 		return nil, nil, nil, nil, fmt.Errorf("inmap: while parsing GridProj: %v", err)
 	}
+	if config.GridFile != "" {
+		if err = config.loadGridFile(); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
 
 	pop, popIndex, err := config.loadPopulation(gridSR, config.bounds())
 	if err != nil {
@@ -451,7 +494,8 @@ func (config *VarGridConfig) webMapTrans() (t proj.Transformer, notMeters bool,
 
 // RegularGrid returns a function that creates a new regular
 // (i.e., not variable resolution) grid
-// as specified by the information in c.
+// as specified by the information in c. If config.GridFile is
+// specified, the grid will instead be made up of the polygons in that file.
 func (config *VarGridConfig) RegularGrid(data *CTMData, pop *Population, popIndex PopIndices, mortRates *MortalityRates, mortIndex MortIndices, emis *Emissions, m Mechanism) DomainManipulator {
 	return func(d *InMAP) error {
 		webMapTrans, notMeters, err := config.webMapTrans()
@@ -470,9 +514,21 @@ func (config *VarGridConfig) RegularGrid(data *CTMData, pop *Population, popInde
 		nz := data.Data["UAvg"].Data.Shape[0]
 		d.nlayers = nz
 
-		type cellErr struct {
-			cell *Cell
-			err  error
+		if config.GridFile != "" {
+			if err = config.loadGridFile(); err != nil {
+				return err
+			}
+			d.irregularGrid = true
+			d.HorizontalWrap = math.NaN()
+			indices := make([][][2]int, 0, nz*len(config.gridPolygons))
+			layers := make([]int, 0, nz*len(config.gridPolygons))
+			for k := 0; k < nz; k++ {
+				for i := range config.gridPolygons {
+					indices = append(indices, [][2]int{{i, 0}})
+					layers = append(layers, k)
+				}
+			}
+			return d.addCells(config, indices, layers, nil, data, pop, mortRates, emis, webMapTrans, m, notMeters)
 		}
 
 		nx := config.Xnests[0]
@@ -518,8 +574,15 @@ func (d *InMAP) totalMassPopulation(popGridColumn string) (totalMass, totalPopul
 // true are divided to the next nest level (up to the maximum nest level), and
 // cells where divideRule is false are combined (down to the baseline nest level).
 // Log messages are written to logChan if it is not nil.
+// Grids created from config.GridFile are not mutated.
 func (config *VarGridConfig) MutateGrid(divideRule GridMutator, data *CTMData, pop *Population, mortRates *MortalityRates, emis *Emissions, m Mechanism, logChan chan string) DomainManipulator {
 	return func(d *InMAP) error {
+		if d.irregularGrid {
+			if logChan != nil {
+				logChan <- "Grid was created from GridFile; skipping grid mutation."
+			}
+			return nil
+		}
 		if logChan != nil {
 			logChan <- fmt.Sprint("Adding grid cells...")
 		}
@@ -783,6 +846,9 @@ func (p *PopConcMutator) Mutate() GridMutator {
 
 // cellGeometry returns the geometry of a cell with the give index.
 func (config *VarGridConfig) cellGeometry(index [][2]int) geom.Polygonal {
+	if config.gridPolygons != nil {
+		return config.gridPolygons[index[0][0]]
+	}
 	xResFac, yResFac := 1., 1.
 	l := config.VariableGridXo
 	b := config.VariableGridYo
@@ -826,14 +892,18 @@ func (config *VarGridConfig) createCell(data *CTMData, pop *Population, popIndic
 	}
 	cell.WebMapGeom = gg.(geom.Polygonal)
 
-	var bounds *geom.Bounds
+	g := cell.Polygonal
 	if notMeters {
-		bounds = cell.WebMapGeom.Bounds()
-	} else {
-		bounds = cell.Polygonal.Bounds()
+		g = cell.WebMapGeom
 	}
+	bounds := g.Bounds()
 	cell.Dx = bounds.Max.X - bounds.Min.X
 	cell.Dy = bounds.Max.Y - bounds.Min.Y
+	if config.gridPolygons != nil {
+		// Cells from GridFile are not necessarily rectangular, so
+		// set Dy so that the cell volume matches the polygon area.
+		cell.Dy = g.Area() / cell.Dx
+	}
 
 	cell.make(m)
 	if err := cell.loadData(data, layer); err != nil {